@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto-token/config"
+	"crypto-token/tkengine"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// sampleConfig returns a valid Config with a single version "a" and the default alphabets.
+// Its keys are freshly generated random keys, meant to be replaced.
+func sampleConfig() (config.Config, error) {
+	encKey := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, encKey); err != nil {
+		return config.Config{}, err
+	}
+	hmacKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, hmacKey); err != nil {
+		return config.Config{}, err
+	}
+
+	charSets := make(map[string]string)
+	for _, base := range tkengine.RequiredAlphabetBases() {
+		alpha, err := tkengine.DefaultAlphabetProvider{}.GetAlphabetForBase(base)
+		if err != nil {
+			return config.Config{}, err
+		}
+		charSets[fmt.Sprint(base)] = string(alpha)
+	}
+
+	return config.Config{
+		Versioner: config.Versioner{TokenizationVersion: "a", DetokenizationVersions: "a"},
+		Versions:  []config.Version{{Vid: "a", EncryptionKey: encKey, HmacKey: hmacKey}},
+		CharSets:  charSets,
+	}, nil
+}
+
+// writeSampleConfig writes the indented JSON of a sample Config to w
+func writeSampleConfig(w io.Writer) error {
+	c, err := sampleConfig()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
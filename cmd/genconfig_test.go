@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"crypto-token/config"
+	"encoding/json"
+	"testing"
+)
+
+func Test_writeSampleConfig(t *testing.T) {
+	var first, second bytes.Buffer
+	if err := writeSampleConfig(&first); err != nil {
+		t.Fatalf("writeSampleConfig() error = %v", err)
+	}
+	if err := writeSampleConfig(&second); err != nil {
+		t.Fatalf("writeSampleConfig() error = %v", err)
+	}
+
+	var c config.Config
+	if err := json.Unmarshal(first.Bytes(), &c); err != nil {
+		t.Fatalf("sample configuration is not valid JSON: %v", err)
+	}
+	if errs := config.Validate(c); len(errs) > 0 {
+		t.Errorf("sample configuration is invalid: %v", errs)
+	}
+	if _, err := buildTKEngine(&c); err != nil {
+		t.Errorf("buildTKEngine() with the sample configuration error = %v", err)
+	}
+	// every sample gets fresh random keys
+	if bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("writeSampleConfig() wrote the same keys twice")
+	}
+}
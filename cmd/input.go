@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// utf8BOM is the byte order mark some editors write at the beginning of UTF-8 files
+const utf8BOM = "\xef\xbb\xbf"
+
+// openCCFile opens the credit-cards file at path, stdin if path is "-"
+func openCCFile(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// scanCCs streams the credit-cards of r, one per line, to fn with their 1-based line number. Lines are
+// trimmed of spaces and of the CR of CRLF line endings, a leading UTF-8 BOM is dropped and blank lines
+// are skipped. Scanning stops early when fn returns false.
+func scanCCs(r io.Reader, fn func(line int, cc string) bool) error {
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := scanner.Text()
+		if n == 1 {
+			line = strings.TrimPrefix(line, utf8BOM)
+		}
+		// TrimSpace also trims the CR of CRLF line endings
+		if cc := strings.TrimSpace(line); cc != "" && !fn(n, cc) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
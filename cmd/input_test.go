@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_scanCCs(t *testing.T) {
+	type line struct {
+		n  int
+		cc string
+	}
+	tests := map[string]struct {
+		input   string
+		stopAt  int
+		want    []line
+		wantErr bool
+	}{
+		"lf":                {"4444333322221111\n4444333322221112\n", 0, []line{{1, "4444333322221111"}, {2, "4444333322221112"}}, false},
+		"no_final_newline":  {"4444333322221111\n4444333322221112", 0, []line{{1, "4444333322221111"}, {2, "4444333322221112"}}, false},
+		"crlf":              {"4444333322221111\r\n4444333322221112\r\n", 0, []line{{1, "4444333322221111"}, {2, "4444333322221112"}}, false},
+		"bom":               {"\xef\xbb\xbf4444333322221111\n4444333322221112\n", 0, []line{{1, "4444333322221111"}, {2, "4444333322221112"}}, false},
+		"bom_crlf":          {"\xef\xbb\xbf4444333322221111\r\n4444333322221112\r\n", 0, []line{{1, "4444333322221111"}, {2, "4444333322221112"}}, false},
+		"bom_only_line_one": {"4444333322221111\n\xef\xbb\xbf4444333322221112\n", 0, []line{{1, "4444333322221111"}, {2, "\xef\xbb\xbf4444333322221112"}}, false},
+		"bom_blank_line":    {"\xef\xbb\xbf\r\n4444333322221111\r\n", 0, []line{{2, "4444333322221111"}}, false},
+		"blank_lines":       {"\n4444333322221111\n  \n\r\n4444333322221112\n\n", 0, []line{{2, "4444333322221111"}, {5, "4444333322221112"}}, false},
+		"spaces":            {"  4444333322221111\t\n", 0, []line{{1, "4444333322221111"}}, false},
+		"empty":             {"", 0, nil, false},
+		"stop":              {"4444333322221111\n4444333322221112\n4444333322221113\n", 2, []line{{1, "4444333322221111"}, {2, "4444333322221112"}}, false},
+		"line_too_long":     {strings.Repeat("4", 70000) + "\n", 0, nil, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got []line
+			err := scanCCs(strings.NewReader(tt.input), func(n int, cc string) bool {
+				got = append(got, line{n, cc})
+				return len(got) != tt.stopAt
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("scanCCs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scanCCs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,13 +1,12 @@
 package main
 
 import (
+	"crypto-token/config"
 	"crypto-token/tkengine"
-	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"os"
 	"strings"
@@ -18,240 +17,205 @@ type CCList []string
 func main() {
 	var ccs CCList
 	flag.Var(&ccs, "i", "Comma-separated list of credit-cards")
-	separator := flag.String("s", "|", "Separator for the table output")
-	confFile := flag.String("c", "", "Engine configuration file path")
+	ccFile := flag.String("file", "", "File with one credit-card per line, streamed, - to read them from stdin")
+	separator := flag.String("s", defaultSeparator, "Separator for the table output")
+	format := flag.String("o", defaultFormat, "Output format: table or json")
+	var confFiles ConfigFiles
+	flag.Var(&confFiles, "c", "Engine configuration file path, repeat to merge several files (later files override the versioner)")
+	strict := flag.Bool("strict", false, "Stop at the first credit-card that can't be tokenized")
+	header := flag.Bool("header", true, "Write the header line of the table output")
+	unsafeLog := flag.Bool("unsafe-log", false, "Log full credit-cards in diagnostics, for local debugging only")
+	validate := flag.Bool("validate", false, "Only validate the credit-cards, without any key nor tokenization, and report the invalid ones")
+	luhn := flag.Bool("luhn", false, "With -validate, also check the Luhn check digit of the credit-cards")
+	genConfig := flag.Bool("gen-config", false, "Write a sample engine configuration file with random keys to stdout and exit")
+	checkConfig := flag.Bool("check-config", false, "Only validate the -c configuration files, without any tokenization, and report all their problems")
 	flag.Parse()
-	if len(ccs) == 0 {
-		log.Fatal("Empty input")
-		os.Exit(1)
-	}
-
-	tEngine, err := buildTKEngine(confFile)
-	if err != nil {
-		log.Fatalf("Error while creating dummy token engine, error %v\n", err)
-		os.Exit(2)
+	if *genConfig {
+		if err := writeSampleConfig(os.Stdout); err != nil {
+			log.Fatalf("Error while generating the sample configuration, error %v\n", err)
+		}
+		return
 	}
-
-	fmt.Printf("%s%s%s\n", "CC", *separator, "TK")
-
-	for _, cc := range ccs {
-
-		tk, err := tEngine.EncryptCC(cc)
-		if err != nil {
-			log.Fatalf("Could not Encrypt CC, error %v\n", err)
-			os.Exit(3)
+	// the configuration is checked without building the engine nor any credit-card
+	if *checkConfig {
+		if len(confFiles) == 0 {
+			log.Fatal("No configuration file to check")
 		}
-
-		fmt.Printf("%s%s%s\n", cc, *separator, tk)
-
-		cc2, err := tEngine.DecryptTK(tk)
+		conf, err := config.ReadFiles(confFiles)
 		if err != nil {
-			log.Fatalf("Could not Decrypt TK, error %v\n", err)
-			os.Exit(4)
+			log.Fatalf("Error while reading configuration file, error %v\n", err)
 		}
-
-		if cc != cc2 {
-			log.Fatalf("Input CC %s different from decrypted CC %s", cc, cc2)
-			os.Exit(5)
+		errs := config.Validate(*conf)
+		for _, err := range errs {
+			log.Println(err)
+		}
+		if len(errs) > 0 {
+			os.Exit(2)
 		}
+		log.Println("Valid configuration")
+		return
+	}
+	if len(ccs) == 0 && *ccFile == "" {
+		log.Fatal("Empty input")
+		os.Exit(1)
 	}
 
-}
-
-
-func buildTKEngine(confFile *string) (tkengine.TKEngine, error){
-	var tEngine tkengine.TKEngine
-	var err error
-	if *confFile == "" {
-		if tEngine, err = tkengine.NewDummyEngine(); err != nil {
-			return nil, err
-		}
-	} else {
-		conf, err := readConfigFile(*confFile)
-		if err != nil {
-			return nil, err
+	var in io.ReadCloser
+	if *ccFile != "" {
+		var err error
+		if in, err = openCCFile(*ccFile); err != nil {
+			log.Fatalf("Error while opening credit-cards file, error %v\n", err)
 		}
+		defer in.Close()
+	}
 
-		versioner, encKeysRepo, hmacKeysRepo, alphaProvider, err := parseConfig(conf)
+	// validation loads no engine: it needs no configuration nor keys
+	if *validate {
+		total, invalid, err := validateInputs(ccs, in, *luhn)
 		if err != nil {
-			return nil, err
+			log.Fatalf("Error while reading credit-cards file, error %v\n", err)
 		}
-
-		if tEngine, err = tkengine.NewEngine(versioner, encKeysRepo, hmacKeysRepo, alphaProvider); err != nil {
-			return nil, err
+		log.Printf("%d credit-cards: %d valid, %d invalid\n", total, total-invalid, invalid)
+		if invalid > 0 {
+			os.Exit(3)
 		}
+		return
 	}
-	return tEngine, nil
-}
 
-func readConfigFile(path string) (*Config, error) {
-	// Open our jsonFile
-	jsonFile, err := os.Open(path)
-	// if we os.Open returns an error then handle it
-	if err != nil {
-		return nil, err
+	var conf *config.Config
+	if len(confFiles) > 0 {
+		var err error
+		if conf, err = config.ReadFiles(confFiles); err != nil {
+			log.Fatalf("Error while reading configuration file, error %v\n", err)
+			os.Exit(2)
+		}
 	}
-	// defer the closing of our jsonFile so that we can parse it later on
-	defer jsonFile.Close()
 
-	// read our opened jsonFile as a byte array.
-	byteValue, _ := ioutil.ReadAll(jsonFile)
+	tEngine, err := buildTKEngine(conf)
 	if err != nil {
-		return nil, err
+		log.Fatalf("Error while creating dummy token engine, error %v\n", err)
+		os.Exit(2)
+	}
+	// missing or invalid keys fail the run upfront rather than every credit-card
+	if w, ok := tEngine.(tkengine.Warmer); ok {
+		if err := w.Warm(); err != nil {
+			log.Fatalf("Error while checking the token engine keys, error %v\n", err)
+			os.Exit(2)
+		}
 	}
 
-	var c Config
-	// we unmarshal our byteArray which contains our
-	// jsonFile's content into 'c' which we defined above
-	err = json.Unmarshal(byteValue, &c)
+	// flags explicitly set on the command-line override the configuration file
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	out, err := newOutputWriter(os.Stdout, resolveOutput(conf, setFlags, *separator, *format, *header))
 	if err != nil {
-		return nil, err
+		log.Fatalf("Invalid output configuration, error %v\n", err)
+		os.Exit(2)
 	}
 
-	return &c, nil
-}
-
-// ByteString is a byte array that serializes to hex
-type ByteString []byte
-
-// MarshalJSON serializes ByteArray to hex
-func (s ByteString) MarshalJSON() ([]byte, error) {
-	bytes, err := json.Marshal(fmt.Sprintf("%x", string(s)))
-	return bytes, err
-}
+	out.WriteHeader()
 
-// UnmarshalJSON deserializes ByteArray to hex
-func (s *ByteString) UnmarshalJSON(data []byte) error {
-	var x string
-	err := json.Unmarshal(data, &x)
-	if err == nil {
-		str, e := hex.DecodeString(x)
-		*s = str
-		err = e
+	// credit-cards are masked in diagnostics unless explicitly asked otherwise
+	mask := tkengine.MaskPAN
+	if *unsafeLog {
+		mask = func(cc string) string { return cc }
 	}
 
-	return err
-
-}
-
-type Versioner struct {
-	TokenizationVersion    string `json:"tokenizationVersion"`
-	DetokenizationVersions string `json:"detokenizationVersions"`
-}
-
-func (v *Versioner) GetTokenizationVersion() (byte, error) {
-	if v == nil {
-		return 0, errors.New("nil Versioner")
-	}
-	if len(v.TokenizationVersion) != 1 {
-		return 0, errors.New(fmt.Sprintf("Versioner should have a single-byte for tokenizationVersion, instead its %s", v.TokenizationVersion))
+	total, failed, err := tokenizeInputs(tEngine, ccs, in, out, *strict, mask)
+	if err != nil {
+		log.Fatal(err)
 	}
-	return []byte(v.TokenizationVersion)[0], nil
-}
-
-func (v *Versioner) GetDetokenizationVersions() ([]byte, error) {
-	return []byte(v.DetokenizationVersions), nil
-}
 
-type Version struct {
-	Vid           string     `json:"vid"`
-	EncryptionKey ByteString `json:"encryptionKey"`
-	HmacKey       ByteString `json:"hmacKey"`
-}
+	out.Flush()
 
-type EncKeysRepo []Version
-func (r *EncKeysRepo) GetKey(version byte) ([]byte, error) {
-	if r == nil {
-		return nil, errors.New("nil encryption key repo")
+	if msg := summary(total, failed, in != nil); msg != "" {
+		log.Println(msg)
 	}
-	for _, ver := range *r {
-		if string(version) == ver.Vid {
-			return ver.EncryptionKey, nil
-		}
+	if failed > 0 {
+		os.Exit(3)
 	}
-
-	return nil, errors.New(fmt.Sprintf("Version %s not found in repo", string(version)))
 }
 
-type HmacKeysRepo []Version
-func (r *HmacKeysRepo) GetKey(version byte) ([]byte, error) {
-	if r == nil {
-		return nil, errors.New("nil encryption key repo")
+// tokenize encrypts cc and verifies that the token decrypts back to cc.
+// Credit-cards are formatted with mask in the returned errors.
+func tokenize(tEngine tkengine.TKEngine, cc string, mask func(string) string) (string, error) {
+	tk, err := tEngine.EncryptCC(cc)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Could not Encrypt CC %s, error %v", mask(cc), err))
 	}
-	for _, ver := range *r {
-		if string(version) == ver.Vid {
-			return ver.HmacKey, nil
-		}
+
+	cc2, err := tEngine.DecryptTK(tk)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Could not Decrypt TK %s, error %v", tk, err))
 	}
 
-	return nil, errors.New(fmt.Sprintf("Version %s not found in repo", string(version)))
+	if cc != cc2 {
+		return "", errors.New(fmt.Sprintf("Input CC %s different from decrypted CC %s", mask(cc), mask(cc2)))
+	}
+	return tk, nil
 }
 
-type Config struct {
-	Versioner Versioner         `json:"versioner"`
-	Versions  []Version         `json:"versions"`
-	CharSets  map[string]string `json:"charSets"`
-}
-type alphaProvider map[string]string
+// tokenizeInputs tokenizes the credit-cards of ccs and, if not nil, the ones streamed from in, writing
+// the CC/TK pairs to out. A credit-card that can't be tokenized is reported on stderr and does not stop
+// the others, unless strict: its error is then returned. The file is streamed, it is never loaded in memory.
+// It returns the numbers of processed and failed credit-cards.
+func tokenizeInputs(tEngine tkengine.TKEngine, ccs CCList, in io.Reader, out outputWriter, strict bool, mask func(string) string) (int, int, error) {
+	total, failed := 0, 0
+	process := func(cc string) error {
+		total++
+		tk, err := tokenize(tEngine, cc, mask)
+		if err != nil {
+			failed++
+			if strict {
+				return err
+			}
+			log.Println(err)
+			return nil
+		}
 
-func (a *alphaProvider) GetAlphabetForBase(base uint32) ([]byte, error) {
-	if a == nil {
-		return nil, errors.New("nil CharSets provider")
-	}
-	alpha, ok := (*a)[fmt.Sprint(base)]
-	if !ok {
-		return nil, errors.New(fmt.Sprintf("no available Version %d", base))
+		out.WriteRow(cc, tk)
+		return nil
 	}
-	return []byte(alpha), nil
-}
 
-func parseConfig(c *Config) (tkengine.KeyVersioner, tkengine.KeyRepo, tkengine.KeyRepo, tkengine.AlphabetProvider, error) {
-	if c == nil {
-		return nil, nil, nil, nil, errors.New("nil Config")
+	for _, cc := range ccs {
+		if err := process(cc); err != nil {
+			return total, failed, err
+		}
 	}
-	// return error if write Version is more than one byte
-	if _, err := c.Versioner.GetTokenizationVersion(); err != nil {
-		return nil, nil, nil, nil, err
+	if in != nil {
+		var stop error
+		err := scanCCs(in, func(_ int, cc string) bool {
+			stop = process(cc)
+			return stop == nil
+		})
+		if stop != nil {
+			return total, failed, stop
+		}
+		if err != nil {
+			return total, failed, errors.New(fmt.Sprintf("Error while reading credit-cards file, error %v", err))
+		}
 	}
+	return total, failed, nil
+}
 
-	var encRepo EncKeysRepo
-	encRepo = c.Versions
-
-	var hmacRepo HmacKeysRepo
-	hmacRepo = c.Versions
-
-	var alphaP alphaProvider
-	alphaP = c.CharSets
-
-	// sanity check - verify that all the tokenization Version is available in  both repositories
-	tokVer, err := c.Versioner.GetTokenizationVersion()
-	if err != nil {
-		return nil, nil, nil, nil, err
+// summary returns the line reporting the failures of a run, empty if there is nothing to report.
+// Runs streaming a file always report their counts.
+func summary(total int, failed int, streamed bool) string {
+	if streamed {
+		return fmt.Sprintf("%d credit-cards: %d tokenized, %d failed", total, total-failed, failed)
 	}
-	if _, err := encRepo.GetKey(tokVer); err != nil {
-		return nil, nil, nil, nil, err
-	}
-	if _, err := hmacRepo.GetKey(tokVer); err != nil {
-		return nil, nil, nil, nil, err
+	if failed > 0 {
+		return fmt.Sprintf("%d out of %d credit-cards could not be tokenized", failed, total)
 	}
+	return ""
+}
 
-	// sanity check - verify that all the de-tokenization Versions are available in  both repositories
-	detokVer, err := c.Versioner.GetDetokenizationVersions()
-	if err != nil {
-		return nil, nil, nil, nil, err
+func buildTKEngine(conf *config.Config) (tkengine.TKEngine, error) {
+	if conf == nil {
+		return tkengine.NewDummyEngine()
 	}
-	for _,dver := range detokVer {
-		if _, err := encRepo.GetKey(dver); err != nil {
-			return nil, nil, nil, nil, err
-		}
-		if _, err := hmacRepo.GetKey(dver); err != nil {
-			return nil, nil, nil, nil, err
-		}
-	}
-
-	// sanity-check for alpha can be delegated to the NewEngine method therefore we do not check it here
 
-	return &c.Versioner, &encRepo, &hmacRepo, &alphaP, nil
+	return config.NewEngine(conf)
 }
 
 // Set is the method to set the flag value, part of the flag.Value interface.
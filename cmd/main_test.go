@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto-token/tkengine"
+	"io"
+	"strings"
+	"testing"
+)
+
+// rowsWriter records the rows it is given
+type rowsWriter struct {
+	rows [][2]string
+}
+
+func (r *rowsWriter) WriteHeader() {}
+
+func (r *rowsWriter) WriteRow(cc string, tk string) {
+	r.rows = append(r.rows, [2]string{cc, tk})
+}
+
+func (r *rowsWriter) Flush() {}
+
+func Test_tokenizeInputs(t *testing.T) {
+	tEngine, err := tkengine.NewDummyEngine()
+	if err != nil {
+		t.Fatalf("NewDummyEngine() error = %v", err)
+	}
+	tests := map[string]struct {
+		ccs        CCList
+		in         io.Reader
+		strict     bool
+		wantRows   []string
+		wantTotal  int
+		wantFailed int
+		wantLog    []string
+		wantErr    string
+	}{
+		"inputs": {CCList{"4444333322221111", "4444333322221112"}, nil, false,
+			[]string{"4444333322221111", "4444333322221112"}, 2, 0, nil, ""},
+		"file": {CCList{"4444333322221111"}, strings.NewReader("\xef\xbb\xbf4444333322221112\r\n\r\n4444333322221113\r\n"), false,
+			[]string{"4444333322221111", "4444333322221112", "4444333322221113"}, 3, 0, nil, ""},
+		// failures are masked and do not stop the others
+		"failures": {CCList{"12"}, strings.NewReader("4444333322221112\n44443333222211x2\n4444333322221113\n"), false,
+			[]string{"4444333322221112", "4444333322221113"}, 4, 2,
+			[]string{"Could not Encrypt CC **, error", "Could not Encrypt CC 444433******11x2, error"}, ""},
+		"strict_input": {CCList{"4444333322221111", "12", "4444333322221112"}, strings.NewReader("4444333322221113\n"), true,
+			[]string{"4444333322221111"}, 2, 1, nil, "Could not Encrypt CC **"},
+		// the file is not read past the first failure
+		"strict_file": {nil, strings.NewReader("4444333322221112\n44443333222211x2\n4444333322221113\n"), true,
+			[]string{"4444333322221112"}, 2, 1, nil, "Could not Encrypt CC 444433******11x2"},
+		"read_error": {nil, io.MultiReader(strings.NewReader("4444333322221112\n"), errReader{}), false,
+			[]string{"4444333322221112"}, 1, 0, nil, "Error while reading credit-cards file"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			logs := captureLog(t)
+			out := &rowsWriter{}
+			total, failed, err := tokenizeInputs(tEngine, tt.ccs, tt.in, out, tt.strict, tkengine.MaskPAN)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.wantErr)) {
+				t.Fatalf("tokenizeInputs() error = %v, want %q", err, tt.wantErr)
+			}
+			if total != tt.wantTotal || failed != tt.wantFailed {
+				t.Errorf("tokenizeInputs() = %d, %d, want %d, %d", total, failed, tt.wantTotal, tt.wantFailed)
+			}
+			if len(out.rows) != len(tt.wantRows) {
+				t.Fatalf("rows = %v, want the credit-cards %v", out.rows, tt.wantRows)
+			}
+			for i, row := range out.rows {
+				if row[0] != tt.wantRows[i] {
+					t.Errorf("row %d credit-card = %v, want %v", i, row[0], tt.wantRows[i])
+				}
+				if cc, err := tEngine.DecryptTK(row[1]); err != nil || cc != row[0] {
+					t.Errorf("row %d token %v decrypts to %v, %v", i, row[1], cc, err)
+				}
+			}
+			checkLog(t, logs, tt.wantLog)
+		})
+	}
+}
+
+func Test_summary(t *testing.T) {
+	tests := map[string]struct {
+		total    int
+		failed   int
+		streamed bool
+		want     string
+	}{
+		"inputs":           {2, 0, false, ""},
+		"inputs_failures":  {2, 1, false, "1 out of 2 credit-cards could not be tokenized"},
+		"streamed":         {3, 0, true, "3 credit-cards: 3 tokenized, 0 failed"},
+		"streamed_failure": {3, 2, true, "3 credit-cards: 1 tokenized, 2 failed"},
+		"streamed_empty":   {0, 0, true, "0 credit-cards: 0 tokenized, 0 failed"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := summary(tt.total, tt.failed, tt.streamed); got != tt.want {
+				t.Errorf("summary() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"strings"
+)
+
+// ConfigFiles is the list of configuration file paths, one per -c flag
+type ConfigFiles []string
+
+// Set appends a configuration file path, part of the flag.Value interface.
+// Unlike CCList the flag can be repeated: the files are merged in order.
+func (f *ConfigFiles) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// String is the method to format the flag's value, part of the flag.Value interface.
+func (f *ConfigFiles) String() string {
+	return strings.Join(*f, ",")
+}
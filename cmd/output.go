@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto-token/config"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+const (
+	defaultSeparator = "|"
+	defaultFormat    = formatTable
+
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// resolveOutput merges the output settings: the flags explicitly set on the command-line
+// (setFlags) override the configuration file which overrides the flags defaults
+func resolveOutput(conf *config.Config, setFlags map[string]bool, separator string, format string, header bool) config.Output {
+	defaultHeader := true
+	out := config.Output{Separator: defaultSeparator, Format: defaultFormat, Header: &defaultHeader}
+	if conf != nil && conf.Output != nil {
+		if conf.Output.Separator != "" {
+			out.Separator = conf.Output.Separator
+		}
+		if conf.Output.Format != "" {
+			out.Format = conf.Output.Format
+		}
+		if conf.Output.Header != nil {
+			out.Header = conf.Output.Header
+		}
+	}
+	if setFlags["s"] {
+		out.Separator = separator
+	}
+	if setFlags["o"] {
+		out.Format = format
+	}
+	if setFlags["header"] {
+		out.Header = &header
+	}
+	return out
+}
+
+// outputWriter writes the CC/TK pairs in a given format
+type outputWriter interface {
+	WriteHeader()
+	WriteRow(cc string, tk string)
+	Flush()
+}
+
+// newOutputWriter returns the outputWriter writing to w in the format of out
+func newOutputWriter(w io.Writer, out config.Output) (outputWriter, error) {
+	switch out.Format {
+	case formatTable:
+		comma, size := utf8.DecodeRuneInString(out.Separator)
+		if size == 0 || size != len(out.Separator) || comma == utf8.RuneError {
+			return nil, errors.New(fmt.Sprintf("invalid separator %q: it should be a single character", out.Separator))
+		}
+		if comma == '"' || comma == '\r' || comma == '\n' {
+			return nil, errors.New(fmt.Sprintf("invalid separator %q: quotes and line breaks are reserved", out.Separator))
+		}
+		cw := csv.NewWriter(w)
+		cw.Comma = comma
+		return &tableWriter{w: cw, header: out.Header == nil || *out.Header}, nil
+	case formatJSON:
+		return &jsonWriter{w: w}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown output format %s: it should be %s or %s", out.Format, formatTable, formatJSON))
+	}
+}
+
+// tableWriter writes an optional header line and one line per row as CSV records:
+// fields containing the separator or quotes are quoted and escaped
+type tableWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (t *tableWriter) WriteHeader() {
+	if t.header {
+		t.w.Write([]string{"CC", "TK"})
+	}
+}
+
+func (t *tableWriter) WriteRow(cc string, tk string) {
+	t.w.Write([]string{cc, tk})
+}
+
+func (t *tableWriter) Flush() {
+	t.w.Flush()
+}
+
+// jsonWriter writes a JSON array with one {"cc":...,"tk":...} object per row.
+// Rows are streamed as they are written: the array is closed by Flush.
+type jsonWriter struct {
+	w    io.Writer
+	rows int
+}
+
+type jsonRow struct {
+	CC string `json:"cc"`
+	TK string `json:"tk"`
+}
+
+func (j *jsonWriter) WriteHeader() {
+	fmt.Fprint(j.w, "[")
+}
+
+func (j *jsonWriter) WriteRow(cc string, tk string) {
+	row, _ := json.Marshal(jsonRow{CC: cc, TK: tk})
+	if j.rows > 0 {
+		fmt.Fprint(j.w, ",")
+	}
+	fmt.Fprintf(j.w, "\n  %s", row)
+	j.rows++
+}
+
+func (j *jsonWriter) Flush() {
+	fmt.Fprint(j.w, "\n]\n")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"crypto-token/config"
+	"reflect"
+	"testing"
+)
+
+func Test_resolveOutput(t *testing.T) {
+	yes, no := true, false
+	tests := map[string]struct {
+		conf      *config.Config
+		setFlags  map[string]bool
+		separator string
+		format    string
+		header    bool
+		want      config.Output
+	}{
+		"defaults": {nil, nil, ",", "json", false,
+			config.Output{Separator: "|", Format: "table", Header: &yes}},
+		"no_output_section": {&config.Config{}, nil, ",", "json", false,
+			config.Output{Separator: "|", Format: "table", Header: &yes}},
+		"configuration": {&config.Config{Output: &config.Output{Separator: ";", Format: "json", Header: &no}}, nil, ",", "table", true,
+			config.Output{Separator: ";", Format: "json", Header: &no}},
+		"partial_configuration": {&config.Config{Output: &config.Output{Separator: ";"}}, nil, ",", "json", false,
+			config.Output{Separator: ";", Format: "table", Header: &yes}},
+		"flags_override_configuration": {&config.Config{Output: &config.Output{Separator: ";", Format: "json", Header: &no}},
+			map[string]bool{"s": true, "o": true, "header": true}, ",", "table", true,
+			config.Output{Separator: ",", Format: "table", Header: &yes}},
+		"flags_without_configuration": {nil, map[string]bool{"header": true}, ",", "json", false,
+			config.Output{Separator: "|", Format: "table", Header: &no}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := resolveOutput(tt.conf, tt.setFlags, tt.separator, tt.format, tt.header); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolveOutput() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newOutputWriter(t *testing.T) {
+	yes, no := true, false
+	rows := [][2]string{{"4444333322221111", "444433akeblg1111"}, {"4444333322221112", "444433bh|c1112"}}
+	tests := map[string]struct {
+		out     config.Output
+		want    string
+		wantErr bool
+	}{
+		// fields containing the separator are quoted
+		"table": {config.Output{Separator: "|", Format: "table", Header: &yes},
+			"CC|TK\n4444333322221111|444433akeblg1111\n4444333322221112|\"444433bh|c1112\"\n", false},
+		"table_nil_header": {config.Output{Separator: "|", Format: "table"},
+			"CC|TK\n4444333322221111|444433akeblg1111\n4444333322221112|\"444433bh|c1112\"\n", false},
+		"table_no_header": {config.Output{Separator: "|", Format: "table", Header: &no},
+			"4444333322221111|444433akeblg1111\n4444333322221112|\"444433bh|c1112\"\n", false},
+		"csv": {config.Output{Separator: ",", Format: "table", Header: &yes},
+			"CC,TK\n4444333322221111,444433akeblg1111\n4444333322221112,444433bh|c1112\n", false},
+		"multi_byte_separator": {config.Output{Separator: "¦", Format: "table", Header: &no},
+			"4444333322221111¦444433akeblg1111\n4444333322221112¦444433bh|c1112\n", false},
+		"json": {config.Output{Separator: "|", Format: "json", Header: &no},
+			"[\n  {\"cc\":\"4444333322221111\",\"tk\":\"444433akeblg1111\"},\n  {\"cc\":\"4444333322221112\",\"tk\":\"444433bh|c1112\"}\n]\n", false},
+		"empty_separator":     {config.Output{Separator: "", Format: "table"}, "", true},
+		"long_separator":      {config.Output{Separator: "||", Format: "table"}, "", true},
+		"invalid_utf8":        {config.Output{Separator: "\xff", Format: "table"}, "", true},
+		"quote_separator":     {config.Output{Separator: "\"", Format: "table"}, "", true},
+		"line_feed_separator": {config.Output{Separator: "\n", Format: "table"}, "", true},
+		"unknown_format":      {config.Output{Separator: "|", Format: "xml"}, "", true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newOutputWriter(&buf, tt.out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newOutputWriter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			w.WriteHeader()
+			for _, row := range rows {
+				w.WriteRow(row[0], row[1])
+			}
+			w.Flush()
+			if got := buf.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_jsonWriter_empty(t *testing.T) {
+	var buf bytes.Buffer
+	w := &jsonWriter{w: &buf}
+	w.WriteHeader()
+	w.Flush()
+	if got, want := buf.String(), "[\n]\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
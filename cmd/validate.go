@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto-token/tkengine"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+)
+
+// validateCC checks cc without any engine: its length and digits and, if luhn, its check digit
+func validateCC(cc string, luhn bool) error {
+	if err := tkengine.ValidateCC(cc); err != nil {
+		return err
+	}
+	if luhn && !tkengine.IsLuhnValid(cc) {
+		return errors.New("Invalid CC: Luhn check digit mismatch")
+	}
+	return nil
+}
+
+// validateInputs checks the credit-cards of ccs and, if not nil, of in without loading any engine.
+// The invalid ones are reported on stderr by their position: their index in ccs or their line in in.
+// It returns the numbers of checked and invalid credit-cards.
+func validateInputs(ccs CCList, in io.Reader, luhn bool) (int, int, error) {
+	total, invalid := 0, 0
+	check := func(pos string, cc string) {
+		total++
+		if err := validateCC(cc, luhn); err != nil {
+			log.Printf("%s: %v\n", pos, err)
+			invalid++
+		}
+	}
+	for i, cc := range ccs {
+		check(fmt.Sprintf("input %d", i+1), cc)
+	}
+	if in != nil {
+		if err := scanCCs(in, func(line int, cc string) bool {
+			check(fmt.Sprintf("line %d", line), cc)
+			return true
+		}); err != nil {
+			return total, invalid, err
+		}
+	}
+	return total, invalid, nil
+}
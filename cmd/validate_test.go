@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_validateInputs(t *testing.T) {
+	tests := map[string]struct {
+		ccs         CCList
+		in          io.Reader
+		luhn        bool
+		wantTotal   int
+		wantInvalid int
+		wantLog     []string
+		wantErr     bool
+	}{
+		"valid": {CCList{"4444333322221111"}, strings.NewReader("4444333322221112\r\n"), false, 2, 0, nil, false},
+		"invalid_input": {CCList{"4444333322221111", "12"}, nil, false, 2, 1,
+			[]string{"input 2: Invalid CC format: length 2 out of range [13, 19]"}, false},
+		"invalid_line": {nil, strings.NewReader("\xef\xbb\xbf4444333322221111\n\n44443333222211x1\n"), false, 2, 1,
+			[]string{"line 3: Invalid CC format"}, false},
+		"luhn": {CCList{"4444333322221111"}, strings.NewReader("4444333322221112\n"), true, 2, 1,
+			[]string{"line 1: Invalid CC: Luhn check digit mismatch"}, false},
+		"read_error": {CCList{"4444333322221111"}, io.MultiReader(strings.NewReader("4444333322221112\n"), errReader{}), false, 2, 0, nil, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			logs := captureLog(t)
+			total, invalid, err := validateInputs(tt.ccs, tt.in, tt.luhn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateInputs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if total != tt.wantTotal || invalid != tt.wantInvalid {
+				t.Errorf("validateInputs() = %d, %d, want %d, %d", total, invalid, tt.wantTotal, tt.wantInvalid)
+			}
+			checkLog(t, logs, tt.wantLog)
+		})
+	}
+}
+
+// errReader fails every read
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("read error")
+}
+
+// captureLog redirects the standard logger to the returned buffer for the duration of the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	flags := log.Flags()
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	})
+	return &buf
+}
+
+// checkLog verifies that the logged lines start with want, in order
+func checkLog(t *testing.T, logs *bytes.Buffer, want []string) {
+	t.Helper()
+	var lines []string
+	if s := strings.TrimSuffix(logs.String(), "\n"); s != "" {
+		lines = strings.Split(s, "\n")
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("logged %q, want %q", lines, want)
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, want[i]) {
+			t.Errorf("logged line %d = %q, want prefix %q", i, line, want[i])
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// Package config is the JSON configuration of a tokenization engine: its versioner, the keys of
+// its versions and its alphabets. It reads, merges and validates configuration files and builds the
+// engine dependencies out of them, so that deployment pipelines can check a configuration without
+// running the command-line tool.
+package config
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// Config is the configuration of an engine
+type Config struct {
+	Versioner Versioner         `json:"versioner"`
+	Versions  []Version         `json:"versions"`
+	CharSets  map[string]string `json:"charSets"`
+	Output    *Output           `json:"output,omitempty"`
+}
+
+// Output is the optional output section of a Config. Empty fields
+// fall back to the command-line flags defaults.
+type Output struct {
+	Separator string `json:"separator,omitempty"`
+	Format    string `json:"format,omitempty"`
+	Header    *bool  `json:"header,omitempty"`
+}
+
+// ByteString is a byte array that serializes to hex
+type ByteString []byte
+
+// MarshalJSON serializes ByteArray to hex
+func (s ByteString) MarshalJSON() ([]byte, error) {
+	bytes, err := json.Marshal(fmt.Sprintf("%x", string(s)))
+	return bytes, err
+}
+
+// UnmarshalJSON deserializes ByteArray to hex
+func (s *ByteString) UnmarshalJSON(data []byte) error {
+	var x string
+	err := json.Unmarshal(data, &x)
+	if err == nil {
+		str, e := hex.DecodeString(x)
+		*s = str
+		err = e
+	}
+
+	return err
+
+}
+
+// Versioner selects the versions by their id (the version char embedded in the tokens) or, for
+// the tokenization version and DetokenizationVersionNames, by their Version name
+type Versioner struct {
+	TokenizationVersion        string   `json:"tokenizationVersion"`
+	DetokenizationVersions     string   `json:"detokenizationVersions"`
+	DetokenizationVersionNames []string `json:"detokenizationVersionNames,omitempty"`
+}
+
+// GetTokenizationVersion returns the single-byte tokenization version id
+func (v *Versioner) GetTokenizationVersion() (byte, error) {
+	if v == nil {
+		return 0, errors.New("nil Versioner")
+	}
+	if len(v.TokenizationVersion) != 1 {
+		return 0, errors.New(fmt.Sprintf("Versioner should have a single-byte version id or a version name for tokenizationVersion, instead its %s", v.TokenizationVersion))
+	}
+	return []byte(v.TokenizationVersion)[0], nil
+}
+
+// GetDetokenizationVersions returns the detokenization version ids
+func (v *Versioner) GetDetokenizationVersions() ([]byte, error) {
+	return []byte(v.DetokenizationVersions), nil
+}
+
+// Version holds the keys of a version. Vid is the single char identifying the version in the
+// tokens while the optional Name is a human-readable identifier of any length (e.g. "2021-q2").
+type Version struct {
+	Vid           string     `json:"vid"`
+	Name          string     `json:"name,omitempty"`
+	EncryptionKey ByteString `json:"encryptionKey"`
+	HmacKey       ByteString `json:"hmacKey"`
+}
+
+// EncKeysRepo serves the encryption keys of the versions
+type EncKeysRepo []Version
+
+// GetKey returns the encryption key of version
+func (r *EncKeysRepo) GetKey(version byte) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("nil encryption key repo")
+	}
+	for _, ver := range *r {
+		if string(version) == ver.Vid {
+			return ver.EncryptionKey, nil
+		}
+	}
+
+	return nil, errors.New(fmt.Sprintf("Version %s not found in repo", string(version)))
+}
+
+// HmacKeysRepo serves the hmac keys of the versions
+type HmacKeysRepo []Version
+
+// GetKey returns the hmac key of version
+func (r *HmacKeysRepo) GetKey(version byte) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("nil encryption key repo")
+	}
+	for _, ver := range *r {
+		if string(version) == ver.Vid {
+			return ver.HmacKey, nil
+		}
+	}
+
+	return nil, errors.New(fmt.Sprintf("Version %s not found in repo", string(version)))
+}
+
+// ReadFile reads the JSON Config of the file at path
+func ReadFile(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// ReadFiles reads and merges the configuration files in order (see Merge)
+func ReadFiles(paths []string) (*Config, error) {
+	var merged *Config
+	for _, path := range paths {
+		c, err := ReadFile(path)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s: %v", path, err))
+		}
+		if merged == nil {
+			merged = c
+			continue
+		}
+		if merged, err = Merge(*merged, *c); err != nil {
+			return nil, errors.New(fmt.Sprintf("%s: %v", path, err))
+		}
+	}
+	return merged, nil
+}
+
+// Merge merges next on top of base: the versioner and the output of next, when set,
+// override the ones of base while the versions and the charSets are the union of both.
+// A version with different keys or a base with different alphabets in the two Configs is a conflict.
+func Merge(base, next Config) (*Config, error) {
+	var errs []error
+
+	merged := Config{
+		Versioner: base.Versioner,
+		Output:    base.Output,
+		CharSets:  make(map[string]string),
+	}
+	if next.Versioner.TokenizationVersion != "" || next.Versioner.DetokenizationVersions != "" || len(next.Versioner.DetokenizationVersionNames) > 0 {
+		merged.Versioner = next.Versioner
+	}
+	if next.Output != nil {
+		merged.Output = next.Output
+	}
+
+	merged.Versions = append(merged.Versions, base.Versions...)
+	for _, ver := range next.Versions {
+		i := indexOfVersion(merged.Versions, ver.Vid)
+		if i < 0 {
+			merged.Versions = append(merged.Versions, ver)
+			continue
+		}
+		prev := merged.Versions[i]
+		if !bytes.Equal(prev.EncryptionKey, ver.EncryptionKey) || !bytes.Equal(prev.HmacKey, ver.HmacKey) {
+			errs = append(errs, errors.New(fmt.Sprintf("Version %s is defined with different keys", ver.Vid)))
+			continue
+		}
+		if ver.Name != "" {
+			if prev.Name != "" && prev.Name != ver.Name {
+				errs = append(errs, errors.New(fmt.Sprintf("Version %s is defined with different names %s and %s", ver.Vid, prev.Name, ver.Name)))
+				continue
+			}
+			merged.Versions[i].Name = ver.Name
+		}
+	}
+
+	for key, alpha := range base.CharSets {
+		merged.CharSets[key] = alpha
+	}
+	// keys are sorted for a stable report, map iteration order being random
+	keys := make([]string, 0, len(next.CharSets))
+	for key := range next.CharSets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		alpha := next.CharSets[key]
+		if prev, ok := merged.CharSets[key]; ok && prev != alpha {
+			errs = append(errs, errors.New(fmt.Sprintf("charSet for base %s is defined with different alphabets", key)))
+			continue
+		}
+		merged.CharSets[key] = alpha
+	}
+
+	if len(errs) > 0 {
+		return nil, joinErrors(errs)
+	}
+	return &merged, nil
+}
+
+// indexOfVersion returns the index of the version with id vid in versions, -1 if absent
+func indexOfVersion(versions []Version, vid string) int {
+	for i, ver := range versions {
+		if ver.Vid == vid {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	yes := true
+	keyA, keyB := ByteString{1}, ByteString{2}
+	tests := map[string]struct {
+		base    Config
+		next    Config
+		want    Config
+		wantErr []string
+	}{
+		"versions_union": {
+			Config{Versioner: Versioner{TokenizationVersion: "a", DetokenizationVersions: "a"},
+				Versions: []Version{{Vid: "a", EncryptionKey: keyA, HmacKey: keyA}}},
+			Config{Versions: []Version{{Vid: "b", EncryptionKey: keyB, HmacKey: keyB}}},
+			Config{Versioner: Versioner{TokenizationVersion: "a", DetokenizationVersions: "a"},
+				Versions: []Version{{Vid: "a", EncryptionKey: keyA, HmacKey: keyA}, {Vid: "b", EncryptionKey: keyB, HmacKey: keyB}},
+				CharSets: map[string]string{}},
+			nil,
+		},
+		"versioner_override": {
+			Config{Versioner: Versioner{TokenizationVersion: "a", DetokenizationVersions: "a"}},
+			Config{Versioner: Versioner{DetokenizationVersionNames: []string{"2021-q2"}}},
+			Config{Versioner: Versioner{DetokenizationVersionNames: []string{"2021-q2"}}, CharSets: map[string]string{}},
+			nil,
+		},
+		"output_override": {
+			Config{Output: &Output{Separator: ";"}},
+			Config{Output: &Output{Header: &yes}},
+			Config{Output: &Output{Header: &yes}, CharSets: map[string]string{}},
+			nil,
+		},
+		"output_kept": {
+			Config{Output: &Output{Separator: ";"}},
+			Config{},
+			Config{Output: &Output{Separator: ";"}, CharSets: map[string]string{}},
+			nil,
+		},
+		// a version repeated with the same keys may name it
+		"same_version": {
+			Config{Versions: []Version{{Vid: "a", EncryptionKey: keyA, HmacKey: keyA}}},
+			Config{Versions: []Version{{Vid: "a", Name: "2021-q1", EncryptionKey: keyA, HmacKey: keyA}}},
+			Config{Versions: []Version{{Vid: "a", Name: "2021-q1", EncryptionKey: keyA, HmacKey: keyA}}, CharSets: map[string]string{}},
+			nil,
+		},
+		"charsets_union": {
+			Config{CharSets: map[string]string{"14": "0123456789abcd"}},
+			Config{CharSets: map[string]string{"14": "0123456789abcd", "15": "0123456789abcde"}},
+			Config{CharSets: map[string]string{"14": "0123456789abcd", "15": "0123456789abcde"}},
+			nil,
+		},
+		"conflicting_keys": {
+			Config{Versions: []Version{{Vid: "a", EncryptionKey: keyA, HmacKey: keyA}}},
+			Config{Versions: []Version{{Vid: "a", EncryptionKey: keyA, HmacKey: keyB}}},
+			Config{},
+			[]string{"Version a is defined with different keys"},
+		},
+		"conflicting_names": {
+			Config{Versions: []Version{{Vid: "a", Name: "2021-q1", EncryptionKey: keyA, HmacKey: keyA}}},
+			Config{Versions: []Version{{Vid: "a", Name: "2021-q2", EncryptionKey: keyA, HmacKey: keyA}}},
+			Config{},
+			[]string{"Version a is defined with different names 2021-q1 and 2021-q2"},
+		},
+		// all the conflicts are reported at once
+		"conflicts": {
+			Config{Versions: []Version{{Vid: "a", EncryptionKey: keyA, HmacKey: keyA}, {Vid: "b", EncryptionKey: keyB, HmacKey: keyB}},
+				CharSets: map[string]string{"14": "0123456789abcd", "15": "0123456789abcde"}},
+			Config{Versions: []Version{{Vid: "a", EncryptionKey: keyB, HmacKey: keyA}, {Vid: "b", EncryptionKey: keyA, HmacKey: keyB}},
+				CharSets: map[string]string{"15": "0123456789ABCDE", "14": "0123456789ABCD"}},
+			Config{},
+			[]string{"Version a is defined with different keys", "Version b is defined with different keys",
+				"charSet for base 14 is defined with different alphabets", "charSet for base 15 is defined with different alphabets"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := Merge(tt.base, tt.next)
+			if tt.wantErr != nil {
+				want := "invalid configuration: [" + strings.Join(tt.wantErr, "; ") + "]"
+				if err == nil || err.Error() != want {
+					t.Fatalf("Merge() error = %v, want %v", err, want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Merge() error = %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Merge() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// keys and versioner in separate files, as with repeated -c flags
+	c := validConfig(t)
+	files := map[string]Config{
+		"keys.json":      {Versions: c.Versions},
+		"charsets.json":  {CharSets: c.CharSets},
+		"versioner.json": {Versioner: c.Versioner},
+		"conflict.json":  {Versions: []Version{{Vid: "a", EncryptionKey: make([]byte, 24), HmacKey: make([]byte, 32)}}},
+	}
+	for name, file := range files {
+		b, err := json.Marshal(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "invalid.json"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	got, err := ReadFiles([]string{path("keys.json"), path("charsets.json"), path("versioner.json")})
+	if err != nil {
+		t.Fatalf("ReadFiles() error = %v", err)
+	}
+	if !reflect.DeepEqual(*got, c) {
+		t.Errorf("ReadFiles() = %+v, want %+v", *got, c)
+	}
+	if errs := Validate(*got); len(errs) > 0 {
+		t.Errorf("Validate() of the merged files = %v", errs)
+	}
+
+	// the errors name the offending file
+	for _, paths := range [][]string{
+		{path("keys.json"), path("conflict.json")},
+		{path("keys.json"), path("invalid.json")},
+		{path("keys.json"), path("missing.json")},
+	} {
+		if _, err := ReadFiles(paths); err == nil || !strings.HasPrefix(err.Error(), paths[1]+": ") {
+			t.Errorf("ReadFiles(%v) error = %v, want an error about %v", paths, err, paths[1])
+		}
+	}
+}
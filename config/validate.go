@@ -0,0 +1,243 @@
+package config
+
+import (
+	"crypto-token/tkengine"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Parse validates c and returns the engine dependencies it configures
+func Parse(c *Config) (tkengine.KeyVersioner, tkengine.KeyRepo, tkengine.KeyRepo, tkengine.AlphabetProvider, error) {
+	if c == nil {
+		return nil, nil, nil, nil, errors.New("nil Config")
+	}
+
+	if errs := Validate(*c); len(errs) > 0 {
+		return nil, nil, nil, nil, joinErrors(errs)
+	}
+
+	versioner, _ := resolveVersioner(*c)
+
+	var encRepo EncKeysRepo
+	encRepo = c.Versions
+
+	var hmacRepo HmacKeysRepo
+	hmacRepo = c.Versions
+
+	alphaP, err := tkengine.NewMapAlphabetProvider(c.CharSets)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	return &versioner, &encRepo, &hmacRepo, alphaP, nil
+}
+
+// NewEngine builds the engine configured by c
+func NewEngine(c *Config) (tkengine.TKEngine, error) {
+	versioner, encKeysRepo, hmacKeysRepo, alphaProvider, err := Parse(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return tkengine.NewEngine(versioner, encKeysRepo, hmacKeysRepo, alphaProvider)
+}
+
+// resolveVersioner returns the Versioner of c with the version names replaced by the version ids.
+// The errors report the invalid or unknown names.
+func resolveVersioner(c Config) (Versioner, []error) {
+	var errs []error
+
+	ids := make(map[string]string)
+	for _, ver := range c.Versions {
+		if ver.Name == "" {
+			continue
+		}
+		if len(ver.Name) == 1 {
+			// single chars are version ids
+			errs = append(errs, errors.New(fmt.Sprintf("Version %s: name %s should be longer than a single char", ver.Vid, ver.Name)))
+			continue
+		}
+		if _, ok := ids[ver.Name]; ok {
+			errs = append(errs, errors.New(fmt.Sprintf("Version name %s is used by more than one version", ver.Name)))
+			continue
+		}
+		ids[ver.Name] = ver.Vid
+	}
+
+	v := Versioner{
+		TokenizationVersion:    c.Versioner.TokenizationVersion,
+		DetokenizationVersions: c.Versioner.DetokenizationVersions,
+	}
+	if len(v.TokenizationVersion) > 1 {
+		if id, ok := ids[v.TokenizationVersion]; ok {
+			v.TokenizationVersion = id
+		}
+	}
+	for _, name := range c.Versioner.DetokenizationVersionNames {
+		id, ok := ids[name]
+		if !ok {
+			errs = append(errs, errors.New(fmt.Sprintf("detokenizationVersionNames: unknown version name %s", name)))
+			continue
+		}
+		if !strings.Contains(v.DetokenizationVersions, id) {
+			v.DetokenizationVersions += id
+		}
+	}
+	return v, errs
+}
+
+// Validate runs all the sanity checks on a Config without building the engine nor
+// performing any crypto operation. Instead of failing on the first problem it returns all
+// the problems found so that they can be fixed in one pass.
+func Validate(c Config) []error {
+	// version names are resolved into version ids first
+	versioner, errs := resolveVersioner(c)
+
+	var encRepo EncKeysRepo
+	encRepo = c.Versions
+
+	var hmacRepo HmacKeysRepo
+	hmacRepo = c.Versions
+
+	// sanity check - verify that every Version has a single-byte id and legal keys
+	for _, ver := range c.Versions {
+		if len(ver.Vid) != 1 {
+			errs = append(errs, errors.New(fmt.Sprintf("Version id should be a single-byte, instead its %s", ver.Vid)))
+		} else if err := tkengine.ValidateVersion(ver.Vid[0]); err != nil {
+			errs = append(errs, err)
+		}
+		if err := tkengine.ValidateEncryptionKey(ver.EncryptionKey); err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("Version %s: %v", ver.Vid, err)))
+		}
+		if len(ver.HmacKey) == 0 {
+			errs = append(errs, errors.New(fmt.Sprintf("Version %s: empty hmac key", ver.Vid)))
+		}
+	}
+
+	// sanity check - verify that the tokenization Version is available in both repositories
+	// (an error is returned if the tokenization Version is more than one byte)
+	tokVer, tokErr := versioner.GetTokenizationVersion()
+	if tokErr != nil {
+		errs = append(errs, tokErr)
+	} else {
+		errs = append(errs, missingKeys(tokVer, &encRepo, &hmacRepo)...)
+	}
+
+	// sanity check - verify that all the de-tokenization Versions are available in both repositories
+	detokVer, err := versioner.GetDetokenizationVersions()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, dver := range detokVer {
+		errs = append(errs, missingKeys(dver, &encRepo, &hmacRepo)...)
+	}
+
+	// sanity check - verify that the tokenization Version is also a de-tokenization Version,
+	// otherwise freshly minted tokens would be immediately undecryptable
+	if tokErr == nil && !strings.ContainsRune(versioner.DetokenizationVersions, rune(tokVer)) {
+		errs = append(errs, errors.New(fmt.Sprintf("tokenizationVersion %s is not among the detokenizationVersions %s", string(tokVer), versioner.DetokenizationVersions)))
+	}
+
+	// sanity check - verify that the charSets are exactly the alphabets of the required bases
+	errs = append(errs, validateCharSets(c.CharSets)...)
+
+	// sanity check - verify the alphabet provider built from the charSets as NewEngine does: it stops at
+	// its first problem, which is not reported again if the charSets checks already did
+	if err := tkengine.ValidateAlphabetProvider(charSetsProvider(c.CharSets)); err != nil && !reported(errs, err) {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// charSetsProvider returns the alphabets of the charSets indexed by a decimal base, the other ones
+// are reported by validateCharSets
+func charSetsProvider(charSets map[string]string) tkengine.MapAlphabetProvider {
+	m := make(tkengine.MapAlphabetProvider, len(charSets))
+	for key, alpha := range charSets {
+		if base, err := strconv.ParseUint(key, 10, 32); err == nil {
+			m[uint32(base)] = []byte(alpha)
+		}
+	}
+	return m
+}
+
+// validateCharSets returns all the problems of the charSets at once: missing and unexpected bases,
+// and alphabets of the wrong size or with duplicated symbols
+func validateCharSets(charSets map[string]string) []error {
+	var errs []error
+
+	required := make(map[uint32]bool)
+	for _, base := range tkengine.RequiredAlphabetBases() {
+		required[base] = true
+	}
+
+	// keys are sorted for a stable report, map iteration order being random
+	keys := make([]string, 0, len(charSets))
+	for key := range charSets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var extra []string
+	present := make(map[uint32]bool)
+	for _, key := range keys {
+		alpha := charSets[key]
+		base, err := strconv.ParseUint(key, 10, 32)
+		if err != nil || !required[uint32(base)] {
+			extra = append(extra, key)
+			continue
+		}
+		present[uint32(base)] = true
+		if err := tkengine.ValidateAlphabet(uint32(base), []byte(alpha)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	var missing []string
+	for _, base := range tkengine.RequiredAlphabetBases() {
+		if !present[base] {
+			missing = append(missing, fmt.Sprint(base))
+		}
+	}
+	if len(missing) > 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("charSets missing for bases [%s]", strings.Join(missing, ", "))))
+	}
+	if len(extra) > 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("unexpected charSets for bases [%s], only bases %v are used", strings.Join(extra, ", "), tkengine.RequiredAlphabetBases())))
+	}
+	return errs
+}
+
+// reported returns true if errs contains an error with the message of err
+func reported(errs []error, err error) bool {
+	for _, e := range errs {
+		if e.Error() == err.Error() {
+			return true
+		}
+	}
+	return false
+}
+
+// missingKeys returns an error for each repository in which the version v is not available
+func missingKeys(v byte, repos ...tkengine.KeyRepo) []error {
+	var errs []error
+	for _, repo := range repos {
+		if _, err := repo.GetKey(v); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// joinErrors merges multiple errors in a single one listing all of them
+func joinErrors(errs []error) error {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return errors.New(fmt.Sprintf("invalid configuration: [%s]", strings.Join(msgs, "; ")))
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"crypto-token/tkengine"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// validConfig returns a valid Config with the versions a and b and the default alphabets
+func validConfig(t *testing.T) Config {
+	t.Helper()
+	charSets := make(map[string]string)
+	for _, base := range tkengine.RequiredAlphabetBases() {
+		alpha, err := tkengine.DefaultAlphabetProvider{}.GetAlphabetForBase(base)
+		if err != nil {
+			t.Fatalf("GetAlphabetForBase() error = %v", err)
+		}
+		charSets[fmt.Sprint(base)] = string(alpha)
+	}
+	return Config{
+		Versioner: Versioner{TokenizationVersion: "a", DetokenizationVersions: "ab"},
+		Versions: []Version{
+			{Vid: "a", Name: "2021-q1", EncryptionKey: make([]byte, 16), HmacKey: make([]byte, 32)},
+			{Vid: "b", Name: "2021-q2", EncryptionKey: make([]byte, 32), HmacKey: make([]byte, 32)},
+		},
+		CharSets: charSets,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := map[string]struct {
+		edit func(c *Config)
+		want []string
+	}{
+		"valid": {func(c *Config) {}, nil},
+		"tokenization_version_name": {func(c *Config) {
+			c.Versioner.TokenizationVersion = "2021-q2"
+		}, nil},
+		"detokenization_version_names": {func(c *Config) {
+			c.Versioner.DetokenizationVersions = "a"
+			c.Versioner.DetokenizationVersionNames = []string{"2021-q2"}
+		}, nil},
+		"multi_byte_version_id": {func(c *Config) {
+			c.Versions[1].Vid = "bb"
+			c.Versioner.DetokenizationVersions = "a"
+		}, []string{"Version id should be a single-byte, instead its bb"}},
+		"unprintable_version_id": {func(c *Config) {
+			c.Versions[1].Vid = " "
+			c.Versioner.DetokenizationVersions = "a "
+		}, []string{"Invalid version byte 32"}},
+		"invalid_encryption_key": {func(c *Config) {
+			c.Versions[1].EncryptionKey = make([]byte, 10)
+		}, []string{"Version b: Invalid encryption key size 10 bytes"}},
+		"empty_hmac_key": {func(c *Config) {
+			c.Versions[1].HmacKey = nil
+		}, []string{"Version b: empty hmac key"}},
+		"unknown_tokenization_version": {func(c *Config) {
+			c.Versioner.TokenizationVersion = "c"
+			c.Versioner.DetokenizationVersions = "abc"
+		}, []string{"Version c not found in repo", "Version c not found in repo", "Version c not found in repo", "Version c not found in repo"}},
+		"unknown_tokenization_version_name": {func(c *Config) {
+			c.Versioner.TokenizationVersion = "2021-q3"
+		}, []string{"single-byte version id or a version name for tokenizationVersion, instead its 2021-q3"}},
+		"unknown_detokenization_version": {func(c *Config) {
+			c.Versioner.DetokenizationVersions = "abc"
+		}, []string{"Version c not found in repo", "Version c not found in repo"}},
+		"tokenization_version_not_detokenization_version": {func(c *Config) {
+			c.Versioner.DetokenizationVersions = "b"
+		}, []string{"tokenizationVersion a is not among the detokenizationVersions b"}},
+		"single_char_version_name": {func(c *Config) {
+			c.Versions[1].Name = "q"
+		}, []string{"Version b: name q should be longer than a single char"}},
+		"duplicated_version_name": {func(c *Config) {
+			c.Versions[1].Name = "2021-q1"
+		}, []string{"Version name 2021-q1 is used by more than one version"}},
+		"unknown_detokenization_version_name": {func(c *Config) {
+			c.Versioner.DetokenizationVersionNames = []string{"2021-q3"}
+		}, []string{"detokenizationVersionNames: unknown version name 2021-q3"}},
+		"missing_charsets": {func(c *Config) {
+			delete(c.CharSets, "14")
+			delete(c.CharSets, "32")
+		}, []string{"charSets missing for bases [14, 32]", "Error while retriving alphabet for base 32"}},
+		"unexpected_charsets": {func(c *Config) {
+			c.CharSets["10"] = "0123456789"
+			c.CharSets["hex"] = "0123456789abcdef"
+		}, []string{"unexpected charSets for bases [10, hex]"}},
+		"wrong_size_alphabet": {func(c *Config) {
+			c.CharSets["14"] = "0123456789"
+		}, []string{"Got alphabet size 10 for base 14", "alphabet for base 14 has 10 symbols: it can't encode 8 middle digits with 7 symbols"}},
+		// the same problem found by the alphabet provider check is reported once
+		"duplicated_symbols_alphabet": {func(c *Config) {
+			c.CharSets["14"] = "0123456789abca"
+		}, []string{"alphabet for base 14 contains duplicated elements"}},
+		// all the problems are reported at once
+		"several_problems": {func(c *Config) {
+			c.Versions[1].HmacKey = nil
+			c.Versioner.DetokenizationVersions = "b"
+			delete(c.CharSets, "14")
+		}, []string{"Version b: empty hmac key", "tokenizationVersion a is not among the detokenizationVersions b", "charSets missing for bases [14]", "Error while retriving alphabet for base 14"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := validConfig(t)
+			tt.edit(&c)
+			errs := Validate(c)
+			if len(errs) != len(tt.want) {
+				t.Fatalf("Validate() = %v, want %d errors %v", errs, len(tt.want), tt.want)
+			}
+			for i, err := range errs {
+				if !strings.Contains(err.Error(), tt.want[i]) {
+					t.Errorf("Validate() error %d = %v, want it to contain %q", i, err, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNewEngine(t *testing.T) {
+	c := validConfig(t)
+	c.Versioner.TokenizationVersion = "2021-q2"
+	e, err := NewEngine(&c)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	tk, err := e.EncryptCC("4444333322221111")
+	if err != nil {
+		t.Fatalf("EncryptCC() error = %v", err)
+	}
+	// the version name is resolved into the version id embedded in the token
+	if tk[6] != 'b' {
+		t.Errorf("EncryptCC() = %v, want version b", tk)
+	}
+	if cc, err := e.DecryptTK(tk); err != nil || cc != "4444333322221111" {
+		t.Errorf("DecryptTK() = %v, %v, want 4444333322221111", cc, err)
+	}
+
+	c.Versioner.DetokenizationVersions = "a"
+	if _, err := NewEngine(&c); err == nil || !strings.HasPrefix(err.Error(), "invalid configuration: [") {
+		t.Errorf("NewEngine() with an invalid Config error = %v, want an invalid configuration", err)
+	}
+	if _, err := NewEngine(nil); err == nil {
+		t.Errorf("NewEngine(nil) error = nil, want an error")
+	}
+}
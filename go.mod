@@ -2,4 +2,10 @@ module crypto-token
 
 go 1.14
 
-require github.com/capitalone/fpe v1.2.1
+require (
+	github.com/capitalone/fpe v1.2.1
+	github.com/golang/protobuf v1.4.2
+	golang.org/x/crypto v0.0.0-20210513164829-c07d793c2f9a
+	google.golang.org/grpc v1.38.0
+	google.golang.org/protobuf v1.25.0
+)
@@ -0,0 +1,5 @@
+package grpc
+
+// The messages, the client and the service registration are generated from tokenizer.proto
+// with protoc-gen-go v1.25.0 and protoc-gen-go-grpc v1.1.0.
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative tokenizer.proto
@@ -0,0 +1,87 @@
+// Package grpc exposes a tokenization engine as the Tokenizer gRPC service
+// defined in tokenizer.proto. The package is a thin transport layer: all the
+// crypto is delegated to the tkengine.TKEngine it wraps. The messages, client
+// and service registration are generated from tokenizer.proto (see generate.go).
+package grpc
+
+import (
+	"context"
+	"crypto-token/tkengine"
+
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NewGRPCServer returns a gRPC server exposing engine as the Tokenizer service.
+// Extra server options (e.g. credentials, interceptors) can be passed in opts.
+func NewGRPCServer(engine tkengine.TKEngine, opts ...gogrpc.ServerOption) *gogrpc.Server {
+	s := gogrpc.NewServer(opts...)
+	RegisterTokenizerServer(s, NewTokenizerServer(engine))
+	return s
+}
+
+// NewTokenizerServer returns the Tokenizer service implementation backed by engine
+func NewTokenizerServer(engine tkengine.TKEngine) TokenizerServer {
+	return &server{engine: engine}
+}
+
+type server struct {
+	UnimplementedTokenizerServer
+	engine tkengine.TKEngine
+}
+
+// Tokenize encrypts the requested credit-card
+func (s *server) Tokenize(_ context.Context, req *TokenizeRequest) (*TokenizeResponse, error) {
+	tk, err := s.engine.EncryptCC(req.Cc)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &TokenizeResponse{Tk: tk}, nil
+}
+
+// Detokenize decrypts the requested token
+func (s *server) Detokenize(_ context.Context, req *DetokenizeRequest) (*DetokenizeResponse, error) {
+	cc, err := s.engine.DecryptTK(req.Tk)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &DetokenizeResponse{Cc: cc}, nil
+}
+
+// TokenizeBatch encrypts the requested credit-cards. A credit-card that can't be
+// tokenized does not fail the whole batch: the kind of error (the name of its gRPC
+// code, e.g. InvalidArgument) is reported in its result. A canceled or expired
+// request fails with Canceled or DeadlineExceeded.
+func (s *server) TokenizeBatch(ctx context.Context, req *TokenizeBatchRequest) (*TokenizeBatchResponse, error) {
+	results := make([]*TokenizeResult, 0, len(req.Ccs))
+	for _, cc := range req.Ccs {
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+		tk, err := s.engine.EncryptCC(cc)
+		if err != nil {
+			results = append(results, &TokenizeResult{Error: toCode(err).String()})
+			continue
+		}
+		results = append(results, &TokenizeResult{Tk: tk})
+	}
+	return &TokenizeBatchResponse{Results: results}, nil
+}
+
+// toStatus maps the engine errors to gRPC status (see toCode)
+func toStatus(err error) error {
+	return status.Error(toCode(err), err.Error())
+}
+
+// toCode classifies the engine errors: invalid inputs are reported as InvalidArgument,
+// unavailable keys as Unavailable, any other failure as Internal
+func toCode(err error) codes.Code {
+	if tkengine.IsInvalidInput(err) {
+		return codes.InvalidArgument
+	}
+	if tkengine.IsUnavailable(err) {
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
@@ -0,0 +1,118 @@
+package grpc
+
+import (
+	"context"
+	"crypto-token/tkengine"
+	"net"
+	"testing"
+	"time"
+
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestClient(t *testing.T) TokenizerClient {
+	engine, err := tkengine.NewDummyEngine()
+	if err != nil {
+		t.Fatalf("NewDummyEngine() error = %v", err)
+	}
+	lis := bufconn.Listen(1024 * 1024)
+	s := NewGRPCServer(engine)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := gogrpc.Dial("bufnet",
+		gogrpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.Dial() }),
+		gogrpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewTokenizerClient(conn)
+}
+
+func TestTokenizer_roundTrip(t *testing.T) {
+	client := newTestClient(t)
+	tests := map[string]struct {
+		cc      string
+		wantErr bool
+	}{
+		"nominal_16_digits":          {"4444333322221111", false},
+		"nominal_19_digits":          {"4444333322221111222", false},
+		"domain_too_small_13_digits": {"4444333322221", true},
+		"invalid_cc":                 {"A444333322221111", true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tkResp, err := client.Tokenize(context.Background(), &TokenizeRequest{Cc: tt.cc})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Tokenize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				if status.Code(err) != codes.InvalidArgument {
+					t.Errorf("Tokenize() code = %v, want %v", status.Code(err), codes.InvalidArgument)
+				}
+				return
+			}
+			ccResp, err := client.Detokenize(context.Background(), &DetokenizeRequest{Tk: tkResp.Tk})
+			if err != nil {
+				t.Errorf("Detokenize() error = %v", err)
+				return
+			}
+			if ccResp.Cc != tt.cc {
+				t.Errorf("Detokenize() got = %v, want %v", ccResp.Cc, tt.cc)
+			}
+		})
+	}
+}
+
+func TestTokenizer_TokenizeBatch(t *testing.T) {
+	client := newTestClient(t)
+	req := &TokenizeBatchRequest{Ccs: []string{"4444333322221111", "A444333322221111", "4444333322221112"}}
+	resp, err := client.TokenizeBatch(context.Background(), req)
+	if err != nil {
+		t.Fatalf("TokenizeBatch() error = %v", err)
+	}
+	if len(resp.Results) != len(req.Ccs) {
+		t.Fatalf("TokenizeBatch() got %d results, want %d", len(resp.Results), len(req.Ccs))
+	}
+	for i, wantErr := range []string{"", codes.InvalidArgument.String(), ""} {
+		r := resp.Results[i]
+		if r.Error != wantErr {
+			t.Errorf("TokenizeBatch() result %d error = %v, want %v", i, r.Error, wantErr)
+		}
+		if (r.Tk == "") != (wantErr != "") {
+			t.Errorf("TokenizeBatch() result %d token = %v, want error %v", i, r.Tk, wantErr)
+		}
+	}
+}
+
+func TestTokenizer_TokenizeBatch_contextDone(t *testing.T) {
+	engine, err := tkengine.NewDummyEngine()
+	if err != nil {
+		t.Fatalf("NewDummyEngine() error = %v", err)
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	tests := map[string]struct {
+		ctx      context.Context
+		wantCode codes.Code
+	}{
+		"canceled": {canceled, codes.Canceled},
+		"expired":  {expired, codes.DeadlineExceeded},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewTokenizerServer(engine).TokenizeBatch(tt.ctx, &TokenizeBatchRequest{Ccs: []string{"4444333322221111"}})
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("TokenizeBatch() code = %v, want %v", status.Code(err), tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+// Package http exposes a tokenization engine as a JSON REST API. The package is a
+// thin transport layer: all the crypto is delegated to the tkengine.TKEngine it wraps.
+package http
+
+import (
+	"crypto-token/tkengine"
+	"encoding/json"
+	nethttp "net/http"
+)
+
+// MaxRequestBodySize is the maximum size in bytes of a request body: the requests carry a single card
+// or token, bounded by the engine maximum input length (see tkengine.WithMaxInputLength)
+const MaxRequestBodySize = 1 << 10
+
+// TokenizeRequest is the body of a POST /tokenize request
+type TokenizeRequest struct {
+	Cc string `json:"cc"`
+}
+
+// TokenizeResponse is the body of a successful POST /tokenize response
+type TokenizeResponse struct {
+	Tk string `json:"tk"`
+}
+
+// DetokenizeRequest is the body of a POST /detokenize request
+type DetokenizeRequest struct {
+	Tk string `json:"tk"`
+}
+
+// DetokenizeResponse is the body of a successful POST /detokenize response
+type DetokenizeResponse struct {
+	Cc string `json:"cc"`
+}
+
+// ErrorResponse is the body of a failed response
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewHandler returns a handler serving POST /tokenize and POST /detokenize with engine
+func NewHandler(engine tkengine.TKEngine) nethttp.Handler {
+	mux := nethttp.NewServeMux()
+	mux.Handle("/tokenize", TokenizeHandler(engine))
+	mux.Handle("/detokenize", DetokenizeHandler(engine))
+	return mux
+}
+
+// TokenizeHandler returns a handler encrypting the credit-card of a TokenizeRequest
+func TokenizeHandler(engine tkengine.TKEngine) nethttp.Handler {
+	return nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		var req TokenizeRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		tk, err := engine.EncryptCC(req.Cc)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, nethttp.StatusOK, TokenizeResponse{Tk: tk})
+	})
+}
+
+// DetokenizeHandler returns a handler decrypting the token of a DetokenizeRequest
+func DetokenizeHandler(engine tkengine.TKEngine) nethttp.Handler {
+	return nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		var req DetokenizeRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		cc, err := engine.DecryptTK(req.Tk)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, nethttp.StatusOK, DetokenizeResponse{Cc: cc})
+	})
+}
+
+// decodeRequest decodes the JSON body of a POST request into v. If the request
+// can't be decoded an error response is written and false is returned.
+func decodeRequest(w nethttp.ResponseWriter, r *nethttp.Request, v interface{}) bool {
+	if r.Method != nethttp.MethodPost {
+		w.Header().Set("Allow", nethttp.MethodPost)
+		writeJSON(w, nethttp.StatusMethodNotAllowed, ErrorResponse{Error: "method not allowed"})
+		return false
+	}
+	if err := json.NewDecoder(nethttp.MaxBytesReader(w, r.Body, MaxRequestBodySize)).Decode(v); err != nil {
+		writeJSON(w, nethttp.StatusBadRequest, ErrorResponse{Error: "malformed JSON body"})
+		return false
+	}
+	return true
+}
+
+// writeError maps the engine errors to HTTP status codes: invalid inputs are reported as 400, any
+// other failure, key repository failures included, as 500. The body only names the status class:
+// the engine errors may carry key repository and version details which are not meant for clients.
+func writeError(w nethttp.ResponseWriter, err error) {
+	if tkengine.IsInvalidInput(err) {
+		writeJSON(w, nethttp.StatusBadRequest, ErrorResponse{Error: "invalid input"})
+		return
+	}
+	writeJSON(w, nethttp.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+}
+
+func writeJSON(w nethttp.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,151 @@
+package http
+
+import (
+	"crypto-token/tkengine"
+	"encoding/json"
+	"errors"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fixedVersioner struct{}
+
+func (fixedVersioner) GetTokenizationVersion() (byte, error) {
+	return 'a', nil
+}
+
+func (fixedVersioner) GetDetokenizationVersions() ([]byte, error) {
+	return []byte{'a'}, nil
+}
+
+type fixedKeyRepo struct {
+	err bool
+}
+
+func (f fixedKeyRepo) GetKey(_ byte) ([]byte, error) {
+	if f.err {
+		return nil, errors.New("version does not exist")
+	}
+	return []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, nil
+}
+
+func newTestEngine(t *testing.T, keyErr bool) tkengine.TKEngine {
+	e, err := tkengine.NewEngine(fixedVersioner{}, fixedKeyRepo{keyErr}, fixedKeyRepo{keyErr}, tkengine.DefaultAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	return e
+}
+
+func TestHandler(t *testing.T) {
+	tests := map[string]struct {
+		keyErr   bool
+		method   string
+		path     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		"nominal_tokenization": {
+			method:   nethttp.MethodPost,
+			path:     "/tokenize",
+			body:     `{"cc":"4444333322221111"}`,
+			wantCode: nethttp.StatusOK,
+			wantBody: `{"tk":"444433aapchc1111"}`,
+		},
+		"nominal_detokenization": {
+			method:   nethttp.MethodPost,
+			path:     "/detokenize",
+			body:     `{"tk":"444433aapchc1111"}`,
+			wantCode: nethttp.StatusOK,
+			wantBody: `{"cc":"4444333322221111"}`,
+		},
+		"invalid_cc_is_bad_request": {
+			method:   nethttp.MethodPost,
+			path:     "/tokenize",
+			body:     `{"cc":"A444333322221111"}`,
+			wantCode: nethttp.StatusBadRequest,
+			wantBody: `{"error":"invalid input"}`,
+		},
+		"invalid_tk_is_bad_request": {
+			method:   nethttp.MethodPost,
+			path:     "/detokenize",
+			body:     `{"tk":"444433fapchc1111"}`,
+			wantCode: nethttp.StatusBadRequest,
+			wantBody: `{"error":"invalid input"}`,
+		},
+		"malformed_json_is_bad_request": {
+			method:   nethttp.MethodPost,
+			path:     "/tokenize",
+			body:     `{"cc":`,
+			wantCode: nethttp.StatusBadRequest,
+			wantBody: `{"error":"malformed JSON body"}`,
+		},
+		"too_long_cc_is_bad_request": {
+			method:   nethttp.MethodPost,
+			path:     "/tokenize",
+			body:     `{"cc":"` + strings.Repeat("4", 65) + `"}`,
+			wantCode: nethttp.StatusBadRequest,
+			wantBody: `{"error":"invalid input"}`,
+		},
+		"oversized_body_is_bad_request": {
+			method:   nethttp.MethodPost,
+			path:     "/tokenize",
+			body:     `{"cc":"` + strings.Repeat("4", MaxRequestBodySize) + `"}`,
+			wantCode: nethttp.StatusBadRequest,
+			wantBody: `{"error":"malformed JSON body"}`,
+		},
+		"key_repo_failure_is_internal_error": {
+			keyErr:   true,
+			method:   nethttp.MethodPost,
+			path:     "/tokenize",
+			body:     `{"cc":"4444333322221111"}`,
+			wantCode: nethttp.StatusInternalServerError,
+			wantBody: `{"error":"internal error"}`,
+		},
+		"get_is_not_allowed": {
+			method:   nethttp.MethodGet,
+			path:     "/tokenize",
+			wantCode: nethttp.StatusMethodNotAllowed,
+			wantBody: `{"error":"method not allowed"}`,
+		},
+		"unknown_path": {
+			method:   nethttp.MethodPost,
+			path:     "/retokenize",
+			body:     `{"tk":"444433aapchc1111"}`,
+			wantCode: nethttp.StatusNotFound,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := NewHandler(newTestEngine(t, tt.keyErr))
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Errorf("ServeHTTP() code = %v, want %v", rec.Code, tt.wantCode)
+			}
+			if tt.wantBody == "" {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("ServeHTTP() Content-Type = %v, want application/json", ct)
+			}
+			var got, want interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("ServeHTTP() body %s is not JSON: %v", rec.Body.String(), err)
+			}
+			json.Unmarshal([]byte(tt.wantBody), &want)
+			if gotS, wantS := toJSON(got), toJSON(want); gotS != wantS {
+				t.Errorf("ServeHTTP() body = %v, want %v", gotS, wantS)
+			}
+		})
+	}
+}
+
+func toJSON(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
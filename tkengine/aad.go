@@ -0,0 +1,52 @@
+package tkengine
+
+// AADTokenizer is an optional interface of a TKEngine binding credit card tokens to additional data.
+// The engines built by this package implement it.
+type AADTokenizer interface {
+	// EncryptCCWithAAD is EncryptCC binding the token to aad (e.g. a
+	// customer ID): the token only decrypts to cc with the same aad
+	EncryptCCWithAAD(cc string, aad []byte) (string, error)
+	// DecryptTKWithAAD is DecryptTK for tokens produced by
+	// EncryptCCWithAAD: the same aad must be passed
+	DecryptTKWithAAD(tk string, aad []byte) (string, error)
+}
+
+// EncryptCCWithAAD is EncryptCC binding the token to aad, some additional data such as a customer ID:
+// aad is mixed into the FF1 tweak, so the token only decrypts back to cc when DecryptTKWithAAD is
+// given the same aad. This is domain separation, not authenticated encryption in the AEAD sense: the
+// token carries no authentication tag and decrypting it with another aad doesn't fail, it returns
+// another, unrelated card. Callers can detect most mismatches by checking the card with IsLuhnValid
+// (about one random card out of ten passes it). An empty aad gives the same token as EncryptCC, and
+// Retokenize only supports tokens produced without aad.
+func (e *engine) EncryptCCWithAAD(cc string, aad []byte) (tk string, err error) {
+	defer func() { e.audit(AuditTokenize, tk, e.ccVersionIndex(tk), len(cc), err) }()
+	defer e.recoverCipherPanic("EncryptCCWithAAD", &err)
+
+	return e.encryptCC(cc, aad)
+}
+
+// DecryptTKWithAAD decrypts a token produced by EncryptCCWithAAD with the same aad
+// (see EncryptCCWithAAD for what happens with another aad)
+func (e *engine) DecryptTKWithAAD(tk string, aad []byte) (_ string, err error) {
+	defer func() { e.audit(AuditDetokenize, tk, e.ccVersionIndex(tk), len(tk), err) }()
+	defer e.recoverCipherPanic("DecryptTKWithAAD", &err)
+
+	return e.decryptTK(tk, aad)
+}
+
+// EncryptNumericWithAAD is EncryptNumeric binding the token to aad the way EncryptCCWithAAD does. It is the
+// way to supply the tweak context of layouts preserving no digit, such as CreditCardFullFormat.
+func (e *engine) EncryptNumericWithAAD(value string, opts FormatOpts, aad []byte) (tk string, err error) {
+	defer func() { e.audit(AuditTokenize, tk, opts.PreservedPrefix, len(value), err) }()
+	defer e.recoverCipherPanic("EncryptNumericWithAAD", &err)
+
+	return e.encryptNumeric(value, opts, aad)
+}
+
+// DecryptNumericWithAAD decrypts a token produced by EncryptNumericWithAAD with the same opts and aad
+func (e *engine) DecryptNumericWithAAD(tk string, opts FormatOpts, aad []byte) (_ string, err error) {
+	defer func() { e.audit(AuditDetokenize, tk, opts.PreservedPrefix, len(tk), err) }()
+	defer e.recoverCipherPanic("DecryptNumericWithAAD", &err)
+
+	return e.decryptNumeric(tk, opts, aad)
+}
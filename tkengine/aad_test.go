@@ -0,0 +1,50 @@
+package tkengine
+
+import (
+	"testing"
+)
+
+func Test_engine_AAD(t *testing.T) {
+	keys := fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+	e, err := NewEngine(deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, keys, keys, DefaultAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	cc := "4111111111111111"
+	tests := map[string]struct {
+		encAAD   []byte
+		decAAD   []byte
+		wantSame bool
+	}{
+		"same_aad":       {encAAD: []byte("customer-1"), decAAD: []byte("customer-1"), wantSame: true},
+		"other_aad":      {encAAD: []byte("customer-1"), decAAD: []byte("customer-2")},
+		"missing_aad":    {encAAD: []byte("customer-1"), decAAD: nil},
+		"unexpected_aad": {encAAD: nil, decAAD: []byte("customer-1")},
+		"empty_aad":      {encAAD: []byte{}, decAAD: nil, wantSame: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tk, err := e.(AADTokenizer).EncryptCCWithAAD(cc, tt.encAAD)
+			if err != nil {
+				t.Fatalf("EncryptCCWithAAD() error = %v", err)
+			}
+			got, err := e.(AADTokenizer).DecryptTKWithAAD(tk, tt.decAAD)
+			if err != nil {
+				t.Fatalf("DecryptTKWithAAD() error = %v", err)
+			}
+			if (got == cc) != tt.wantSame {
+				t.Errorf("DecryptTKWithAAD() got = %v, want same card %v", got, tt.wantSame)
+			}
+			// a mismatching aad gives another card, caught by the Luhn check here
+			if !tt.wantSame && IsLuhnValid(got) {
+				t.Errorf("DecryptTKWithAAD() got Luhn-valid card %v with mismatching aad", got)
+			}
+		})
+	}
+
+	// without aad tokens are unchanged
+	tk, err := e.(AADTokenizer).EncryptCCWithAAD("4444333322221111", nil)
+	if err != nil || tk != "444433aapchc1111" {
+		t.Errorf("EncryptCCWithAAD() got = %v, %v, want 444433aapchc1111", tk, err)
+	}
+}
@@ -0,0 +1,136 @@
+package tkengine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FilteringAlphabetProvider is an AlphabetProvider decorator removing the symbols of Blocklist
+// from the alphabets of Provider, e.g. to exclude characters humans confuse (0/o, 1/l/i) from tokens
+// read or typed by people. For each base the first base symbols left after filtering are used:
+// Provider should hence supply more symbols than the base (e.g. the same pool of candidate
+// symbols for every base), and an error is returned if fewer than base symbols are left.
+type FilteringAlphabetProvider struct {
+	Provider  AlphabetProvider
+	Blocklist string
+}
+
+// NewFilteringAlphabetProvider returns a FilteringAlphabetProvider removing the symbols of blocklist
+// from the alphabets of p. An error is returned if the filtered alphabets are not valid for the engine,
+// e.g. if filtering leaves fewer symbols than required for a base.
+func NewFilteringAlphabetProvider(p AlphabetProvider, blocklist string) (*FilteringAlphabetProvider, error) {
+	f := &FilteringAlphabetProvider{Provider: p, Blocklist: blocklist}
+	if err := validateAlphabetProvider(f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// GetAlphabetForBase returns the first base symbols of the Provider alphabet which are not in the Blocklist
+func (f *FilteringAlphabetProvider) GetAlphabetForBase(base uint32) ([]byte, error) {
+	alpha, err := f.Provider.GetAlphabetForBase(base)
+	if err != nil {
+		return nil, err
+	}
+	filtered := make([]byte, 0, base)
+	for _, symbol := range alpha {
+		if len(filtered) == int(base) {
+			break
+		}
+		if strings.IndexByte(f.Blocklist, symbol) < 0 {
+			filtered = append(filtered, symbol)
+		}
+	}
+	if len(filtered) < int(base) {
+		return nil, errors.New(fmt.Sprintf("filtering [%s] out of the alphabet for base %d leaves %d symbols, %d are required", f.Blocklist, base, len(filtered), base))
+	}
+	return filtered, nil
+}
+
+// UppercaseAlphabetProvider is an AlphabetProvider decorator upper-casing the letters of the alphabets
+// of Provider (DefaultAlphabetProvider if nil), e.g. for systems storing tokens in uppercase-only fields.
+// Together with uppercase (or digit) versions, it produces tokens without lowercase letters.
+// Uppercase alphabets are distinct alphabets: tokens produced with the lowercase alphabets are not
+// valid for an engine using the uppercase ones, and vice versa. Moving to uppercase tokens requires
+// detokenizing the existing tokens and tokenizing the cards again: as the default base 32 alphabet
+// shares its digits with its uppercase version, NewEngineWithAlphabets can't combine them.
+type UppercaseAlphabetProvider struct {
+	Provider AlphabetProvider
+}
+
+// GetAlphabetForBase returns the Provider alphabet for base with its letters upper-cased
+func (u UppercaseAlphabetProvider) GetAlphabetForBase(base uint32) ([]byte, error) {
+	p := u.Provider
+	if p == nil {
+		p = DefaultAlphabetProvider{}
+	}
+	alpha, err := p.GetAlphabetForBase(base)
+	if err != nil {
+		return nil, err
+	}
+	upper := make([]byte, len(alpha))
+	for i, symbol := range alpha {
+		if 'a' <= symbol && symbol <= 'z' {
+			symbol -= 'a' - 'A'
+		}
+		upper[i] = symbol
+	}
+	return upper, nil
+}
+
+// MapAlphabetProvider is an AlphabetProvider serving the alphabets of a map indexed by base
+type MapAlphabetProvider map[uint32][]byte
+
+// GetAlphabetForBase returns the alphabet of the map for base
+func (m MapAlphabetProvider) GetAlphabetForBase(base uint32) ([]byte, error) {
+	alpha, ok := m[base]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("no alphabet for base %d", base))
+	}
+	return alpha, nil
+}
+
+// NewMapAlphabetProvider returns a MapAlphabetProvider serving charSets, a map of decimal bases to
+// their alphabets (e.g. "14": "abcdefghijklmn"). Every alphabet must have exactly base distinct symbols
+// and the bases used to tokenize credit cards (RequiredAlphabetBases) must be present.
+func NewMapAlphabetProvider(charSets map[string]string) (MapAlphabetProvider, error) {
+	// keys are sorted for a stable report, map iteration order being random
+	keys := make([]string, 0, len(charSets))
+	for key := range charSets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	m := make(MapAlphabetProvider, len(charSets))
+	for _, key := range keys {
+		base, err := strconv.ParseUint(key, 10, 32)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid base %q: bases should be decimal numbers", key))
+		}
+		alpha := []byte(charSets[key])
+		if err := ValidateAlphabet(uint32(base), alpha); err != nil {
+			return nil, err
+		}
+		m[uint32(base)] = alpha
+	}
+	if err := validateAlphabetProvider(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewAlphabetProviderFromReader returns a MapAlphabetProvider serving the alphabets of the JSON
+// object read from r, mapping decimal bases to their alphabets like the charSets of the CLI configuration
+// (see NewMapAlphabetProvider).
+func NewAlphabetProviderFromReader(r io.Reader) (AlphabetProvider, error) {
+	var charSets map[string]string
+	if err := json.NewDecoder(r).Decode(&charSets); err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid alphabets: %v", err))
+	}
+	return NewMapAlphabetProvider(charSets)
+}
@@ -0,0 +1,257 @@
+package tkengine
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// poolAlphabetProvider returns the same pool of candidate symbols for every base
+type poolAlphabetProvider string
+
+func (p poolAlphabetProvider) GetAlphabetForBase(base uint32) ([]byte, error) {
+	return []byte(p), nil
+}
+
+func TestNewFilteringAlphabetProvider(t *testing.T) {
+	alnum := poolAlphabetProvider("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
+	lower := poolAlphabetProvider("abcdefghijklmnopqrstuvwxyz0123456789")
+	tests := map[string]struct {
+		provider  AlphabetProvider
+		blocklist string
+		wantErr   bool
+	}{
+		"confusing_symbols":   {alnum, "0oO1lIi", false},
+		"empty_blocklist":     {alnum, "", false},
+		"too_few_symbols_32":  {lower, "0o1li", true},
+		"exact_size_provider": {DefaultAlphabetProvider{}, "o", true},
+		"unblocked_provider":  {DefaultAlphabetProvider{}, "!?", false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			f, err := NewFilteringAlphabetProvider(tt.provider, tt.blocklist)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewFilteringAlphabetProvider() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			for _, base := range []uint32{14, 15, 16, 18, 22, 32} {
+				alpha, err := f.GetAlphabetForBase(base)
+				if err != nil {
+					t.Errorf("GetAlphabetForBase(%d) error = %v", base, err)
+					continue
+				}
+				if len(alpha) != int(base) || strings.ContainsAny(string(alpha), tt.blocklist) {
+					t.Errorf("GetAlphabetForBase(%d) got = %s, want %d symbols out of [%s]", base, alpha, base, tt.blocklist)
+				}
+			}
+		})
+	}
+}
+
+func Test_engine_filteredAlphabet(t *testing.T) {
+	alpha, err := NewFilteringAlphabetProvider(poolAlphabetProvider("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"), "0oO1lIi")
+	if err != nil {
+		t.Fatalf("NewFilteringAlphabetProvider() error = %v", err)
+	}
+	keys := fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+	e, err := NewEngine(deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, keys, keys, alpha, WithMinDomainSize(shortCardsDomain))
+	if err != nil {
+		t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+	}
+	for _, cc := range []string{"4444333322221111", "4444339999999999999", "4444330002222"} {
+		tk, err := e.EncryptCC(cc)
+		if err != nil {
+			t.Errorf("EncryptCC(%s) error = %v", cc, err)
+			continue
+		}
+		if md := tk[7 : len(tk)-4]; strings.ContainsAny(md, "0oO1lIi") {
+			t.Errorf("EncryptCC(%s) got = %v, encoded middle digits contain blocked symbols", cc, tk)
+		}
+		got, err := e.DecryptTK(tk)
+		if err != nil || got != cc {
+			t.Errorf("DecryptTK(%s) got = %v, %v, want %v", tk, got, err, cc)
+		}
+	}
+}
+
+func Test_engine_uppercaseAlphabet(t *testing.T) {
+	keys := fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+	upper, err := NewEngine(deterministicVersioner{tokVersion: 'A', detokVersions: []byte{'A'}}, keys, keys, UppercaseAlphabetProvider{}, WithMinDomainSize(shortCardsDomain))
+	if err != nil {
+		t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+	}
+	lower, err := NewEngine(deterministicVersioner{tokVersion: 'A', detokVersions: []byte{'A'}}, keys, keys, DefaultAlphabetProvider{}, WithMinDomainSize(shortCardsDomain))
+	if err != nil {
+		t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+	}
+
+	for _, cc := range benchmarkCards {
+		t.Run(cc, func(t *testing.T) {
+			tk, err := upper.EncryptCC(cc)
+			if err != nil {
+				t.Fatalf("EncryptCC() error = %v", err)
+			}
+			if tk != strings.ToUpper(tk) {
+				t.Errorf("EncryptCC() got = %v, want no lowercase letter", tk)
+			}
+			got, err := upper.DecryptTK(tk)
+			if err != nil || got != cc {
+				t.Errorf("DecryptTK() got = %v, %v, want %v", got, err, cc)
+			}
+
+			// the same card is encrypted the same way and only encoded differently
+			lowerTK, err := lower.EncryptCC(cc)
+			if err != nil {
+				t.Fatalf("EncryptCC() error = %v", err)
+			}
+			if strings.ToUpper(lowerTK) != tk {
+				t.Errorf("EncryptCC() got = %v, want uppercase of %v", tk, lowerTK)
+			}
+			// but tokens of the two alphabets are not interchangeable
+			if lowerTK != tk && upper.(TokenInspector).IsToken(lowerTK) {
+				t.Errorf("IsToken(%v) = true for a lowercase token", lowerTK)
+			}
+		})
+	}
+}
+
+func TestNewAlphabetProviderFromReader(t *testing.T) {
+	// the default alphabets as JSON, with the given entries replaced or removed (empty value)
+	charSets := func(entries map[string]string) string {
+		var pairs []string
+		for _, base := range RequiredAlphabetBases() {
+			alpha, _ := DefaultAlphabetProvider{}.GetAlphabetForBase(base)
+			key := fmt.Sprint(base)
+			if a, ok := entries[key]; ok {
+				alpha = []byte(a)
+				delete(entries, key)
+			}
+			if len(alpha) > 0 {
+				pairs = append(pairs, fmt.Sprintf("%q: %q", key, alpha))
+			}
+		}
+		for key, alpha := range entries {
+			pairs = append(pairs, fmt.Sprintf("%q: %q", key, alpha))
+		}
+		return "{" + strings.Join(pairs, ", ") + "}"
+	}
+	tests := map[string]struct {
+		json    string
+		wantErr bool
+	}{
+		"default_alphabets": {charSets(map[string]string{}), false},
+		"extra_base":        {charSets(map[string]string{"12": "abcdefghijkl"}), false},
+		"invalid_json":      {"{\"14\": ", true},
+		"not_an_object":     {"[\"abcdefghijklmn\"]", true},
+		"invalid_base":      {charSets(map[string]string{"x": "abc"}), true},
+		"wrong_size":        {charSets(map[string]string{"14": "abcdefghijklm"}), true},
+		"duplicate_symbol":  {charSets(map[string]string{"14": "abcdefghijklma"}), true},
+		"missing_base":      {charSets(map[string]string{"32": ""}), true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			p, err := NewAlphabetProviderFromReader(strings.NewReader(tt.json))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewAlphabetProviderFromReader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			// the default alphabets produce the default tokens
+			e, err := NewEngine(deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, fixedKeyRepo{false, make([]byte, 16)}, fixedKeyRepo{false, make([]byte, 16)}, p)
+			if err != nil {
+				t.Fatalf("NewEngine() error = %v", err)
+			}
+			if tk, err := e.EncryptCC("4444333322221111"); err != nil || tk != "444433aapchc1111" {
+				t.Errorf("EncryptCC() = %s, %v, want 444433aapchc1111", tk, err)
+			}
+		})
+	}
+}
+
+func Test_isValidTK_baseFromLength(t *testing.T) {
+	vers := []byte{'a'}
+	// every middle symbol belongs to some base alphabet: only the base of the token length counts
+	tests := map[string]struct {
+		tk   string
+		want bool
+	}{
+		"13_base32_symbols": {"444433az51111", true},
+		"14_base22_symbol":  {"444433aabv1111", true},
+		"14_base32_symbol":  {"444433aabw1111", false},
+		"15_base18_symbol":  {"444433aabcr1111", true},
+		"15_base22_symbol":  {"444433aabcs1111", false},
+		"16_base16_symbol":  {"444433aabcdp1111", true},
+		"16_base18_symbol":  {"444433aabcdq1111", false},
+		"16_base32_symbol":  {"444433aabcdz1111", false},
+		"17_base15_symbol":  {"444433aabcdeo1111", true},
+		"17_base16_symbol":  {"444433aabcdep1111", false},
+		"19_base14_symbol":  {"444433aabcdefgn1111", true},
+		"19_base15_symbol":  {"444433aabcdefgo1111", false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := isValidTK(tt.tk, DefaultAlphabetProvider{}, vers); got != tt.want {
+				t.Errorf("isValidTK(%v) = %v, want %v", tt.tk, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_decodeTkMD_baseFromLength(t *testing.T) {
+	alpha := DefaultAlphabetProvider{}
+	bases := []uint32{12, 13, 14, 15, 16, 18, 22, 32}
+	for l := 2; l <= 18; l++ {
+		// 'b' is the symbol 1 of every base: the value of tkMD differs in each base
+		tkMD := strings.Repeat("b", l)
+		base, err := encodingBaseToSaveOneChar(l + 1)
+		if err != nil {
+			t.Fatalf("encodingBaseToSaveOneChar(%d) error = %v", l+1, err)
+		}
+		got, err := decodeTkMD(tkMD, alpha)
+		if err != nil {
+			t.Errorf("decodeTkMD(%v) error = %v", tkMD, err)
+			continue
+		}
+		for _, b := range bases {
+			a, _ := alpha.GetAlphabetForBase(b)
+			n, err := DecodeBaseN(tkMD, a)
+			if err != nil {
+				// beyond the uint64 range in a larger base, it can't match
+				continue
+			}
+			if match := got == fmt.Sprintf("%0*d", l+1, n); match != (b == base) {
+				t.Errorf("decodeTkMD(%v) = %v, decoding in base %d = %d, want the base %d decoding only", tkMD, got, b, n, base)
+			}
+		}
+	}
+}
+
+func Test_engine_symbolsMappingDifferentlyAcrossBases(t *testing.T) {
+	// the same symbols in a different order in each base
+	pool := "abcdefghijklmnopqrstuvwxyz012345"
+	alpha := MapAlphabetProvider{}
+	for i, b := range []uint32{12, 13, 14, 15, 16, 18, 22, 32} {
+		rotated := pool[i:] + pool[:i]
+		alpha[b] = []byte(rotated[:b])
+	}
+	keys := fixedKeyRepo{false, make([]byte, 16)}
+	e, err := NewEngine(deterministicVersioner{tokVersion: 'A', detokVersions: []byte{'A'}}, keys, keys, alpha, WithMinDomainSize(shortCardsDomain))
+	if err != nil {
+		t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+	}
+	for _, cc := range []string{"4444330002222", "44443300012222", "444433000012222", "4444333322221111",
+		"44443300000012222", "444433000000012222", "4444339999999999999"} {
+		tk, err := e.EncryptCC(cc)
+		if err != nil {
+			t.Errorf("EncryptCC(%s) error = %v", cc, err)
+			continue
+		}
+		if got, err := e.DecryptTK(tk); err != nil || got != cc {
+			t.Errorf("DecryptTK(%s) = %v, %v, want %v", tk, got, err, cc)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package tkengine
+
+import (
+	"errors"
+	"time"
+)
+
+// AuditOperation is the type of operation recorded by an AuditEvent
+type AuditOperation string
+
+const (
+	// AuditTokenize is recorded by EncryptCC and EncryptNumeric
+	AuditTokenize AuditOperation = "tokenize"
+	// AuditDetokenize is recorded by DecryptTK and DecryptNumeric
+	AuditDetokenize AuditOperation = "detokenize"
+	// AuditRetokenize is recorded by Retokenize and, for each token, by RetokenizeBatch
+	AuditRetokenize AuditOperation = "retokenize"
+)
+
+// AuditErrorKind classifies the failure of an audited operation. Error messages are
+// not recorded as they may contain card data.
+type AuditErrorKind string
+
+const (
+	// AuditErrNone is the error kind of successful operations
+	AuditErrNone AuditErrorKind = ""
+	// AuditErrInvalidInput is the error kind of operations rejecting their input format
+	AuditErrInvalidInput AuditErrorKind = "invalid_input"
+	// AuditErrDomainTooSmall is the error kind of operations failing with ErrDomainTooSmall
+	AuditErrDomainTooSmall AuditErrorKind = "domain_too_small"
+	// AuditErrInternal is the error kind of operations failing with ErrInternalCipherFailure
+	AuditErrInternal AuditErrorKind = "internal_cipher_failure"
+	// AuditErrKeyUnavailable is the error kind of operations failing with ErrKeyUnavailable
+	AuditErrKeyUnavailable AuditErrorKind = "key_unavailable"
+	// AuditErrTokenIntegrity is the error kind of operations failing with ErrTokenIntegrity
+	AuditErrTokenIntegrity AuditErrorKind = "token_integrity"
+	// AuditErrOther is the error kind of any other failure
+	AuditErrOther AuditErrorKind = "error"
+)
+
+// AuditEvent describes a tokenization or detokenization operation for compliance purposes.
+// It never carries card numbers nor tokens.
+type AuditEvent struct {
+	// Time is the completion time of the operation
+	Time time.Time
+	// Operation is the type of operation
+	Operation AuditOperation
+	// Version is the version of the produced or decrypted token, 0 if the operation failed
+	Version byte
+	// TokenLength is the length of the processed value, which is also the length of its token
+	TokenLength int
+	// ErrorKind is the kind of failure, AuditErrNone if the operation succeeded
+	ErrorKind AuditErrorKind
+}
+
+// AuditSink durably records the audit trail of an engine. Record is invoked synchronously
+// once per operation: implementations should be fast and safe for concurrent use.
+type AuditSink interface {
+	Record(event AuditEvent)
+}
+
+// WithAuditSink sets the sink recording an AuditEvent for every tokenization, detokenization and
+// retokenization performed by the engine. By default no audit trail is recorded.
+func WithAuditSink(s AuditSink) Option {
+	return func(e *engine) {
+		e.auditSink = s
+	}
+}
+
+// audit records an op event in the audit sink, if any, and counts the produced tokens per version. tk is the token the operation produced or
+// decrypted, its version being at index p, and l the length of the processed value.
+func (e *engine) audit(op AuditOperation, tk string, p int, l int, err error) {
+	if err == nil {
+		e.countVersion(op, tk, p)
+	}
+	if e.auditSink == nil {
+		return
+	}
+	event := AuditEvent{
+		Time:        time.Now(),
+		Operation:   op,
+		TokenLength: l,
+		ErrorKind:   auditErrorKind(err),
+	}
+	if err == nil && p >= 0 && p < len(tk) {
+		event.Version = tk[p]
+	}
+	e.auditSink.Record(event)
+}
+
+// auditErrorKind classifies err
+func auditErrorKind(err error) AuditErrorKind {
+	switch {
+	case err == nil:
+		return AuditErrNone
+	case errors.Is(err, ErrInvalidCC), errors.Is(err, ErrInvalidTK), errors.Is(err, ErrInvalidValue), errors.Is(err, ErrAlreadyTokenized), errors.Is(err, ErrTestPAN), errors.Is(err, ErrImplausiblePAN), errors.Is(err, ErrInputTooLong):
+		return AuditErrInvalidInput
+	case errors.Is(err, ErrDomainTooSmall):
+		return AuditErrDomainTooSmall
+	case errors.Is(err, ErrInternalCipherFailure):
+		return AuditErrInternal
+	case errors.Is(err, ErrKeyUnavailable):
+		return AuditErrKeyUnavailable
+	case errors.Is(err, ErrTokenIntegrity):
+		return AuditErrTokenIntegrity
+	default:
+		return AuditErrOther
+	}
+}
@@ -0,0 +1,124 @@
+package tkengine
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// recordingAuditSink keeps the recorded events in memory
+type recordingAuditSink struct {
+	events []AuditEvent
+}
+
+func (s *recordingAuditSink) Record(event AuditEvent) {
+	s.events = append(s.events, event)
+}
+
+func Test_engine_auditSink(t *testing.T) {
+	const cc, tk = "4444333322221111", "444433aapchc1111"
+	tests := map[string]struct {
+		op   func(e TKEngine) error
+		repo KeyRepo
+		want AuditEvent
+	}{
+		"tokenize": {
+			op:   func(e TKEngine) error { _, err := e.EncryptCC(cc); return err },
+			want: AuditEvent{Operation: AuditTokenize, Version: 'a', TokenLength: 16},
+		},
+		"tokenize_invalid_cc": {
+			op:   func(e TKEngine) error { _, err := e.EncryptCC("4444"); return err },
+			want: AuditEvent{Operation: AuditTokenize, TokenLength: 4, ErrorKind: AuditErrInvalidInput},
+		},
+		"tokenize_key_failure": {
+			op:   func(e TKEngine) error { _, err := e.EncryptCC(cc); return err },
+			repo: fixedKeyRepo{true, nil},
+			want: AuditEvent{Operation: AuditTokenize, TokenLength: 16, ErrorKind: AuditErrKeyUnavailable},
+		},
+		"tokenize_numeric": {
+			op: func(e TKEngine) error {
+				_, err := e.(NumericTokenizer).EncryptNumeric("123456789", FormatOpts{MinLength: 9, MaxLength: 9, PreservedSuffix: 4})
+				return err
+			},
+			want: AuditEvent{Operation: AuditTokenize, Version: 'a', TokenLength: 9},
+		},
+		"detokenize": {
+			op:   func(e TKEngine) error { _, err := e.DecryptTK(tk); return err },
+			want: AuditEvent{Operation: AuditDetokenize, Version: 'a', TokenLength: 16},
+		},
+		"detokenize_invalid_tk": {
+			op:   func(e TKEngine) error { _, err := e.DecryptTK("444433zapchc1111"); return err },
+			want: AuditEvent{Operation: AuditDetokenize, TokenLength: 16, ErrorKind: AuditErrInvalidInput},
+		},
+		"retokenize": {
+			op:   func(e TKEngine) error { _, err := e.(Retokenizer).Retokenize(tk); return err },
+			want: AuditEvent{Operation: AuditRetokenize, Version: 'a', TokenLength: 16},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			repo := tt.repo
+			if repo == nil {
+				repo = fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+			}
+			sink := &recordingAuditSink{}
+			e := &engine{
+				minDomainSize: &shortCardsDomain,
+				versioner: deterministicVersioner{
+					tokVersion:    byte('a'),
+					detokVersions: []byte{'a'},
+				},
+				encryptionKeys: repo,
+				hmacKeys:       repo,
+				alphaProvider:  DefaultAlphabetProvider{},
+			}
+			WithAuditSink(sink)(e)
+
+			err := tt.op(e)
+			if (err != nil) != (tt.want.ErrorKind != AuditErrNone) {
+				t.Fatalf("operation error = %v, want error kind %q", err, tt.want.ErrorKind)
+			}
+			if len(sink.events) != 1 {
+				t.Fatalf("recorded %d events, want 1: %v", len(sink.events), sink.events)
+			}
+			got := sink.events[0]
+			if got.Time.IsZero() {
+				t.Errorf("recorded event without time: %v", got)
+			}
+			got.Time = tt.want.Time
+			if got != tt.want {
+				t.Errorf("recorded event = %+v, want %+v", got, tt.want)
+			}
+			// the event never carries card data
+			if dump := fmt.Sprintf("%+v", got); strings.Contains(dump, "4444") || strings.Contains(dump, "1111") {
+				t.Errorf("recorded event leaks card data: %s", dump)
+			}
+		})
+	}
+}
+
+func Test_engine_auditSink_retokenizeBatch(t *testing.T) {
+	sink := &recordingAuditSink{}
+	e := &engine{
+		versioner: deterministicVersioner{
+			tokVersion:    byte('a'),
+			detokVersions: []byte{'a'},
+		},
+		encryptionKeys: fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		hmacKeys:       fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		alphaProvider:  DefaultAlphabetProvider{},
+		auditSink:      sink,
+	}
+	e.RetokenizeBatch([]string{"444433aapchc1111", "444433zapchc1111"}, nil)
+
+	// one event per token, passed through or not
+	want := []AuditErrorKind{AuditErrNone, AuditErrInvalidInput}
+	if len(sink.events) != len(want) {
+		t.Fatalf("recorded %d events, want %d: %v", len(sink.events), len(want), sink.events)
+	}
+	for i, event := range sink.events {
+		if event.Operation != AuditRetokenize || event.ErrorKind != want[i] {
+			t.Errorf("recorded event #%d = %+v, want %s retokenize", i, event, want[i])
+		}
+	}
+}
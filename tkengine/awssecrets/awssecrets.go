@@ -0,0 +1,165 @@
+// Package awssecrets provides a tkengine.KeyRepo serving the version keys stored in AWS Secrets
+// Manager. It is a module of its own, so that the AWS SDK is not a dependency of the applications
+// which don't store their keys in AWS.
+package awssecrets
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+// ErrVersionNotFound is returned by GetKey when the secret of the version doesn't exist
+var ErrVersionNotFound = errors.New("Version not found")
+
+// GetSecretValueAPI is the Secrets Manager operation used by the repository, implemented by *secretsmanager.Client
+type GetSecretValueAPI interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// Option configures a KeyRepo
+type Option func(r *KeyRepo)
+
+// WithClient reads the secrets with client instead of a Secrets Manager client of the default
+// AWS configuration, e.g. a client with custom credentials or endpoint
+func WithClient(client GetSecretValueAPI) Option {
+	return func(r *KeyRepo) {
+		r.client = client
+	}
+}
+
+// WithTimeout bounds every Secrets Manager call to timeout, none by default
+func WithTimeout(timeout time.Duration) Option {
+	return func(r *KeyRepo) {
+		r.timeout = timeout
+	}
+}
+
+// KeyRepo is a key repository resolving the versions to secrets of AWS Secrets Manager. The secret
+// of a version holds its key either as binary (SecretBinary) or as a hex string (SecretString).
+// The keys are cached for a TTL, so that the engine doesn't call Secrets Manager on every operation
+// while the keys rotated in Secrets Manager are picked up once the cached ones expire. As a KeyRepo
+// serves one key per version, encryption and HMAC keys use two repositories with distinct templates.
+type KeyRepo struct {
+	nameTemplate string
+	ttl          time.Duration
+	timeout      time.Duration
+	client       GetSecretValueAPI
+	// now returns the current time, time.Now outside of the tests
+	now func() time.Time
+
+	// mu guards cache
+	mu    sync.Mutex
+	cache map[byte]cachedKey
+}
+
+// cachedKey is a key retrieved from Secrets Manager and the time it expires at
+type cachedKey struct {
+	key     []byte
+	expires time.Time
+}
+
+// NewKeyRepo returns a key repository reading the secrets of region named after nameTemplate, a
+// format with a single %c verb replaced by the version (e.g. "tk/%c/enc"), and caching the keys for
+// ttl. A zero ttl disables the cache. Unless WithClient is given, the secrets are read with a client
+// of the default AWS configuration: its credentials come from the SDK default chain (environment,
+// shared configuration files, container or instance roles...).
+func NewKeyRepo(region string, nameTemplate string, ttl time.Duration, opts ...Option) (*KeyRepo, error) {
+	if region == "" {
+		return nil, errors.New("Missing AWS region")
+	}
+	if strings.Count(nameTemplate, "%c") != 1 || strings.Count(nameTemplate, "%") != 1 {
+		return nil, errors.New(fmt.Sprintf("Invalid secret name template %q: it should hold a single %%c verb", nameTemplate))
+	}
+	if ttl < 0 {
+		return nil, errors.New(fmt.Sprintf("Invalid cache TTL %v: it should not be negative", ttl))
+	}
+	r := &KeyRepo{
+		nameTemplate: nameTemplate,
+		ttl:          ttl,
+		now:          time.Now,
+		cache:        map[byte]cachedKey{},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.client == nil {
+		cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(region))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid AWS configuration: %v", err))
+		}
+		r.client = secretsmanager.NewFromConfig(cfg)
+	}
+	return r, nil
+}
+
+// GetKey returns the key of version v, from the cache if it hasn't expired and from Secrets Manager
+// otherwise. ErrVersionNotFound is returned if the secret of the version doesn't exist.
+func (r *KeyRepo) GetKey(v byte) ([]byte, error) {
+	r.mu.Lock()
+	c, ok := r.cache[v]
+	r.mu.Unlock()
+	if ok && r.now().Before(c.expires) {
+		return c.key, nil
+	}
+
+	key, err := r.fetch(v)
+	if err != nil {
+		return nil, err
+	}
+	if r.ttl > 0 {
+		r.mu.Lock()
+		r.cache[v] = cachedKey{key: key, expires: r.now().Add(r.ttl)}
+		r.mu.Unlock()
+	}
+	return key, nil
+}
+
+// Clear drops the cached keys, e.g. right after a rotation
+func (r *KeyRepo) Clear() {
+	r.mu.Lock()
+	r.cache = map[byte]cachedKey{}
+	r.mu.Unlock()
+}
+
+// fetch retrieves the key of version v from Secrets Manager
+func (r *KeyRepo) fetch(v byte) ([]byte, error) {
+	name := fmt.Sprintf(r.nameTemplate, v)
+	ctx := context.Background()
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	out, err := r.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(name)})
+	if err != nil {
+		var notFound *types.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			return nil, fmt.Errorf("%w: version %s, secret %s", ErrVersionNotFound, string(v), name)
+		}
+		return nil, errors.New(fmt.Sprintf("Secrets Manager request for secret %s failed: %v", name, err))
+	}
+
+	switch {
+	case len(out.SecretBinary) > 0:
+		return out.SecretBinary, nil
+	case out.SecretString != nil:
+		key, err := hex.DecodeString(strings.TrimSpace(*out.SecretString))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Secret %s is not hex encoded", name))
+		}
+		return key, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Secret %s holds no value", name))
+	}
+}
@@ -0,0 +1,173 @@
+package awssecrets
+
+import (
+	"bytes"
+	"context"
+	"crypto-token/tkengine"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+// secretsManager is a fake Secrets Manager serving secrets, the secret values being either
+// []byte (SecretBinary) or string (SecretString), and counting its GetSecretValue calls
+type secretsManager struct {
+	secrets map[string]interface{}
+	calls   int
+}
+
+func (m *secretsManager) GetSecretValue(_ context.Context, params *secretsmanager.GetSecretValueInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	m.calls++
+	name := aws.ToString(params.SecretId)
+	switch s := m.secrets[name].(type) {
+	case []byte:
+		return &secretsmanager.GetSecretValueOutput{Name: params.SecretId, SecretBinary: s}, nil
+	case string:
+		return &secretsmanager.GetSecretValueOutput{Name: params.SecretId, SecretString: aws.String(s)}, nil
+	case error:
+		return nil, s
+	default:
+		return nil, &types.ResourceNotFoundException{Message: aws.String("Secrets Manager can't find the specified secret.")}
+	}
+}
+
+func TestKeyRepo_GetKey(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 16)
+	secrets := map[string]interface{}{
+		"tk/a/enc": key,
+		"tk/b/enc": "01010101010101010101010101010101",
+		"tk/c/enc": "not hex",
+		"tk/e/enc": errors.New("throttled"),
+		"tk/f/enc": []byte{},
+	}
+	tests := map[string]struct {
+		version byte
+		want    []byte
+		wantErr error
+	}{
+		"secret_binary":   {'a', key, nil},
+		"secret_string":   {'b', key, nil},
+		"missing_secret":  {'d', nil, ErrVersionNotFound},
+		"malformed_value": {'c', nil, errors.New("Secret tk/c/enc is not hex encoded")},
+		"request_failure": {'e', nil, errors.New("Secrets Manager request for secret tk/e/enc failed: throttled")},
+		"no_value":        {'f', nil, errors.New("Secret tk/f/enc holds no value")},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r, err := NewKeyRepo("eu-west-1", "tk/%c/enc", time.Minute, WithClient(&secretsManager{secrets: secrets}))
+			if err != nil {
+				t.Fatalf("NewKeyRepo() error = %v", err)
+			}
+			got, err := r.GetKey(tt.version)
+			if (err != nil) != (tt.wantErr != nil) || err != nil && !errors.Is(err, tt.wantErr) && err.Error() != tt.wantErr.Error() {
+				t.Fatalf("GetKey() error = %v, want %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("GetKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyRepo_cache(t *testing.T) {
+	sm := &secretsManager{secrets: map[string]interface{}{"tk/a/hmac": make([]byte, 16)}}
+	tests := map[string]struct {
+		ttl       time.Duration
+		elapsed   time.Duration
+		wantCalls int
+	}{
+		"cached":         {time.Minute, 30 * time.Second, 1},
+		"expired":        {time.Minute, 2 * time.Minute, 2},
+		"cache_disabled": {0, 0, 2},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			sm.calls = 0
+			r, err := NewKeyRepo("eu-west-1", "tk/%c/hmac", tt.ttl, WithClient(sm))
+			if err != nil {
+				t.Fatalf("NewKeyRepo() error = %v", err)
+			}
+			now := time.Now()
+			r.now = func() time.Time { return now }
+			if _, err := r.GetKey('a'); err != nil {
+				t.Fatalf("GetKey() error = %v", err)
+			}
+			now = now.Add(tt.elapsed)
+			if _, err := r.GetKey('a'); err != nil {
+				t.Fatalf("GetKey() error = %v", err)
+			}
+			if sm.calls != tt.wantCalls {
+				t.Errorf("GetKey() called Secrets Manager %d times, want %d", sm.calls, tt.wantCalls)
+			}
+			r.Clear()
+			if _, err := r.GetKey('a'); err != nil || sm.calls != tt.wantCalls+1 {
+				t.Errorf("GetKey() after Clear() called Secrets Manager %d times, %v, want %d", sm.calls, err, tt.wantCalls+1)
+			}
+		})
+	}
+}
+
+func TestNewKeyRepo(t *testing.T) {
+	tests := map[string]struct {
+		region   string
+		template string
+		ttl      time.Duration
+		opts     []Option
+		wantErr  bool
+	}{
+		"nominal":        {"eu-west-1", "tk/%c/enc", time.Minute, []Option{WithClient(&secretsManager{})}, false},
+		"default_client": {"eu-west-1", "tk/%c/enc", time.Minute, []Option{WithTimeout(time.Second)}, false},
+		"missing_region": {"", "tk/%c/enc", time.Minute, []Option{WithClient(&secretsManager{})}, true},
+		"missing_verb":   {"eu-west-1", "tk/enc", time.Minute, []Option{WithClient(&secretsManager{})}, true},
+		"other_verb":     {"eu-west-1", "tk/%c/%s", time.Minute, []Option{WithClient(&secretsManager{})}, true},
+		"negative_ttl":   {"eu-west-1", "tk/%c/enc", -time.Minute, []Option{WithClient(&secretsManager{})}, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewKeyRepo(tt.region, tt.template, tt.ttl, tt.opts...); (err != nil) != tt.wantErr {
+				t.Errorf("NewKeyRepo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyRepo_engine(t *testing.T) {
+	zeros := make([]byte, 16)
+	sm := &secretsManager{secrets: map[string]interface{}{"tk/a/enc": zeros, "tk/a/hmac": zeros}}
+	encKeys, err := NewKeyRepo("eu-west-1", "tk/%c/enc", time.Minute, WithClient(sm))
+	if err != nil {
+		t.Fatalf("NewKeyRepo() error = %v", err)
+	}
+	hmacKeys, err := NewKeyRepo("eu-west-1", "tk/%c/hmac", time.Minute, WithClient(sm))
+	if err != nil {
+		t.Fatalf("NewKeyRepo() error = %v", err)
+	}
+	e, err := tkengine.NewEngineWithDefaultAlphabet(versioner{}, encKeys, hmacKeys)
+	if err != nil {
+		t.Fatalf("NewEngineWithDefaultAlphabet() error = %v", err)
+	}
+	// the engine closes the keys it gets, the cached keys must survive it
+	for i := 0; i < 2; i++ {
+		if tk, err := e.EncryptCC("4444333322221111"); err != nil || tk != "444433aapchc1111" {
+			t.Errorf("EncryptCC() = %v, %v, want 444433aapchc1111", tk, err)
+		}
+	}
+	if sm.calls != 2 {
+		t.Errorf("Secrets Manager called %d times, want 2", sm.calls)
+	}
+}
+
+// versioner tokenizes and detokenizes under version a
+type versioner struct{}
+
+func (versioner) GetTokenizationVersion() (byte, error) {
+	return 'a', nil
+}
+
+func (versioner) GetDetokenizationVersions() ([]byte, error) {
+	return []byte{'a'}, nil
+}
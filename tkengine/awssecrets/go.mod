@@ -0,0 +1,12 @@
+module crypto-token/tkengine/awssecrets
+
+go 1.15
+
+require (
+	crypto-token v0.0.0
+	github.com/aws/aws-sdk-go-v2 v1.16.2
+	github.com/aws/aws-sdk-go-v2/config v1.15.3
+	github.com/aws/aws-sdk-go-v2/service/secretsmanager v1.15.4
+)
+
+replace crypto-token => ../..
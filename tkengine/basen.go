@@ -0,0 +1,65 @@
+package tkengine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// EncodeBaseN encodes value in base len(alphabet) with exactly outLen symbols of alphabet, the most
+// significant first, left-padded with the first symbol of the alphabet. An error is returned if value
+// doesn't fit in outLen symbols or if the alphabet has fewer than 2 symbols.
+func EncodeBaseN(value uint64, alphabet []byte, outLen int) (string, error) {
+	if len(alphabet) < 2 {
+		return "", errors.New(fmt.Sprintf("Invalid alphabet of %d symbols: at least 2 symbols are required", len(alphabet)))
+	}
+	if outLen < 0 {
+		return "", errors.New(fmt.Sprintf("Invalid output length %d", outLen))
+	}
+	base := uint64(len(alphabet))
+
+	// fill the symbols from the least significant one
+	encoded := make([]byte, outLen)
+	n := value
+	for i := outLen - 1; i >= 0; i-- {
+		encoded[i] = alphabet[n%base]
+		n /= base
+	}
+	if n != 0 {
+		return "", errors.New(fmt.Sprintf("Value %d doesn't fit in %d symbols of base %d", value, outLen, base))
+	}
+	return string(encoded), nil
+}
+
+// DecodeBaseN is the inverse of EncodeBaseN: it decodes the symbols of encoded, written in base
+// len(alphabet) the most significant first, into their value. An error is returned if a symbol doesn't
+// belong to the alphabet, if the alphabet has duplicated or fewer than 2 symbols or if the value
+// exceeds the uint64 range.
+func DecodeBaseN(encoded string, alphabet []byte) (uint64, error) {
+	if len(alphabet) < 2 {
+		return 0, errors.New(fmt.Sprintf("Invalid alphabet of %d symbols: at least 2 symbols are required", len(alphabet)))
+	}
+	base := uint64(len(alphabet))
+
+	// build the alpha map for fast translation between byte and index
+	alphaMap := make(map[byte]uint64, len(alphabet))
+	for i, el := range alphabet {
+		alphaMap[el] = uint64(i)
+	}
+	if len(alphaMap) != len(alphabet) {
+		return 0, errors.New(fmt.Sprintf("alphabet for base %d contains duplicated elements [%v]", base, alphabet))
+	}
+
+	var n uint64
+	for _, b := range []byte(encoded) {
+		m, ok := alphaMap[b]
+		if !ok {
+			return 0, errors.New(fmt.Sprintf("Found char in token that does not belong to the alphabet: char %s ( byte %d)", string(b), b))
+		}
+		if n > (math.MaxUint64-m)/base {
+			return 0, errors.New(fmt.Sprintf("%d symbols of base %d decode to a value exceeding the uint64 range", len(encoded), base))
+		}
+		n = n*base + m
+	}
+	return n, nil
+}
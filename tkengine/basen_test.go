@@ -0,0 +1,75 @@
+package tkengine
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEncodeBaseN(t *testing.T) {
+	hex := []byte("0123456789abcdef")
+	tests := map[string]struct {
+		value    uint64
+		alphabet []byte
+		outLen   int
+		want     string
+		wantErr  bool
+	}{
+		"padded":         {255, hex, 4, "00ff", false},
+		"exact_length":   {255, hex, 2, "ff", false},
+		"zero":           {0, hex, 3, "000", false},
+		"zero_length":    {0, hex, 0, "", false},
+		"binary":         {5, []byte("01"), 4, "0101", false},
+		"max_uint64":     {math.MaxUint64, hex, 16, "ffffffffffffffff", false},
+		"overflow":       {256, hex, 2, "", true},
+		"nonzero_empty":  {1, hex, 0, "", true},
+		"negative_len":   {0, hex, -1, "", true},
+		"single_symbol":  {0, []byte("a"), 2, "", true},
+		"empty_alphabet": {0, nil, 2, "", true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := EncodeBaseN(tt.value, tt.alphabet, tt.outLen)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EncodeBaseN() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("EncodeBaseN() = %v, want %v", got, tt.want)
+			}
+			if tt.wantErr {
+				return
+			}
+			if back, err := DecodeBaseN(got, tt.alphabet); err != nil || back != tt.value {
+				t.Errorf("DecodeBaseN(%v) = %v, %v, want %v", got, back, err, tt.value)
+			}
+		})
+	}
+}
+
+func TestDecodeBaseN(t *testing.T) {
+	hex := []byte("0123456789abcdef")
+	tests := map[string]struct {
+		encoded  string
+		alphabet []byte
+		want     uint64
+		wantErr  bool
+	}{
+		"padded":          {"00ff", hex, 255, false},
+		"empty":           {"", hex, 0, false},
+		"max_uint64":      {"ffffffffffffffff", hex, math.MaxUint64, false},
+		"uint64_overflow": {"10000000000000000", hex, 0, true},
+		"foreign_symbol":  {"0g", hex, 0, true},
+		"duplicated":      {"ab", []byte("aab"), 0, true},
+		"single_symbol":   {"aa", []byte("a"), 0, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := DecodeBaseN(tt.encoded, tt.alphabet)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeBaseN() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DecodeBaseN() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package tkengine
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Benchmarks are named <Operation>/len=<card length> so that benchstat groups the
+// results per card length, e.g. go test -run=^$ -bench=. -count=10 ./tkengine | benchstat
+
+// benchmarkCards are valid credit cards covering the supported card lengths
+var benchmarkCards = []string{
+	"4444333322221",
+	"44443333222211",
+	"444433332222111",
+	"4444333322221111",
+	"44443333222211112",
+	"444433332222111122",
+	"4444333322221111222",
+}
+
+func benchmarkEngine() *engine {
+	return &engine{
+		versioner: deterministicVersioner{
+			tokVersion:    byte('a'),
+			detokVersions: []byte{'a', 'b', 'c', 'd'},
+		},
+		encryptionKeys: fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		hmacKeys:       fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		alphaProvider:  DefaultAlphabetProvider{},
+	}
+}
+
+func BenchmarkEncryptCC(b *testing.B) {
+	e := benchmarkEngine()
+	for _, cc := range benchmarkCards {
+		b.Run(fmt.Sprintf("len=%d", len(cc)), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := e.EncryptCC(cc); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecryptTK(b *testing.B) {
+	e := benchmarkEngine()
+	for _, cc := range benchmarkCards {
+		tk, err := e.EncryptCC(cc)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("len=%d", len(tk)), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := e.DecryptTK(tk); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkEncodeTkMD(b *testing.B) {
+	for _, cc := range benchmarkCards {
+		md := cc[6 : len(cc)-4]
+		b.Run(fmt.Sprintf("len=%d", len(cc)), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := encodeTkMD(md, DefaultAlphabetProvider{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkIsValidCC(b *testing.B) {
+	for _, cc := range benchmarkCards {
+		b.Run(fmt.Sprintf("len=%d", len(cc)), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if !isValidCC(cc) {
+					b.Fatal("invalid credit card")
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkTweak compares building a new HMAC for every tweak with resetting and reusing
+// the HMACs as done in batches
+func BenchmarkTweak(b *testing.B) {
+	hkey := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	sixByFour := sixByFourV0([]byte("4444333322221111"))
+	engines := map[string]*engine{
+		"hmac_new":    benchmarkEngine(),
+		"reset_reuse": benchmarkEngine().withHMACCache(),
+	}
+	for _, name := range []string{"hmac_new", "reset_reuse"} {
+		e := engines[name]
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := e.tweak('a', hkey, sixByFour); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
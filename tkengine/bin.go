@@ -0,0 +1,139 @@
+package tkengine
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// CreditCardEightDigitBINFormat is the layout of the credit cards with 8-digit BINs: 15 to 19 digits
+// preserving the first 8 and the last 4. Shorter cards would leave fewer than 3 middle digits to encrypt.
+var CreditCardEightDigitBINFormat = FormatOpts{
+	MinLength:       15,
+	MaxLength:       19,
+	PreservedPrefix: 8,
+	PreservedSuffix: 4,
+}
+
+// BINLengthDetector returns the length of the BIN, 6 or 8, of the card whose first 6 digits are firstSix.
+// It only gets the first 6 digits, which are preserved in every token, so that the BIN length of a card
+// is found from its token too. Any length other than 8 is considered 6.
+type BINLengthDetector func(firstSix string) int
+
+// WithBINLengthDetection preserves the first 8 digits of the cards whose BIN detect reports as 8-digit
+// long (CreditCardEightDigitBINFormat) instead of 6, for the tokens of versions. The version char then
+// follows the 8th digit: as with per-version layouts (see LayoutVersioner), the construction fails unless
+// the versions preserving 6 digits are not digits and the versions of versions are neither digits nor
+// alphabet symbols, so that the tokens of the older versions keep decrypting. Cards with an 8-digit BIN
+// shorter than 15 digits are rejected. The engine construction fails if detect is nil, if no version is
+// given or if a version of versions has a custom layout.
+func WithBINLengthDetection(detect BINLengthDetector, versions ...byte) Option {
+	return func(e *engine) {
+		e.binLength = detect
+		e.binVersions = make(map[byte]bool, len(versions))
+		for _, v := range versions {
+			e.binVersions[v] = true
+		}
+	}
+}
+
+// validateBINLengthDetection returns an error if the BIN length detection of the engine is misconfigured
+func (e *engine) validateBINLengthDetection() error {
+	if e.binVersions == nil {
+		return nil
+	}
+	if e.binLength == nil {
+		return errors.New("Invalid BIN length detection: missing detector")
+	}
+	if len(e.binVersions) == 0 {
+		return errors.New("Invalid BIN length detection: no version given")
+	}
+	return nil
+}
+
+// eightDigitBIN returns true if the card or token pan of version v preserves an 8-digit BIN
+func (e *engine) eightDigitBIN(v byte, pan string) bool {
+	return e.binVersions[v] && len(pan) >= 6 && e.binLength(pan[:6]) == 8
+}
+
+// cardFormat returns the layout of the card or token pan under version v: the layout of the version or,
+// if its BIN is detected as 8-digit long, CreditCardEightDigitBINFormat
+func (e *engine) cardFormat(v byte, pan string) (FormatOpts, error) {
+	if e.eightDigitBIN(v, pan) {
+		return CreditCardEightDigitBINFormat, nil
+	}
+	return e.ccFormat(v)
+}
+
+// checkCardLength returns an error wrapping kind if a card or token of l digits is too short for opts
+func checkCardLength(l int, opts FormatOpts, kind error) error {
+	if l < opts.MinLength {
+		return fmt.Errorf("%w: length %d out of range [%d, %d] of the layout preserving %d leading digits", kind, l, opts.MinLength, opts.MaxLength, opts.PreservedPrefix)
+	}
+	return nil
+}
+
+// CardNetwork is a payment card network
+type CardNetwork string
+
+const (
+	// NetworkVisa is the Visa network: BINs starting with 4
+	NetworkVisa CardNetwork = "visa"
+	// NetworkMastercard is the Mastercard network: BINs in 51-55 and 2221-2720
+	NetworkMastercard CardNetwork = "mastercard"
+	// NetworkAmex is the American Express network: BINs starting with 34 or 37
+	NetworkAmex CardNetwork = "amex"
+	// NetworkDiscover is the Discover network: BINs in 6011, 644-649 and 65
+	NetworkDiscover CardNetwork = "discover"
+	// NetworkDiners is the Diners Club network: BINs in 300-305, 36, 38 and 39
+	NetworkDiners CardNetwork = "diners"
+	// NetworkJCB is the JCB network: BINs in 3528-3589
+	NetworkJCB CardNetwork = "jcb"
+	// NetworkUnionPay is the UnionPay network: BINs starting with 62
+	NetworkUnionPay CardNetwork = "unionpay"
+)
+
+// DetectNetwork returns the network of the card or token pan out of its first 6 digits, an empty
+// network if it is unknown
+func DetectNetwork(pan string) CardNetwork {
+	if len(pan) < 6 || !isRadixString(pan[:6], 10) {
+		return ""
+	}
+	// the leading n digits of pan
+	lead := func(n int) int {
+		v, _ := strconv.Atoi(pan[:n])
+		return v
+	}
+	switch {
+	case lead(1) == 4:
+		return NetworkVisa
+	case lead(2) >= 51 && lead(2) <= 55, lead(4) >= 2221 && lead(4) <= 2720:
+		return NetworkMastercard
+	case lead(2) == 34, lead(2) == 37:
+		return NetworkAmex
+	case lead(4) == 6011, lead(3) >= 644 && lead(3) <= 649, lead(2) == 65:
+		return NetworkDiscover
+	case lead(3) >= 300 && lead(3) <= 305, lead(2) == 36, lead(2) == 38, lead(2) == 39:
+		return NetworkDiners
+	case lead(4) >= 3528 && lead(4) <= 3589:
+		return NetworkJCB
+	case lead(2) == 62:
+		return NetworkUnionPay
+	}
+	return ""
+}
+
+// EightDigitBINNetworks returns a BINLengthDetector reporting 8-digit BINs for the cards of networks
+// (see DetectNetwork) and 6-digit BINs for the others
+func EightDigitBINNetworks(networks ...CardNetwork) BINLengthDetector {
+	eight := make(map[CardNetwork]bool, len(networks))
+	for _, n := range networks {
+		eight[n] = true
+	}
+	return func(firstSix string) int {
+		if n := DetectNetwork(firstSix); n != "" && eight[n] {
+			return 8
+		}
+		return 6
+	}
+}
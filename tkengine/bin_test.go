@@ -0,0 +1,116 @@
+package tkengine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDetectNetwork(t *testing.T) {
+	tests := map[string]struct {
+		pan  string
+		want CardNetwork
+	}{
+		"visa":             {"4111111111111111", NetworkVisa},
+		"mastercard_51_55": {"5555555555554444", NetworkMastercard},
+		"mastercard_2_bin": {"2223003122003222", NetworkMastercard},
+		"amex":             {"378282246310005", NetworkAmex},
+		"discover":         {"6011111111111117", NetworkDiscover},
+		"discover_65":      {"6500000000000002", NetworkDiscover},
+		"diners":           {"30569309025904", NetworkDiners},
+		"jcb":              {"3530111333300000", NetworkJCB},
+		"unionpay":         {"6200000000000005", NetworkUnionPay},
+		"unknown":          {"9999999999999999", ""},
+		"token":            {"444433aapchc1111", NetworkVisa},
+		"too_short":        {"41111", ""},
+		"non_numeric":      {"4111a1111111111", ""},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := DetectNetwork(tt.pan); got != tt.want {
+				t.Errorf("DetectNetwork(%v) = %v, want %v", tt.pan, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_engine_BINLengthDetection(t *testing.T) {
+	keys := &keyRepo{keys: map[byte][]byte{'a': make([]byte, 16), 'B': make([]byte, 16)}}
+	// B preserves the 8-digit BINs of Visa cards, a is the former 6x4 version
+	versioner := deterministicVersioner{tokVersion: 'B', detokVersions: []byte{'a', 'B'}}
+	e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, WithBINLengthDetection(EightDigitBINNetworks(NetworkVisa), 'B'), WithMinDomainSize(shortCardsDomain))
+	if err != nil {
+		t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+	}
+
+	tests := map[string]struct {
+		cc         string
+		wantPrefix int
+		wantErr    error
+	}{
+		"visa_16_digits":       {"4444333322221111", 8, nil},
+		"visa_19_digits":       {"4444333322221111444", 8, nil},
+		"visa_15_digits":       {"444433332222111", 8, nil},
+		"visa_too_short":       {"44443333222211", 0, ErrInvalidCC},
+		"mastercard_16_digits": {"5555555555554444", 6, nil},
+		"mastercard_13_digits": {"5555555555554", 6, nil},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tk, err := e.EncryptCC(tt.cc)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("EncryptCC() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			p := tt.wantPrefix
+			if len(tk) != len(tt.cc) || tk[:p] != tt.cc[:p] || tk[p] != 'B' || tk[len(tk)-4:] != tt.cc[len(tt.cc)-4:] {
+				t.Errorf("EncryptCC() = %v, want the first %d and last 4 digits of %v around version B", tk, p, tt.cc)
+			}
+			if cc, err := e.DecryptTK(tk); err != nil || cc != tt.cc {
+				t.Errorf("DecryptTK(%v) = %v, %v, want %v", tk, cc, err, tt.cc)
+			}
+			if d, err := e.(TokenInspector).DescribeToken(tk); err != nil || d.PreservedPrefix != p || d.MiddleLength != len(tt.cc)-p-4 {
+				t.Errorf("DescribeToken(%v) = %v, %v, want a prefix of %d digits", tk, d, err, p)
+			}
+		})
+	}
+
+	// the tokens of the 6x4 version keep decrypting and are retokenized preserving 8 digits
+	cc, err := e.DecryptTK("444433aapchc1111")
+	if err != nil || cc != "4444333322221111" {
+		t.Fatalf("DecryptTK() = %v, %v, want 4444333322221111", cc, err)
+	}
+	want, _ := e.EncryptCC(cc)
+	if got, err := e.(Retokenizer).Retokenize("444433aapchc1111"); err != nil || got != want {
+		t.Errorf("Retokenize() = %v, %v, want %v", got, err, want)
+	}
+}
+
+func TestWithBINLengthDetection(t *testing.T) {
+	keys := &keyRepo{keys: map[byte][]byte{'a': make([]byte, 16), 'b': make([]byte, 16), 'B': make([]byte, 16)}}
+	visa := EightDigitBINNetworks(NetworkVisa)
+	tests := map[string]struct {
+		versioner KeyVersioner
+		opts      []Option
+		wantErr   bool
+	}{
+		"single_version":         {deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, []Option{WithBINLengthDetection(visa, 'a')}, false},
+		"uppercase_new_version":  {deterministicVersioner{tokVersion: 'B', detokVersions: []byte{'a', 'B'}}, []Option{WithBINLengthDetection(visa, 'B')}, false},
+		"ambiguous_new_version":  {deterministicVersioner{tokVersion: 'b', detokVersions: []byte{'a', 'b'}}, []Option{WithBINLengthDetection(visa, 'b')}, true},
+		"missing_detector":       {deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, []Option{WithBINLengthDetection(nil, 'a')}, true},
+		"no_version":             {deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, []Option{WithBINLengthDetection(visa)}, true},
+		"with_version_fallback":  {deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, []Option{WithBINLengthDetection(visa, 'a'), WithVersionFallback()}, true},
+		"with_fixed_token_width": {deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, []Option{WithBINLengthDetection(visa, 'a'), WithFixedTokenWidth(19)}, true},
+		"with_custom_layout":     {layoutVersioner{deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, map[byte][2]int{'a': {8, 2}}}, []Option{WithBINLengthDetection(visa, 'a')}, true},
+		"not_a_detoken_version":  {deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, []Option{WithBINLengthDetection(visa, 'z')}, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewEngine(tt.versioner, keys, keys, DefaultAlphabetProvider{}, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewEngine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
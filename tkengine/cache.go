@@ -0,0 +1,155 @@
+package tkengine
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// WithDecryptCache memoizes up to maxEntries detokenized cards for ttl, evicting the least recently used
+// ones first, for read-heavy workloads detokenizing the same tokens repeatedly.
+//
+// SECURITY: this keeps card numbers in the process memory, where they can't be wiped (Go strings are
+// immutable) until they are evicted and garbage collected. Only enable it where in-memory PANs are
+// acceptable and keep maxEntries and ttl as low as possible; ClearCache drops all the entries.
+//
+// Tokens are still validated against the current detokenization versions before the cache is looked
+// up, so retired versions are not served from the cache, and all the entries are dropped as soon as a
+// RefreshableKeyRepo of the engine reloads different keys. DecryptTKWithAAD and the version fallback
+// are not cached. The engine construction fails if maxEntries or ttl is not positive.
+func WithDecryptCache(maxEntries int, ttl time.Duration) Option {
+	return func(e *engine) {
+		e.decryptCache = &decryptCache{
+			maxEntries: maxEntries,
+			ttl:        ttl,
+			now:        time.Now,
+			lru:        list.New(),
+			entries:    make(map[string]*list.Element),
+		}
+	}
+}
+
+// CacheClearer is an optional interface of a TKEngine memoizing detokenized cards (see
+// WithDecryptCache). The engines built by this package implement it.
+type CacheClearer interface {
+	// ClearCache drops the detokenized cards memoized
+	// by the engine (see WithDecryptCache)
+	ClearCache()
+}
+
+// ClearCache drops all the cards memoized by WithDecryptCache. It does nothing on engines without cache.
+func (e *engine) ClearCache() {
+	if e.decryptCache != nil {
+		e.decryptCache.clear()
+	}
+}
+
+// validateDecryptCache returns an error if the decrypt cache of the engine is misconfigured
+func (e *engine) validateDecryptCache() error {
+	c := e.decryptCache
+	if c != nil && (c.maxEntries <= 0 || c.ttl <= 0) {
+		return errors.New(fmt.Sprintf("Invalid decrypt cache of %d entries for %v: both should be positive", c.maxEntries, c.ttl))
+	}
+	return nil
+}
+
+// keyGenerationRepo is implemented by the key repositories whose keys can change over time
+type keyGenerationRepo interface {
+	// keyGeneration changes every time the keys of the repository change
+	keyGeneration() uint64
+}
+
+// keyGeneration returns a value changing every time the keys of the engine change
+func (e *engine) keyGeneration() uint64 {
+	var g uint64
+	for _, repo := range []KeyRepo{e.encryptionKeys, e.hmacKeys} {
+		if gr, ok := repo.(keyGenerationRepo); ok {
+			g += gr.keyGeneration()
+		}
+	}
+	return g
+}
+
+// decryptCache is a LRU cache of detokenized cards indexed by token, safe for concurrent use
+type decryptCache struct {
+	maxEntries int
+	ttl        time.Duration
+	now        func() time.Time
+
+	// mu guards lru, entries and gen
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	// gen is the key generation of the cached entries
+	gen uint64
+}
+
+// decryptCacheEntry is the card of a token, cached until expires
+type decryptCacheEntry struct {
+	tk      string
+	cc      string
+	expires time.Time
+}
+
+// get returns the card cached for tk, if any. The whole cache is dropped if the keys changed since
+// the entries were cached, as gen differs.
+func (c *decryptCache) get(tk string, gen uint64) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		c.clearLocked()
+		c.gen = gen
+		return "", false
+	}
+	el, ok := c.entries[tk]
+	if !ok {
+		return "", false
+	}
+	entry := el.Value.(*decryptCacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.removeLocked(el)
+		return "", false
+	}
+	c.lru.MoveToFront(el)
+	return entry.cc, true
+}
+
+// put caches cc as the card of tk under the key generation gen
+func (c *decryptCache) put(tk string, cc string, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		c.clearLocked()
+		c.gen = gen
+	}
+	expires := c.now().Add(c.ttl)
+	if el, ok := c.entries[tk]; ok {
+		entry := el.Value.(*decryptCacheEntry)
+		entry.cc, entry.expires = cc, expires
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[tk] = c.lru.PushFront(&decryptCacheEntry{tk: tk, cc: cc, expires: expires})
+	for c.lru.Len() > c.maxEntries {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+// clear drops all the entries
+func (c *decryptCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clearLocked()
+}
+
+func (c *decryptCache) clearLocked() {
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+func (c *decryptCache) removeLocked(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*decryptCacheEntry).tk)
+}
@@ -0,0 +1,143 @@
+package tkengine
+
+import (
+	"container/list"
+	"testing"
+	"time"
+)
+
+func TestWithDecryptCache(t *testing.T) {
+	keys := fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	tests := map[string]struct {
+		maxEntries int
+		ttl        time.Duration
+		wantErr    bool
+	}{
+		"valid":            {10, time.Minute, false},
+		"zero_entries":     {0, time.Minute, true},
+		"negative_entries": {-1, time.Minute, true},
+		"zero_ttl":         {10, 0, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, WithDecryptCache(tt.maxEntries, tt.ttl))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewEngine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_engine_decryptCache(t *testing.T) {
+	const tk13, cc13 = "444433ad32221", "4444333322221"
+	const tk16, cc16 = "444433aapchc1111", "4444333322221111"
+	tests := map[string]struct {
+		ops       func(e TKEngine, now *time.Time)
+		wantCalls int
+	}{
+		"hit": {func(e TKEngine, now *time.Time) {
+			e.DecryptTK(tk16)
+			e.DecryptTK(tk16)
+		}, 2},
+		"expired": {func(e TKEngine, now *time.Time) {
+			e.DecryptTK(tk16)
+			*now = now.Add(time.Minute)
+			e.DecryptTK(tk16)
+		}, 4},
+		"evicted": {func(e TKEngine, now *time.Time) {
+			e.DecryptTK(tk16)
+			e.DecryptTK(tk13)
+			e.DecryptTK(tk16)
+			e.DecryptTK(tk13)
+		}, 8},
+		"cleared": {func(e TKEngine, now *time.Time) {
+			e.DecryptTK(tk16)
+			e.(CacheClearer).ClearCache()
+			e.DecryptTK(tk16)
+		}, 4},
+		"aad_not_cached": {func(e TKEngine, now *time.Time) {
+			e.(AADTokenizer).DecryptTKWithAAD(tk16, []byte("customer"))
+			e.(AADTokenizer).DecryptTKWithAAD(tk16, []byte("customer"))
+		}, 4},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			keys := &countingKeyRepo{keyRepo: keyRepo{keys: map[byte][]byte{'a': {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}}}
+			versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+			maxEntries := 10
+			if name == "evicted" {
+				maxEntries = 1
+			}
+			e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, WithDecryptCache(maxEntries, time.Minute))
+			if err != nil {
+				t.Fatalf("NewEngine() error = %v", err)
+			}
+			now := time.Unix(0, 0)
+			e.(*engine).decryptCache.now = func() time.Time { return now }
+
+			tt.ops(e, &now)
+			if keys.calls != tt.wantCalls {
+				t.Errorf("key repository called %d times, want %d", keys.calls, tt.wantCalls)
+			}
+			if cc, err := e.DecryptTK(tk16); err != nil || cc != cc16 {
+				t.Errorf("DecryptTK() = %v, %v, want %v", cc, err, cc16)
+			}
+			if cc, err := e.DecryptTK(tk13); err != nil || cc != cc13 {
+				t.Errorf("DecryptTK() = %v, %v, want %v", cc, err, cc13)
+			}
+		})
+	}
+}
+
+func Test_engine_decryptCache_keyRotation(t *testing.T) {
+	loader := &rotatingLoader{keys: map[byte][]byte{'a': {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}}
+	r, err := NewRefreshableKeyRepo(loader.load, time.Hour)
+	if err != nil {
+		t.Fatalf("NewRefreshableKeyRepo() error = %v", err)
+	}
+	defer r.Stop()
+	e, err := NewEngineWithDefaultAlphabet(deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, r, r, WithDecryptCache(10, time.Hour))
+	if err != nil {
+		t.Fatalf("NewEngineWithDefaultAlphabet() error = %v", err)
+	}
+	if cc, err := e.DecryptTK("444433aapchc1111"); err != nil || cc != "4444333322221111" {
+		t.Fatalf("DecryptTK() = %v, %v, want 4444333322221111", cc, err)
+	}
+
+	// reloading the same keys keeps the cache, new keys invalidate it
+	if err := r.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if len(e.(*engine).decryptCache.entries) != 1 {
+		t.Errorf("cache dropped on a reload of the same keys")
+	}
+	loader.set(map[byte][]byte{'a': {1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}}, false)
+	if err := r.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if cc, err := e.DecryptTK("444433aapchc1111"); err != nil || cc == "4444333322221111" {
+		t.Errorf("DecryptTK() = %v, %v, want the card decrypted with the rotated key", cc, err)
+	}
+}
+
+func Test_decryptCache_lru(t *testing.T) {
+	c := &decryptCache{maxEntries: 2, ttl: time.Minute, now: time.Now, lru: list.New(), entries: make(map[string]*list.Element)}
+	c.put("tk1", "cc1", 0)
+	c.put("tk2", "cc2", 0)
+	// tk1 becomes the most recently used, tk2 is evicted by tk3
+	if cc, ok := c.get("tk1", 0); !ok || cc != "cc1" {
+		t.Errorf("get(tk1) = %v, %v, want cc1", cc, ok)
+	}
+	c.put("tk3", "cc3", 0)
+	if _, ok := c.get("tk2", 0); ok {
+		t.Errorf("get(tk2) expected eviction")
+	}
+	if cc, ok := c.get("tk1", 0); !ok || cc != "cc1" {
+		t.Errorf("get(tk1) = %v, %v, want cc1", cc, ok)
+	}
+	// a new key generation drops every entry
+	if _, ok := c.get("tk3", 1); ok || c.lru.Len() != 0 {
+		t.Errorf("get(tk3) expected the cache to be dropped on a new key generation")
+	}
+}
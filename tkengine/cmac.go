@@ -0,0 +1,101 @@
+package tkengine
+
+import (
+	"crypto/aes"
+	"errors"
+	"fmt"
+)
+
+// WithCMACTweaks derives the FF1 tweaks of the given versions with AES-CMAC (NIST SP 800-38B), the HMAC key
+// of the version being used as AES key, instead of the tweak HMAC. It is meant for HSM-backed deployments
+// exposing AES-CMAC more efficiently than HMAC. CMAC tweaks are different from HMAC tweaks: a version's tweak
+// derivation can't change once it has tokens, CMAC must be enabled on new versions only. The HMAC keys of the
+// CMAC versions must be 16, 24 or 32 bytes long, and the engine construction fails if no version is given.
+func WithCMACTweaks(versions ...byte) Option {
+	return func(e *engine) {
+		e.cmacVersions = make(map[byte]bool, len(versions))
+		for _, v := range versions {
+			e.cmacVersions[v] = true
+		}
+	}
+}
+
+// validateCMACTweaks returns an error if CMAC tweaks are enabled for no version
+func (e *engine) validateCMACTweaks() error {
+	if e.cmacVersions != nil && len(e.cmacVersions) == 0 {
+		return errors.New("Invalid CMAC tweaks: no version given")
+	}
+	return nil
+}
+
+// versionTweak returns the FF1 tweak of input for version v with hkey, the key of the version
+func (e *engine) versionTweak(v byte, hkey []byte, input []byte) ([]byte, error) {
+	if !e.cmacVersions[v] {
+		return e.tweak(v, hkey, input)
+	}
+	tweak, err := cmac(hkey, input)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid CMAC tweak key of version %q: %v", v, err))
+	}
+	return tweak, nil
+}
+
+// cmac returns the AES-CMAC (RFC 4493) of msg with key
+func cmac(key []byte, msg []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	const bs = aes.BlockSize
+
+	// subkeys
+	k1 := make([]byte, bs)
+	block.Encrypt(k1, k1)
+	defer zero(k1)
+	cmacDouble(k1)
+	k2 := append([]byte(nil), k1...)
+	defer zero(k2)
+	cmacDouble(k2)
+
+	// last block, xored with k1 if complete, padded and xored with k2 otherwise
+	n := (len(msg) + bs - 1) / bs
+	if n == 0 {
+		n = 1
+	}
+	last := make([]byte, bs)
+	defer zero(last)
+	rest := msg[(n-1)*bs:]
+	if len(rest) == bs {
+		xorBytes(last, rest, k1)
+	} else {
+		copy(last, rest)
+		last[len(rest)] = 0x80
+		xorBytes(last, last, k2)
+	}
+
+	// CBC-MAC
+	mac := make([]byte, bs)
+	for i := 0; i < n-1; i++ {
+		xorBytes(mac, mac, msg[i*bs:(i+1)*bs])
+		block.Encrypt(mac, mac)
+	}
+	xorBytes(mac, mac, last)
+	block.Encrypt(mac, mac)
+	return mac, nil
+}
+
+// cmacDouble multiplies the block b by x in GF(2^128), in place
+func cmacDouble(b []byte) {
+	msb := b[0] >> 7
+	for i := 0; i < len(b)-1; i++ {
+		b[i] = b[i]<<1 | b[i+1]>>7
+	}
+	b[len(b)-1] = b[len(b)-1]<<1 ^ 0x87*msb
+}
+
+// xorBytes sets dst to x xor y, all of the same length
+func xorBytes(dst []byte, x []byte, y []byte) {
+	for i := range dst {
+		dst[i] = x[i] ^ y[i]
+	}
+}
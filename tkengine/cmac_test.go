@@ -0,0 +1,78 @@
+package tkengine
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func Test_cmac(t *testing.T) {
+	// RFC 4493 test vectors
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	msg, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+	tests := map[string]struct {
+		msg  []byte
+		want string
+	}{
+		"empty":     {msg[:0], "bb1d6929e95937287fa37d129b756746"},
+		"one_block": {msg[:16], "070a16b46b4d4144f79bdd9dd04a287c"},
+		"partial":   {msg[:40], "dfa66747de9ae63030ca32611497c827"},
+		"four":      {msg, "51f0bebf7e3b9d92fc49741779363cfe"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := cmac(key, tt.msg)
+			if err != nil {
+				t.Fatalf("cmac() error = %v", err)
+			}
+			if hex.EncodeToString(got) != tt.want {
+				t.Errorf("cmac() = %x, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithCMACTweaks(t *testing.T) {
+	keys := &keyRepo{keys: map[byte][]byte{
+		'a': {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		'b': {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		'c': {0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	}}
+	tests := map[string]struct {
+		versioner  deterministicVersioner
+		opts       []Option
+		cc         string
+		wantErr    bool
+		wantLegacy bool
+	}{
+		"cmac_version_16_digits":   {deterministicVersioner{tokVersion: 'b', detokVersions: []byte{'a', 'b'}}, []Option{WithCMACTweaks('b')}, "4444333322221111", false, false},
+		"cmac_version_13_digits":   {deterministicVersioner{tokVersion: 'b', detokVersions: []byte{'a', 'b'}}, []Option{WithCMACTweaks('b')}, "4444333322221", false, false},
+		"cmac_version_19_digits":   {deterministicVersioner{tokVersion: 'b', detokVersions: []byte{'a', 'b'}}, []Option{WithCMACTweaks('b')}, "4444333322221111999", false, false},
+		"hmac_version_unchanged":   {deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a', 'b'}}, []Option{WithCMACTweaks('b')}, "4444333322221111", false, true},
+		"invalid_cmac_key":         {deterministicVersioner{tokVersion: 'c', detokVersions: []byte{'c'}}, []Option{WithCMACTweaks('c')}, "4444333322221111", true, false},
+		"no_cmac_version_rejected": {deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, []Option{WithCMACTweaks()}, "4444333322221111", true, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngine(tt.versioner, keys, keys, DefaultAlphabetProvider{}, append(tt.opts, WithMinDomainSize(shortCardsDomain))...)
+			if err == nil {
+				var tk string
+				tk, err = e.EncryptCC(tt.cc)
+				if err == nil {
+					// CMAC tokens round trip
+					if cc, dErr := e.DecryptTK(tk); dErr != nil || cc != tt.cc {
+						t.Errorf("DecryptTK(%v) = %v, %v, want %v", tk, cc, dErr, tt.cc)
+					}
+					// and differ from the HMAC tokens of the same key
+					legacy, _ := NewEngine(deterministicVersioner{tokVersion: tt.versioner.tokVersion, detokVersions: tt.versioner.detokVersions}, keys, keys, DefaultAlphabetProvider{}, WithMinDomainSize(shortCardsDomain))
+					legacyTK, _ := legacy.EncryptCC(tt.cc)
+					if (legacyTK == tk) != tt.wantLegacy {
+						t.Errorf("EncryptCC() = %v, HMAC token %v, want same %v", tk, legacyTK, tt.wantLegacy)
+					}
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
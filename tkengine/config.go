@@ -0,0 +1,257 @@
+package tkengine
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// configPEMType is the type of the PEM block holding an exported engine configuration
+const configPEMType = "TKENGINE CONFIG"
+
+// configSignatureHeader is the PEM header holding the base64 Ed25519 signature of the configuration
+const configSignatureHeader = "Signature"
+
+// ErrConfigSignature is returned by ImportConfig when the configuration bundle is not signed by any of
+// the verification keys
+var ErrConfigSignature = errors.New("Config signature verification failure")
+
+// Config is the non-secret configuration of an engine: its versions, the token formats and layouts of
+// the versions and the alphabets. It carries no key material and hence can be distributed offline in
+// advance to the datacenters, together with the keys the key repositories serve. The engine options
+// are not part of it.
+type Config struct {
+	// TokenizationVersion is the version of the new tokens
+	TokenizationVersion string `json:"tokenizationVersion"`
+	// DetokenizationVersions are the versions of the tokens the engine decrypts
+	DetokenizationVersions string `json:"detokenizationVersions"`
+	// Formats maps the versions to their token format, the FormatV0 versions being omitted
+	Formats map[string]Format `json:"formats,omitempty"`
+	// Layouts maps the versions to their numbers of preserved leading and trailing digits when the
+	// versions have custom layouts (see LayoutVersioner)
+	Layouts map[string][2]int `json:"layouts,omitempty"`
+	// CharSets maps the decimal encoding bases to their alphabets, like the charSets of the CLI configuration
+	CharSets map[string]string `json:"charSets"`
+}
+
+// configVersioner is the versioner of a Config
+type configVersioner struct {
+	tokVersion    byte
+	detokVersions []byte
+	formats       map[byte]Format
+}
+
+// GetTokenizationVersion returns the tokenization version of the Config
+func (c configVersioner) GetTokenizationVersion() (byte, error) {
+	return c.tokVersion, nil
+}
+
+// GetDetokenizationVersions returns the detokenization versions of the Config
+func (c configVersioner) GetDetokenizationVersions() ([]byte, error) {
+	return c.detokVersions, nil
+}
+
+// GetFormat returns the format of version, FormatV0 if the Config declares none
+func (c configVersioner) GetFormat(version byte) (Format, error) {
+	return c.formats[version], nil
+}
+
+// layoutConfigVersioner is the versioner of a Config with custom layouts
+type layoutConfigVersioner struct {
+	configVersioner
+	layouts map[byte][2]int
+}
+
+// GetLayout returns the layout of version declared by the Config
+func (c layoutConfigVersioner) GetLayout(version byte) (int, int, error) {
+	l, ok := c.layouts[version]
+	if !ok {
+		return 0, 0, errors.New(fmt.Sprintf("No layout declared for version %s", string(version)))
+	}
+	return l[0], l[1], nil
+}
+
+// Versioner returns the versioner of the Config, also a FormatVersioner and, if the Config declares
+// layouts, a LayoutVersioner
+func (c Config) Versioner() (KeyVersioner, error) {
+	if len(c.TokenizationVersion) != 1 {
+		return nil, errors.New(fmt.Sprintf("Invalid config: tokenization version should be a single-byte, instead its %q", c.TokenizationVersion))
+	}
+	if len(c.DetokenizationVersions) == 0 {
+		return nil, errors.New("Invalid config: no detokenization version")
+	}
+	v := configVersioner{
+		tokVersion:    c.TokenizationVersion[0],
+		detokVersions: []byte(c.DetokenizationVersions),
+		formats:       make(map[byte]Format, len(c.Formats)),
+	}
+	for ver, f := range c.Formats {
+		if len(ver) != 1 {
+			return nil, errors.New(fmt.Sprintf("Invalid config: format version should be a single-byte, instead its %q", ver))
+		}
+		v.formats[ver[0]] = f
+	}
+	if len(c.Layouts) == 0 {
+		return v, nil
+	}
+	layouts := make(map[byte][2]int, len(c.Layouts))
+	for ver, l := range c.Layouts {
+		if len(ver) != 1 {
+			return nil, errors.New(fmt.Sprintf("Invalid config: layout version should be a single-byte, instead its %q", ver))
+		}
+		layouts[ver[0]] = l
+	}
+	return layoutConfigVersioner{configVersioner: v, layouts: layouts}, nil
+}
+
+// AlphabetProvider returns the alphabet provider serving the CharSets of the Config (see NewMapAlphabetProvider)
+func (c Config) AlphabetProvider() (AlphabetProvider, error) {
+	return NewMapAlphabetProvider(c.CharSets)
+}
+
+// ConfigExporter is an optional interface of a TKEngine exporting its non-secret configuration
+// (see ImportConfig). The engines built by this package implement it.
+type ConfigExporter interface {
+	// ExportConfig returns the non-secret Config of the engine
+	// (versions, formats, alphabets) as a PEM bundle for ImportConfig
+	ExportConfig() ([]byte, error)
+}
+
+// ExportConfig returns the Config of the engine as an unsigned PEM bundle: the versions, formats and
+// layouts of the versioner and the alphabets of the encoding bases of the versions. The bundle can be
+// signed with SignConfig and is read by ImportConfig.
+func (e *engine) ExportConfig() ([]byte, error) {
+	tokVer, err := e.versioner.GetTokenizationVersion()
+	if err != nil {
+		return nil, err
+	}
+	detokVers, err := e.versioner.GetDetokenizationVersions()
+	if err != nil {
+		return nil, err
+	}
+	c := Config{
+		TokenizationVersion:    string(tokVer),
+		DetokenizationVersions: string(detokVers),
+		CharSets:               map[string]string{},
+	}
+
+	bases := RequiredAlphabetBases()
+	if e.binVersions != nil {
+		bases = append(bases, CreditCardEightDigitBINFormat.encodingBases()...)
+	}
+	lv, hasLayouts := e.versioner.(LayoutVersioner)
+	for _, v := range detokVers {
+		f, err := e.formatFor(v)
+		if err != nil {
+			return nil, err
+		}
+		if f != FormatV0 {
+			if c.Formats == nil {
+				c.Formats = map[string]Format{}
+			}
+			c.Formats[string(v)] = f
+		}
+		if !hasLayouts {
+			continue
+		}
+		p, s, err := lv.GetLayout(v)
+		if err != nil {
+			return nil, err
+		}
+		if c.Layouts == nil {
+			c.Layouts = map[string][2]int{}
+		}
+		c.Layouts[string(v)] = [2]int{p, s}
+		opts, err := e.ccFormat(v)
+		if err != nil {
+			return nil, err
+		}
+		bases = append(bases, opts.encodingBases()...)
+	}
+	for _, base := range bases {
+		alpha, err := e.alphaProvider.GetAlphabetForBase(base)
+		if err != nil {
+			return nil, err
+		}
+		c.CharSets[strconv.FormatUint(uint64(base), 10)] = string(alpha)
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: configPEMType, Bytes: data}), nil
+}
+
+// SignConfig returns the bundle exported by ExportConfig signed with the Ed25519 key. The detached
+// signature of the Config is stored in a header of the PEM block, any previous signature is replaced.
+func SignConfig(bundle []byte, key ed25519.PrivateKey) ([]byte, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, errors.New(fmt.Sprintf("Invalid Ed25519 private key size %d, expected %d", len(key), ed25519.PrivateKeySize))
+	}
+	block, err := decodeConfigBlock(bundle)
+	if err != nil {
+		return nil, err
+	}
+	block.Headers = map[string]string{configSignatureHeader: base64.StdEncoding.EncodeToString(ed25519.Sign(key, block.Bytes))}
+	return pem.EncodeToMemory(block), nil
+}
+
+// ImportConfig returns the Config of the bundle exported by ExportConfig. If verifyKeys are given, the
+// bundle must be signed (see SignConfig) by the private key of one of them, otherwise ErrConfigSignature
+// is returned: several keys allow to rotate the signing key. The versions and the alphabets of the Config
+// are validated.
+func ImportConfig(signed []byte, verifyKeys ...ed25519.PublicKey) (Config, error) {
+	block, err := decodeConfigBlock(signed)
+	if err != nil {
+		return Config{}, err
+	}
+	if len(verifyKeys) > 0 {
+		if err := verifyConfigSignature(block, verifyKeys); err != nil {
+			return Config{}, err
+		}
+	}
+
+	var c Config
+	if err := json.Unmarshal(block.Bytes, &c); err != nil {
+		return Config{}, errors.New(fmt.Sprintf("Malformed config: %v", err))
+	}
+	if _, err := c.Versioner(); err != nil {
+		return Config{}, err
+	}
+	if _, err := c.AlphabetProvider(); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// decodeConfigBlock returns the config PEM block of bundle
+func decodeConfigBlock(bundle []byte) (*pem.Block, error) {
+	block, _ := pem.Decode(bundle)
+	if block == nil || block.Type != configPEMType {
+		return nil, errors.New(fmt.Sprintf("config bundle is not a %s PEM block", configPEMType))
+	}
+	return block, nil
+}
+
+// verifyConfigSignature returns ErrConfigSignature unless the signature of block verifies with one of keys
+func verifyConfigSignature(block *pem.Block, keys []ed25519.PublicKey) error {
+	encoded, ok := block.Headers[configSignatureHeader]
+	if !ok {
+		return fmt.Errorf("%w: unsigned config", ErrConfigSignature)
+	}
+	sig, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("%w: malformed signature", ErrConfigSignature)
+	}
+	for _, key := range keys {
+		if len(key) == ed25519.PublicKeySize && ed25519.Verify(key, block.Bytes, sig) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no verification key matches", ErrConfigSignature)
+}
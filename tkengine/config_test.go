@@ -0,0 +1,142 @@
+package tkengine
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/pem"
+	"errors"
+	"testing"
+)
+
+func Test_engine_ExportImportConfig(t *testing.T) {
+	keys := &keyRepo{keys: map[byte][]byte{'a': make([]byte, 16), 'b': make([]byte, 16), 'A': make([]byte, 16)}}
+	tests := map[string]struct {
+		versioner KeyVersioner
+		opts      []Option
+		cc        string
+	}{
+		"default":    {deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a', 'b'}}, nil, "4444333322221111"},
+		"format_mac": {macVersioner, nil, "4444333322221"},
+		"layout": {layoutVersioner{deterministicVersioner{tokVersion: 'A', detokVersions: []byte{'A'}}, map[byte][2]int{'A': {4, 4}}},
+			nil, "4444333322221111"},
+		"eight_digit_bin": {deterministicVersioner{tokVersion: 'A', detokVersions: []byte{'A'}},
+			[]Option{WithBINLengthDetection(EightDigitBINNetworks(NetworkVisa), 'A')}, "4444333322221111"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngine(tt.versioner, keys, keys, DefaultAlphabetProvider{}, append(tt.opts, WithMinDomainSize(shortCardsDomain))...)
+			if err != nil {
+				t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+			}
+			bundle, err := e.(ConfigExporter).ExportConfig()
+			if err != nil {
+				t.Fatalf("ExportConfig() error = %v", err)
+			}
+			c, err := ImportConfig(bundle)
+			if err != nil {
+				t.Fatalf("ImportConfig() error = %v", err)
+			}
+
+			// an engine built out of the config tokenizes alike
+			v, err := c.Versioner()
+			if err != nil {
+				t.Fatalf("Versioner() error = %v", err)
+			}
+			alpha, err := c.AlphabetProvider()
+			if err != nil {
+				t.Fatalf("AlphabetProvider() error = %v", err)
+			}
+			imported, err := NewEngine(v, keys, keys, alpha, append(tt.opts, WithMinDomainSize(shortCardsDomain))...)
+			if err != nil {
+				t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) from the imported config error = %v", err)
+			}
+			want, err := e.EncryptCC(tt.cc)
+			if err != nil {
+				t.Fatalf("EncryptCC() error = %v", err)
+			}
+			if got, err := imported.EncryptCC(tt.cc); err != nil || got != want {
+				t.Errorf("EncryptCC() with the imported config = %v, %v, want %v", got, err, want)
+			}
+			if got, err := imported.DecryptTK(want); err != nil || got != tt.cc {
+				t.Errorf("DecryptTK() with the imported config = %v, %v, want %v", got, err, tt.cc)
+			}
+		})
+	}
+}
+
+func TestImportConfig_signature(t *testing.T) {
+	keys := &keyRepo{keys: map[byte][]byte{'a': make([]byte, 16)}}
+	e, err := NewEngine(deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, keys, keys, DefaultAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	bundle, err := e.(ConfigExporter).ExportConfig()
+	if err != nil {
+		t.Fatalf("ExportConfig() error = %v", err)
+	}
+	signer := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	other := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize))
+	signed, err := SignConfig(bundle, signer)
+	if err != nil {
+		t.Fatalf("SignConfig() error = %v", err)
+	}
+	// the signed config with another base14 alphabet
+	block, _ := pem.Decode(signed)
+	block.Bytes = bytes.Replace(block.Bytes, []byte("abcdefghijklmn"), []byte("nmlkjihgfedcba"), 1)
+	tampered := pem.EncodeToMemory(block)
+	resigned, err := SignConfig(tampered, other)
+	if err != nil {
+		t.Fatalf("SignConfig() error = %v", err)
+	}
+
+	signerPub, otherPub := signer.Public().(ed25519.PublicKey), other.Public().(ed25519.PublicKey)
+	tests := map[string]struct {
+		bundle     []byte
+		verifyKeys []ed25519.PublicKey
+		wantErr    error
+	}{
+		"unsigned_not_verified": {bundle, nil, nil},
+		"signed_not_verified":   {signed, nil, nil},
+		"signed_verified":       {signed, []ed25519.PublicKey{signerPub}, nil},
+		"rotated_signing_key":   {signed, []ed25519.PublicKey{otherPub, signerPub}, nil},
+		"unsigned":              {bundle, []ed25519.PublicKey{signerPub}, ErrConfigSignature},
+		"other_key":             {signed, []ed25519.PublicKey{otherPub}, ErrConfigSignature},
+		"tampered":              {tampered, []ed25519.PublicKey{signerPub}, ErrConfigSignature},
+		"resigned_by_other":     {resigned, []ed25519.PublicKey{signerPub}, ErrConfigSignature},
+		"invalid_key":           {signed, []ed25519.PublicKey{signerPub[:8]}, ErrConfigSignature},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c, err := ImportConfig(tt.bundle, tt.verifyKeys...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ImportConfig() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (c.TokenizationVersion != "a" || c.DetokenizationVersions != "a") {
+				t.Errorf("ImportConfig() = %+v, want the versions of the engine", c)
+			}
+		})
+	}
+}
+
+func TestImportConfig_invalid(t *testing.T) {
+	tests := map[string]string{
+		"not_pem":          "tokenizationVersion: a",
+		"other_pem_type":   "-----BEGIN TKENGINE ENCRYPTED KEYS-----\ne30=\n-----END TKENGINE ENCRYPTED KEYS-----\n",
+		"malformed_json":   "-----BEGIN TKENGINE CONFIG-----\new==\n-----END TKENGINE CONFIG-----\n",
+		"missing_charsets": "-----BEGIN TKENGINE CONFIG-----\neyJ0b2tlbml6YXRpb25WZXJzaW9uIjoiYSIsImRldG9rZW5pemF0aW9uVmVyc2lvbnMiOiJhIn0=\n-----END TKENGINE CONFIG-----\n",
+		"invalid_version":  "-----BEGIN TKENGINE CONFIG-----\neyJ0b2tlbml6YXRpb25WZXJzaW9uIjoiYWIiLCJkZXRva2VuaXphdGlvblZlcnNpb25zIjoiYSJ9\n-----END TKENGINE CONFIG-----\n",
+	}
+	for name, bundle := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ImportConfig([]byte(bundle)); err == nil {
+				t.Errorf("ImportConfig() error = nil, want an error")
+			}
+		})
+	}
+	if _, err := SignConfig([]byte(tests["not_pem"]), ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))); err == nil {
+		t.Errorf("SignConfig() of an invalid bundle error = nil, want an error")
+	}
+	if _, err := SignConfig(nil, make([]byte, 8)); err == nil {
+		t.Errorf("SignConfig() with an invalid key error = nil, want an error")
+	}
+}
@@ -0,0 +1,83 @@
+package tkengine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TokenDescriptor describes how a credit card token was formed, e.g. to diagnose format mismatches
+// between systems exchanging tokens
+type TokenDescriptor struct {
+	// Version is the version char of the token
+	Version byte
+	// Format is the token format bound to the version
+	Format Format
+	// PreservedPrefix is the number of leading digits of the card preserved in clear
+	PreservedPrefix int
+	// PreservedSuffix is the number of trailing digits of the card preserved in clear
+	PreservedSuffix int
+	// MiddleLength is the number of encrypted middle digits, encoded with one symbol less
+	MiddleLength int
+	// Base is the encoding base of the middle digits
+	Base uint32
+	// Padding is the number of FixedWidthPadding chars of the token (see WithFixedTokenWidth)
+	Padding int
+	// MACLength is the number of MAC chars of the token (see FormatMAC)
+	MACLength int
+}
+
+// String returns the descriptor as semicolon-separated key=value pairs, e.g.
+// v=a;base=16;prefix=6;suffix=4;midlen=6. The format, padding and MAC length are only
+// written when they are set.
+func (d TokenDescriptor) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "v=%s;base=%d;prefix=%d;suffix=%d;midlen=%d", string(d.Version), d.Base, d.PreservedPrefix, d.PreservedSuffix, d.MiddleLength)
+	if d.Format != FormatV0 {
+		fmt.Fprintf(&b, ";format=%d", d.Format)
+	}
+	if d.Padding > 0 {
+		fmt.Fprintf(&b, ";padding=%d", d.Padding)
+	}
+	if d.MACLength > 0 {
+		fmt.Fprintf(&b, ";mac=%d", d.MACLength)
+	}
+	return b.String()
+}
+
+// DescribeToken returns the descriptor of the valid credit card token tk. The token is validated as
+// by DecryptTK, the MAC of FormatMAC tokens included, but it is not decrypted.
+func (e *engine) DescribeToken(tk string) (TokenDescriptor, error) {
+	if err := e.checkInputLength(tk); err != nil {
+		return TokenDescriptor{}, err
+	}
+	detokVers, err := e.versioner.GetDetokenizationVersions()
+	if err != nil {
+		return TokenDescriptor{}, err
+	}
+	opened, err := e.openToken(tk)
+	if err != nil {
+		return TokenDescriptor{}, err
+	}
+	_, opts, err := e.detokAlphabet(opened, detokVers)
+	if err != nil {
+		return TokenDescriptor{}, err
+	}
+
+	d := TokenDescriptor{
+		Version:         opened[opts.PreservedPrefix],
+		PreservedPrefix: opts.PreservedPrefix,
+		PreservedSuffix: opts.PreservedSuffix,
+		MiddleLength:    len(opened) - opts.PreservedPrefix - opts.PreservedSuffix,
+	}
+	if d.Format, err = e.formatFor(d.Version); err != nil {
+		return TokenDescriptor{}, err
+	}
+	if d.Format == FormatMAC {
+		d.MACLength = TokenMACLength
+	}
+	d.Padding = len(tk) - d.MACLength - len(opened)
+	if d.Base, err = encodingBaseForRadix(opts.radix(), d.MiddleLength); err != nil {
+		return TokenDescriptor{}, err
+	}
+	return d, nil
+}
@@ -0,0 +1,53 @@
+package tkengine
+
+import (
+	"testing"
+)
+
+func Test_engine_DescribeToken(t *testing.T) {
+	keys := &keyRepo{keys: map[byte][]byte{'a': make([]byte, 16), 'b': make([]byte, 16)}}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a', 'b'}}
+	tests := map[string]struct {
+		versioner KeyVersioner
+		opts      []Option
+		cc        string
+		want      string
+		wantErr   bool
+	}{
+		"16_digits":   {versioner, nil, "4444333322221111", "v=a;base=16;prefix=6;suffix=4;midlen=6", false},
+		"13_digits":   {versioner, nil, "4444333322221", "v=a;base=32;prefix=6;suffix=4;midlen=3", false},
+		"19_digits":   {versioner, nil, "4444333322221111444", "v=a;base=14;prefix=6;suffix=4;midlen=9", false},
+		"fixed_width": {versioner, []Option{WithFixedTokenWidth(19)}, "4444333322221", "v=a;base=32;prefix=6;suffix=4;midlen=3;padding=6", false},
+		"format_mac":  {macVersioner, nil, "4444333322221111", "v=a;base=16;prefix=6;suffix=4;midlen=6;format=1;mac=4", false},
+		"layout":      {layoutVersioner{deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, map[byte][2]int{'a': {8, 2}}}, nil, "4444333322221111", "v=a;base=16;prefix=8;suffix=2;midlen=6", false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngine(tt.versioner, keys, keys, DefaultAlphabetProvider{}, append(tt.opts, WithMinDomainSize(shortCardsDomain))...)
+			if err != nil {
+				t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+			}
+			tk, err := e.EncryptCC(tt.cc)
+			if err != nil {
+				t.Fatalf("EncryptCC() error = %v", err)
+			}
+			got, err := e.(TokenInspector).DescribeToken(tk)
+			if err != nil {
+				t.Fatalf("DescribeToken(%v) error = %v", tk, err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("DescribeToken(%v) = %v, want %v", tk, got, tt.want)
+			}
+			if l := got.PreservedPrefix + got.MiddleLength + got.PreservedSuffix; l != len(tt.cc) {
+				t.Errorf("DescribeToken(%v) describes a card of %d digits, want %d", tk, l, len(tt.cc))
+			}
+		})
+	}
+
+	e, _ := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, WithMinDomainSize(shortCardsDomain))
+	for _, tk := range []string{"4444331", "444433fapchc1111", "4444333322221111"} {
+		if got, err := e.(TokenInspector).DescribeToken(tk); err == nil {
+			t.Errorf("DescribeToken(%v) = %v, want an error", tk, got)
+		}
+	}
+}
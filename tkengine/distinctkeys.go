@@ -0,0 +1,62 @@
+package tkengine
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+)
+
+// WithDistinctKeyValidation makes the engine construction fail if two detokenization versions share
+// the same encryption key or the same HMAC key, typically a copy-pasted configuration: such versions
+// are not cryptographically separated. The keys of all the detokenization versions are retrieved at
+// construction, so they must all be available then. The error never contains key material.
+func WithDistinctKeyValidation() Option {
+	return func(e *engine) {
+		e.distinctKeys = true
+	}
+}
+
+// validateDistinctKeys returns an error if distinct keys are required and two detokenization
+// versions share a key
+func (e *engine) validateDistinctKeys() error {
+	if !e.distinctKeys {
+		return nil
+	}
+	vers, err := e.versioner.GetDetokenizationVersions()
+	if err != nil {
+		return err
+	}
+	for _, repo := range []struct {
+		name string
+		keys KeyRepo
+	}{{"encryption", e.encryptionKeys}, {"hmac", e.hmacKeys}} {
+		if err := checkDistinctKeys(repo.name, repo.keys, vers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDistinctKeys returns an error if two versions of vers share the same key of repo
+func checkDistinctKeys(name string, repo KeyRepo, vers []byte) error {
+	keys := make([]*SecretKey, 0, len(vers))
+	defer func() {
+		for _, k := range keys {
+			k.Close()
+		}
+	}()
+	for _, v := range vers {
+		key, err := getSecretKey(repo, v)
+		if err != nil {
+			return err
+		}
+		for j, other := range keys {
+			if subtle.ConstantTimeCompare(key.Bytes(), other.Bytes()) == 1 {
+				key.Close()
+				return errors.New(fmt.Sprintf("Versions %q and %q share the same %s key: versions must have distinct keys", vers[j], v, name))
+			}
+		}
+		keys = append(keys, key)
+	}
+	return nil
+}
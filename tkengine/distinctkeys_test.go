@@ -0,0 +1,40 @@
+package tkengine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWithDistinctKeyValidation(t *testing.T) {
+	k0 := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	k1 := []byte{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}
+	k2 := []byte{2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a', 'b', 'c'}}
+	tests := map[string]struct {
+		encKeys  map[byte][]byte
+		hmacKeys map[byte][]byte
+		wantErr  string
+	}{
+		"distinct_keys":       {map[byte][]byte{'a': k0, 'b': k1, 'c': k2}, map[byte][]byte{'a': k2, 'b': k1, 'c': k0}, ""},
+		"shared_encryption":   {map[byte][]byte{'a': k0, 'b': k1, 'c': k0}, map[byte][]byte{'a': k0, 'b': k1, 'c': k2}, "Versions 'a' and 'c' share the same encryption key"},
+		"shared_hmac":         {map[byte][]byte{'a': k0, 'b': k1, 'c': k2}, map[byte][]byte{'a': k0, 'b': k2, 'c': k2}, "Versions 'b' and 'c' share the same hmac key"},
+		"missing_version_key": {map[byte][]byte{'a': k0, 'b': k1}, map[byte][]byte{'a': k0, 'b': k1, 'c': k2}, "Key unavailable"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewEngine(versioner, &keyRepo{keys: tt.encKeys}, &keyRepo{keys: tt.hmacKeys}, DefaultAlphabetProvider{}, WithDistinctKeyValidation())
+			if (err != nil) != (tt.wantErr != "") || err != nil && !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewEngine() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// keys are only checked on demand
+	shared := &keyRepo{keys: map[byte][]byte{'a': k0, 'b': k0, 'c': k0}}
+	if _, err := NewEngine(versioner, shared, shared, DefaultAlphabetProvider{}); err != nil {
+		t.Errorf("NewEngine() error = %v without WithDistinctKeyValidation", err)
+	}
+	if _, err := NewDummyEngine(WithDistinctKeyValidation()); err != nil {
+		t.Errorf("NewDummyEngine() error = %v, want distinct keys", err)
+	}
+}
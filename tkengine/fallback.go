@@ -0,0 +1,43 @@
+package tkengine
+
+import (
+	"fmt"
+)
+
+// decryptWithVersionFallback detokenizes tk trying all the detokenization versions when its
+// version char looks corrupted: either it is not a detokenization version or the card decrypted
+// under it fails the Luhn check. The first Luhn-valid card is returned. If none is found, the card
+// decrypted under the embedded version is returned if it is a detokenization version.
+func (e *engine) decryptWithVersionFallback(tk string, detokVers []byte, aad []byte) (string, error) {
+	// the token structure is checked regardless of the embedded version
+	if len(tk) <= CreditCardFormat.PreservedPrefix {
+		return "", fmt.Errorf("%w: length %d out of range [%d, %d]", ErrInvalidTK, len(tk), CreditCardFormat.MinLength, CreditCardFormat.MaxLength)
+	}
+	embedded := tk[CreditCardFormat.PreservedPrefix]
+	alpha, _, err := e.detokAlphabet(tk, []byte{embedded})
+	if err != nil {
+		return "", err
+	}
+
+	var embeddedCC string
+	embeddedErr := checkDetokenizationVersion(embedded, detokVers)
+	if contains(detokVers, embedded) {
+		embeddedCC, embeddedErr = e.decrypt(tk, CreditCardFormat, alpha, aad)
+		if embeddedErr == nil && IsLuhnValid(embeddedCC) {
+			return embeddedCC, nil
+		}
+	}
+
+	for _, v := range detokVers {
+		if v == embedded {
+			continue
+		}
+		candidate := tk[:CreditCardFormat.PreservedPrefix] + string(v) + tk[CreditCardFormat.PreservedPrefix+1:]
+		cc, err := e.decrypt(candidate, CreditCardFormat, alpha, aad)
+		if err == nil && IsLuhnValid(cc) {
+			e.logf("token version %q recovered as version %q", embedded, v)
+			return cc, nil
+		}
+	}
+	return embeddedCC, embeddedErr
+}
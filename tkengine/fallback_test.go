@@ -0,0 +1,101 @@
+package tkengine
+
+import (
+	"testing"
+)
+
+func Test_engine_versionFallback(t *testing.T) {
+	keys := &keyRepo{keys: map[byte][]byte{
+		'a': {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		'b': {1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+	}}
+	versioner := deterministicVersioner{tokVersion: 'b', detokVersions: []byte{'a', 'b'}}
+	e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	fallback, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, WithVersionFallback())
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	withVersion := func(tk string, v byte) string {
+		return tk[:6] + string(v) + tk[7:]
+	}
+	tests := map[string]struct {
+		cc        string
+		version   byte
+		wantErr   bool
+		wantFbCC  string
+		wantFbErr bool
+	}{
+		"uncorrupted_token": {
+			cc:       "4111111111111111",
+			version:  'b',
+			wantFbCC: "4111111111111111",
+		},
+		"unknown_version": {
+			cc:       "4111111111111111",
+			version:  'z',
+			wantErr:  true,
+			wantFbCC: "4111111111111111",
+		},
+		"wrong_known_version": {
+			cc:       "4111111111111111",
+			version:  'a',
+			wantFbCC: "4111111111111111",
+		},
+		"uncorrupted_token_of_non_luhn_card": {
+			cc:       "4444333322221112",
+			version:  'b',
+			wantFbCC: "4444333322221112",
+		},
+		"unknown_version_of_non_luhn_card": {
+			cc:        "4444333322221112",
+			version:   'z',
+			wantErr:   true,
+			wantFbErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tk, err := e.EncryptCC(tt.cc)
+			if err != nil {
+				t.Fatalf("EncryptCC() error = %v", err)
+			}
+			tk = withVersion(tk, tt.version)
+
+			if _, err := e.DecryptTK(tk); (err != nil) != tt.wantErr {
+				t.Errorf("DecryptTK() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got, err := fallback.DecryptTK(tk)
+			if (err != nil) != tt.wantFbErr {
+				t.Fatalf("DecryptTK() with fallback error = %v, wantErr %v", err, tt.wantFbErr)
+			}
+			if got != tt.wantFbCC {
+				t.Errorf("DecryptTK() with fallback got = %v, want %v", got, tt.wantFbCC)
+			}
+		})
+	}
+}
+
+func TestIsLuhnValid(t *testing.T) {
+	tests := map[string]struct {
+		cc   string
+		want bool
+	}{
+		"valid_visa":    {"4111111111111111", true},
+		"valid_amex":    {"378282246310005", true},
+		"invalid_digit": {"4111111111111112", false},
+		"non_digit":     {"411111111111111a", false},
+		"empty":         {"", false},
+		"single_zero":   {"0", true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := IsLuhnValid(tt.cc); got != tt.want {
+				t.Errorf("IsLuhnValid(%q) = %v, want %v", tt.cc, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package tkengine
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"reflect"
+)
+
+// fipsMinHMACKeyLength is the minimum HMAC key length in bytes of FIPS mode: 112 bits, the minimum
+// security strength allowed by NIST SP 800-131A
+const fipsMinHMACKeyLength = 14
+
+// fipsHashes are the FIPS 180-4 hash functions accepted as tweak hash in FIPS mode
+var fipsHashes = []func() hash.Hash{
+	sha256.New,
+	sha256.New224,
+	sha512.New,
+	sha512.New384,
+	sha512.New512_224,
+	sha512.New512_256,
+}
+
+// WithFIPSMode restricts the engine to FIPS-approved primitives: FF1 (NIST SP 800-38G) with AES-128,
+// AES-192 or AES-256 encryption keys and a HMAC tweak over a SHA-2 hash (FIPS 180-4) with keys of at
+// least 112 bits. The engine construction fails if WithTweakHash selects another hash function, and
+// operations fail for versions whose keys have other lengths. FIPS mode restricts the choice of
+// primitives only: a validated cryptographic module also requires a Go toolchain built against one.
+func WithFIPSMode() Option {
+	return func(e *engine) {
+		e.fipsMode = true
+	}
+}
+
+// FIPSReporter is an optional interface of a TKEngine reporting its FIPS mode for compliance audits.
+// The engines built by this package implement it.
+type FIPSReporter interface {
+	// FIPSCompliant reports whether the engine is restricted
+	// to FIPS-approved primitives (see WithFIPSMode)
+	FIPSCompliant() bool
+}
+
+// FIPSCompliant reports whether the engine was built WithFIPSMode and only uses FIPS-approved primitives
+func (e *engine) FIPSCompliant() bool {
+	return e.fipsMode && isFIPSHash(e.hashFunc())
+}
+
+// validateFIPS returns an error if the engine is in FIPS mode with a non-approved tweak hash
+func (e *engine) validateFIPS() error {
+	if e.fipsMode && !isFIPSHash(e.hashFunc()) {
+		return errors.New("Invalid tweak hash: FIPS mode requires a SHA-2 hash function of crypto/sha256 or crypto/sha512")
+	}
+	return nil
+}
+
+// checkFIPSKeys returns an error if the engine is in FIPS mode and the keys of version v have non-approved lengths.
+// Key material is never part of the error.
+func (e *engine) checkFIPSKeys(v byte, ekey []byte, hkey []byte) error {
+	if !e.fipsMode {
+		return nil
+	}
+	if l := len(ekey); l != 16 && l != 24 && l != 32 {
+		return errors.New(fmt.Sprintf("Invalid encryption key of version %q: FIPS mode requires a 16, 24 or 32 bytes AES key, got %d bytes", v, l))
+	}
+	if l := len(hkey); l < fipsMinHMACKeyLength {
+		return errors.New(fmt.Sprintf("Invalid hmac key of version %q: FIPS mode requires at least %d bytes, got %d bytes", v, fipsMinHMACKeyLength, l))
+	}
+	return nil
+}
+
+// isFIPSHash reports whether h is one of the FIPS-approved hash functions
+func isFIPSHash(h func() hash.Hash) bool {
+	p := reflect.ValueOf(h).Pointer()
+	for _, f := range fipsHashes {
+		if reflect.ValueOf(f).Pointer() == p {
+			return true
+		}
+	}
+	return false
+}
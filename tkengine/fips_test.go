@@ -0,0 +1,79 @@
+package tkengine
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"testing"
+)
+
+func TestWithFIPSMode(t *testing.T) {
+	keys := fixedKeyRepo{false, make([]byte, 16)}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	tests := map[string]struct {
+		opts          []Option
+		wantErr       bool
+		wantCompliant bool
+	}{
+		"no_fips_mode":          {nil, false, false},
+		"fips_default_hash":     {[]Option{WithFIPSMode()}, false, true},
+		"fips_sha512":           {[]Option{WithFIPSMode(), WithTweakHash(sha512.New)}, false, true},
+		"fips_sha224":           {[]Option{WithFIPSMode(), WithTweakHash(sha256.New224)}, false, true},
+		"fips_sha1":             {[]Option{WithFIPSMode(), WithTweakHash(sha1.New)}, true, false},
+		"fips_md5":              {[]Option{WithFIPSMode(), WithTweakHash(md5.New)}, true, false},
+		"fips_custom_hash":      {[]Option{WithFIPSMode(), WithTweakHash(func() hash.Hash { return sha256.New() })}, true, false},
+		"sha256_no_fips_mode":   {[]Option{WithTweakHash(sha256.New)}, false, false},
+		"fips_before_hash_opts": {[]Option{WithTweakHash(md5.New), WithFIPSMode()}, true, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewEngine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := e.(FIPSReporter).FIPSCompliant(); got != tt.wantCompliant {
+				t.Errorf("FIPSCompliant() = %v, want %v", got, tt.wantCompliant)
+			}
+		})
+	}
+}
+
+func Test_engine_fipsKeyLengths(t *testing.T) {
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	tests := map[string]struct {
+		encKey  []byte
+		hmacKey []byte
+		wantErr bool
+	}{
+		"aes128":           {make([]byte, 16), make([]byte, 16), false},
+		"aes192":           {make([]byte, 24), make([]byte, 32), false},
+		"aes256":           {make([]byte, 32), make([]byte, 64), false},
+		"short_enc_key":    {make([]byte, 8), make([]byte, 32), true},
+		"odd_enc_key":      {make([]byte, 20), make([]byte, 32), true},
+		"short_hmac_key":   {make([]byte, 16), make([]byte, 8), true},
+		"minimum_hmac_key": {make([]byte, 16), make([]byte, fipsMinHMACKeyLength), false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngineWithDefaultAlphabet(versioner, fixedKeyRepo{false, tt.encKey}, fixedKeyRepo{false, tt.hmacKey}, WithFIPSMode())
+			if err != nil {
+				t.Fatalf("NewEngineWithDefaultAlphabet() error = %v", err)
+			}
+			tk, err := e.EncryptCC("4444333322221111")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EncryptCC() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if cc, err := e.DecryptTK(tk); err != nil || cc != "4444333322221111" {
+				t.Errorf("DecryptTK() = %v, %v, want 4444333322221111", cc, err)
+			}
+		})
+	}
+}
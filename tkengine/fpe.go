@@ -0,0 +1,79 @@
+package tkengine
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/capitalone/fpe/ff1"
+	"github.com/capitalone/fpe/ff3"
+)
+
+// FF31TweakLength is the length in bytes of the FF3-1 tweaks: 56 bits
+const FF31TweakLength = 7
+
+// fpeCipher is the format preserving encryption cipher of the middle digits of a version
+type fpeCipher interface {
+	// Encrypt returns the ciphertext of the radix string X, of the same length
+	Encrypt(X string) (string, error)
+	// Decrypt returns the plaintext of the radix string X, of the same length
+	Decrypt(X string) (string, error)
+}
+
+// WithFF31 encrypts the middle digits of the tokens of the given versions with FF3-1 (NIST SP 800-38G Rev. 1
+// draft) instead of FF1, for partners standardized on it. The FF3-1 tweak is the first 56 bits of the tweak
+// derived from the preserved digits (the tweak HMAC, or CMAC with WithCMACTweaks). FF3-1 tokens are different
+// from FF1 tokens: a version's cipher can't change once it has tokens, FF3-1 must be enabled on new versions
+// only. The engine construction fails if no version is given, if the tweak hash output is shorter than 56
+// bits or in FIPS mode, which is restricted to FF1.
+func WithFF31(versions ...byte) Option {
+	return func(e *engine) {
+		e.ff31Versions = make(map[byte]bool, len(versions))
+		for _, v := range versions {
+			e.ff31Versions[v] = true
+		}
+	}
+}
+
+// validateFF31 returns an error if FF3-1 is enabled for no version or can't be used by the engine
+func (e *engine) validateFF31() error {
+	if e.ff31Versions == nil {
+		return nil
+	}
+	if len(e.ff31Versions) == 0 {
+		return errors.New("Invalid FF3-1 versions: no version given")
+	}
+	if e.fipsMode {
+		return errors.New("Invalid FF3-1 versions: FIPS mode is restricted to FF1")
+	}
+	if size := e.hashFunc()().Size(); size < FF31TweakLength {
+		return errors.New(fmt.Sprintf("Invalid tweak hash: its %d bytes output is shorter than the %d bytes FF3-1 tweaks", size, FF31TweakLength))
+	}
+	return nil
+}
+
+// newFPECipher returns the cipher of version v in radix with ekey and tweak: FF3-1 with the first
+// FF31TweakLength bytes of tweak for the WithFF31 versions, FF1 otherwise
+func (e *engine) newFPECipher(v byte, radix int, ekey []byte, tweak []byte) (fpeCipher, error) {
+	if !e.ff31Versions[v] {
+		c, err := ff1.NewCipher(radix, MaxTweakLength, ekey, tweak)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	if len(tweak) < FF31TweakLength {
+		return nil, errors.New(fmt.Sprintf("Invalid FF3-1 tweak of version %q: %d bytes, at least %d are required", v, len(tweak), FF31TweakLength))
+	}
+	c, err := ff3.NewCipher(radix, ekey, ff31Tweak(tweak[:FF31TweakLength]))
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ff31Tweak expands the 56-bit FF3-1 tweak t into the 64-bit tweak TL || TR of the FF3 rounds: TL is
+// the first 28 bits of t followed by 4 zero bits, TR the last 24 bits of t followed by the bits 28 to
+// 31 of t and 4 zero bits
+func ff31Tweak(t []byte) []byte {
+	return []byte{t[0], t[1], t[2], t[3] & 0xF0, t[4], t[5], t[6], (t[3] & 0x0F) << 4}
+}
@@ -0,0 +1,109 @@
+package tkengine
+
+import (
+	"encoding/hex"
+	"hash"
+	"hash/fnv"
+	"strings"
+	"testing"
+)
+
+func Test_engine_newFPECipher_FF31Vectors(t *testing.T) {
+	// ACVP AES-FF3-1 sample vectors, the radix 26 ones written in a-z
+	tests := map[string]struct {
+		radix      int
+		key        string
+		tweak      string
+		plaintext  string
+		ciphertext string
+	}{
+		"aes128_radix10": {10, "2DE79D232DF5585D68CE47882AE256D6", "CBD09280979564", "3992520240", "8901801106"},
+		"aes128_radix10_56_digits": {10, "01C63017111438F7FC8E24EB16C71AB5", "C4E822DCD09F27",
+			"60761757463116869318437658042297305934914824457484538562", "35637144092473838892796702739628394376915177448290847293"},
+		"aes128_radix26": {26, "718385E6542534604419E83CE387A437", "B6F35084FA90E1", "wfmwlrorcd", "ywowehycyd"},
+	}
+	// the radix 26 digits of the cipher and of the vectors
+	const digits, letters = "0123456789abcdefghijklmnop", "abcdefghijklmnopqrstuvwxyz"
+	translate := func(s string, radix int, from, to string) string {
+		if radix != 26 {
+			return s
+		}
+		return strings.Map(func(r rune) rune { return rune(to[strings.IndexRune(from, r)]) }, s)
+	}
+	e := &engine{ff31Versions: map[byte]bool{'a': true}}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			key, _ := hex.DecodeString(tt.key)
+			tweak, _ := hex.DecodeString(tt.tweak)
+			c, err := e.newFPECipher('a', tt.radix, key, tweak)
+			if err != nil {
+				t.Fatalf("newFPECipher() error = %v", err)
+			}
+			ct, err := c.Encrypt(translate(tt.plaintext, tt.radix, letters, digits))
+			if got := translate(ct, tt.radix, digits, letters); err != nil || got != tt.ciphertext {
+				t.Errorf("Encrypt() = %v, %v, want %v", got, err, tt.ciphertext)
+			}
+			pt, err := c.Decrypt(ct)
+			if got := translate(pt, tt.radix, digits, letters); err != nil || got != tt.plaintext {
+				t.Errorf("Decrypt() = %v, %v, want %v", got, err, tt.plaintext)
+			}
+		})
+	}
+}
+
+func TestWithFF31(t *testing.T) {
+	keys := &keyRepo{keys: map[byte][]byte{'a': make([]byte, 16), 'b': make([]byte, 16)}}
+	versioner := deterministicVersioner{tokVersion: 'b', detokVersions: []byte{'a', 'b'}}
+	tests := map[string]struct {
+		opts []Option
+		cc   string
+		want string
+	}{
+		"ff31_16_digits":  {[]Option{WithFF31('b')}, "4444333322221111", "444433bobjbk1111"},
+		"ff31_13_digits":  {[]Option{WithFF31('b')}, "4444333322221", "444433bma2221"},
+		"ff31_cmac_tweak": {[]Option{WithFF31('b'), WithCMACTweaks('b')}, "4444333322221111", ""},
+		"ff1_by_default":  {nil, "4444333322221111", "444433bapchc1111"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, append(tt.opts, WithMinDomainSize(shortCardsDomain))...)
+			if err != nil {
+				t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+			}
+			tk, err := e.EncryptCC(tt.cc)
+			if err != nil || tt.want != "" && tk != tt.want {
+				t.Fatalf("EncryptCC() = %v, %v, want %v", tk, err, tt.want)
+			}
+			if cc, err := e.DecryptTK(tk); err != nil || cc != tt.cc {
+				t.Errorf("DecryptTK(%v) = %v, %v, want %v", tk, cc, err, tt.cc)
+			}
+			// the FF1 tokens of version a keep decrypting and are migrated
+			if cc, err := e.DecryptTK("444433aapchc1111"); err != nil || cc != "4444333322221111" {
+				t.Errorf("DecryptTK() of the FF1 token = %v, %v, want 4444333322221111", cc, err)
+			}
+			if rtk, err := e.(Retokenizer).Retokenize("444433aapchc1111"); err != nil || tt.cc == "4444333322221111" && rtk != tk {
+				t.Errorf("Retokenize() = %v, %v, want %v", rtk, err, tk)
+			}
+			if err := e.(Warmer).Warm(); err != nil {
+				t.Errorf("Warm() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestWithFF31_validation(t *testing.T) {
+	keys := &keyRepo{keys: map[byte][]byte{'a': make([]byte, 16)}}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	tests := map[string][]Option{
+		"no_version":       {WithFF31()},
+		"fips_mode":        {WithFF31('a'), WithFIPSMode()},
+		"short_tweak_hash": {WithFF31('a'), WithTweakHash(func() hash.Hash { return fnv.New32() })},
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, opts...); err == nil {
+				t.Errorf("NewEngine() error = nil, want an error")
+			}
+		})
+	}
+}
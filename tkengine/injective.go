@@ -0,0 +1,130 @@
+package tkengine
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// injectiveExhaustiveDomain is the largest middle-digit domain VerifyInjective tokenizes exhaustively
+const injectiveExhaustiveDomain = 10000
+
+// injectiveBIN is the BIN of the cards VerifyInjective tokenizes exhaustively
+const injectiveBIN = "444433"
+
+// InjectivityVerifier is an optional interface of a TKEngine giving auditors evidence that its
+// tokenization is injective. The engines built by this package implement it.
+type InjectivityVerifier interface {
+	// VerifyInjective checks, exhaustively on small domains and by sampling,
+	// that distinct cards never get the same token under version
+	VerifyInjective(version byte, sampleSize int) (bool, error)
+}
+
+// VerifyInjective gives auditors evidence that distinct cards never share a token under version: FF1
+// (or FF3-1) is a permutation of the middle digits and the preserved digits are kept as is, so the
+// tokenization is injective. The check is twofold:
+//   - deterministic: if the shortest cards of the version layout have a middle-digit domain of at most
+//     10000 values (e.g. the 3 middle digits, 1000 values, of 13-digit cards), every middle-digit value
+//     of such a card is tokenized and the tokens must all differ
+//   - probabilistic: sampleSize random cards of random lengths are tokenized and two distinct cards
+//     must never get the same token
+//
+// It returns false if a collision is found and an error if the cards can't be tokenized under version
+// (e.g. missing keys). The tokens are neither audited nor counted in VersionStats.
+func (e *engine) VerifyInjective(version byte, sampleSize int) (ok bool, err error) {
+	defer e.recoverCipherPanic("VerifyInjective", &err)
+
+	if err := ValidateVersion(version); err != nil {
+		return false, err
+	}
+	if sampleSize < 0 {
+		return false, errors.New(fmt.Sprintf("Invalid sample size %d: it should not be negative", sampleSize))
+	}
+
+	// exhaustive check of the smallest domain
+	opts, err := e.cardFormat(version, injectiveBIN)
+	if err != nil {
+		return false, err
+	}
+	l, err := e.shortestCardLength(opts)
+	if err != nil {
+		return false, err
+	}
+	if md := l - opts.PreservedPrefix - opts.PreservedSuffix; pow10(md) <= injectiveExhaustiveDomain {
+		prefix := (injectiveBIN + strings.Repeat("4", opts.PreservedPrefix))[:opts.PreservedPrefix]
+		suffix := strings.Repeat("1", opts.PreservedSuffix)
+		seen := make(map[string]string, pow10(md))
+		for i := 0; i < pow10(md); i++ {
+			cc := fmt.Sprintf("%s%0*d%s", prefix, md, i, suffix)
+			if ok, err := e.tokenizeOnce(seen, cc, version, opts); !ok || err != nil {
+				return false, err
+			}
+		}
+	}
+
+	// random sampling
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	seen := make(map[string]string, sampleSize)
+	for i := 0; i < sampleSize; i++ {
+		bin := randomDigits(rnd, 6)
+		opts, err := e.cardFormat(version, bin)
+		if err != nil {
+			return false, err
+		}
+		l, err := e.shortestCardLength(opts)
+		if err != nil {
+			return false, err
+		}
+		l += rnd.Intn(opts.MaxLength - l + 1)
+		cc := bin + randomDigits(rnd, l-len(bin))
+		if ok, err := e.tokenizeOnce(seen, cc, version, opts); !ok || err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// tokenizeOnce tokenizes cc under version and records the token in seen, indexed by token. It returns
+// false if seen already holds the token for another card.
+func (e *engine) tokenizeOnce(seen map[string]string, cc string, version byte, opts FormatOpts) (bool, error) {
+	tk, err := e.encryptVersion(cc, version, opts, e.alphaProvider, nil)
+	if err != nil {
+		return false, err
+	}
+	if prev, ok := seen[tk]; ok && prev != cc {
+		e.logf("token collision under version %s", string(version))
+		return false, nil
+	}
+	seen[tk] = cc
+	return true, nil
+}
+
+// shortestCardLength returns the length of the shortest cards of opts the engine tokenizes (see WithMinDomainSize)
+func (e *engine) shortestCardLength(opts FormatOpts) (int, error) {
+	for l := opts.MinLength; l <= opts.MaxLength; l++ {
+		if e.checkDomain(l, opts) == nil {
+			return l, nil
+		}
+	}
+	return 0, e.checkDomain(opts.MaxLength, opts)
+}
+
+// pow10 returns 10^n, or a value above injectiveExhaustiveDomain if it overflows
+func pow10(n int) int {
+	p := 1
+	for i := 0; i < n && p <= injectiveExhaustiveDomain; i++ {
+		p *= 10
+	}
+	return p
+}
+
+// randomDigits returns n random decimal digits
+func randomDigits(rnd *rand.Rand, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte('0' + rnd.Intn(10))
+	}
+	return string(b)
+}
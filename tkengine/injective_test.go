@@ -0,0 +1,91 @@
+package tkengine
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// collapsingAlphabetProvider returns the default alphabets with all their symbols replaced by the first one
+type collapsingAlphabetProvider struct{}
+
+func (collapsingAlphabetProvider) GetAlphabetForBase(base uint32) ([]byte, error) {
+	alpha, err := DefaultAlphabetProvider{}.GetAlphabetForBase(base)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Repeat(alpha[:1], len(alpha)), nil
+}
+
+func Test_engine_VerifyInjective(t *testing.T) {
+	keys := &keyRepo{keys: map[byte][]byte{'a': make([]byte, 16), 'b': make([]byte, 16), 'B': make([]byte, 16)}}
+	versioner := formattedVersioner{
+		deterministicVersioner: deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a', 'b'}},
+		formats:                map[byte]Format{'b': FormatV1},
+	}
+	tests := map[string]struct {
+		opts       []Option
+		version    byte
+		sampleSize int
+		want       bool
+		wantErr    error
+	}{
+		"exhaustive_only":     {[]Option{WithMinDomainSize(1000)}, 'a', 0, true, nil},
+		"sampled":             {[]Option{WithMinDomainSize(1000)}, 'a', 1000, true, nil},
+		"format_v1":           {[]Option{WithMinDomainSize(1000)}, 'b', 1000, true, nil},
+		"ff31":                {[]Option{WithMinDomainSize(1000), WithFF31('b')}, 'b', 1000, true, nil},
+		"larger_domain":       {nil, 'a', 1000, true, nil},
+		"eight_digit_bins":    {[]Option{WithBINLengthDetection(EightDigitBINNetworks(NetworkVisa), 'B')}, 'B', 1000, true, nil},
+		"missing_keys":        {nil, 'c', 10, false, ErrKeyUnavailable},
+		"inactive_version_ok": {nil, 'b', 10, true, nil},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, tt.opts...)
+			if err != nil {
+				t.Fatalf("NewEngine() error = %v", err)
+			}
+			got, err := e.(InjectivityVerifier).VerifyInjective(tt.version, tt.sampleSize)
+			if !errors.Is(err, tt.wantErr) || (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("VerifyInjective() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("VerifyInjective() = %v, want %v", got, tt.want)
+			}
+			if n := len(e.(StatsReporter).VersionStats()); n != 0 {
+				t.Errorf("VersionStats() counted %d versions, want none", n)
+			}
+		})
+	}
+}
+
+func Test_engine_VerifyInjectiveInvalidArguments(t *testing.T) {
+	keys := fixedKeyRepo{false, make([]byte, 16)}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	if _, err := e.(InjectivityVerifier).VerifyInjective(0x01, 10); err == nil {
+		t.Errorf("VerifyInjective() with a non-printable version error = nil, want an error")
+	}
+	if _, err := e.(InjectivityVerifier).VerifyInjective('a', -1); err == nil {
+		t.Errorf("VerifyInjective() with a negative sample size error = nil, want an error")
+	}
+}
+
+func Test_engine_VerifyInjectiveCollision(t *testing.T) {
+	keys := fixedKeyRepo{false, make([]byte, 16)}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, WithMinDomainSize(shortCardsDomain))
+	if err != nil {
+		t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+	}
+	// an alphabet encoding every middle-digit value the same way breaks the injectivity
+	e.(*engine).alphaProvider = collapsingAlphabetProvider{}
+	for _, sampleSize := range []int{0, 100} {
+		if got, err := e.(InjectivityVerifier).VerifyInjective('a', sampleSize); err != nil || got {
+			t.Errorf("VerifyInjective(%d) = %v, %v, want false", sampleSize, got, err)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package tkengine
+
+// insecureDummyKeysWarning is logged by NewDummyEngine unless AllowInsecureDummyKeys is set
+const insecureDummyKeysWarning = "WARNING: the engine uses the hard-coded dummy keys of NewDummyEngine, tokens are NOT protected: never use it in production"
+
+// AllowInsecureDummyKeys acknowledges that the engine built by NewDummyEngine uses well-known
+// hard-coded keys, silencing the warning logged otherwise. The engine still reports IsInsecure.
+// It has no effect on the engines built by NewEngine.
+func AllowInsecureDummyKeys() Option {
+	return func(e *engine) {
+		e.allowInsecure = true
+	}
+}
+
+// InsecureReporter is an optional interface of a TKEngine reporting the use of well-known keys.
+// The engines built by this package implement it.
+type InsecureReporter interface {
+	// IsInsecure reports whether the engine uses the well-known
+	// hard-coded keys of NewDummyEngine (see AllowInsecureDummyKeys)
+	IsInsecure() bool
+}
+
+// IsInsecure reports whether the engine uses the hard-coded dummy keys of NewDummyEngine.
+// Deployments can assert it is false in a startup preflight.
+func (e *engine) IsInsecure() bool {
+	return e.insecure
+}
+
+// warnInsecure logs the insecure dummy keys warning unless acknowledged with AllowInsecureDummyKeys
+func (e *engine) warnInsecure() {
+	if e.insecure && !e.allowInsecure {
+		e.logf(insecureDummyKeysWarning)
+	}
+}
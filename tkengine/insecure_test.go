@@ -0,0 +1,44 @@
+package tkengine
+
+import "testing"
+
+func TestNewDummyEngine_insecure(t *testing.T) {
+	tests := map[string]struct {
+		allow        bool
+		wantWarnings int
+	}{
+		"warns":        {false, 1},
+		"acknowledged": {true, 0},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			logger := &recordingLogger{}
+			opts := []Option{WithLogger(logger)}
+			if tt.allow {
+				opts = append(opts, AllowInsecureDummyKeys())
+			}
+			e, err := NewDummyEngine(opts...)
+			if err != nil {
+				t.Fatalf("NewDummyEngine() error = %v", err)
+			}
+			if !e.(InsecureReporter).IsInsecure() {
+				t.Errorf("IsInsecure() = false, want true")
+			}
+			if len(logger.lines) != tt.wantWarnings {
+				t.Errorf("logged %v, want %d warnings", logger.lines, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestNewEngine_notInsecure(t *testing.T) {
+	keys := fixedKeyRepo{false, make([]byte, 16)}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, AllowInsecureDummyKeys())
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	if e.(InsecureReporter).IsInsecure() {
+		t.Errorf("IsInsecure() = true, want false")
+	}
+}
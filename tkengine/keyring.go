@@ -0,0 +1,254 @@
+package tkengine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// KeyProvider is an optional interface that the KeyVersioner or the encryption KeyRepo of an engine
+// can implement to return the tokenization version together with its keys in a single call. The engine
+// prefers it to the GetTokenizationVersion and GetKey calls and tokenizes with these keys: a key rotation
+// landing between the lookups can't pair the version with the keys of another snapshot, or with none.
+// Detokenization still looks the keys of the token version up in the key repositories.
+type KeyProvider interface {
+	// CurrentKeys returns the current tokenization version and its keys
+	CurrentKeys() (version byte, encryptionKey []byte, hmacKey []byte, err error)
+}
+
+// pinnedKeyRepo returns key for version and defers to repo for the other versions
+type pinnedKeyRepo struct {
+	version byte
+	key     []byte
+	repo    KeyRepo
+}
+
+func (p pinnedKeyRepo) GetKey(version byte) ([]byte, error) {
+	if version == p.version {
+		return p.key, nil
+	}
+	return p.repo.GetKey(version)
+}
+
+// keyProvider returns the KeyProvider of the engine, the versioner first, nil if there is none
+func (e *engine) keyProvider() KeyProvider {
+	if kp, ok := e.versioner.(KeyProvider); ok {
+		return kp
+	}
+	if kp, ok := e.encryptionKeys.(KeyProvider); ok {
+		return kp
+	}
+	return nil
+}
+
+// tokenizationVersion returns the tokenization version and the engine tokenizing with it. With a
+// KeyProvider, the engine is a copy of e whose key repositories are pinned to the keys returned with
+// the version, so that a single operation uses a consistent version and keys for its whole duration.
+// Otherwise it is e, the keys being looked up in the key repositories.
+func (e *engine) tokenizationVersion() (byte, *engine, error) {
+	kp := e.keyProvider()
+	if kp == nil {
+		v, err := e.versioner.GetTokenizationVersion()
+		return v, e, err
+	}
+	v, ekey, hkey, err := kp.CurrentKeys()
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrKeyUnavailable, err)
+	}
+	pinned := *e
+	pinned.encryptionKeys = pinnedKeyRepo{v, ekey, e.encryptionKeys}
+	pinned.hmacKeys = pinnedKeyRepo{v, hkey, e.hmacKeys}
+	return v, &pinned, nil
+}
+
+// KeyringSnapshot is a consistent set of versions and keys loaded by a RefreshableKeyring
+type KeyringSnapshot struct {
+	TokenizationVersion    byte
+	DetokenizationVersions []byte
+	EncryptionKeys         map[byte][]byte
+	HmacKeys               map[byte][]byte
+}
+
+// validate returns an error if the snapshot versions are invalid or miss their keys
+func (s KeyringSnapshot) validate() error {
+	if !contains(s.DetokenizationVersions, s.TokenizationVersion) {
+		return errors.New(fmt.Sprintf("Tokenization version %s is not among the detokenization versions [%s]", string(s.TokenizationVersion), string(s.DetokenizationVersions)))
+	}
+	for _, v := range s.DetokenizationVersions {
+		if err := ValidateVersion(v); err != nil {
+			return err
+		}
+		if _, ok := s.EncryptionKeys[v]; !ok {
+			return errors.New(fmt.Sprintf("No encryption key for version %s", string(v)))
+		}
+		if _, ok := s.HmacKeys[v]; !ok {
+			return errors.New(fmt.Sprintf("No hmac key for version %s", string(v)))
+		}
+	}
+	return nil
+}
+
+// RefreshableKeyring periodically reloads the versions and the keys of an engine as a whole with a
+// user-supplied function. Unlike a KeyVersioner and two RefreshableKeyRepo reloaded independently, the
+// versioner (the keyring itself) and its key repositories (EncryptionKeys and HmacKeys) always serve the
+// same snapshot, and the tokenization version and its keys are fetched in a single call (see
+// KeyProvider): an operation in flight during a reload completes with the keys it
+// started with while the next ones use the new snapshot.
+type RefreshableKeyring struct {
+	load func() (KeyringSnapshot, error)
+
+	// mu guards snap, err and gen
+	mu   sync.RWMutex
+	snap KeyringSnapshot
+	err  error
+	// gen is incremented every time a reload changes the keys
+	gen uint64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewRefreshableKeyring returns a keyring loaded with load and reloaded every interval until Stop is
+// called. The first load happens before returning and its error is returned. A failing reload, or one
+// returning an invalid snapshot, keeps the previous snapshot (see Err).
+func NewRefreshableKeyring(load func() (KeyringSnapshot, error), interval time.Duration) (*RefreshableKeyring, error) {
+	if load == nil {
+		return nil, errors.New("Missing keyring loading function")
+	}
+	if interval <= 0 {
+		return nil, errors.New(fmt.Sprintf("Invalid refresh interval %v: it should be positive", interval))
+	}
+	r := &RefreshableKeyring{
+		load: load,
+		stop: make(chan struct{}),
+	}
+	if err := r.Refresh(); err != nil {
+		return nil, err
+	}
+	go r.refreshEvery(interval)
+	return r, nil
+}
+
+// GetTokenizationVersion returns the tokenization version of the current snapshot
+func (r *RefreshableKeyring) GetTokenizationVersion() (byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.snap.TokenizationVersion, nil
+}
+
+// GetDetokenizationVersions returns the detokenization versions of the current snapshot
+func (r *RefreshableKeyring) GetDetokenizationVersions() ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]byte(nil), r.snap.DetokenizationVersions...), nil
+}
+
+// CurrentKeys returns the tokenization version of the current snapshot and its keys
+func (r *RefreshableKeyring) CurrentKeys() (byte, []byte, []byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	v := r.snap.TokenizationVersion
+	return v, r.snap.EncryptionKeys[v], r.snap.HmacKeys[v], nil
+}
+
+// EncryptionKeys returns the repository of the encryption keys of the keyring
+func (r *RefreshableKeyring) EncryptionKeys() KeyRepo {
+	return keyringRepo{r, false}
+}
+
+// HmacKeys returns the repository of the hmac keys of the keyring
+func (r *RefreshableKeyring) HmacKeys() KeyRepo {
+	return keyringRepo{r, true}
+}
+
+// Refresh reloads the snapshot immediately. On error the previous snapshot is kept.
+func (r *RefreshableKeyring) Refresh() error {
+	loaded, err := r.load()
+	var snap KeyringSnapshot
+	if err == nil {
+		err = loaded.validate()
+	}
+	if err == nil {
+		// the snapshot is copied so that the caller can't mutate it after the swap
+		snap = KeyringSnapshot{
+			TokenizationVersion:    loaded.TokenizationVersion,
+			DetokenizationVersions: append([]byte(nil), loaded.DetokenizationVersions...),
+			EncryptionKeys:         copyKeys(loaded.EncryptionKeys),
+			HmacKeys:               copyKeys(loaded.HmacKeys),
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+	if err == nil {
+		if !sameKeys(r.snap.EncryptionKeys, snap.EncryptionKeys) || !sameKeys(r.snap.HmacKeys, snap.HmacKeys) {
+			r.gen++
+		}
+		r.snap = snap
+	}
+	return err
+}
+
+// copyKeys returns a deep copy of keys
+func copyKeys(keys map[byte][]byte) map[byte][]byte {
+	c := make(map[byte][]byte, len(keys))
+	for v, key := range keys {
+		c[v] = append([]byte(nil), key...)
+	}
+	return c
+}
+
+// Err returns the error of the last reload, nil if it succeeded
+func (r *RefreshableKeyring) Err() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.err
+}
+
+// Stop stops the periodic reloads. The keyring keeps serving its last snapshot.
+func (r *RefreshableKeyring) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+}
+
+// refreshEvery reloads the snapshot every interval until Stop is called
+func (r *RefreshableKeyring) refreshEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = r.Refresh()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// keyringRepo serves the encryption or the hmac keys of the current snapshot of a keyring
+type keyringRepo struct {
+	r    *RefreshableKeyring
+	hmac bool
+}
+
+func (k keyringRepo) GetKey(v byte) ([]byte, error) {
+	k.r.mu.RLock()
+	defer k.r.mu.RUnlock()
+	keys := k.r.snap.EncryptionKeys
+	if k.hmac {
+		keys = k.r.snap.HmacKeys
+	}
+	key, ok := keys[v]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("No key exists for version %v", v))
+	}
+	return key, nil
+}
+
+// keyGeneration returns the number of reloads which changed the keys of the keyring
+func (k keyringRepo) keyGeneration() uint64 {
+	k.r.mu.RLock()
+	defer k.r.mu.RUnlock()
+	return k.r.gen
+}
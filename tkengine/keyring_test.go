@@ -0,0 +1,248 @@
+package tkengine
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// keyringSnapshot returns a snapshot holding the keys of version v only, all set to b
+func keyringSnapshot(v byte, b byte) KeyringSnapshot {
+	key := make([]byte, 16)
+	for i := range key {
+		key[i] = b
+	}
+	return KeyringSnapshot{
+		TokenizationVersion:    v,
+		DetokenizationVersions: []byte{v},
+		EncryptionKeys:         map[byte][]byte{v: key},
+		HmacKeys:               map[byte][]byte{v: key},
+	}
+}
+
+func TestNewRefreshableKeyring(t *testing.T) {
+	valid := func() (KeyringSnapshot, error) { return keyringSnapshot('a', 0), nil }
+	tests := map[string]struct {
+		load     func() (KeyringSnapshot, error)
+		interval time.Duration
+		wantErr  bool
+	}{
+		"valid":         {valid, time.Hour, false},
+		"nil_load":      {nil, time.Hour, true},
+		"zero_interval": {valid, 0, true},
+		"failing_load":  {func() (KeyringSnapshot, error) { return KeyringSnapshot{}, errors.New("unavailable") }, time.Hour, true},
+		"tokenization_version_not_detokenization": {func() (KeyringSnapshot, error) {
+			s := keyringSnapshot('a', 0)
+			s.TokenizationVersion = 'b'
+			return s, nil
+		}, time.Hour, true},
+		"missing_hmac_key": {func() (KeyringSnapshot, error) {
+			s := keyringSnapshot('a', 0)
+			s.HmacKeys = map[byte][]byte{}
+			return s, nil
+		}, time.Hour, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r, err := NewRefreshableKeyring(tt.load, tt.interval)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewRefreshableKeyring() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				r.Stop()
+			}
+		})
+	}
+}
+
+func TestRefreshableKeyring_invalidReloadKeepsSnapshot(t *testing.T) {
+	snap := keyringSnapshot('a', 0)
+	r, err := NewRefreshableKeyring(func() (KeyringSnapshot, error) { return snap, nil }, time.Hour)
+	if err != nil {
+		t.Fatalf("NewRefreshableKeyring() error = %v", err)
+	}
+	defer r.Stop()
+
+	snap.DetokenizationVersions = []byte{'b'}
+	if err := r.Refresh(); err == nil || r.Err() == nil {
+		t.Fatalf("Refresh() error = %v, Err() = %v, want errors", err, r.Err())
+	}
+	if v, _ := r.GetTokenizationVersion(); v != 'a' {
+		t.Errorf("GetTokenizationVersion() = %q, want 'a'", v)
+	}
+	if _, err := r.EncryptionKeys().GetKey('a'); err != nil {
+		t.Errorf("GetKey('a') error = %v", err)
+	}
+}
+
+// racingVersioner returns a tokenization version whose keys are missing from the repositories,
+// as if a rotation removed them between the version and the key lookups
+type racingVersioner struct {
+	deterministicVersioner
+	key []byte
+}
+
+func (r racingVersioner) CurrentKeys() (byte, []byte, []byte, error) {
+	return r.tokVersion, r.key, r.key, nil
+}
+
+func Test_engine_tokenizationKeysArePinned(t *testing.T) {
+	key := make([]byte, 16)
+	versioner := racingVersioner{deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, key}
+	e, err := NewEngine(versioner, fixedKeyRepo{true, nil}, fixedKeyRepo{true, nil}, DefaultAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	tests := map[string]struct {
+		op   func() (string, error)
+		want string
+	}{
+		"encrypt_cc": {func() (string, error) { return e.EncryptCC("4444333322221111") }, "444433aapchc1111"},
+		"encrypt_numeric": {func() (string, error) {
+			return e.(NumericTokenizer).EncryptNumeric("4444333322221111", CreditCardFormat)
+		}, "444433aapchc1111"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got, err := tt.op(); err != nil || got != tt.want {
+				t.Errorf("got %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+	// detokenization still goes through the repositories
+	if _, err := e.DecryptTK("444433aapchc1111"); !errors.Is(err, ErrKeyUnavailable) {
+		t.Errorf("DecryptTK() error = %v, want ErrKeyUnavailable", err)
+	}
+}
+
+// refreshingKeyring reloads the keyring right after returning the tokenization version once active,
+// as if a rotation landed before the keys are looked up
+type refreshingKeyring struct {
+	*RefreshableKeyring
+	active bool
+}
+
+func (r *refreshingKeyring) refresh() {
+	if r.active {
+		_ = r.Refresh()
+	}
+}
+
+func (r *refreshingKeyring) GetTokenizationVersion() (byte, error) {
+	defer r.refresh()
+	return r.RefreshableKeyring.GetTokenizationVersion()
+}
+
+func (r *refreshingKeyring) CurrentKeys() (byte, []byte, []byte, error) {
+	defer r.refresh()
+	return r.RefreshableKeyring.CurrentKeys()
+}
+
+// alternatingSnapshots returns a loader alternating the versions 'a' and 'b', every snapshot only
+// holding the keys of its own version
+func alternatingSnapshots() func() (KeyringSnapshot, error) {
+	var mu sync.Mutex
+	gen := 0
+	return func() (KeyringSnapshot, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		gen++
+		return keyringSnapshot(byte('a'+gen%2), byte(gen%2)), nil
+	}
+}
+
+func Test_engine_keyringRotationBetweenLookups(t *testing.T) {
+	r, err := NewRefreshableKeyring(alternatingSnapshots(), time.Hour)
+	if err != nil {
+		t.Fatalf("NewRefreshableKeyring() error = %v", err)
+	}
+	defer r.Stop()
+	rk := &refreshingKeyring{RefreshableKeyring: r}
+	e, err := NewEngine(rk, r.EncryptionKeys(), r.HmacKeys(), DefaultAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	rk.active = true
+	// the version and the keys of a snapshot are used together although the keyring rotated
+	for i := 0; i < 4; i++ {
+		if _, err := e.EncryptCC("4444333322221111"); err != nil {
+			t.Fatalf("EncryptCC() #%d error = %v", i, err)
+		}
+	}
+}
+
+func Test_engine_keyringConcurrentRotation(t *testing.T) {
+	// an operation pairing the version of a snapshot with the keys of the next one would fail
+	// with ErrKeyUnavailable
+	r, err := NewRefreshableKeyring(alternatingSnapshots(), time.Hour)
+	if err != nil {
+		t.Fatalf("NewRefreshableKeyring() error = %v", err)
+	}
+	defer r.Stop()
+	e, err := NewEngine(r, r.EncryptionKeys(), r.HmacKeys(), DefaultAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				_ = r.Refresh()
+			}
+		}
+	}()
+	for i := 0; i < 500; i++ {
+		if _, err := e.EncryptCC("4444333322221111"); err != nil {
+			t.Fatalf("EncryptCC() during rotation error = %v", err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+// providerKeyRepo is a key repository also providing the current keys in a single call
+type providerKeyRepo struct {
+	fixedKeyRepo
+	err bool
+}
+
+func (p providerKeyRepo) CurrentKeys() (byte, []byte, []byte, error) {
+	if p.err {
+		return 0, nil, nil, errors.New("key store unavailable")
+	}
+	return 'a', make([]byte, 16), make([]byte, 16), nil
+}
+
+func Test_engine_keyProvider(t *testing.T) {
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	tests := map[string]struct {
+		keys    KeyRepo
+		want    string
+		wantErr error
+	}{
+		"no_provider":              {fixedKeyRepo{false, make([]byte, 16)}, "444433aapchc1111", nil},
+		"repo_provider":            {providerKeyRepo{fixedKeyRepo{true, nil}, false}, "444433aapchc1111", nil},
+		"repo_provider_failure":    {providerKeyRepo{fixedKeyRepo{false, make([]byte, 16)}, true}, "", ErrKeyUnavailable},
+		"no_provider_repo_failure": {fixedKeyRepo{true, nil}, "", ErrKeyUnavailable},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngine(versioner, tt.keys, tt.keys, DefaultAlphabetProvider{})
+			if err != nil {
+				t.Fatalf("NewEngine() error = %v", err)
+			}
+			got, err := e.EncryptCC("4444333322221111")
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Errorf("EncryptCC() = %s, %v, want %s, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package tkengine
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// keystorePEMType is the type of the PEM block holding an encrypted keystore
+const keystorePEMType = "TKENGINE ENCRYPTED KEYS"
+
+// scrypt parameters deriving the keystore wrapping key from the passphrase
+const (
+	keystoreSaltSize = 16
+	keystoreScryptN  = 1 << 15
+	keystoreScryptR  = 8
+	keystoreScryptP  = 1
+)
+
+// encryptedFileKeyRepo is a key repository loaded from an encrypted keystore file
+type encryptedFileKeyRepo struct {
+	keys map[byte][]byte
+}
+
+// NewEncryptedFileKeyRepo returns a key repository holding the keys of the keystore file at path.
+// The keystore is a PEM block wrapping salt || nonce || AES-256-GCM ciphertext of the version keys,
+// the AES key being derived from passphrase with scrypt. The keys are decrypted once at load time
+// and never written in clear on disk. As a KeyRepo serves one key per version, encryption and HMAC
+// keys are stored in two distinct keystores. Keystores are created with WriteEncryptedFileKeyRepo.
+func NewEncryptedFileKeyRepo(path string, passphrase string) (KeyRepo, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != keystorePEMType {
+		return nil, errors.New(fmt.Sprintf("keystore %s is not a %s PEM block", path, keystorePEMType))
+	}
+
+	plaintext, err := openKeystore(block.Bytes, passphrase)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("could not decrypt keystore %s: %v", path, err))
+	}
+	defer zero(plaintext)
+
+	var hexKeys map[string]string
+	if err := json.Unmarshal(plaintext, &hexKeys); err != nil {
+		return nil, errors.New(fmt.Sprintf("malformed keystore %s: %v", path, err))
+	}
+
+	keys := make(map[byte][]byte, len(hexKeys))
+	for v, hexKey := range hexKeys {
+		if len(v) != 1 {
+			return nil, errors.New(fmt.Sprintf("keystore %s: version id should be a single-byte, instead its %s", path, v))
+		}
+		key, err := hex.DecodeString(hexKey)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("keystore %s: version %s key is not hex encoded", path, v))
+		}
+		keys[v[0]] = key
+	}
+	return &encryptedFileKeyRepo{keys: keys}, nil
+}
+
+// WriteEncryptedFileKeyRepo writes keys to a keystore file at path readable by NewEncryptedFileKeyRepo
+// with the same passphrase. The file is only readable by its owner.
+func WriteEncryptedFileKeyRepo(path string, passphrase string, keys map[byte][]byte) error {
+	hexKeys := make(map[string]string, len(keys))
+	for v, key := range keys {
+		hexKeys[string(v)] = hex.EncodeToString(key)
+	}
+	plaintext, err := json.Marshal(hexKeys)
+	if err != nil {
+		return err
+	}
+	defer zero(plaintext)
+
+	sealed, err := sealKeystore(plaintext, passphrase)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: keystorePEMType, Bytes: sealed}), 0600)
+}
+
+// GetKey returns the key of version v
+func (r *encryptedFileKeyRepo) GetKey(v byte) ([]byte, error) {
+	key, ok := r.keys[v]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Version %s not found in keystore", string(v)))
+	}
+	return key, nil
+}
+
+// sealKeystore encrypts plaintext with a key derived from passphrase and returns salt || nonce || ciphertext
+func sealKeystore(plaintext []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, keystoreSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aead, err := keystoreAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := append(salt, nonce...)
+	return aead.Seal(sealed, nonce, plaintext, nil), nil
+}
+
+// openKeystore is the inverse of sealKeystore
+func openKeystore(sealed []byte, passphrase string) ([]byte, error) {
+	if len(sealed) < keystoreSaltSize {
+		return nil, errors.New("truncated keystore")
+	}
+	salt, sealed := sealed[:keystoreSaltSize], sealed[keystoreSaltSize:]
+	aead, err := keystoreAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("truncated keystore")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupted keystore")
+	}
+	return plaintext, nil
+}
+
+// keystoreAEAD returns the AES-256-GCM cipher keyed with the scrypt derivation of passphrase and salt
+func keystoreAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty keystore passphrase")
+	}
+	key, err := scrypt.Key([]byte(passphrase), salt, keystoreScryptN, keystoreScryptR, keystoreScryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(key)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,91 @@
+package tkengine
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewEncryptedFileKeyRepo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keys := map[byte][]byte{
+		'a': {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		'b': {1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+	}
+	keystore := filepath.Join(dir, "keys.pem")
+	if err := WriteEncryptedFileKeyRepo(keystore, "s3cr3t", keys); err != nil {
+		t.Fatalf("WriteEncryptedFileKeyRepo() error = %v", err)
+	}
+	notPEM := filepath.Join(dir, "keys.json")
+	if err := ioutil.WriteFile(notPEM, []byte(`{"a":"00000000000000000000000000000000"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// keys are not stored in clear
+	data, err := ioutil.ReadFile(keystore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("0101010101")) {
+		t.Errorf("keystore contains the keys in clear: %s", data)
+	}
+
+	tests := map[string]struct {
+		path       string
+		passphrase string
+		wantErr    bool
+	}{
+		"valid_passphrase": {keystore, "s3cr3t", false},
+		"wrong_passphrase": {keystore, "secret", true},
+		"empty_passphrase": {keystore, "", true},
+		"missing_file":     {filepath.Join(dir, "missing.pem"), "s3cr3t", true},
+		"not_a_keystore":   {notPEM, "s3cr3t", true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			repo, err := NewEncryptedFileKeyRepo(tt.path, tt.passphrase)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewEncryptedFileKeyRepo() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			for v, want := range keys {
+				got, err := repo.GetKey(v)
+				if err != nil {
+					t.Errorf("GetKey(%s) error = %v", string(v), err)
+					continue
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("GetKey(%s) got = %v, want %v", string(v), got, want)
+				}
+			}
+			if _, err := repo.GetKey('c'); err == nil {
+				t.Errorf("GetKey(c) expected error for missing version")
+			}
+
+			// the keystore drives an engine as any other key repository
+			e, err := NewEngineWithDefaultAlphabet(deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, repo, repo)
+			if err != nil {
+				t.Errorf("NewEngineWithDefaultAlphabet() error = %v", err)
+				return
+			}
+			tk, err := e.EncryptCC("4444333322221111")
+			if err != nil {
+				t.Errorf("EncryptCC() error = %v", err)
+				return
+			}
+			if want := "444433aapchc1111"; tk != want {
+				t.Errorf("EncryptCC() got = %v, want %v", tk, want)
+			}
+		})
+	}
+}
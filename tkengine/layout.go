@@ -0,0 +1,190 @@
+package tkengine
+
+import (
+	"errors"
+	"fmt"
+)
+
+// LayoutVersioner is an optional interface that a KeyVersioner can implement to bind a credit card
+// layout (the numbers of leading and trailing digits preserved in clear) to each version. The layout
+// is not stored in the tokens: as the version char follows the preserved prefix, DecryptTK locates
+// it at the position of each detokenization version layout and decrypts the token with the layout
+// of the version found there. Changing the preserved lengths is then a key rotation: tokens of the
+// older versions keep decrypting with their own layout. Versioners not implementing it use the
+// CreditCardFormat layout (6 first and 4 last digits) for all the versions.
+type LayoutVersioner interface {
+	// GetLayout returns the numbers of leading and trailing digits preserved
+	// by the credit card tokens of the input version
+	GetLayout(version byte) (prefix int, suffix int, err error)
+}
+
+// ccFormat returns the credit card layout bound to version v
+func (e *engine) ccFormat(v byte) (FormatOpts, error) {
+	lv, ok := e.versioner.(LayoutVersioner)
+	if !ok {
+		return CreditCardFormat, nil
+	}
+	p, s, err := lv.GetLayout(v)
+	if err != nil {
+		return FormatOpts{}, err
+	}
+	opts := CreditCardFormat
+	opts.PreservedPrefix, opts.PreservedSuffix = p, s
+	if err := opts.validate(); err != nil {
+		return FormatOpts{}, errors.New(fmt.Sprintf("Invalid layout of version %s: %v", string(v), err))
+	}
+	return opts, nil
+}
+
+// ccLayout returns the layout of the credit card token tk: the layout of the detokenization version
+// found at its version position. CreditCardFormat is returned if there is none, so that the token
+// validation reports why tk is not a token.
+func (e *engine) ccLayout(tk string, detokVers []byte) (FormatOpts, error) {
+	if !e.hasCustomLayouts() {
+		return CreditCardFormat, nil
+	}
+	for _, v := range detokVers {
+		opts, err := e.cardFormat(v, tk)
+		if err != nil {
+			return FormatOpts{}, err
+		}
+		// validateLayouts guarantees that a single version matches
+		if opts.PreservedPrefix < len(tk) && tk[opts.PreservedPrefix] == v {
+			return opts, nil
+		}
+	}
+	return CreditCardFormat, nil
+}
+
+// ccVersionIndex returns the position of the version char in the credit card token tk
+func (e *engine) ccVersionIndex(tk string) int {
+	if !e.hasCustomLayouts() {
+		return CreditCardFormat.PreservedPrefix
+	}
+	detokVers, err := e.versioner.GetDetokenizationVersions()
+	if err != nil {
+		return CreditCardFormat.PreservedPrefix
+	}
+	opts, err := e.ccLayout(tk, detokVers)
+	if err != nil {
+		return CreditCardFormat.PreservedPrefix
+	}
+	return opts.PreservedPrefix
+}
+
+// hasCustomLayouts returns true if the layouts of the credit card tokens can differ from CreditCardFormat,
+// with a LayoutVersioner or a BIN length detection
+func (e *engine) hasCustomLayouts() bool {
+	_, ok := e.versioner.(LayoutVersioner)
+	return ok || e.binVersions != nil
+}
+
+// retokenizeLayout re-encrypts the valid token tk laid out as oldOpts under version v laid out as opts
+func (e *engine) retokenizeLayout(tk string, oldOpts FormatOpts, opts FormatOpts, v byte, alpha AlphabetProvider) (string, error) {
+	if err := e.checkDomain(len(tk), opts); err != nil {
+		return "", err
+	}
+	cc, err := e.decrypt(tk, oldOpts, alpha, nil)
+	if err != nil {
+		return "", err
+	}
+	return e.encryptVersion(cc, v, opts, e.alphaProvider, nil)
+}
+
+// validateLayouts returns an error if the layouts of the detokenization versions are invalid or if
+// the version of a token could be found at the version position of another layout: the version chars
+// of the layouts preserving fewer leading digits can't be digits, and the ones of the layouts
+// preserving more can't be digits nor symbols of the alphabets either. The versions with a BIN length
+// detection have both the 6 and the 8 leading digits layouts.
+func (e *engine) validateLayouts() error {
+	if !e.hasCustomLayouts() {
+		return nil
+	}
+	detokVers, err := e.versioner.GetDetokenizationVersions()
+	if err != nil {
+		return err
+	}
+	layouts := make(map[byte]FormatOpts, len(detokVers))
+	// prefixes are the numbers of leading digits the tokens of each version can preserve
+	prefixes := make(map[byte][]int, len(detokVers))
+	custom := false
+	for _, v := range detokVers {
+		opts, err := e.ccFormat(v)
+		if err != nil {
+			return err
+		}
+		layouts[v] = opts
+		prefixes[v] = []int{opts.PreservedPrefix}
+		isCustom := opts.PreservedPrefix != CreditCardFormat.PreservedPrefix || opts.PreservedSuffix != CreditCardFormat.PreservedSuffix
+		if e.binVersions[v] {
+			if isCustom {
+				return errors.New(fmt.Sprintf("BIN length detection is not supported with the custom layout of version %s", string(v)))
+			}
+			prefixes[v] = append(prefixes[v], CreditCardEightDigitBINFormat.PreservedPrefix)
+			isCustom = true
+		}
+		custom = custom || isCustom
+	}
+	if !custom {
+		return nil
+	}
+	if e.versionFallback {
+		return errors.New("WithVersionFallback is not supported with per-version layouts or BIN length detection: the version position depends on the layout")
+	}
+	if e.tokenWidth != 0 {
+		return errors.New("WithFixedTokenWidth is not supported with per-version layouts or BIN length detection")
+	}
+
+	// the bases of CreditCardEightDigitBINFormat are among the ones of CreditCardFormat
+	symbols, err := e.layoutSymbols(layouts)
+	if err != nil {
+		return err
+	}
+	for _, a := range detokVers {
+		for _, b := range detokVers {
+			// the layout of the tokens of a version is found out of their own leading digits
+			if a == b {
+				continue
+			}
+			for _, pa := range prefixes[a] {
+				for _, pb := range prefixes[b] {
+					if pa >= pb {
+						continue
+					}
+					if isDigit(a) || isDigit(b) || symbols[b] {
+						return errors.New(fmt.Sprintf("Ambiguous layouts of versions %s and %s: the version preserving fewer leading digits can't be a digit and the other one can't be a digit nor an alphabet symbol", string(a), string(b)))
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// layoutSymbols returns the symbols of the tokenization and detokenization alphabets encoding the
+// middle digits of layouts
+func (e *engine) layoutSymbols(layouts map[byte]FormatOpts) (map[byte]bool, error) {
+	symbols := make(map[byte]bool)
+	for _, alpha := range []AlphabetProvider{e.alphaProvider, e.detokAlphaProvider} {
+		if alpha == nil {
+			continue
+		}
+		for v, opts := range layouts {
+			if err := validateAlphabetBases(alpha, opts.encodingBases()); err != nil {
+				return nil, errors.New(fmt.Sprintf("Invalid layout of version %s: %v", string(v), err))
+			}
+			for _, base := range opts.encodingBases() {
+				a, _ := alpha.GetAlphabetForBase(base)
+				for _, symbol := range a {
+					symbols[symbol] = true
+				}
+			}
+		}
+	}
+	return symbols, nil
+}
+
+// isDigit returns true if c is a decimal digit
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
@@ -0,0 +1,153 @@
+package tkengine
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// layoutVersioner binds a preserved prefix and suffix to each of its versions
+type layoutVersioner struct {
+	deterministicVersioner
+	layouts map[byte][2]int
+}
+
+func (l layoutVersioner) GetLayout(version byte) (int, int, error) {
+	layout, ok := l.layouts[version]
+	if !ok {
+		return 0, 0, errors.New("no layout")
+	}
+	return layout[0], layout[1], nil
+}
+
+func newLayoutEngine(t *testing.T, tokVersion byte, layouts map[byte][2]int, opts ...Option) (TKEngine, error) {
+	t.Helper()
+	var detokVersions []byte
+	for _, v := range []byte{'a', 'B', 'C', 'b', '1'} {
+		if _, ok := layouts[v]; ok {
+			detokVersions = append(detokVersions, v)
+		}
+	}
+	keys := fixedKeyRepo{false, make([]byte, 16)}
+	versioner := layoutVersioner{deterministicVersioner{tokVersion: tokVersion, detokVersions: detokVersions}, layouts}
+	return NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, append(opts, WithMinDomainSize(shortCardsDomain))...)
+}
+
+func TestNewEngine_layouts(t *testing.T) {
+	tests := map[string]struct {
+		layouts map[byte][2]int
+		opts    []Option
+		wantErr bool
+	}{
+		"default_layouts":           {map[byte][2]int{'a': {6, 4}, 'b': {6, 4}}, nil, false},
+		"distinct_layouts":          {map[byte][2]int{'a': {6, 4}, 'B': {8, 2}, 'C': {9, 0}}, nil, false},
+		"shorter_prefix":            {map[byte][2]int{'a': {6, 4}, 'C': {0, 4}}, nil, true},
+		"middle_too_short":          {map[byte][2]int{'a': {6, 4}, 'B': {8, 4}}, nil, true},
+		"negative_prefix":           {map[byte][2]int{'a': {6, 4}, 'B': {-1, 4}}, nil, true},
+		"symbol_version":            {map[byte][2]int{'a': {6, 4}, 'b': {8, 2}}, nil, true},
+		"digit_version":             {map[byte][2]int{'1': {6, 4}, 'B': {8, 2}}, nil, true},
+		"digit_version_same_layout": {map[byte][2]int{'1': {6, 4}, 'a': {6, 4}}, nil, false},
+		"version_fallback":          {map[byte][2]int{'a': {6, 4}, 'B': {8, 2}}, []Option{WithVersionFallback()}, true},
+		"fixed_token_width":         {map[byte][2]int{'a': {6, 4}, 'B': {8, 2}}, []Option{WithFixedTokenWidth(19)}, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var tokVersion byte
+			for v := range tt.layouts {
+				tokVersion = v
+			}
+			_, err := newLayoutEngine(t, tokVersion, tt.layouts, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewEngine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_engine_layouts(t *testing.T) {
+	layouts := map[byte][2]int{'a': {6, 4}, 'B': {8, 2}, 'C': {9, 0}}
+	tests := map[string]struct {
+		cc         string
+		tokVersion byte
+		wantPrefix string
+		wantSuffix string
+	}{
+		"default_layout":   {"4444333322221111", 'a', "444433a", "1111"},
+		"longer_prefix":    {"4444333322221111", 'B', "44443333B", "11"},
+		"longer_prefix_13": {"4444333322221", 'B', "44443333B", "21"},
+		"no_suffix":        {"4444333322221111", 'C', "444433332C", ""},
+		"no_suffix_19":     {"4444333322221111999", 'C', "444433332C", ""},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := newLayoutEngine(t, tt.tokVersion, layouts)
+			if err != nil {
+				t.Fatalf("NewEngine() error = %v", err)
+			}
+			tk, err := e.EncryptCC(tt.cc)
+			if err != nil {
+				t.Fatalf("EncryptCC() error = %v", err)
+			}
+			if len(tk) != len(tt.cc) || !strings.HasPrefix(tk, tt.wantPrefix) || !strings.HasSuffix(tk, tt.wantSuffix) {
+				t.Errorf("EncryptCC() = %s, want %s...%s of %d chars", tk, tt.wantPrefix, tt.wantSuffix, len(tt.cc))
+			}
+			if v, err := e.(TokenInspector).TokenVersion(tk); err != nil || v != tt.tokVersion {
+				t.Errorf("TokenVersion() = %q, %v, want %q", v, err, tt.tokVersion)
+			}
+			if n := e.(StatsReporter).VersionStats()[tt.tokVersion]; n != 1 {
+				t.Errorf("VersionStats() counted %d tokens of version %q, want 1", n, tt.tokVersion)
+			}
+
+			// tokens of every version decrypt with their own layout, whatever the tokenization version
+			for v := range layouts {
+				other, err := newLayoutEngine(t, v, layouts)
+				if err != nil {
+					t.Fatalf("NewEngine() error = %v", err)
+				}
+				if got, err := other.DecryptTK(tk); err != nil || got != tt.cc {
+					t.Errorf("DecryptTK() with tokenization version %q = %s, %v, want %s", v, got, err, tt.cc)
+				}
+				rtk, err := other.(Retokenizer).Retokenize(tk)
+				if err != nil {
+					t.Fatalf("Retokenize() with tokenization version %q error = %v", v, err)
+				}
+				if got, err := e.DecryptTK(rtk); err != nil || got != tt.cc {
+					t.Errorf("DecryptTK() of retokenized %s = %s, %v, want %s", rtk, got, err, tt.cc)
+				}
+				if rv, _ := e.(TokenInspector).TokenVersion(rtk); rv != v {
+					t.Errorf("retokenized %s has version %q, want %q", rtk, rv, v)
+				}
+			}
+		})
+	}
+}
+
+func Test_engine_layoutsDefaultTokens(t *testing.T) {
+	// versioners binding the default layout produce the same tokens as the others
+	e, err := newLayoutEngine(t, 'a', map[byte][2]int{'a': {6, 4}})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	if tk, err := e.EncryptCC("4444333322221111"); err != nil || tk != "444433aapchc1111" {
+		t.Errorf("EncryptCC() = %s, %v, want 444433aapchc1111", tk, err)
+	}
+}
+
+func TestNewEngineWithAlphabets_layoutBases(t *testing.T) {
+	// the middle digits of a layout preserving the 4 last digits alone are encoded in base 13
+	keys := fixedKeyRepo{false, make([]byte, 16)}
+	versioner := layoutVersioner{deterministicVersioner{tokVersion: 'X', detokVersions: []byte{'X'}}, map[byte][2]int{'X': {0, 4}}}
+	e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	if tk, err := e.EncryptCC("4444333322221111"); err != nil || tk != "Xbbjjabgdhkm1111" {
+		t.Fatalf("EncryptCC() = %s, %v, want Xbbjjabgdhkm1111", tk, err)
+	}
+
+	// a base 13 alphabet reordering the old one would decode the old tokens to other cards
+	rotated := defaultAlphabetsWith(map[uint32]string{13: "bcdefghijklma"})
+	if _, err := NewEngineWithAlphabets(versioner, keys, keys, rotated, DefaultAlphabetProvider{}); err == nil {
+		t.Errorf("NewEngineWithAlphabets() with a reordered base 13 alphabet error = nil, want an error")
+	}
+}
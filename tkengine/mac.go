@@ -0,0 +1,142 @@
+package tkengine
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// FormatMAC is the FormatV0 layout followed by TokenMACLength chars of a truncated HMAC of the token,
+// keyed with the hmac key of the version. DecryptTK, TokenVersion and Retokenize verify the
+// MAC before any decryption and DecryptTK returns ErrTokenIntegrity on mismatch. IsToken, which
+// retrieves no key, only checks that the MAC chars belong to the MAC alphabet. The tokens are
+// TokenMACLength chars longer than the cards: declare it for new versions only (see FormatVersioner).
+// Numeric values (EncryptNumeric) don't carry the MAC.
+const FormatMAC Format = 1
+
+// TokenMACLength is the number of MAC chars appended to the FormatMAC tokens. Each char encodes 5 bits
+// of the HMAC: a tampered token goes undetected with a probability of 2^-20 (about one in a million).
+const TokenMACLength = 4
+
+// macAlphabet is the alphabet of the MAC chars, the lowercase RFC 4648 base32 alphabet
+var macAlphabet = []byte("abcdefghijklmnopqrstuvwxyz234567")
+
+// macLabel separates the MAC HMAC from the tweak HMAC computed with the same key
+var macLabel = []byte("tkengine-token-mac")
+
+// ErrTokenIntegrity is returned when the MAC of a FormatMAC token doesn't match its content,
+// i.e. the token was altered or truncated
+var ErrTokenIntegrity = errors.New("Token integrity check failure")
+
+// sealToken pads the credit card token tk (see padToken) and, if its version is bound to FormatMAC,
+// appends its MAC
+func (e *engine) sealToken(tk string) (string, error) {
+	tk, err := e.padToken(tk)
+	if err != nil {
+		return "", err
+	}
+	v := tk[e.ccVersionIndex(tk)]
+	f, err := e.formatFor(v)
+	if err != nil || f != FormatMAC {
+		return tk, err
+	}
+	mac, err := e.tokenMAC(tk, v)
+	if err != nil {
+		return "", err
+	}
+	return tk + mac, nil
+}
+
+// openToken verifies and strips the MAC of the credit card token tk if its version is bound to
+// FormatMAC, then strips its padding (see unpadToken)
+func (e *engine) openToken(tk string) (string, error) {
+	body, mac, v, err := e.splitMAC(tk)
+	if err != nil || mac == "" {
+		return body, err
+	}
+	want, err := e.tokenMAC(body, v)
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal([]byte(mac), []byte(want)) {
+		return "", ErrTokenIntegrity
+	}
+	return e.unpadToken(body)
+}
+
+// stripToken strips the MAC of the credit card token tk if its version is bound to FormatMAC, then
+// its padding, like openToken but without verifying the MAC: no key is retrieved and the MAC chars
+// are only checked to belong to the MAC alphabet
+func (e *engine) stripToken(tk string) (string, error) {
+	body, mac, _, err := e.splitMAC(tk)
+	if err != nil || mac == "" {
+		return body, err
+	}
+	for i := 0; i < len(mac); i++ {
+		if !contains(macAlphabet, mac[i]) {
+			return "", fmt.Errorf("%w: MAC characters outside the MAC alphabet", ErrInvalidTK)
+		}
+	}
+	return e.unpadToken(body)
+}
+
+// splitMAC splits the credit card token tk of version v into its body and its MAC if v is bound to
+// FormatMAC. Otherwise mac is empty and body is tk stripped of its padding (see unpadToken).
+func (e *engine) splitMAC(tk string) (body string, mac string, v byte, err error) {
+	i := e.ccVersionIndex(tk)
+	if i >= len(tk) {
+		// not a token, the token validation reports why
+		body, err = e.unpadToken(tk)
+		return body, "", 0, err
+	}
+	v = tk[i]
+	f, err := e.formatFor(v)
+	if err != nil {
+		return "", "", v, err
+	}
+	if f != FormatMAC {
+		body, err = e.unpadToken(tk)
+		return body, "", v, err
+	}
+	if len(tk) < i+1+TokenMACLength {
+		return "", "", v, fmt.Errorf("%w: length %d too short for a MAC of %d chars", ErrInvalidTK, len(tk), TokenMACLength)
+	}
+	return tk[:len(tk)-TokenMACLength], tk[len(tk)-TokenMACLength:], v, nil
+}
+
+// tokenMAC returns the TokenMACLength chars MAC of the token body tk of version v
+func (e *engine) tokenMAC(tk string, v byte) (string, error) {
+	hkey, err := getSecretKey(e.hmacKeys, v)
+	if err != nil {
+		return "", err
+	}
+	defer hkey.Close()
+
+	h := e.hmacFor(v, hkey.Bytes())
+	h.Write(macLabel)
+	h.Write([]byte(tk))
+	sum := h.Sum(nil)
+
+	// the first bits of the HMAC, TokenMACLength symbols of the alphabet
+	bits := uint(TokenMACLength) * 5
+	return EncodeBaseN(binary.BigEndian.Uint64(sum[:8])>>(64-bits), macAlphabet, TokenMACLength)
+}
+
+// validateMACFormats returns an error if a detokenization version is bound to FormatMAC on an engine
+// built with WithVersionFallback: the MAC already detects the corrupted version chars
+func (e *engine) validateMACFormats() error {
+	if !e.versionFallback {
+		return nil
+	}
+	detokVers, err := e.versioner.GetDetokenizationVersions()
+	if err != nil {
+		return err
+	}
+	for _, v := range detokVers {
+		if f, err := e.formatFor(v); err == nil && f == FormatMAC {
+			return errors.New(fmt.Sprintf("WithVersionFallback is not supported with FormatMAC version %s: the MAC detects the corrupted tokens", string(v)))
+		}
+	}
+	return nil
+}
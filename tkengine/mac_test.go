@@ -0,0 +1,167 @@
+package tkengine
+
+import (
+	"errors"
+	"testing"
+)
+
+// macVersioner tokenizes with the FormatMAC version a and detokenizes the FormatV0 version b too
+var macVersioner = formattedVersioner{
+	deterministicVersioner: deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a', 'b'}},
+	formats:                map[byte]Format{'a': FormatMAC},
+}
+
+// tamper returns tk with its char at index i replaced by another char of the same kind
+func tamper(tk string, i int) string {
+	c := tk[i] + 1
+	if c == 'z'+1 || c == '9'+1 || c == '7'+1 && i >= len(tk)-TokenMACLength {
+		c = tk[i] - 1
+	}
+	return tk[:i] + string(c) + tk[i+1:]
+}
+
+func Test_engine_FormatMAC(t *testing.T) {
+	keys := &keyRepo{keys: map[byte][]byte{'a': make([]byte, 16), 'b': make([]byte, 16)}}
+	tests := map[string]struct {
+		cc   string
+		opts []Option
+		// body is the token without its MAC
+		body string
+	}{
+		"16_digits":   {"4444333322221111", nil, "444433aapchc1111"},
+		"13_digits":   {"4444333322221", nil, "444433ad32221"},
+		"fixed_width": {"4444333322221", []Option{WithFixedTokenWidth(19)}, "444433ad39999992221"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngine(macVersioner, keys, keys, DefaultAlphabetProvider{}, append(tt.opts, WithMinDomainSize(shortCardsDomain))...)
+			if err != nil {
+				t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+			}
+			tk, err := e.EncryptCC(tt.cc)
+			if err != nil {
+				t.Fatalf("EncryptCC() error = %v", err)
+			}
+			if len(tk) != len(tt.body)+TokenMACLength || tk[:len(tt.body)] != tt.body {
+				t.Fatalf("EncryptCC() = %v, want %v followed by %d MAC chars", tk, tt.body, TokenMACLength)
+			}
+			if cc, err := e.DecryptTK(tk); err != nil || cc != tt.cc {
+				t.Errorf("DecryptTK() = %v, %v, want %v", cc, err, tt.cc)
+			}
+			if !e.(TokenInspector).IsToken(tk) {
+				t.Errorf("IsToken(%v) = false, want true", tk)
+			}
+			if v, err := e.(TokenInspector).TokenVersion(tk); err != nil || v != 'a' {
+				t.Errorf("TokenVersion() = %v, %v, want a", v, err)
+			}
+			if rtk, err := e.(Retokenizer).Retokenize(tk); err != nil || rtk != tk {
+				t.Errorf("Retokenize() = %v, %v, want %v", rtk, err, tk)
+			}
+
+			// any altered char of the middle digits or of the MAC is detected
+			for _, i := range []int{7, len(tk) - TokenMACLength - 5, len(tk) - 1} {
+				tampered := tamper(tk, i)
+				if _, err := e.DecryptTK(tampered); !errors.Is(err, ErrTokenIntegrity) {
+					t.Errorf("DecryptTK(%v) error = %v, want ErrTokenIntegrity", tampered, err)
+				}
+			}
+			// so is a token stripped of its MAC
+			if _, err := e.DecryptTK(tt.body); err == nil {
+				t.Errorf("DecryptTK(%v) error = nil, want an error", tt.body)
+			}
+		})
+	}
+}
+
+func Test_engine_FormatMACRotation(t *testing.T) {
+	zeros := make([]byte, 16)
+	ones := []byte{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}
+	encryptionKeys := &keyRepo{keys: map[byte][]byte{'a': zeros, 'b': zeros}}
+	e, err := NewEngine(macVersioner, encryptionKeys, &keyRepo{keys: map[byte][]byte{'a': zeros, 'b': zeros}}, DefaultAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	// a FormatV0 token is retokenized into a FormatMAC token
+	cc, err := e.DecryptTK("444433bapchc1111")
+	if err != nil {
+		t.Fatalf("DecryptTK() error = %v", err)
+	}
+	rtk, err := e.(Retokenizer).Retokenize("444433bapchc1111")
+	if err != nil || len(rtk) != 16+TokenMACLength || rtk[6] != 'a' {
+		t.Fatalf("Retokenize() = %v, %v, want a FormatMAC token of version a", rtk, err)
+	}
+	if got, err := e.DecryptTK(rtk); err != nil || got != cc {
+		t.Errorf("DecryptTK(%v) = %v, %v, want %v", rtk, got, err, cc)
+	}
+
+	// the MAC follows the hmac key of the version
+	rotated, err := NewEngine(macVersioner, encryptionKeys, &keyRepo{keys: map[byte][]byte{'a': ones, 'b': zeros}}, DefaultAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	if _, err := rotated.DecryptTK(rtk); !errors.Is(err, ErrTokenIntegrity) {
+		t.Errorf("DecryptTK() with another hmac key error = %v, want ErrTokenIntegrity", err)
+	}
+	wrapped, err := rotated.(TweakRewrapper).RewrapTweak(rtk, zeros, ones)
+	if err != nil {
+		t.Fatalf("RewrapTweak() error = %v", err)
+	}
+	if got, err := rotated.DecryptTK(wrapped); err != nil || got != cc {
+		t.Errorf("DecryptTK(%v) = %v, %v, want %v", wrapped, got, err, cc)
+	}
+}
+
+func TestFormatMACWithVersionFallback(t *testing.T) {
+	keys := &keyRepo{keys: map[byte][]byte{'a': make([]byte, 16), 'b': make([]byte, 16)}}
+	if _, err := NewEngine(macVersioner, keys, keys, DefaultAlphabetProvider{}, WithVersionFallback()); err == nil {
+		t.Errorf("NewEngine() error = nil, want an error")
+	}
+	v0 := formattedVersioner{deterministicVersioner: macVersioner.deterministicVersioner}
+	if _, err := NewEngine(v0, keys, keys, DefaultAlphabetProvider{}, WithVersionFallback()); err != nil {
+		t.Errorf("NewEngine() error = %v, want nil", err)
+	}
+}
+
+func Test_engine_IsTokenFormatMACKeyless(t *testing.T) {
+	keys := &keyRepo{keys: map[byte][]byte{'a': make([]byte, 16), 'b': make([]byte, 16)}}
+	e, err := NewEngine(macVersioner, keys, keys, DefaultAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	tk, err := e.EncryptCC("4444333322221111")
+	if err != nil {
+		t.Fatalf("EncryptCC() error = %v", err)
+	}
+
+	// IsToken retrieves no key: a key repository outage doesn't affect it
+	outage, err := NewEngine(macVersioner, keys, &keyRepo{keys: map[byte][]byte{}}, DefaultAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	if !outage.(TokenInspector).IsToken(tk) {
+		t.Errorf("IsToken(%v) = false without hmac keys, want true", tk)
+	}
+	if _, err := outage.DecryptTK(tk); !errors.Is(err, ErrKeyUnavailable) {
+		t.Errorf("DecryptTK(%v) error = %v, want ErrKeyUnavailable", tk, err)
+	}
+
+	// the MAC is not verified, only its structure
+	tests := map[string]struct {
+		tk   string
+		want bool
+	}{
+		"sealed":          {tk, true},
+		"altered_mac":     {tamper(tk, len(tk)-1), true},
+		"non_mac_symbol":  {tk[:len(tk)-1] + "1", false},
+		"missing_mac":     {tk[:len(tk)-TokenMACLength], false},
+		"truncated_token": {tk[:7], false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := outage.(TokenInspector).IsToken(tt.tk); got != tt.want {
+				t.Errorf("IsToken(%v) = %v, want %v", tt.tk, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package tkengine
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultMaxInputLength is the default maximum length in bytes of the cards, values and tokens the
+// engine accepts (see WithMaxInputLength)
+const DefaultMaxInputLength = 64
+
+// ErrInputTooLong is returned when a card, value or token exceeds the maximum input length of the engine
+var ErrInputTooLong = errors.New("Input too long")
+
+// WithMaxInputLength sets the maximum length in bytes of the cards, values and tokens the engine accepts,
+// DefaultMaxInputLength by default. Longer inputs are rejected with ErrInputTooLong before any parsing,
+// MAC verification or crypto operation, so that untrusted clients can't spend the engine resources with
+// huge inputs. The engine construction fails if n is shorter than the longest credit card tokens (19
+// chars followed by a TokenMACLength MAC).
+func WithMaxInputLength(n int) Option {
+	return func(e *engine) {
+		e.maxInputLength = n
+	}
+}
+
+// validateMaxInputLength returns an error if the maximum input length would reject credit card tokens
+func (e *engine) validateMaxInputLength() error {
+	if min := CreditCardFormat.MaxLength + TokenMACLength; e.maxInputLength != 0 && e.maxInputLength < min {
+		return errors.New(fmt.Sprintf("Invalid max input length %d: it should be at least %d, the length of the longest credit card tokens", e.maxInputLength, min))
+	}
+	return nil
+}
+
+// checkInputLength returns an error wrapping ErrInputTooLong if s exceeds the maximum input length.
+// The error never contains s.
+func (e *engine) checkInputLength(s string) error {
+	max := e.maxInputLength
+	if max == 0 {
+		max = DefaultMaxInputLength
+	}
+	if len(s) > max {
+		return fmt.Errorf("%w: %d bytes, the maximum is %d", ErrInputTooLong, len(s), max)
+	}
+	return nil
+}
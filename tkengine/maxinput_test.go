@@ -0,0 +1,69 @@
+package tkengine
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWithMaxInputLength(t *testing.T) {
+	keys := fixedKeyRepo{false, make([]byte, 16)}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	tests := map[string]struct {
+		opts    []Option
+		input   string
+		wantErr error
+	}{
+		"default_limit":           {nil, strings.Repeat("4", DefaultMaxInputLength+1), ErrInputTooLong},
+		"huge_input":              {nil, strings.Repeat("4", 1<<20), ErrInputTooLong},
+		"at_default_limit":        {nil, strings.Repeat("4", DefaultMaxInputLength), ErrInvalidCC},
+		"custom_limit":            {[]Option{WithMaxInputLength(30)}, strings.Repeat("4", 31), ErrInputTooLong},
+		"at_custom_limit":         {[]Option{WithMaxInputLength(30)}, strings.Repeat("4", 30), ErrInvalidCC},
+		"raised_limit":            {[]Option{WithMaxInputLength(128)}, strings.Repeat("4", 100), ErrInvalidCC},
+		"regular_card_unaffected": {[]Option{WithMaxInputLength(23)}, "4444333322221111", nil},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, tt.opts...)
+			if err != nil {
+				t.Fatalf("NewEngine() error = %v", err)
+			}
+			_, err = e.EncryptCC(tt.input)
+			if !errors.Is(err, tt.wantErr) || (err != nil) != (tt.wantErr != nil) {
+				t.Errorf("EncryptCC() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != ErrInputTooLong {
+				return
+			}
+			if _, err := e.DecryptTK(tt.input); !errors.Is(err, ErrInputTooLong) {
+				t.Errorf("DecryptTK() error = %v, want ErrInputTooLong", err)
+			}
+			if _, err := e.(Retokenizer).Retokenize(tt.input); !errors.Is(err, ErrInputTooLong) {
+				t.Errorf("Retokenize() error = %v, want ErrInputTooLong", err)
+			}
+			if _, err := e.(TokenInspector).TokenVersion(tt.input); !errors.Is(err, ErrInputTooLong) {
+				t.Errorf("TokenVersion() error = %v, want ErrInputTooLong", err)
+			}
+			if _, err := e.(NumericTokenizer).EncryptNumeric(tt.input, CreditCardFormat); !errors.Is(err, ErrInputTooLong) {
+				t.Errorf("EncryptNumeric() error = %v, want ErrInputTooLong", err)
+			}
+			if _, err := e.(NumericTokenizer).DecryptNumeric(tt.input, CreditCardFormat); !errors.Is(err, ErrInputTooLong) {
+				t.Errorf("DecryptNumeric() error = %v, want ErrInputTooLong", err)
+			}
+			if e.(TokenInspector).IsToken(tt.input) {
+				t.Errorf("IsToken() = true, want false")
+			}
+		})
+	}
+}
+
+func TestWithMaxInputLength_invalid(t *testing.T) {
+	keys := fixedKeyRepo{false, make([]byte, 16)}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	if _, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, WithMaxInputLength(22)); err == nil {
+		t.Errorf("NewEngine() error = nil, want an error")
+	}
+	if _, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, WithMaxInputLength(23)); err != nil {
+		t.Errorf("NewEngine() error = %v, want nil", err)
+	}
+}
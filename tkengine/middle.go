@@ -0,0 +1,178 @@
+package tkengine
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MiddleTokenizer is an optional interface of a TKEngine tokenizing cards split into their BIN,
+// middle and last 4 digits. The engines built by this package implement it.
+type MiddleTokenizer interface {
+	// EncryptMiddle tokenizes a card split into its BIN, middle
+	// and last 4 digits under version, without assembling it
+	EncryptMiddle(bin6, middle, last4 string, version byte) (string, error)
+	// DecryptMiddle detokenizes a TK into the BIN, middle and
+	// last 4 digits of the card, without assembling it
+	DecryptMiddle(tk string) (bin6, middle, last4 string, err error)
+}
+
+// EncryptMiddle tokenizes the card split into its 6-digit BIN, its middle digits and its last 4
+// digits under version, without assembling the card: the tweak is derived from bin6 and last4 and
+// only middle is encrypted. The token is the one EncryptCC returns for the card under version,
+// hence decrypted by DecryptTK or DecryptMiddle. version must be a detokenization version preserving
+// the first 6 and the last 4 digits of the card (see LayoutVersioner and WithBINLengthDetection).
+// The checks of WithRejectTokens, WithRejectTestPANs and WithStrictPANChecks are made on the whole
+// card, which is then transiently assembled.
+func (e *engine) EncryptMiddle(bin6, middle, last4 string, version byte) (tk string, err error) {
+	if err := e.checkConfigured(); err != nil {
+		return "", err
+	}
+	defer func() { e.audit(AuditTokenize, tk, e.ccVersionIndex(tk), len(bin6)+len(middle)+len(last4), err) }()
+	defer e.recoverCipherPanic("EncryptMiddle", &err)
+
+	// input validation
+	if err := e.checkInputLength(middle); err != nil {
+		return "", err
+	}
+	if err := checkCardSegments(bin6, middle, last4); err != nil {
+		return "", err
+	}
+	if e.rejectTokens || e.testPANs != nil || e.strictPAN {
+		if err := e.checkCard(bin6 + middle + last4); err != nil {
+			return "", err
+		}
+	}
+	opts, err := e.middleVersionFormat(version, bin6)
+	if err != nil {
+		return "", err
+	}
+	l := len(bin6) + len(middle) + len(last4)
+	if l > opts.MaxLength {
+		return "", fmt.Errorf("%w: length %d out of range [%d, %d]", ErrInvalidCC, l, opts.MinLength, opts.MaxLength)
+	}
+	if err := checkCardLength(l, opts, ErrInvalidCC); err != nil {
+		return "", err
+	}
+	if err := e.checkDomain(l, opts); err != nil {
+		return "", err
+	}
+
+	f, err := e.formatFor(version)
+	if err != nil {
+		return "", err
+	}
+	tweakInput := tweakInputFor(f, []byte(bin6+last4), len(bin6), len(last4))
+	defer zero(tweakInput)
+	tkmd, err := e.encryptMDV0(tweakInput, middle, version, opts.radix(), e.alphaProvider)
+	if err != nil {
+		return "", err
+	}
+	if tk, err = e.sealToken(bin6 + string(version) + tkmd + last4); err != nil || !e.verifyOnEncrypt {
+		return tk, err
+	}
+	if _, got, _, err := e.decryptMiddle(tk); err != nil || got != middle {
+		return "", fmt.Errorf("%w: the token doesn't detokenize to the middle digits", ErrRoundTripFailure)
+	}
+	return tk, nil
+}
+
+// DecryptMiddle detokenizes tk into the BIN, the middle digits and the last 4 digits of the card,
+// without assembling the card (see EncryptMiddle). The tokens of the layouts not preserving the
+// first 6 and the last 4 digits are rejected.
+func (e *engine) DecryptMiddle(tk string) (bin6, middle, last4 string, err error) {
+	if err := e.checkConfigured(); err != nil {
+		return "", "", "", err
+	}
+	defer func() { e.audit(AuditDetokenize, tk, e.ccVersionIndex(tk), len(tk), err) }()
+	defer e.recoverCipherPanic("DecryptMiddle", &err)
+
+	return e.decryptMiddle(tk)
+}
+
+// decryptMiddle detokenizes tk into the segments of the card (see DecryptMiddle)
+func (e *engine) decryptMiddle(tk string) (string, string, string, error) {
+	if err := e.checkInputLength(tk); err != nil {
+		return "", "", "", err
+	}
+	detokVers, err := e.versioner.GetDetokenizationVersions()
+	if err != nil {
+		return "", "", "", err
+	}
+	if tk, err = e.openToken(tk); err != nil {
+		return "", "", "", err
+	}
+	alpha, opts, err := e.detokAlphabet(tk, detokVers)
+	if err != nil {
+		return "", "", "", err
+	}
+	p, s := opts.PreservedPrefix, opts.PreservedSuffix
+	if p != CreditCardFormat.PreservedPrefix || s != CreditCardFormat.PreservedSuffix {
+		return "", "", "", fmt.Errorf("%w: the layout preserves %d leading and %d trailing digits", ErrInvalidTK, p, s)
+	}
+
+	v := tk[p]
+	f, err := e.formatFor(v)
+	if err != nil {
+		return "", "", "", err
+	}
+	bin6, last4 := tk[:p], tk[len(tk)-s:]
+	tweakInput := tweakInputFor(f, []byte(bin6+last4), p, s)
+	defer zero(tweakInput)
+	middle, err := e.decryptMDV0(tweakInput, tk[p:len(tk)-s], v, opts.radix(), alpha)
+	if err != nil {
+		return "", "", "", err
+	}
+	return bin6, middle, last4, nil
+}
+
+// checkCardSegments returns an error wrapping ErrInvalidCC unless bin6 and last4 are 6 and 4 digits
+// long and middle is numeric
+func checkCardSegments(bin6, middle, last4 string) error {
+	if len(bin6) != CreditCardFormat.PreservedPrefix || !isRadixString(bin6, 10) {
+		return fmt.Errorf("%w: the BIN should be %d digits", ErrInvalidCC, CreditCardFormat.PreservedPrefix)
+	}
+	if len(last4) != CreditCardFormat.PreservedSuffix || !isRadixString(last4, 10) {
+		return fmt.Errorf("%w: the last digits should be %d digits", ErrInvalidCC, CreditCardFormat.PreservedSuffix)
+	}
+	if !isRadixString(middle, 10) {
+		return fmt.Errorf("%w: non-numeric middle digits", ErrInvalidCC)
+	}
+	return nil
+}
+
+// checkCard runs the checks of WithRejectTokens, WithRejectTestPANs and WithStrictPANChecks on cc
+func (e *engine) checkCard(cc string) error {
+	if e.rejectTokens && e.IsToken(cc) {
+		return ErrAlreadyTokenized
+	}
+	if e.testPANs != nil && e.isTestPAN(cc) {
+		return ErrTestPAN
+	}
+	if e.strictPAN {
+		return checkPlausiblePAN(cc)
+	}
+	return nil
+}
+
+// middleVersionFormat returns the layout of the cards of BIN bin6 under version, which must be a
+// detokenization version preserving 6 leading and 4 trailing digits
+func (e *engine) middleVersionFormat(version byte, bin6 string) (FormatOpts, error) {
+	if err := ValidateVersion(version); err != nil {
+		return FormatOpts{}, err
+	}
+	detokVers, err := e.versioner.GetDetokenizationVersions()
+	if err != nil {
+		return FormatOpts{}, err
+	}
+	if !contains(detokVers, version) {
+		return FormatOpts{}, errors.New(fmt.Sprintf("Version %s is not a detokenization version", string(version)))
+	}
+	opts, err := e.cardFormat(version, bin6)
+	if err != nil {
+		return FormatOpts{}, err
+	}
+	if opts.PreservedPrefix != CreditCardFormat.PreservedPrefix || opts.PreservedSuffix != CreditCardFormat.PreservedSuffix {
+		return FormatOpts{}, errors.New(fmt.Sprintf("Version %s preserves %d leading and %d trailing digits, instead of %d and %d", string(version), opts.PreservedPrefix, opts.PreservedSuffix, CreditCardFormat.PreservedPrefix, CreditCardFormat.PreservedSuffix))
+	}
+	return opts, nil
+}
@@ -0,0 +1,117 @@
+package tkengine
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_engine_EncryptMiddle(t *testing.T) {
+	keys := &keyRepo{keys: map[byte][]byte{'a': make([]byte, 16), 'b': make([]byte, 16), 'c': make([]byte, 16)}}
+	versioner := formattedVersioner{
+		deterministicVersioner: deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a', 'b'}},
+		formats:                map[byte]Format{'b': FormatMAC},
+	}
+	tests := map[string]struct {
+		opts    []Option
+		bin6    string
+		middle  string
+		last4   string
+		version byte
+		want    string
+		wantErr error
+	}{
+		"16_digits":           {nil, "444433", "332222", "1111", 'a', "444433aapchc1111", nil},
+		"13_digits":           {nil, "444433", "332", "2221", 'a', "444433ad32221", nil},
+		"older_version":       {nil, "444433", "332222", "1111", 'b', "", nil},
+		"verify_on_encrypt":   {[]Option{WithVerifyOnEncrypt()}, "444433", "332222", "1111", 'a', "444433aapchc1111", nil},
+		"short_bin":           {nil, "44443", "3332222", "1111", 'a', "", ErrInvalidCC},
+		"non_numeric_middle":  {nil, "444433", "33a222", "1111", 'a', "", ErrInvalidCC},
+		"too_short":           {nil, "444433", "33", "1111", 'a', "", ErrInvalidCC},
+		"too_long":            {nil, "444433", "3322221111", "1111", 'a', "", ErrInvalidCC},
+		"huge_middle":         {nil, "444433", string(make([]byte, 100)), "1111", 'a', "", ErrInputTooLong},
+		"test_pan":            {[]Option{WithRejectTestPANs()}, "411111", "111111", "1111", 'a', "", ErrTestPAN},
+		"implausible_pan":     {[]Option{WithStrictPANChecks()}, "000000", "000000", "0000", 'a', "", ErrImplausiblePAN},
+		"not_a_detok_version": {nil, "444433", "332222", "1111", 'c', "", errors.New("Version c is not a detokenization version")},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, append(tt.opts, WithMinDomainSize(shortCardsDomain))...)
+			if err != nil {
+				t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+			}
+			tk, err := e.(MiddleTokenizer).EncryptMiddle(tt.bin6, tt.middle, tt.last4, tt.version)
+			if (err != nil) != (tt.wantErr != nil) || err != nil && !errors.Is(err, tt.wantErr) && err.Error() != tt.wantErr.Error() {
+				t.Fatalf("EncryptMiddle() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tt.want != "" && tk != tt.want {
+				t.Errorf("EncryptMiddle() = %v, want %v", tk, tt.want)
+			}
+			// the token is the one of the whole card
+			cc := tt.bin6 + tt.middle + tt.last4
+			if got, err := e.DecryptTK(tk); err != nil || got != cc {
+				t.Errorf("DecryptTK(%v) = %v, %v, want %v", tk, got, err, cc)
+			}
+			bin6, middle, last4, err := e.(MiddleTokenizer).DecryptMiddle(tk)
+			if err != nil || bin6 != tt.bin6 || middle != tt.middle || last4 != tt.last4 {
+				t.Errorf("DecryptMiddle(%v) = %v, %v, %v, %v, want %v, %v, %v", tk, bin6, middle, last4, err, tt.bin6, tt.middle, tt.last4)
+			}
+		})
+	}
+}
+
+func Test_engine_EncryptMiddleEightDigitBIN(t *testing.T) {
+	keys := fixedKeyRepo{false, make([]byte, 16)}
+	versioner := deterministicVersioner{tokVersion: 'B', detokVersions: []byte{'a', 'B'}}
+	e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, WithBINLengthDetection(EightDigitBINNetworks(NetworkVisa), 'B'), WithMinDomainSize(shortCardsDomain))
+	if err != nil {
+		t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+	}
+	// the cards of the 8-digit BINs can't be split into 6x4
+	want := "Version B preserves 8 leading and 4 trailing digits, instead of 6 and 4"
+	if _, err := e.(MiddleTokenizer).EncryptMiddle("444433", "332222", "1111", 'B'); err == nil || err.Error() != want {
+		t.Errorf("EncryptMiddle() error = %v, want %v", err, want)
+	}
+	tk, err := e.EncryptCC("4444333322221111")
+	if err != nil {
+		t.Fatalf("EncryptCC() error = %v", err)
+	}
+	if _, _, _, err := e.(MiddleTokenizer).DecryptMiddle(tk); !errors.Is(err, ErrInvalidTK) {
+		t.Errorf("DecryptMiddle(%v) error = %v, want ErrInvalidTK", tk, err)
+	}
+	// the other cards can
+	tk, err = e.(MiddleTokenizer).EncryptMiddle("555533", "332222", "1111", 'B')
+	if err != nil {
+		t.Fatalf("EncryptMiddle() error = %v", err)
+	}
+	if got, err := e.DecryptTK(tk); err != nil || got != "5555333322221111" {
+		t.Errorf("DecryptTK(%v) = %v, %v, want 5555333322221111", tk, got, err)
+	}
+}
+
+func Test_engine_DecryptMiddle(t *testing.T) {
+	keys := fixedKeyRepo{false, make([]byte, 16)}
+	tests := map[string]struct {
+		versioner KeyVersioner
+		tk        string
+		wantErr   error
+	}{
+		"invalid_token":   {deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, "4444333322221111", ErrInvalidTK},
+		"unknown_version": {deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, "444433bapchc1111", ErrInvalidTK},
+		"custom_layout":   {layoutVersioner{deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, map[byte][2]int{'a': {4, 4}}}, "4444alkbdcec1111", ErrInvalidTK},
+		"too_long":        {deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, string(make([]byte, 65)), ErrInputTooLong},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngine(tt.versioner, keys, keys, DefaultAlphabetProvider{})
+			if err != nil {
+				t.Fatalf("NewEngine() error = %v", err)
+			}
+			if _, _, _, err := e.(MiddleTokenizer).DecryptMiddle(tt.tk); !errors.Is(err, tt.wantErr) {
+				t.Errorf("DecryptMiddle(%v) error = %v, want %v", tt.tk, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package tkengine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NormalizeCC strips the spaces and dashes separating the digit groups of a captured card number and
+// returns the card digits. If other non-digit characters remain, the returned error wraps ErrInvalidCC
+// and reports their 1-based positions in cc, never their values, so that operators can fix the capture
+// forms upstream without card data leaking into logs. The length of the normalized card is checked too.
+func NormalizeCC(cc string) (string, error) {
+	var b strings.Builder
+	var rejected []int
+	pos := 0
+	for _, r := range cc {
+		pos++
+		switch {
+		case r == ' ' || r == '-':
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		default:
+			rejected = append(rejected, pos)
+		}
+	}
+	if len(rejected) > 0 {
+		return "", fmt.Errorf("%w: non-numeric characters at positions %v", ErrInvalidCC, rejected)
+	}
+	normalized := b.String()
+	if err := checkNumeric(normalized, CreditCardFormat, ErrInvalidCC); err != nil {
+		return "", err
+	}
+	return normalized, nil
+}
+
+// ValidateCC checks that cc is a credit card the engines accept to tokenize: 13 to 19 digits. It needs
+// no key nor performs any crypto operation. The returned error wraps ErrInvalidCC and never contains cc.
+func ValidateCC(cc string) error {
+	return checkNumeric(cc, CreditCardFormat, ErrInvalidCC)
+}
@@ -0,0 +1,71 @@
+package tkengine
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeCC(t *testing.T) {
+	tests := map[string]struct {
+		cc      string
+		want    string
+		wantErr string
+	}{
+		"digits_only":         {"4444333322221111", "4444333322221111", ""},
+		"spaces":              {"4444 3333 2222 1111", "4444333322221111", ""},
+		"dashes":              {"4444-3333-2222-1111", "4444333322221111", ""},
+		"trailing_check_char": {"4444 3333 2222 1111X", "", "positions [20]"},
+		"accidental_letters":  {"4444-33Q3-2222-Z111", "", "positions [8 16]"},
+		"non_ascii":           {"4444 3333 2222 111é", "", "positions [19]"},
+		"too_short":           {"4444 3333 22", "", "length 10 out of range"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := NormalizeCC(tt.cc)
+			if (err != nil) != (tt.wantErr != "") {
+				t.Fatalf("NormalizeCC() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrInvalidCC) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("NormalizeCC() error = %v, want %v wrapping %v", err, tt.wantErr, ErrInvalidCC)
+				}
+				// rejected characters are reported by position only
+				if strings.Contains(err.Error(), "4444") || strings.ContainsAny(err.Error(), "XQZé") {
+					t.Errorf("NormalizeCC() error = %v leaks card data", err)
+				}
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeCC() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateCC(t *testing.T) {
+	tests := map[string]struct {
+		cc      string
+		wantErr bool
+	}{
+		"16_digits":    {"4444333322221111", false},
+		"13_digits":    {"4444333322221", false},
+		"19_digits":    {"4444333322221111999", false},
+		"too_short":    {"444433332222", true},
+		"too_long":     {"44443333222211119999", true},
+		"spaces":       {"4444 3333 2222 1111", true},
+		"letters":      {"44443333X2221111", true},
+		"empty":        {"", true},
+		"luhn_invalid": {"4444333322221112", false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateCC(tt.cc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateCC() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && (!errors.Is(err, ErrInvalidCC) || (tt.cc != "" && strings.Contains(err.Error(), tt.cc))) {
+				t.Errorf("ValidateCC() error = %v, want ErrInvalidCC without card data", err)
+			}
+		})
+	}
+}
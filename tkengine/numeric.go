@@ -0,0 +1,459 @@
+package tkengine
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidValue is returned when the input numeric value does not match its FormatOpts
+var ErrInvalidValue = errors.New("Invalid value format")
+
+// FormatOpts describes the layout of a numeric value (credit card, SSN, bank account number...)
+// to tokenize. The token preserves the first PreservedPrefix and the last PreservedSuffix digits
+// of the value, and replaces the middle digits by the version char followed by the encrypted
+// middle digits encoded with one char less. The "save one char" encoding supports middle
+// sections of 3 to 19 digits (3 to 9 symbols for radixes other than 10), so for every length in
+// [MinLength, MaxLength] the number of middle digits (length - PreservedPrefix - PreservedSuffix)
+// must be in that interval.
+type FormatOpts struct {
+	// MinLength is the minimum number of digits of the value
+	MinLength int
+	// MaxLength is the maximum number of digits of the value
+	MaxLength int
+	// PreservedPrefix is the number of leading digits preserved in clear in the token
+	PreservedPrefix int
+	// PreservedSuffix is the number of trailing digits preserved in clear in the token
+	PreservedSuffix int
+	// Alphabet is the alphabet provider used to encode the middle digits.
+	// If nil the engine alphabet provider is used.
+	Alphabet AlphabetProvider
+	// Radix is the number of symbols the value is written with, in [2, MaxRadix]. The symbols
+	// are the first Radix chars of 0-9a-z (e.g. 0-9a-f for radix 16). If 0 the radix is 10.
+	// The FF1 cipher runs in this radix and the encoding base of the middle digits depends
+	// on it (see encodingBaseForRadix).
+	Radix int
+}
+
+// MaxRadix is the largest radix supported by FormatOpts
+const MaxRadix = 36
+
+// radixSymbols are the symbols of a value written in radix r: the first r chars. They match
+// the symbols the FF1 cipher reads and writes.
+const radixSymbols = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// CreditCardFormat is the layout of credit cards: 13 to 19 digits preserving the first 6 and the last 4
+var CreditCardFormat = FormatOpts{
+	MinLength:       13,
+	MaxLength:       19,
+	PreservedPrefix: 6,
+	PreservedSuffix: 4,
+}
+
+// CreditCardLastFourFormat is the layout of credit cards preserving only the last 4 digits: the BIN
+// is encrypted along with the middle digits (9 to 15 digits) and the token starts with the version char.
+// Note that the FF1 tweak is then only derived from the last 4 digits: there are only 10^4 distinct
+// tweaks and all the cards sharing their last 4 digits are encrypted under the same FF1 permutation.
+// The encrypted domain is however much larger (10^9 to 10^15 values) than with CreditCardFormat.
+var CreditCardLastFourFormat = FormatOpts{
+	MinLength:       13,
+	MaxLength:       19,
+	PreservedPrefix: 0,
+	PreservedSuffix: 4,
+}
+
+// CreditCardFullFormat is the layout of credit cards preserving no digit: the whole card is encrypted
+// and the token is the version char followed by the encoded card, with no BIN nor last 4 digits in clear.
+// With nothing preserved the FF1 tweak has no card digits to derive from, so a context must be supplied
+// with EncryptNumericWithAAD (e.g. a tenant or merchant ID, known again at detokenization): without it
+// all the cards would be encrypted under the same FF1 permutation and ErrContextRequired is returned.
+// The context is mixed into the tweak, so that each context gets its own permutation. The encrypted
+// domain is 10^13 to 10^19 values.
+var CreditCardFullFormat = FormatOpts{
+	MinLength:       13,
+	MaxLength:       19,
+	PreservedPrefix: 0,
+	PreservedSuffix: 0,
+}
+
+// ErrContextRequired is returned when a value laid out with no preserved digit, such as CreditCardFullFormat,
+// is tokenized or detokenized without context (aad): its FF1 tweak would be the same for all the values
+var ErrContextRequired = errors.New("tweak context required")
+
+// checkContext returns ErrContextRequired if the layout preserves no digit and aad is empty
+func (o FormatOpts) checkContext(aad []byte) error {
+	if o.PreservedPrefix+o.PreservedSuffix == 0 && len(aad) == 0 {
+		return fmt.Errorf("%w: the layout preserves no digit to derive the FF1 tweak from", ErrContextRequired)
+	}
+	return nil
+}
+
+// validate returns an error if the layout can't be tokenized
+func (o FormatOpts) validate() error {
+	if o.PreservedPrefix < 0 || o.PreservedSuffix < 0 {
+		return errors.New(fmt.Sprintf("Invalid preserved digits: prefix %d and suffix %d should be positive", o.PreservedPrefix, o.PreservedSuffix))
+	}
+	if o.MinLength > o.MaxLength {
+		return errors.New(fmt.Sprintf("Invalid length bounds: min length %d is greater than max length %d", o.MinLength, o.MaxLength))
+	}
+	if o.Radix != 0 && (o.Radix < 2 || o.Radix > MaxRadix) {
+		return errors.New(fmt.Sprintf("Invalid radix %d: it should be in [2, %d]", o.Radix, MaxRadix))
+	}
+	preserved := o.PreservedPrefix + o.PreservedSuffix
+	maxMD := 19
+	if o.radix() != 10 {
+		maxMD = 9
+	}
+	if o.MinLength-preserved < 3 || o.MaxLength-preserved > maxMD {
+		return errors.New(fmt.Sprintf("Invalid layout: middle digits should be in [3, %d] for all lengths, instead they are in [%d, %d]", maxMD, o.MinLength-preserved, o.MaxLength-preserved))
+	}
+	if o.Alphabet != nil {
+		if o.radix() == 10 {
+			if err := validateAlphabetProvider(o.Alphabet); err != nil {
+				return err
+			}
+		}
+		return validateAlphabetBases(o.Alphabet, o.encodingBases())
+	}
+	return nil
+}
+
+// radix returns the radix of the layout, defaulting to 10
+func (o FormatOpts) radix() int {
+	if o.Radix == 0 {
+		return 10
+	}
+	return o.Radix
+}
+
+// encodingBases returns the encoding bases of the middle digits for all the lengths of the layout
+func (o FormatOpts) encodingBases() []uint32 {
+	var bases []uint32
+	preserved := o.PreservedPrefix + o.PreservedSuffix
+	for l := o.MinLength; l <= o.MaxLength; l++ {
+		if base, err := encodingBaseForRadix(o.radix(), l-preserved); err == nil {
+			bases = append(bases, base)
+		}
+	}
+	return bases
+}
+
+// alphabet returns the alphabet provider of the layout, falling back to def
+func (o FormatOpts) alphabet(def AlphabetProvider) AlphabetProvider {
+	if o.Alphabet == nil {
+		return def
+	}
+	return o.Alphabet
+}
+
+// NumericTokenizer is an optional interface of a TKEngine tokenizing numeric values other than credit
+// cards (see FormatOpts). The engines built by this package implement it.
+type NumericTokenizer interface {
+	// EncryptNumeric takes a numeric value laid out as described by opts
+	// and outputs a Token or an error. EncryptCC is the special case
+	// with CreditCardFormat opts.
+	// Error types: InvalidValue format
+	EncryptNumeric(value string, opts FormatOpts) (string, error)
+	// DecryptNumeric takes a TK produced by EncryptNumeric with the
+	// same opts and outputs the decrypted value or an error
+	// Error types: InvalidTK format
+	DecryptNumeric(tk string, opts FormatOpts) (string, error)
+	// EncryptNumericWithAAD is EncryptNumeric binding the token to aad,
+	// e.g. the tweak context of layouts preserving no digit
+	EncryptNumericWithAAD(value string, opts FormatOpts, aad []byte) (string, error)
+	// DecryptNumericWithAAD is DecryptNumeric for tokens produced by
+	// EncryptNumericWithAAD: the same aad must be passed
+	DecryptNumericWithAAD(tk string, opts FormatOpts, aad []byte) (string, error)
+}
+
+// EncryptNumeric tokenizes a numeric value laid out as described by opts. The credit card
+// tokenization (EncryptCC) is the special case of EncryptNumeric with CreditCardFormat.
+func (e *engine) EncryptNumeric(value string, opts FormatOpts) (tk string, err error) {
+	if err := e.checkConfigured(); err != nil {
+		return "", err
+	}
+	defer func() { e.audit(AuditTokenize, tk, opts.PreservedPrefix, len(value), err) }()
+	defer e.recoverCipherPanic("EncryptNumeric", &err)
+
+	return e.encryptNumeric(value, opts, nil)
+}
+
+// encryptNumeric tokenizes value laid out as opts binding the token to aad
+func (e *engine) encryptNumeric(value string, opts FormatOpts, aad []byte) (string, error) {
+	if err := e.checkInputLength(value); err != nil {
+		return "", err
+	}
+	if err := opts.validate(); err != nil {
+		return "", err
+	}
+
+	// input validation
+	if err := checkNumeric(value, opts, ErrInvalidValue); err != nil {
+		return "", err
+	}
+
+	return e.encrypt(value, opts, opts.alphabet(e.alphaProvider), aad)
+}
+
+// DecryptNumeric detokenizes a token produced by EncryptNumeric with the same opts
+func (e *engine) DecryptNumeric(tk string, opts FormatOpts) (_ string, err error) {
+	if err := e.checkConfigured(); err != nil {
+		return "", err
+	}
+	defer func() { e.audit(AuditDetokenize, tk, opts.PreservedPrefix, len(tk), err) }()
+	defer e.recoverCipherPanic("DecryptNumeric", &err)
+
+	return e.decryptNumeric(tk, opts, nil)
+}
+
+// decryptNumeric detokenizes tk laid out as opts and bound to aad
+func (e *engine) decryptNumeric(tk string, opts FormatOpts, aad []byte) (string, error) {
+	if err := e.checkInputLength(tk); err != nil {
+		return "", err
+	}
+	if err := opts.validate(); err != nil {
+		return "", err
+	}
+
+	detokVers, err := e.versioner.GetDetokenizationVersions()
+	if err != nil {
+		return "", err
+	}
+
+	alpha := opts.alphabet(e.alphaProvider)
+
+	// input validation
+	if err := checkNumericTK(tk, opts, alpha, detokVers); err != nil {
+		return "", err
+	}
+
+	return e.decrypt(tk, opts, alpha, aad)
+}
+
+// checkNumeric returns nil if value is only made of symbols of the opts radix and its length matches opts,
+// otherwise an error wrapping invalid describes the failure. The error never contains the value.
+func checkNumeric(value string, opts FormatOpts, invalid error) error {
+	if len(value) < opts.MinLength || len(value) > opts.MaxLength {
+		return fmt.Errorf("%w: length %d out of range [%d, %d]", invalid, len(value), opts.MinLength, opts.MaxLength)
+	}
+	if !isRadixString(value, opts.radix()) {
+		return fmt.Errorf("%w: %s characters", invalid, nonRadixDescription(opts.radix()))
+	}
+	return nil
+}
+
+// nonRadixDescription describes the symbols which are not part of radix
+func nonRadixDescription(radix int) string {
+	if radix == 10 {
+		return "non-numeric"
+	}
+	return fmt.Sprintf("non radix %d", radix)
+}
+
+// isRadixString returns true if s is only made of symbols of radix
+func isRadixString(s string, radix int) bool {
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(radixSymbols[:radix], s[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// isValidNumericTK returns true if string matches the structure of a token laid out as described by opts
+func isValidNumericTK(tk string, opts FormatOpts, alphaProvider AlphabetProvider, vers []byte) bool {
+	return checkNumericTK(tk, opts, alphaProvider, vers) == nil
+}
+
+// checkNumericTK returns nil if tk matches the structure of a token laid out as described by opts
+// and its version is among vers, otherwise an error wrapping ErrInvalidTK describes the failure (see
+// checkNumericTKStructure and checkNumericTKVersion). The error never contains the token.
+func checkNumericTK(tk string, opts FormatOpts, alphaProvider AlphabetProvider, vers []byte) error {
+	if err := checkNumericTKStructure(tk, opts, alphaProvider); err != nil {
+		return err
+	}
+	return checkNumericTKVersion(tk, opts, vers)
+}
+
+// checkNumericTKStructure returns nil if tk matches the structure of a token laid out as described by
+// opts and encoded with alphaProvider, whatever its version, otherwise an error wrapping ErrInvalidTK
+// describes the failure. The error never contains the token.
+func checkNumericTKStructure(tk string, opts FormatOpts, alphaProvider AlphabetProvider) error {
+	if len(tk) < opts.MinLength || len(tk) > opts.MaxLength {
+		return fmt.Errorf("%w: length %d out of range [%d, %d]", ErrInvalidTK, len(tk), opts.MinLength, opts.MaxLength)
+	}
+	prefix, _, middle, suffix := tokenSections(tk, opts)
+
+	// prefix and suffix digits
+	if !isRadixString(prefix, opts.radix()) || !isRadixString(suffix, opts.radix()) {
+		return fmt.Errorf("%w: %s prefix or suffix", ErrInvalidTK, nonRadixDescription(opts.radix()))
+	}
+
+	// retrieve the encoding base for the specific ciphertext: the version char and the middle
+	// section together take the place of the middle digits
+	base, err := encodingBaseForRadix(opts.radix(), 1+len(middle))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTK, err)
+	}
+
+	// retrieve the alphabet for the encoding base
+	alpha, err := alphaProvider.GetAlphabetForBase(base)
+	if err != nil {
+		return fmt.Errorf("%w: alphabet mismatch, no alphabet for base %d", ErrInvalidTK, base)
+	}
+
+	// build the alpha map
+	alphaMap := make(map[byte]int, len(alpha))
+	for i, el := range alpha {
+		alphaMap[el] = i
+	}
+
+	// middle digits belong to alphabet in this base
+	for _, el := range middle {
+		_, ok := alphaMap[byte(el)]
+		if !ok {
+			return fmt.Errorf("%w: alphabet mismatch, middle characters outside the base %d alphabet", ErrInvalidTK, base)
+		}
+	}
+
+	return nil
+}
+
+// checkNumericTKVersion returns nil if the version of tk, a structurally valid token laid out as
+// described by opts (see checkNumericTKStructure), is among the detokenization versions vers,
+// otherwise an error describes the failure (see checkDetokenizationVersion)
+func checkNumericTKVersion(tk string, opts FormatOpts, vers []byte) error {
+	_, version, _, _ := tokenSections(tk, opts)
+	return checkDetokenizationVersion(version, vers)
+}
+
+// checkDetokenizationVersion returns nil if version is among the detokenization versions vers. Otherwise
+// an error wrapping ErrVersionExpired is returned if version is a valid version byte (the token is
+// well-formed but its version was retired), an error wrapping ErrInvalidTK if it is not.
+func checkDetokenizationVersion(version byte, vers []byte) error {
+	if contains(vers, version) {
+		return nil
+	}
+	if ValidateVersion(version) != nil {
+		return fmt.Errorf("%w: invalid version byte %d", ErrInvalidTK, version)
+	}
+	return fmt.Errorf("%w: version %s not in the detokenization versions", ErrVersionExpired, string(version))
+}
+
+// tokenSections splits the token tk laid out as opts into its prefix digits, version char, encoded
+// middle section and suffix digits. The offsets only depend on the layout, never on the content: the
+// version char is the char at index PreservedPrefix and the middle section spans the chars between
+// it and the suffix, hence a version char which is also a symbol of the alphabet can't be taken for a
+// middle char, nor a middle char for the version. tk must be longer than the preserved digits.
+func tokenSections(tk string, opts FormatOpts) (prefix string, version byte, middle string, suffix string) {
+	p, s := opts.PreservedPrefix, opts.PreservedSuffix
+	return tk[:p], tk[p], tk[p+1 : len(tk)-s], tk[len(tk)-s:]
+}
+
+// encodingBaseForRadix returns the base in which n middle digits written in radix are encoded with
+// n-1 symbols to make room for the version char: the smallest base b such that b^(n-1) >= radix^n,
+// i.e. b = ceil(radix^(n/(n-1))). n should be in [3, 9] otherwise an error is returned.
+//
+// Design note: the token stays as long as the value only if every one of the radix^n possible
+// ciphertexts has its own (n-1)-symbol encoding. Since b > radix, the alphabet for b must hold more
+// symbols than the value radix, and b grows quickly for short middle sections and large radixes:
+// radix 10 needs 32 symbols for 3 digits but only 14 for 9, radix 16 needs 64 symbols for 3 digits
+// and 23 for 9, radix 36 needs 216 symbols for 3 digits (more than the ASCII symbols available, so
+// such layouts can't be tokenized) and 57 for 9. The FF1 cipher runs in the value radix, not in b:
+// the ciphertext is a radix^n domain value and the encoding is a plain change of base, so the
+// security of the token only depends on the radix^n domain size. For radix 10 the bases are the
+// ones of encodingBaseToSaveOneChar, hence decimal tokens don't change.
+func encodingBaseForRadix(radix int, n int) (uint32, error) {
+	if radix == 10 {
+		return encodingBaseToSaveOneChar(n)
+	}
+	if n < 3 || n > 9 {
+		return 0, errors.New(fmt.Sprintf("Invalid middle digits size: %d", n))
+	}
+	if radix < 2 || radix > MaxRadix {
+		return 0, errors.New(fmt.Sprintf("Invalid radix %d: it should be in [2, %d]", radix, MaxRadix))
+	}
+	domain := powUint64(uint64(radix), n)
+	b := uint64(radix) + 1
+	for powUint64(b, n-1) < domain {
+		b++
+	}
+	return uint32(b), nil
+}
+
+// powUint64 returns b^e computed with integer arithmetic
+func powUint64(b uint64, e int) uint64 {
+	p := uint64(1)
+	for i := 0; i < e; i++ {
+		p *= b
+	}
+	return p
+}
+
+// encodeTkMDRadix encodes the ciphertext written in radix with one char less than in input.
+// Decimal ciphertexts are encoded by encodeTkMD.
+func encodeTkMDRadix(ciphertext string, radix int, alphaProvider AlphabetProvider) (string, error) {
+	if radix == 10 {
+		return encodeTkMD(ciphertext, alphaProvider)
+	}
+
+	// parsing ciphertext into a number
+	if !isRadixString(ciphertext, radix) {
+		return "", errors.New(fmt.Sprintf("ciphertext [%s] is not written in radix %d", ciphertext, radix))
+	}
+	n, err := strconv.ParseUint(ciphertext, radix, 64)
+	if err != nil {
+		return "", err
+	}
+
+	// retrieve the encoding base and alphabet for the specific ciphertext
+	base, err := encodingBaseForRadix(radix, len(ciphertext))
+	if err != nil {
+		return "", err
+	}
+	alpha, err := alphaProvider.GetAlphabetForBase(base)
+	if err != nil {
+		return "", err
+	}
+	if len(alpha) != int(base) {
+		return "", errors.New(fmt.Sprintf("Got alphabet size %d for base %d. Size should match base", len(alpha), base))
+	}
+
+	return EncodeBaseN(n, alpha, len(ciphertext)-1)
+}
+
+// decodeTkMDRadix is the inverse of encodeTkMDRadix: it decodes tkMD into the ciphertext written
+// in radix with one char more than in input. Decimal ciphertexts are decoded by decodeTkMD.
+func decodeTkMDRadix(tkMD string, radix int, alphaProvider AlphabetProvider) (string, error) {
+	if radix == 10 {
+		return decodeTkMD(tkMD, alphaProvider)
+	}
+
+	decodeds := len(tkMD) + 1
+
+	// retrieve the encoding base and alphabet for the encoded token
+	base, err := encodingBaseForRadix(radix, decodeds)
+	if err != nil {
+		return "", err
+	}
+	alpha, err := alphaProvider.GetAlphabetForBase(base)
+	if err != nil {
+		return "", err
+	}
+	if len(alpha) != int(base) {
+		return "", errors.New(fmt.Sprintf("Got alphabet size %d for base %d. Size should match base", len(alpha), base))
+	}
+	n, err := DecodeBaseN(tkMD, alpha)
+	if err != nil {
+		return "", err
+	}
+	// the encoding base can represent more values than the radix digits
+	if n >= powUint64(uint64(radix), decodeds) {
+		return "", errors.New(fmt.Sprintf("tk middle digits decode to a value exceeding %d digits in radix %d", decodeds, radix))
+	}
+
+	// left-pad with the zero symbol of the radix
+	str := strconv.FormatUint(n, radix)
+	return strings.Repeat("0", decodeds-len(str)) + str, nil
+}
@@ -0,0 +1,301 @@
+package tkengine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Test_engine_EncryptDecryptNumeric(t *testing.T) {
+	ssnFormat := FormatOpts{MinLength: 9, MaxLength: 9, PreservedPrefix: 0, PreservedSuffix: 4}
+	accountFormat := FormatOpts{MinLength: 8, MaxLength: 12, PreservedPrefix: 2, PreservedSuffix: 2}
+	tests := map[string]struct {
+		value   string
+		opts    FormatOpts
+		wantErr bool
+	}{
+		"credit_card_preset":          {"4444333322221111", CreditCardFormat, false},
+		"ssn":                         {"123456789", ssnFormat, false},
+		"account_min_length":          {"12345678", accountFormat, false},
+		"account_max_length":          {"123456789012", accountFormat, false},
+		"custom_alphabet":             {"123456789", FormatOpts{MinLength: 9, MaxLength: 9, PreservedSuffix: 4, Alphabet: DefaultAlphabetProvider{}}, false},
+		"too_short_value":             {"1234567", accountFormat, true},
+		"too_long_value":              {"1234567890123", accountFormat, true},
+		"non_numeric_value":           {"12345678a", ssnFormat, true},
+		"middle_digits_too_few":       {"123456", FormatOpts{MinLength: 6, MaxLength: 6, PreservedPrefix: 2, PreservedSuffix: 2}, true},
+		"middle_digits_too_many":      {"123456789012345678901234", FormatOpts{MinLength: 24, MaxLength: 24, PreservedPrefix: 2, PreservedSuffix: 2}, true},
+		"last_four_min_length":        {"4444333322221", CreditCardLastFourFormat, false},
+		"last_four_max_length":        {"4444333322221111222", CreditCardLastFourFormat, false},
+		"hex_middle_digits_too_many":  {"0123456789abcd", FormatOpts{MinLength: 14, MaxLength: 14, PreservedPrefix: 2, PreservedSuffix: 2, Radix: 16, Alphabet: printableAlphabetProvider{}}, true},
+		"negative_preserved_digits":   {"123456789", FormatOpts{MinLength: 9, MaxLength: 9, PreservedPrefix: -1, PreservedSuffix: 4}, true},
+		"min_length_above_max_length": {"123456789", FormatOpts{MinLength: 10, MaxLength: 9, PreservedSuffix: 4}, true},
+		"invalid_alphabet":            {"123456789", FormatOpts{MinLength: 9, MaxLength: 9, PreservedSuffix: 4, Alphabet: missingBase14AlphaProvider}, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e := &engine{
+				minDomainSize: &shortCardsDomain,
+				versioner: deterministicVersioner{
+					tokVersion:    byte('a'),
+					detokVersions: []byte{'a', 'b', 'c', 'd'},
+				},
+				encryptionKeys: fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+				hmacKeys:       fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+				alphaProvider:  DefaultAlphabetProvider{},
+			}
+			tk, err := e.EncryptNumeric(tt.value, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("EncryptNumeric() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(tk) != len(tt.value) {
+				t.Errorf("EncryptNumeric() got = %v, want length %d", tk, len(tt.value))
+			}
+			p, s := tt.opts.PreservedPrefix, tt.opts.PreservedSuffix
+			if tk[:p] != tt.value[:p] || tk[len(tk)-s:] != tt.value[len(tt.value)-s:] {
+				t.Errorf("EncryptNumeric() got = %v, preserved digits of %v differ", tk, tt.value)
+			}
+			got, err := e.DecryptNumeric(tk, tt.opts)
+			if err != nil {
+				t.Errorf("DecryptNumeric() error = %v", err)
+				return
+			}
+			if got != tt.value {
+				t.Errorf("DecryptNumeric() got = %v, want %v", got, tt.value)
+			}
+		})
+	}
+}
+
+func Test_engine_EncryptNumeric_creditCardPresetMatchesEncryptCC(t *testing.T) {
+	e := &engine{
+		versioner: deterministicVersioner{
+			tokVersion:    byte('a'),
+			detokVersions: []byte{'a', 'b', 'c', 'd'},
+		},
+		encryptionKeys: fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		hmacKeys:       fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		alphaProvider:  DefaultAlphabetProvider{},
+	}
+	got, err := e.EncryptNumeric("4444333322221111", CreditCardFormat)
+	if err != nil {
+		t.Fatalf("EncryptNumeric() error = %v", err)
+	}
+	if want := "444433aapchc1111"; got != want {
+		t.Errorf("EncryptNumeric() got = %v, want %v", got, want)
+	}
+}
+
+// printableAlphabetProvider provides the first base printable ASCII symbols, supporting bases up to 90
+type printableAlphabetProvider struct{}
+
+func (printableAlphabetProvider) GetAlphabetForBase(base uint32) ([]byte, error) {
+	symbols := "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789!#$%&()*+-./:;<=>?@[]^_{|}~"
+	if int(base) > len(symbols) {
+		return nil, fmt.Errorf("no alphabet for base %d", base)
+	}
+	return []byte(symbols[:base]), nil
+}
+
+func Test_engine_EncryptDecryptNumeric_radix(t *testing.T) {
+	hexFormat := FormatOpts{MinLength: 7, MaxLength: 13, PreservedPrefix: 2, PreservedSuffix: 2, Radix: 16, Alphabet: printableAlphabetProvider{}}
+	base36Format := FormatOpts{MinLength: 13, MaxLength: 13, PreservedPrefix: 2, PreservedSuffix: 2, Radix: 36, Alphabet: printableAlphabetProvider{}}
+	// the domain of the binary case is the 512 values of its 9 middle symbols
+	minDomainSize := uint64(512)
+	tests := map[string]struct {
+		value   string
+		opts    FormatOpts
+		wantErr bool
+	}{
+		"hex_min_length":          {"0a1b2c3", hexFormat, false},
+		"hex_max_length":          {"deadbeef01234", hexFormat, false},
+		"base36":                  {"ab0123456789z", base36Format, false},
+		"binary":                  {"1011011011", FormatOpts{MinLength: 10, MaxLength: 10, PreservedPrefix: 1, PreservedSuffix: 0, Radix: 2, Alphabet: printableAlphabetProvider{}}, false},
+		"explicit_radix_10":       {"123456789", FormatOpts{MinLength: 9, MaxLength: 9, PreservedSuffix: 4, Radix: 10}, false},
+		"symbol_outside_radix":    {"0a1g2c3", hexFormat, true},
+		"uppercase_symbol":        {"0A1B2C3", hexFormat, true},
+		"radix_too_large":         {"ab0123456789z", FormatOpts{MinLength: 13, MaxLength: 13, PreservedPrefix: 2, PreservedSuffix: 2, Radix: 37, Alphabet: printableAlphabetProvider{}}, true},
+		"radix_too_small":         {"1011011011", FormatOpts{MinLength: 10, MaxLength: 10, PreservedPrefix: 1, Radix: 1, Alphabet: printableAlphabetProvider{}}, true},
+		"encoding_base_too_large": {"ab012cd", FormatOpts{MinLength: 7, MaxLength: 7, PreservedPrefix: 2, PreservedSuffix: 2, Radix: 36, Alphabet: printableAlphabetProvider{}}, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e := &engine{
+				minDomainSize: &minDomainSize,
+				versioner: deterministicVersioner{
+					tokVersion:    byte('a'),
+					detokVersions: []byte{'a', 'b', 'c', 'd'},
+				},
+				encryptionKeys: fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+				hmacKeys:       fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+				alphaProvider:  DefaultAlphabetProvider{},
+			}
+			tk, err := e.EncryptNumeric(tt.value, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("EncryptNumeric() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(tk) != len(tt.value) {
+				t.Errorf("EncryptNumeric() got = %v, want length %d", tk, len(tt.value))
+			}
+			got, err := e.DecryptNumeric(tk, tt.opts)
+			if err != nil {
+				t.Errorf("DecryptNumeric() error = %v", err)
+				return
+			}
+			if got != tt.value {
+				t.Errorf("DecryptNumeric() got = %v, want %v", got, tt.value)
+			}
+		})
+	}
+}
+
+func Test_encodingBaseForRadix(t *testing.T) {
+	// decimal bases are the historical ones
+	for n := 3; n <= 9; n++ {
+		got, err := encodingBaseForRadix(10, n)
+		if err != nil {
+			t.Fatalf("encodingBaseForRadix(10, %d) error = %v", n, err)
+		}
+		if want, _ := encodingBaseToSaveOneChar(n); got != want {
+			t.Errorf("encodingBaseForRadix(10, %d) got = %v, want %v", n, got, want)
+		}
+	}
+	// every base is the smallest one encoding radix^n values with n-1 symbols
+	for radix := 2; radix <= MaxRadix; radix++ {
+		for n := 3; n <= 9; n++ {
+			b, err := encodingBaseForRadix(radix, n)
+			if err != nil {
+				t.Fatalf("encodingBaseForRadix(%d, %d) error = %v", radix, n, err)
+			}
+			domain := powUint64(uint64(radix), n)
+			if powUint64(uint64(b), n-1) < domain || powUint64(uint64(b-1), n-1) >= domain {
+				t.Errorf("encodingBaseForRadix(%d, %d) got = %v, not the smallest base", radix, n, b)
+			}
+		}
+	}
+	for name, tt := range map[string]struct{ radix, n int }{
+		"too_few_digits":  {16, 2},
+		"too_many_digits": {16, 10},
+		"invalid_radix":   {37, 5},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := encodingBaseForRadix(tt.radix, tt.n); err == nil {
+				t.Errorf("encodingBaseForRadix(%d, %d) expected error", tt.radix, tt.n)
+			}
+		})
+	}
+}
+
+func Test_encodeDecodeTkMDRadix_roundTrip(t *testing.T) {
+	// all the 3-digit hexadecimal values
+	for i := 0; i < 16*16*16; i++ {
+		md := fmt.Sprintf("%03x", i)
+		enc, err := encodeTkMDRadix(md, 16, printableAlphabetProvider{})
+		if err != nil {
+			t.Fatalf("encodeTkMDRadix(%s) error = %v", md, err)
+		}
+		if len(enc) != 2 {
+			t.Errorf("encodeTkMDRadix(%s) got = %v, want 2 symbols", md, enc)
+		}
+		dec, err := decodeTkMDRadix(enc, 16, printableAlphabetProvider{})
+		if err != nil {
+			t.Fatalf("decodeTkMDRadix(%s) error = %v", enc, err)
+		}
+		if dec != md {
+			t.Errorf("decodeTkMDRadix(%s) got = %v, want %v", enc, dec, md)
+		}
+	}
+}
+
+func Test_engine_creditCardFullFormat(t *testing.T) {
+	e := &engine{
+		versioner: deterministicVersioner{
+			tokVersion:    byte('a'),
+			detokVersions: []byte{'a'},
+		},
+		encryptionKeys: fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		hmacKeys:       fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		alphaProvider:  DefaultAlphabetProvider{},
+	}
+	ccs := map[string]string{
+		"13_digits": "4444333322221",
+		"14_digits": "44443333222211",
+		"15_digits": "444433332222111",
+		"16_digits": "4444333322221111",
+		"17_digits": "44443333222211119",
+		"18_digits": "444433332222111199",
+		"19_digits": "4444333322221111999",
+		"19_max":    "9999999999999999999",
+		"19_zeros":  "0000000000000000000",
+	}
+	for name, cc := range ccs {
+		t.Run(name, func(t *testing.T) {
+			for _, ctx := range [][]byte{[]byte("tenant-1"), []byte("tenant-2")} {
+				tk, err := e.EncryptNumericWithAAD(cc, CreditCardFullFormat, ctx)
+				if err != nil {
+					t.Fatalf("EncryptNumericWithAAD() error = %v", err)
+				}
+				// nothing preserved: the version char followed by the encoded card
+				if len(tk) != len(cc) || tk[0] != 'a' || strings.ContainsAny(tk[1:], "0123456789") {
+					t.Errorf("EncryptNumericWithAAD() got = %v, want the version char followed by %d symbols", tk, len(cc)-1)
+				}
+				got, err := e.DecryptNumericWithAAD(tk, CreditCardFullFormat, ctx)
+				if err != nil || got != cc {
+					t.Errorf("DecryptNumericWithAAD() got = %v, %v, want %v", got, err, cc)
+				}
+			}
+		})
+	}
+
+	// the context selects the FF1 permutation
+	tk1, _ := e.EncryptNumericWithAAD("4444333322221111", CreditCardFullFormat, []byte("tenant-1"))
+	tk2, _ := e.EncryptNumericWithAAD("4444333322221111", CreditCardFullFormat, []byte("tenant-2"))
+	if tk1 == tk2 {
+		t.Errorf("EncryptNumericWithAAD() got the same token %v for two contexts", tk1)
+	}
+	if got, _ := e.DecryptNumericWithAAD(tk1, CreditCardFullFormat, []byte("tenant-2")); got == "4444333322221111" {
+		t.Errorf("DecryptNumericWithAAD() decrypted with another context")
+	}
+
+	// without context all the cards would share a single permutation
+	if _, err := e.EncryptNumeric("4444333322221111", CreditCardFullFormat); !errors.Is(err, ErrContextRequired) {
+		t.Errorf("EncryptNumeric() error = %v, want %v", err, ErrContextRequired)
+	}
+	if _, err := e.EncryptNumericWithAAD("4444333322221111", CreditCardFullFormat, []byte{}); !errors.Is(err, ErrContextRequired) {
+		t.Errorf("EncryptNumericWithAAD() with an empty context error = %v, want %v", err, ErrContextRequired)
+	}
+	if _, err := e.DecryptNumeric(tk1, CreditCardFullFormat); !errors.Is(err, ErrContextRequired) {
+		t.Errorf("DecryptNumeric() error = %v, want %v", err, ErrContextRequired)
+	}
+}
+
+func Test_tokenSections(t *testing.T) {
+	tests := map[string]struct {
+		tk                     string
+		opts                   FormatOpts
+		prefix, middle, suffix string
+		version                byte
+	}{
+		// the version char is also the first symbol of every alphabet
+		"version_is_alphabet_symbol": {"444433aaaaaa1111", CreditCardFormat, "444433", "aaaaa", "1111", 'a'},
+		"13_digits":                  {"444433aaa1111", CreditCardFormat, "444433", "aa", "1111", 'a'},
+		"no_prefix":                  {"aaaaaaaaa1111", FormatOpts{MinLength: 13, MaxLength: 13, PreservedSuffix: 4}, "", "aaaaaaaa", "1111", 'a'},
+		"8_digit_bin":                {"44443333abbb1111", CreditCardEightDigitBINFormat, "44443333", "bbb", "1111", 'a'},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			prefix, version, middle, suffix := tokenSections(tt.tk, tt.opts)
+			if prefix != tt.prefix || version != tt.version || middle != tt.middle || suffix != tt.suffix {
+				t.Errorf("tokenSections() = %v, %c, %v, %v, want %v, %c, %v, %v", prefix, version, middle, suffix, tt.prefix, tt.version, tt.middle, tt.suffix)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package tkengine
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// DefaultMinDomainSize is the default minimum size of the FF1 domain the engine accepts to encrypt:
+// the 1,000,000 values NIST SP 800-38G revision 1 requires (see WithMinDomainSize).
+const DefaultMinDomainSize uint64 = 1000000
+
+// ErrDomainTooSmall is returned when the middle symbols of the value to tokenize span an FF1 domain
+// (radix^number of symbols) smaller than the engine minimum: FF1 security degrades on small domains,
+// as small domains can be enumerated and are exposed to known attacks (see NIST SP 800-38G).
+var ErrDomainTooSmall = errors.New("FF1 domain too small")
+
+// Option customizes the behaviour of an engine at construction
+type Option func(e *engine)
+
+// Logger is the minimal logging interface used by the engine. *log.Logger implements it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// WithLogger sets the logger the engine uses to report unexpected failures.
+// By default the engine does not log.
+func WithLogger(l Logger) Option {
+	return func(e *engine) {
+		e.logger = l
+	}
+}
+
+// logf logs through the optional logger of the engine
+func (e *engine) logf(format string, v ...interface{}) {
+	if e.logger != nil {
+		e.logger.Printf(format, v...)
+	}
+}
+
+// applyOptions applies opts to e and returns it
+func (e *engine) applyOptions(opts []Option) *engine {
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// WithMinDomainSize sets the minimum size of the FF1 domain the engine accepts to encrypt: the middle
+// symbols of a value (the ones actually encrypted) span radix^n values, 10^n for the n middle digits
+// of a card. FF1 is not considered secure on small domains: NIST SP 800-38G required a domain of at
+// least 100 values and its revision 1 raises it to 1,000,000 values, the default (DefaultMinDomainSize).
+// With the first 6 and the last 4 digits preserved, the default rejects the cards shorter than 16
+// digits (at most 5 middle digits): WithMinDomainSize(1000) accepts the 3 middle digits of 13-digit
+// cards. The engine construction fails if n is 0.
+func WithMinDomainSize(n uint64) Option {
+	return func(e *engine) {
+		e.minDomainSize = &n
+	}
+}
+
+// validateMinDomainSize returns an error if the minimum domain size is 0
+func (e *engine) validateMinDomainSize() error {
+	if e.minDomainSize != nil && *e.minDomainSize == 0 {
+		return errors.New("Invalid minimum domain size 0: it should be positive")
+	}
+	return nil
+}
+
+// WithRandSource makes the random version selection of the engine built by NewDummyEngine
+// deterministic by drawing from src instead of the time-seeded global source: engines built
+// with the same seed pick the same sequence of versions. It is meant for tests and has no
+// effect on engines with a custom versioner.
+func WithRandSource(src rand.Source) Option {
+	return func(e *engine) {
+		if _, ok := e.versioner.(dummyVersioner); ok {
+			e.versioner = dummyVersioner{mu: &sync.Mutex{}, rnd: rand.New(src)}
+		}
+	}
+}
+
+// MaxTweakLength is the maximum tweak length in bytes (the FF1 maxTLen parameter) of the engine
+// FF1 ciphers. It accommodates the HMAC of any standard hash function up to SHA-512.
+const MaxTweakLength = 64
+
+// WithTweakHash sets the hash function of the HMAC deriving the FF1 tweak from the preserved digits.
+// By default SHA-256 is used. The hash size must be at most MaxTweakLength bytes, otherwise the engine
+// construction fails, as well as with a non-approved hash in FIPS mode (see WithFIPSMode). Tokens depend on
+// the hash: changing it makes existing tokens undecryptable.
+func WithTweakHash(h func() hash.Hash) Option {
+	return func(e *engine) {
+		e.tweakHash = h
+	}
+}
+
+// validateOptions verifies the options applied to e and returns it as a TKEngine
+func (e *engine) validateOptions() (TKEngine, error) {
+	if err := e.validateTweakLength(); err != nil {
+		return nil, err
+	}
+	if err := e.validateMinDomainSize(); err != nil {
+		return nil, err
+	}
+	if err := e.validateFIPS(); err != nil {
+		return nil, err
+	}
+	if err := e.validateDecryptCache(); err != nil {
+		return nil, err
+	}
+	if err := e.validateCMACTweaks(); err != nil {
+		return nil, err
+	}
+	if err := e.validateFF31(); err != nil {
+		return nil, err
+	}
+	if err := e.validateMaxInputLength(); err != nil {
+		return nil, err
+	}
+	if err := e.validateTokenWidth(); err != nil {
+		return nil, err
+	}
+	if err := e.validateDistinctKeys(); err != nil {
+		return nil, err
+	}
+	if err := e.validateBINLengthDetection(); err != nil {
+		return nil, err
+	}
+	if err := e.validateLayouts(); err != nil {
+		return nil, err
+	}
+	if err := e.validateMACFormats(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// validateTweakLength returns an error if the tweaks computed by the engine don't fit the FF1 ciphers
+func (e *engine) validateTweakLength() error {
+	if size := e.hashFunc()().Size(); size == 0 || size > MaxTweakLength {
+		return errors.New(fmt.Sprintf("Invalid tweak hash: its %d bytes output should be in [1, %d] bytes to be used as FF1 tweak", size, MaxTweakLength))
+	}
+	return nil
+}
+
+// hashFunc returns the hash function of the tweak HMAC, defaulting to SHA-256
+func (e *engine) hashFunc() func() hash.Hash {
+	if e.tweakHash == nil {
+		return sha256.New
+	}
+	return e.tweakHash
+}
+
+// tweak returns the FF1 tweak of the HMAC of input with hkey, the key of version v
+func (e *engine) tweak(v byte, hkey []byte, input []byte) ([]byte, error) {
+	if err := e.validateTweakLength(); err != nil {
+		return nil, err
+	}
+	h := e.hmacFor(v, hkey)
+	h.Write(input)
+	return h.Sum(nil), nil
+}
+
+// hmacFor returns a HMAC keyed with hkey, the key of version v: a new one, or a reset one of the
+// engine hmacs cache if set. The cache is keyed by version so that it holds no copy of the keys.
+func (e *engine) hmacFor(v byte, hkey []byte) hash.Hash {
+	if e.hmacs == nil {
+		return hmac.New(e.hashFunc(), hkey)
+	}
+	if h, ok := e.hmacs[v]; ok {
+		h.Reset()
+		return h
+	}
+	h := hmac.New(e.hashFunc(), hkey)
+	e.hmacs[v] = h
+	return h
+}
+
+// withHMACCache returns a copy of e reusing its HMACs across operations, as long as it lives. Since
+// the cache is not synchronized and retains the HMAC keys state, the copy must be confined to a
+// single goroutine and a bounded batch of operations, during which the HMAC key of a version is
+// assumed not to change.
+func (e *engine) withHMACCache() *engine {
+	c := *e
+	c.hmacs = make(map[byte]hash.Hash)
+	return &c
+}
+
+// WithDoubleTokenizationCheck makes EncryptCC return ErrAlreadyTokenized instead of tokenizing a
+// credit card which is also a valid token. This can only happen with alphabets containing digits
+// and digit versions, and prevents mixed streams of cards and tokens from being tokenized twice.
+func WithDoubleTokenizationCheck() Option {
+	return func(e *engine) {
+		e.rejectTokens = true
+	}
+}
+
+// WithVerifyOnEncrypt makes EncryptCC detokenize every token it produces, through the regular detokenization
+// path, and return ErrRoundTripFailure instead of a token which does not decrypt back to the input card (e.g.
+// an alphabet provider not serving stable alphabets). It doubles the tokenization cost and is disabled by default.
+func WithVerifyOnEncrypt() Option {
+	return func(e *engine) {
+		e.verifyOnEncrypt = true
+	}
+}
+
+// verifyRoundTrip returns an error if tk, produced from cc with aad, does not detokenize back to cc.
+// Neither the card nor the token are part of the error.
+func (e *engine) verifyRoundTrip(cc string, tk string, aad []byte) error {
+	got, err := e.decryptTK(tk, aad)
+	if err != nil {
+		return fmt.Errorf("%w: the token can't be detokenized: %v", ErrRoundTripFailure, err)
+	}
+	if got != cc {
+		return fmt.Errorf("%w: the token detokenizes to another card", ErrRoundTripFailure)
+	}
+	return nil
+}
+
+// WithVersionFallback makes DecryptTK recover tokens whose version char was corrupted: if the
+// embedded version is not a detokenization version, or if the card decrypted under it fails the
+// Luhn check, every other detokenization version is tried and the first Luhn-valid card is returned.
+// It is meant for recovery scenarios only: it multiplies the decryption cost and, since about one
+// random card out of ten is Luhn-valid, it may return a wrong card for a corrupted token (or for a
+// legit token of a card failing the Luhn check), and it turns invalid tokens into decryptable ones.
+func WithVersionFallback() Option {
+	return func(e *engine) {
+		e.versionFallback = true
+	}
+}
+
+// checkDomain returns ErrDomainTooSmall if the middle symbols of a value of length l laid out as opts
+// span a smaller FF1 domain than the engine minimum
+func (e *engine) checkDomain(l int, opts FormatOpts) error {
+	minSize := DefaultMinDomainSize
+	if e.minDomainSize != nil {
+		minSize = *e.minDomainSize
+	}
+	md := l - opts.PreservedPrefix - opts.PreservedSuffix
+	if size := domainSize(opts.radix(), md); size < minSize {
+		return fmt.Errorf("%w: %d middle symbols in radix %d span %d values, the minimum is %d", ErrDomainTooSmall, md, opts.radix(), size, minSize)
+	}
+	return nil
+}
+
+// domainSize returns radix^n, the number of values of n symbols in radix, saturated at math.MaxUint64
+func domainSize(radix int, n int) uint64 {
+	size := uint64(1)
+	for i := 0; i < n; i++ {
+		if size > math.MaxUint64/uint64(radix) {
+			return math.MaxUint64
+		}
+		size *= uint64(radix)
+	}
+	return size
+}
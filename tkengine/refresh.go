@@ -0,0 +1,131 @@
+package tkengine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RefreshableKeyRepo is a key repository periodically reloading its keys with a user-supplied
+// function, so that keys rotated in the backing store are picked up without a restart. Each
+// reload atomically replaces the whole key snapshot: concurrent GetKey calls observe either the
+// previous or the new keys, never a mix of both. The versioner being reloaded independently, use
+// a RefreshableKeyring when the rotations remove the keys of the previous tokenization version.
+type RefreshableKeyRepo struct {
+	load func() (map[byte][]byte, error)
+
+	// mu guards keys, err and gen
+	mu   sync.RWMutex
+	keys map[byte][]byte
+	err  error
+	// gen is incremented every time a reload changes the keys
+	gen uint64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewRefreshableKeyRepo returns a key repository loaded with load and reloaded every interval
+// until Stop is called. The first load happens before returning and its error is returned: a
+// repository is never served empty. A failing reload keeps the previous keys (see Err).
+func NewRefreshableKeyRepo(load func() (map[byte][]byte, error), interval time.Duration) (*RefreshableKeyRepo, error) {
+	if load == nil {
+		return nil, errors.New("Missing key loading function")
+	}
+	if interval <= 0 {
+		return nil, errors.New(fmt.Sprintf("Invalid refresh interval %v: it should be positive", interval))
+	}
+	r := &RefreshableKeyRepo{
+		load: load,
+		stop: make(chan struct{}),
+	}
+	if err := r.Refresh(); err != nil {
+		return nil, err
+	}
+	go r.refreshEvery(interval)
+	return r, nil
+}
+
+// GetKey returns the key of version v in the current snapshot
+func (r *RefreshableKeyRepo) GetKey(v byte) ([]byte, error) {
+	r.mu.RLock()
+	key, ok := r.keys[v]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("No key exists for version %v", v))
+	}
+	return key, nil
+}
+
+// Refresh reloads the keys immediately. On error the previous keys are kept.
+func (r *RefreshableKeyRepo) Refresh() error {
+	loaded, err := r.load()
+	var keys map[byte][]byte
+	if err == nil {
+		// the snapshot is copied so that the caller can't mutate it after the swap
+		keys = make(map[byte][]byte, len(loaded))
+		for v, key := range loaded {
+			keys[v] = append([]byte(nil), key...)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+	if err == nil {
+		if !sameKeys(r.keys, keys) {
+			r.gen++
+		}
+		r.keys = keys
+	}
+	return err
+}
+
+// keyGeneration returns the number of reloads which changed the keys
+func (r *RefreshableKeyRepo) keyGeneration() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.gen
+}
+
+// sameKeys returns true if a and b hold the same keys for the same versions
+func sameKeys(a map[byte][]byte, b map[byte][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for v, key := range a {
+		other, ok := b[v]
+		if !ok || !bytes.Equal(key, other) {
+			return false
+		}
+	}
+	return true
+}
+
+// Err returns the error of the last reload, nil if it succeeded
+func (r *RefreshableKeyRepo) Err() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.err
+}
+
+// Stop stops the periodic reloads. The repository keeps serving its last keys.
+func (r *RefreshableKeyRepo) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+}
+
+// refreshEvery reloads the keys every interval until Stop is called
+func (r *RefreshableKeyRepo) refreshEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = r.Refresh()
+		case <-r.stop:
+			return
+		}
+	}
+}
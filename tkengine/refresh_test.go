@@ -0,0 +1,140 @@
+package tkengine
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rotatingLoader serves the keys of the current generation, or an error if failing
+type rotatingLoader struct {
+	mu      sync.Mutex
+	keys    map[byte][]byte
+	failing bool
+	calls   int
+}
+
+func (l *rotatingLoader) load() (map[byte][]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls++
+	if l.failing {
+		return nil, errors.New("key store unavailable")
+	}
+	return l.keys, nil
+}
+
+func (l *rotatingLoader) set(keys map[byte][]byte, failing bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.keys = keys
+	l.failing = failing
+}
+
+func TestNewRefreshableKeyRepo(t *testing.T) {
+	valid := func() (map[byte][]byte, error) { return map[byte][]byte{'a': {0}}, nil }
+	tests := map[string]struct {
+		load     func() (map[byte][]byte, error)
+		interval time.Duration
+		wantErr  bool
+	}{
+		"valid":             {valid, time.Hour, false},
+		"nil_load":          {nil, time.Hour, true},
+		"zero_interval":     {valid, 0, true},
+		"failing_load":      {func() (map[byte][]byte, error) { return nil, errors.New("unavailable") }, time.Hour, true},
+		"negative_interval": {valid, -time.Second, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r, err := NewRefreshableKeyRepo(tt.load, tt.interval)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewRefreshableKeyRepo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				r.Stop()
+			}
+		})
+	}
+}
+
+func TestRefreshableKeyRepo_Refresh(t *testing.T) {
+	loader := &rotatingLoader{keys: map[byte][]byte{'a': {0}}}
+	r, err := NewRefreshableKeyRepo(loader.load, time.Hour)
+	if err != nil {
+		t.Fatalf("NewRefreshableKeyRepo() error = %v", err)
+	}
+	defer r.Stop()
+
+	// rotation: version a retired, version b added
+	loader.set(map[byte][]byte{'b': {1}}, false)
+	if err := r.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if _, err := r.GetKey('a'); err == nil {
+		t.Errorf("GetKey(a) expected error for retired version")
+	}
+	if key, err := r.GetKey('b'); err != nil || key[0] != 1 {
+		t.Errorf("GetKey(b) = %v, %v, want [1]", key, err)
+	}
+
+	// a failing reload keeps the previous keys
+	loader.set(nil, true)
+	if err := r.Refresh(); err == nil {
+		t.Errorf("Refresh() expected error")
+	}
+	if r.Err() == nil {
+		t.Errorf("Err() expected error of the last reload")
+	}
+	if _, err := r.GetKey('b'); err != nil {
+		t.Errorf("GetKey(b) error = %v, want previous keys kept", err)
+	}
+}
+
+func TestRefreshableKeyRepo_concurrentRotation(t *testing.T) {
+	zero := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	loader := &rotatingLoader{keys: map[byte][]byte{'a': zero}}
+	r, err := NewRefreshableKeyRepo(loader.load, time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewRefreshableKeyRepo() error = %v", err)
+	}
+	defer r.Stop()
+	e, err := NewEngineWithDefaultAlphabet(deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, r, r)
+	if err != nil {
+		t.Fatalf("NewEngineWithDefaultAlphabet() error = %v", err)
+	}
+
+	// keys are reloaded in the background while tokenizing, the same keys being served
+	// under a fresh snapshot at each reload
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				loader.set(map[byte][]byte{'a': zero}, false)
+				tk, err := e.EncryptCC("4444333322221111")
+				if err != nil || tk != "444433aapchc1111" {
+					t.Errorf("EncryptCC() = %v, %v, want 444433aapchc1111", tk, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the tokenizations may complete before the first tick
+	deadline := time.Now().Add(time.Second)
+	for {
+		loader.mu.Lock()
+		calls := loader.calls
+		loader.mu.Unlock()
+		if calls >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected background reloads, got %d loads", calls)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,309 @@
+package tkengine
+
+import (
+	"errors"
+	"fmt"
+)
+
+// retokenizeBatchProgressInterval is the number of tokens processed between
+// two invocations of the RetokenizeBatch progress callback
+const retokenizeBatchProgressInterval = 100
+
+// TokenInspector is an optional interface of a TKEngine inspecting tokens without decrypting them.
+// The engines built by this package implement it.
+type TokenInspector interface {
+	// TokenVersion returns the version byte of a valid TK
+	// Error types: InvalidTK format
+	TokenVersion(tk string) (byte, error)
+	// IsToken returns true if s is a valid TK for the engine
+	// alphabets and detokenization versions
+	IsToken(s string) bool
+	// DescribeToken returns the version, layout and encoding
+	// of a valid TK, without decrypting it
+	DescribeToken(tk string) (TokenDescriptor, error)
+}
+
+// TokenVersion returns the version byte of a token. An error is returned if
+// tk is not a valid token for the current detokenization versions.
+func (e *engine) TokenVersion(tk string) (byte, error) {
+	if err := e.checkInputLength(tk); err != nil {
+		return 0, err
+	}
+	detokVers, err := e.versioner.GetDetokenizationVersions()
+	if err != nil {
+		return 0, err
+	}
+	if tk, err = e.openToken(tk); err != nil {
+		return 0, err
+	}
+	_, opts, err := e.detokAlphabet(tk, detokVers)
+	if err != nil {
+		return 0, err
+	}
+	return tk[opts.PreservedPrefix], nil
+}
+
+// RetokenizeBatch re-encrypts each token of tks under the current tokenization version (see Retokenize).
+// The returned tokens and errors are aligned with tks: for each index either the token or the error is set.
+// Tokens already on the current tokenization version and alphabet are passed through without any crypto
+// operation, so that an interrupted migration can be resumed cheaply by running it again on the same input.
+// If not nil, progress is invoked every few tokens and once all the tokens are processed with the number
+// of processed tokens and the total.
+func (e *engine) RetokenizeBatch(tks []string, progress func(done, total int)) ([]string, []error) {
+	res := make([]string, len(tks))
+	errs := make([]error, len(tks))
+	if err := e.checkConfigured(); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return res, errs
+	}
+
+	// versions are retrieved once for the whole batch
+	v, vErr := e.versioner.GetTokenizationVersion()
+	detokVers, dErr := e.versioner.GetDetokenizationVersions()
+
+	// tokens mostly share a few versions: their tweak HMACs are reset and reused
+	// for the whole batch instead of being built again for every token
+	be := e.withHMACCache()
+
+	for i, tk := range tks {
+		audited := false
+		switch {
+		case vErr != nil:
+			errs[i] = vErr
+		case dErr != nil:
+			errs[i] = dErr
+		case e.isCurrentTK(tk, v, detokVers):
+			// already on the current version and alphabet
+			res[i] = tk
+		default:
+			// Retokenize records its own audit event
+			res[i], errs[i] = be.Retokenize(tk)
+			audited = true
+		}
+		if !audited {
+			e.audit(AuditRetokenize, res[i], e.ccVersionIndex(res[i]), len(tk), errs[i])
+		}
+		if progress != nil && ((i+1)%retokenizeBatchProgressInterval == 0 || i+1 == len(tks)) {
+			progress(i+1, len(tks))
+		}
+	}
+	return res, errs
+}
+
+// isCurrentTK returns true if tk is a valid token of version v, in the layout of v (see LayoutVersioner)
+// and encoded with the tokenization alphabet: Retokenize would return it as is
+func (e *engine) isCurrentTK(tk string, v byte, detokVers []byte) bool {
+	tk, err := e.openToken(tk)
+	if err != nil {
+		return false
+	}
+	_, opts, err := e.detokAlphabet(tk, detokVers)
+	if err != nil {
+		return false
+	}
+	return tk[opts.PreservedPrefix] == v && isValidNumericTK(tk, opts, e.alphaProvider, detokVers)
+}
+
+// TokenEnumerator is an optional interface of a TKEngine looking up the tokens of a card across
+// versions. The engines built by this package implement it.
+type TokenEnumerator interface {
+	// TokensForCard returns the tokens of cc under each
+	// detokenization version, indexed by version
+	TokensForCard(cc string) (map[byte]string, error)
+}
+
+// TokensForCard tokenizes cc under each detokenization version with the keys of the version, so that
+// its tokens can be looked up in stores written at different times. Tokenization options such as
+// WithDoubleTokenizationCheck don't apply. An audit event is recorded for each produced token and the
+// first failing version aborts the operation.
+func (e *engine) TokensForCard(cc string) (map[byte]string, error) {
+	if err := e.checkInputLength(cc); err != nil {
+		e.audit(AuditTokenize, "", CreditCardFormat.PreservedPrefix, len(cc), err)
+		return nil, err
+	}
+	if err := checkNumeric(cc, CreditCardFormat, ErrInvalidCC); err != nil {
+		e.audit(AuditTokenize, "", CreditCardFormat.PreservedPrefix, len(cc), err)
+		return nil, err
+	}
+	if err := e.checkDomain(len(cc), CreditCardFormat); err != nil {
+		e.audit(AuditTokenize, "", CreditCardFormat.PreservedPrefix, len(cc), err)
+		return nil, err
+	}
+	detokVers, err := e.versioner.GetDetokenizationVersions()
+	if err != nil {
+		e.audit(AuditTokenize, "", CreditCardFormat.PreservedPrefix, len(cc), err)
+		return nil, err
+	}
+
+	// the same tweak input is HMACed with the key of every version
+	be := e.withHMACCache()
+	// lookup tokens are not new tokens, they are left out of VersionStats
+	be.stats = nil
+
+	tks := make(map[byte]string, len(detokVers))
+	for _, v := range detokVers {
+		tk, err := be.tokenForVersion(cc, v)
+		be.audit(AuditTokenize, tk, e.ccVersionIndex(tk), len(cc), err)
+		if err != nil {
+			return nil, err
+		}
+		tks[v] = tk
+	}
+	return tks, nil
+}
+
+// tokenForVersion tokenizes a valid cc under version v, recovering cipher panics
+func (e *engine) tokenForVersion(cc string, v byte) (tk string, err error) {
+	defer e.recoverCipherPanic("TokensForCard", &err)
+
+	opts, err := e.cardFormat(v, cc)
+	if err != nil {
+		return "", err
+	}
+	if err := checkCardLength(len(cc), opts, ErrInvalidCC); err != nil {
+		return "", err
+	}
+	if err := e.checkDomain(len(cc), opts); err != nil {
+		return "", err
+	}
+	tk, err = e.encryptVersion(cc, v, opts, e.alphaProvider, nil)
+	if err != nil {
+		return "", err
+	}
+	return e.sealToken(tk)
+}
+
+// TweakRewrapper is an optional interface of a TKEngine migrating tokens after the rotation of an
+// HMAC key alone. The engines built by this package implement it.
+type TweakRewrapper interface {
+	// RewrapTweak migrates a valid TK after the rotation of the hmac key alone
+	// of its version, from the tweak of oldHmacKey to the one of newHmacKey
+	RewrapTweak(tk string, oldHmacKey []byte, newHmacKey []byte) (string, error)
+}
+
+// RewrapTweak migrates tk after the rotation of the HMAC key alone of its version. The FF1 tweak of a
+// token is the HMAC of its preserved digits: a new HMAC key changes the tweak, hence the token, even if
+// the encryption key is kept, and the existing tokens of the version no longer decrypt. The middle digits
+// of tk are decrypted with the encryption key of its version and the tweak of oldHmacKey, then encrypted
+// with the same encryption key and the tweak of newHmacKey, encoded in the tokenization alphabet. The
+// version char and the preserved digits are kept and, as with Retokenize, the full card number is never
+// materialized. The HMAC key repository of the engine is not used.
+func (e *engine) RewrapTweak(tk string, oldHmacKey []byte, newHmacKey []byte) (rtk string, err error) {
+	defer func() { e.audit(AuditRetokenize, rtk, e.ccVersionIndex(rtk), len(tk), err) }()
+	defer e.recoverCipherPanic("RewrapTweak", &err)
+
+	if err := e.checkInputLength(tk); err != nil {
+		return "", err
+	}
+	if len(oldHmacKey) == 0 || len(newHmacKey) == 0 {
+		return "", errors.New("Missing hmac key: both the old and the new hmac keys are required")
+	}
+	detokVers, err := e.versioner.GetDetokenizationVersions()
+	if err != nil {
+		return "", err
+	}
+
+	// the engines of the token version with each hmac key, which also keys the MAC of FormatMAC tokens
+	var tv byte
+	if i := e.ccVersionIndex(tk); i < len(tk) {
+		tv = tk[i]
+	}
+	oldE, newE := *e, *e
+	oldE.hmacKeys = pinnedKeyRepo{tv, oldHmacKey, e.hmacKeys}
+	newE.hmacKeys = pinnedKeyRepo{tv, newHmacKey, e.hmacKeys}
+
+	if tk, err = oldE.openToken(tk); err != nil {
+		return "", err
+	}
+
+	// input validation
+	alpha, opts, err := e.detokAlphabet(tk, detokVers)
+	if err != nil {
+		return "", err
+	}
+	p, s := opts.PreservedPrefix, opts.PreservedSuffix
+	v := tk[p]
+	f, err := e.formatFor(v)
+	if err != nil {
+		return "", err
+	}
+	if !hasV0Layout(f) {
+		return "", errors.New(fmt.Sprintf("Unsupported token format %d for version %s", f, string(v)))
+	}
+
+	sixByFour := tweakInputFor(f, []byte(tk), p, s)
+	defer zero(sixByFour)
+
+	md, err := oldE.decryptMDV0(sixByFour, tk[p:len(tk)-s], v, opts.radix(), alpha)
+	if err != nil {
+		return "", err
+	}
+	tkmd, err := newE.encryptMDV0(sixByFour, md, v, opts.radix(), e.alphaProvider)
+	if err != nil {
+		return "", err
+	}
+	return newE.sealToken(fmt.Sprintf("%s%s%s%s", tk[0:p], string(v), tkmd, tk[len(tk)-s:]))
+}
+
+// ShadowTokenizer is an optional interface of a TKEngine canarying versions not yet promoted to
+// tokenization. The engines built by this package implement it.
+type ShadowTokenizer interface {
+	// ShadowTokenize tokenizes cc under a possibly inactive version
+	// and reports whether the token round trips under that version
+	ShadowTokenize(cc string, version byte) (token string, ok bool, err error)
+}
+
+// ShadowTokenize tokenizes cc under version, typically a new version not yet promoted to tokenization
+// version nor even declared as a detokenization version, and verifies that the token decrypts back to
+// cc under version: a new key can be canaried on production traffic before its promotion. ok reports
+// whether the round trip succeeded, err is set when the token can't be produced (e.g. an invalid card
+// or missing keys for version). Shadow tokens are left out of VersionStats and tokenization options
+// such as WithDoubleTokenizationCheck don't apply.
+func (e *engine) ShadowTokenize(cc string, version byte) (tk string, ok bool, err error) {
+	if err := e.checkConfigured(); err != nil {
+		return "", false, err
+	}
+	// shadow tokens are audited but not counted
+	se := *e
+	se.stats = nil
+	defer func() { se.audit(AuditTokenize, tk, e.ccVersionIndex(tk), len(cc), err) }()
+	defer e.recoverCipherPanic("ShadowTokenize", &err)
+
+	// input validation
+	if err := e.checkInputLength(cc); err != nil {
+		return "", false, err
+	}
+	if err := checkNumeric(cc, CreditCardFormat, ErrInvalidCC); err != nil {
+		return "", false, err
+	}
+	if err := ValidateVersion(version); err != nil {
+		return "", false, err
+	}
+	opts, err := e.cardFormat(version, cc)
+	if err != nil {
+		return "", false, err
+	}
+	if err := checkCardLength(len(cc), opts, ErrInvalidCC); err != nil {
+		return "", false, err
+	}
+	if err := e.checkDomain(len(cc), opts); err != nil {
+		return "", false, err
+	}
+
+	if tk, err = e.encryptVersion(cc, version, opts, e.alphaProvider, nil); err != nil {
+		return "", false, err
+	}
+	if tk, err = e.sealToken(tk); err != nil {
+		return "", false, err
+	}
+
+	// the round trip under version, whatever the detokenization versions
+	opened, err := e.openToken(tk)
+	if err != nil {
+		return tk, false, nil
+	}
+	got, err := e.decrypt(opened, opts, e.alphaProvider, nil)
+	return tk, err == nil && got == cc, nil
+}
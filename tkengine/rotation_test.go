@@ -0,0 +1,307 @@
+package tkengine
+
+import (
+	"errors"
+	"testing"
+)
+
+type countingKeyRepo struct {
+	keyRepo
+	calls int
+}
+
+func (c *countingKeyRepo) GetKey(v byte) ([]byte, error) {
+	c.calls++
+	return c.keyRepo.GetKey(v)
+}
+
+func Test_engine_TokenVersion(t *testing.T) {
+	e := &engine{
+		versioner: deterministicVersioner{
+			tokVersion:    byte('a'),
+			detokVersions: []byte{'a', 'b'},
+		},
+		alphaProvider: DefaultAlphabetProvider{},
+	}
+	tests := map[string]struct {
+		tk      string
+		want    byte
+		wantErr bool
+	}{
+		"version_a":         {"444433aapchc1111", 'a', false},
+		"version_b":         {"444433bapchc1111", 'b', false},
+		"version_not_detok": {"444433fapchc1111", 0, true},
+		"invalid_TK":        {"4444331", 0, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := e.TokenVersion(tt.tk)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TokenVersion() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("TokenVersion() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_engine_RetokenizeBatch(t *testing.T) {
+	keys := &countingKeyRepo{keyRepo: keyRepo{keys: map[byte][]byte{
+		'a': {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		'b': {1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+	}}}
+	e := &engine{
+		versioner: deterministicVersioner{
+			tokVersion:    byte('b'),
+			detokVersions: []byte{'a', 'b'},
+		},
+		encryptionKeys: keys,
+		hmacKeys:       keys,
+		alphaProvider:  DefaultAlphabetProvider{},
+	}
+
+	current, err := e.Retokenize("444433aapchc1111")
+	if err != nil {
+		t.Fatalf("Retokenize() error = %v", err)
+	}
+
+	// 250 already-current tokens, one old token and one invalid token
+	tks := make([]string, 0, 252)
+	for i := 0; i < 250; i++ {
+		tks = append(tks, current)
+	}
+	tks = append(tks, "444433aapchc1111", "444433fapchc1111")
+
+	var calls [][2]int
+	keys.calls = 0
+	got, errs := e.RetokenizeBatch(tks, func(done, total int) {
+		calls = append(calls, [2]int{done, total})
+	})
+
+	if len(got) != len(tks) || len(errs) != len(tks) {
+		t.Fatalf("RetokenizeBatch() got %d tokens and %d errors, want %d", len(got), len(errs), len(tks))
+	}
+	for i := 0; i < 251; i++ {
+		if errs[i] != nil || got[i] != current {
+			t.Errorf("RetokenizeBatch() token %d got = %v, %v, want %v", i, got[i], errs[i], current)
+		}
+	}
+	if errs[251] == nil || got[251] != "" {
+		t.Errorf("RetokenizeBatch() invalid token got = %v, %v, want error", got[251], errs[251])
+	}
+	// only the old token required crypto: 2 versions x (encryption + hmac) keys
+	if keys.calls != 4 {
+		t.Errorf("RetokenizeBatch() fetched %d keys, want 4: already-current tokens should be passed through", keys.calls)
+	}
+	wantCalls := [][2]int{{100, 252}, {200, 252}, {252, 252}}
+	if len(calls) != len(wantCalls) {
+		t.Fatalf("RetokenizeBatch() progress calls = %v, want %v", calls, wantCalls)
+	}
+	for i := range wantCalls {
+		if calls[i] != wantCalls[i] {
+			t.Errorf("RetokenizeBatch() progress calls = %v, want %v", calls, wantCalls)
+		}
+	}
+}
+
+// countingLayoutVersioner counts the retrievals of the tokenization version
+type countingLayoutVersioner struct {
+	layoutVersioner
+	calls *int
+}
+
+func (c countingLayoutVersioner) GetTokenizationVersion() (byte, error) {
+	*c.calls++
+	return c.layoutVersioner.GetTokenizationVersion()
+}
+
+func Test_engine_RetokenizeBatch_layouts(t *testing.T) {
+	keys := keyRepo{keys: map[byte][]byte{
+		'a': {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		'B': {1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+	}}
+	calls := 0
+	versioner := countingLayoutVersioner{layoutVersioner{deterministicVersioner{tokVersion: 'B', detokVersions: []byte{'a', 'B'}}, map[byte][2]int{'a': {6, 4}, 'B': {8, 2}}}, &calls}
+	e, err := NewEngine(versioner, &keys, &keys, DefaultAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	current, err := e.EncryptCC("4444333322221111")
+	if err != nil {
+		t.Fatalf("EncryptCC() error = %v", err)
+	}
+
+	// tokens of the current version are passed through in its own layout: the versions are
+	// retrieved once for the batch and never again by Retokenize
+	calls = 0
+	got, errs := e.(Retokenizer).RetokenizeBatch([]string{current, current}, nil)
+	for i := range got {
+		if errs[i] != nil || got[i] != current {
+			t.Errorf("RetokenizeBatch() token %d got = %v, %v, want %v", i, got[i], errs[i], current)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("RetokenizeBatch() retrieved the tokenization version %d times, want 1: already-current tokens should be passed through", calls)
+	}
+}
+
+func Test_engine_TokensForCard(t *testing.T) {
+	keys := &keyRepo{keys: map[byte][]byte{
+		'a': {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		'b': {1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+	}}
+	tests := map[string]struct {
+		versioner KeyVersioner
+		cc        string
+		wantVers  []byte
+		wantErr   bool
+	}{
+		"every_detok_version": {deterministicVersioner{tokVersion: 'b', detokVersions: []byte{'a', 'b'}}, "4444333322221111", []byte{'a', 'b'}, false},
+		"single_version":      {deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, "4444333322221", []byte{'a'}, false},
+		"missing_key":         {deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a', 'c'}}, "4444333322221111", nil, true},
+		"invalid_cc":          {deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, "444433332222", nil, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngine(tt.versioner, keys, keys, DefaultAlphabetProvider{}, WithMinDomainSize(shortCardsDomain))
+			if err != nil {
+				t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+			}
+			got, err := e.(TokenEnumerator).TokensForCard(tt.cc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TokensForCard() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.wantVers) {
+				t.Fatalf("TokensForCard() = %v, want tokens for versions %s", got, tt.wantVers)
+			}
+			for _, v := range tt.wantVers {
+				tk, ok := got[v]
+				if !ok || tk[6] != v {
+					t.Errorf("TokensForCard()[%c] = %v, want a token of version %c", v, tk, v)
+					continue
+				}
+				if cc, err := e.DecryptTK(tk); err != nil || cc != tt.cc {
+					t.Errorf("DecryptTK(%v) = %v, %v, want %v", tk, cc, err, tt.cc)
+				}
+			}
+		})
+	}
+
+	// the token of the current tokenization version is the one EncryptCC produces
+	e, _ := NewEngine(deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a', 'b'}}, keys, keys, DefaultAlphabetProvider{}, WithMinDomainSize(shortCardsDomain))
+	if got, err := e.(TokenEnumerator).TokensForCard("4444333322221111"); err != nil || got['a'] != "444433aapchc1111" {
+		t.Errorf("TokensForCard() = %v, %v, want 444433aapchc1111 for version a", got, err)
+	}
+}
+
+func Test_engine_RewrapTweak(t *testing.T) {
+	zeros := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	ones := []byte{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	encryptionKeys := &keyRepo{keys: map[byte][]byte{'a': zeros}}
+	// the engines before and after the rotation of the hmac key of version a
+	before, err := NewEngine(versioner, encryptionKeys, &keyRepo{keys: map[byte][]byte{'a': zeros}}, DefaultAlphabetProvider{}, WithMinDomainSize(shortCardsDomain))
+	if err != nil {
+		t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+	}
+	after, err := NewEngine(versioner, encryptionKeys, &keyRepo{keys: map[byte][]byte{'a': ones}}, DefaultAlphabetProvider{}, WithMinDomainSize(shortCardsDomain))
+	if err != nil {
+		t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+	}
+
+	tests := map[string]struct {
+		tk      string
+		oldKey  []byte
+		newKey  []byte
+		wantCC  string
+		wantErr bool
+	}{
+		"16_digits":         {"444433aapchc1111", zeros, ones, "4444333322221111", false},
+		"13_digits":         {"444433ad32221", zeros, ones, "4444333322221", false},
+		"same_key":          {"444433aapchc1111", zeros, zeros, "4444333322221111", false},
+		"missing_old_key":   {"444433aapchc1111", nil, ones, "", true},
+		"missing_new_key":   {"444433aapchc1111", zeros, nil, "", true},
+		"version_not_detok": {"444433bapchc1111", zeros, ones, "", true},
+		"invalid_TK":        {"4444331", zeros, ones, "", true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := after.(TweakRewrapper).RewrapTweak(tt.tk, tt.oldKey, tt.newKey)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RewrapTweak() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.tk) || got[:7] != tt.tk[:7] || got[len(got)-4:] != tt.tk[len(tt.tk)-4:] {
+				t.Errorf("RewrapTweak() = %v, want the prefix, version and suffix of %v", got, tt.tk)
+			}
+			engine := after
+			if string(tt.newKey) == string(zeros) {
+				engine = before
+			}
+			if cc, err := engine.DecryptTK(got); err != nil || cc != tt.wantCC {
+				t.Errorf("DecryptTK(%v) = %v, %v, want %v", got, cc, err, tt.wantCC)
+			}
+			// the rewrapped token is the one the rotated engine produces
+			if tk, err := engine.EncryptCC(tt.wantCC); err != nil || tk != got {
+				t.Errorf("EncryptCC(%v) = %v, %v, want %v", tt.wantCC, tk, err, got)
+			}
+		})
+	}
+}
+
+func Test_engine_ShadowTokenize(t *testing.T) {
+	// b is not yet a detokenization version, c has no keys
+	keys := &keyRepo{keys: map[byte][]byte{'a': make([]byte, 16), 'b': make([]byte, 16)}}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	tests := map[string]struct {
+		alpha   AlphabetProvider
+		cc      string
+		version byte
+		want    string
+		wantOk  bool
+		wantErr error
+	}{
+		"new_version":       {DefaultAlphabetProvider{}, "4444333322221111", 'b', "444433bapchc1111", true, nil},
+		"current_version":   {DefaultAlphabetProvider{}, "4444333322221", 'a', "444433ad32221", true, nil},
+		"unstable_alphabet": {rotatingAlphabetProvider{new(int), new(bool)}, "4444333322221111", 'b', "", false, nil},
+		"missing_keys":      {DefaultAlphabetProvider{}, "4444333322221111", 'c', "", false, ErrKeyUnavailable},
+		"invalid_cc":        {DefaultAlphabetProvider{}, "444433332222", 'b', "", false, ErrInvalidCC},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngine(versioner, keys, keys, tt.alpha, WithMinDomainSize(shortCardsDomain))
+			if err != nil {
+				t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+			}
+			if r, ok := tt.alpha.(rotatingAlphabetProvider); ok {
+				*r.started = true
+			}
+			tk, ok, err := e.(ShadowTokenizer).ShadowTokenize(tt.cc, tt.version)
+			if !errors.Is(err, tt.wantErr) || (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("ShadowTokenize() error = %v, want %v", err, tt.wantErr)
+			}
+			if ok != tt.wantOk || tt.want != "" && tk != tt.want {
+				t.Errorf("ShadowTokenize() = %v, %v, want %v, %v", tk, ok, tt.want, tt.wantOk)
+			}
+			// the production tokenization is not affected
+			if n := len(e.(StatsReporter).VersionStats()); n != 0 {
+				t.Errorf("VersionStats() counted %d versions, want no shadow token counted", n)
+			}
+		})
+	}
+}
+
+func Test_engine_ShadowTokenize_invalidVersion(t *testing.T) {
+	keys := &keyRepo{keys: map[byte][]byte{'a': make([]byte, 16)}}
+	e, err := NewEngine(deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, keys, keys, DefaultAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	if _, ok, err := e.(ShadowTokenizer).ShadowTokenize("4444333322221111", 0x01); err == nil || ok {
+		t.Errorf("ShadowTokenize() = %v, %v, want an invalid version error", ok, err)
+	}
+}
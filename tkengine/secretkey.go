@@ -0,0 +1,57 @@
+package tkengine
+
+import "fmt"
+
+// SecretKey holds key material which is wiped once closed. It implements io.Closer.
+type SecretKey struct {
+	b []byte
+}
+
+// NewSecretKey returns a SecretKey taking ownership of b: b is zeroed when the key is closed
+func NewSecretKey(b []byte) *SecretKey {
+	return &SecretKey{b: b}
+}
+
+// Bytes returns the key material. It must not be used once the key is closed.
+func (k *SecretKey) Bytes() []byte {
+	return k.b
+}
+
+// Close zeroes the key material
+func (k *SecretKey) Close() error {
+	zero(k.b)
+	return nil
+}
+
+// SecretKeyRepo is implemented by the key repositories able to return each key in its own SecretKey.
+// The engine closes the keys after each crypto operation to minimize the residency of key material
+// in memory, so GetSecretKey must return a fresh SecretKey on every call.
+type SecretKeyRepo interface {
+	// GetSecretKey returns a key for the input version
+	// an error is issued if the key is not present in
+	// the repo
+	GetSecretKey(version byte) (*SecretKey, error)
+}
+
+// getSecretKey returns the key of version v held by repo. Repositories not implementing SecretKeyRepo
+// are adapted: their key is copied into a SecretKey so that closing it doesn't wipe the repository.
+// Repository failures are wrapped in ErrKeyUnavailable.
+func getSecretKey(repo KeyRepo, v byte) (*SecretKey, error) {
+	if sr, ok := repo.(SecretKeyRepo); ok {
+		key, err := sr.GetSecretKey(v)
+		if err != nil {
+			return nil, keyUnavailable(v, err)
+		}
+		return key, nil
+	}
+	key, err := repo.GetKey(v)
+	if err != nil {
+		return nil, keyUnavailable(v, err)
+	}
+	return NewSecretKey(append([]byte(nil), key...)), nil
+}
+
+// keyUnavailable wraps the error of a repository failing to return the key of version v
+func keyUnavailable(v byte, err error) error {
+	return fmt.Errorf("%w: version %q: %v", ErrKeyUnavailable, v, err)
+}
@@ -0,0 +1,86 @@
+package tkengine
+
+import (
+	"testing"
+)
+
+// issuingSecretKeyRepo returns a fresh SecretKey on every call and keeps track of them
+type issuingSecretKeyRepo struct {
+	key    []byte
+	issued []*SecretKey
+}
+
+func (r *issuingSecretKeyRepo) GetKey(_ byte) ([]byte, error) {
+	return r.key, nil
+}
+
+func (r *issuingSecretKeyRepo) GetSecretKey(_ byte) (*SecretKey, error) {
+	k := NewSecretKey(append([]byte(nil), r.key...))
+	r.issued = append(r.issued, k)
+	return k, nil
+}
+
+func TestSecretKey_Close(t *testing.T) {
+	b := []byte{1, 2, 3}
+	k := NewSecretKey(b)
+	if got := k.Bytes(); len(got) != 3 || got[0] != 1 {
+		t.Errorf("Bytes() = %v, want [1 2 3]", got)
+	}
+	if err := k.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	for _, el := range b {
+		if el != 0 {
+			t.Errorf("Close() left key material %v", b)
+			break
+		}
+	}
+}
+
+func Test_engine_secretKeys(t *testing.T) {
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	tests := map[string]struct {
+		ops func(e TKEngine) error
+	}{
+		"tokenize":   {func(e TKEngine) error { _, err := e.EncryptCC("4444333322221111"); return err }},
+		"detokenize": {func(e TKEngine) error { _, err := e.DecryptTK("444433aapchc1111"); return err }},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// secret keys are closed after each operation
+			ekeys := &issuingSecretKeyRepo{key: []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+			hkeys := &issuingSecretKeyRepo{key: []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+			e, err := NewEngine(versioner, ekeys, hkeys, DefaultAlphabetProvider{})
+			if err != nil {
+				t.Fatalf("NewEngine() error = %v", err)
+			}
+			if err := tt.ops(e); err != nil {
+				t.Fatalf("operation error = %v", err)
+			}
+			if len(ekeys.issued) == 0 || len(hkeys.issued) == 0 {
+				t.Fatalf("expected secret keys to be used, got %d and %d", len(ekeys.issued), len(hkeys.issued))
+			}
+			for _, k := range append(ekeys.issued, hkeys.issued...) {
+				for _, el := range k.Bytes() {
+					if el != 0 {
+						t.Errorf("secret key %v not closed after the operation", k.Bytes())
+						break
+					}
+				}
+			}
+
+			// the keys of []byte repositories are copied and the repositories never wiped
+			keys := fixedKeyRepo{false, []byte{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}}
+			e, err = NewEngine(versioner, keys, keys, DefaultAlphabetProvider{})
+			if err != nil {
+				t.Fatalf("NewEngine() error = %v", err)
+			}
+			if err := tt.ops(e); err != nil {
+				t.Fatalf("operation error = %v", err)
+			}
+			if keys.key[0] != 1 {
+				t.Errorf("key repository wiped: %v", keys.key)
+			}
+		})
+	}
+}
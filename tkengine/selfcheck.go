@@ -0,0 +1,39 @@
+package tkengine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// selfCheckValues returns the representative values of n digits the encoding self-check round-trips:
+// the smallest, the largest and an intermediate value
+func selfCheckValues(n int) []string {
+	return []string{strings.Repeat("0", n), strings.Repeat("9", n), strings.Repeat("1234567890", 2)[:n]}
+}
+
+// selfCheckEncoding verifies that encodeTkMD and decodeTkMD round-trip representative values of every
+// supported middle digits length (3 to 9) with the alphabets of alphaProvider, so that an alphabet
+// breaking the encoding (e.g. a provider not returning the same alphabet on every call) fails the
+// construction of the engine instead of corrupting the tokens of the first unlucky cards
+func selfCheckEncoding(alphaProvider AlphabetProvider) error {
+	for n := 3; n <= 9; n++ {
+		for _, md := range selfCheckValues(n) {
+			encoded, err := encodeTkMD(md, alphaProvider)
+			if err != nil {
+				return errors.New(fmt.Sprintf("Encoding self-check of %d middle digits failed: %v", n, err))
+			}
+			if len(encoded) != n-1 {
+				return errors.New(fmt.Sprintf("Encoding self-check of %d middle digits failed: %d symbols encoded instead of %d", n, len(encoded), n-1))
+			}
+			decoded, err := decodeTkMD(encoded, alphaProvider)
+			if err != nil {
+				return errors.New(fmt.Sprintf("Encoding self-check of %d middle digits failed: %v", n, err))
+			}
+			if decoded != md {
+				return errors.New(fmt.Sprintf("Encoding self-check of %d middle digits failed: %s decodes to %s instead of %s", n, encoded, decoded, md))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package tkengine
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_selfCheckEncoding(t *testing.T) {
+	tests := map[string]struct {
+		provider AlphabetProvider
+		wantErr  string
+	}{
+		"default":  {DefaultAlphabetProvider{}, ""},
+		"upper":    {UppercaseAlphabetProvider{}, ""},
+		"rotating": {rotatingAlphabetProvider{calls: new(int)}, "Encoding self-check of 3 middle digits failed"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := selfCheckEncoding(tt.provider)
+			if (err != nil) != (tt.wantErr != "") || err != nil && !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("selfCheckEncoding() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewEngine_encodingSelfCheck(t *testing.T) {
+	keys := fixedKeyRepo{false, make([]byte, 16)}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	// every alphabet is valid on its own, but the encoding doesn't round-trip
+	_, err := NewEngine(versioner, keys, keys, rotatingAlphabetProvider{calls: new(int)})
+	if err == nil || !strings.HasPrefix(err.Error(), "Encoding self-check") {
+		t.Errorf("NewEngine() error = %v, want an encoding self-check failure", err)
+	}
+	if _, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}); err != nil {
+		t.Errorf("NewEngine() error = %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package tkengine
+
+import "sync"
+
+// versionStats counts the tokens produced per version, safe for concurrent use
+type versionStats struct {
+	mu     sync.Mutex
+	counts map[byte]uint64
+}
+
+func newVersionStats() *versionStats {
+	return &versionStats{counts: make(map[byte]uint64)}
+}
+
+// add counts a token produced with version v
+func (s *versionStats) add(v byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts[v]++
+}
+
+// snapshot returns a copy of the counts
+func (s *versionStats) snapshot() map[byte]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[byte]uint64, len(s.counts))
+	for v, n := range s.counts {
+		counts[v] = n
+	}
+	return counts
+}
+
+// StatsReporter is an optional interface of a TKEngine counting the tokens it produces per version.
+// The engines built by this package implement it.
+type StatsReporter interface {
+	// VersionStats returns the number of tokens produced per
+	// version since the engine construction
+	VersionStats() map[byte]uint64
+}
+
+// VersionStats returns the number of tokens produced per version since the engine construction,
+// by tokenizations and retokenizations, so that the progress of a key rotation can be monitored.
+// The counters are kept in memory and are not shared between engines.
+func (e *engine) VersionStats() map[byte]uint64 {
+	if e.stats == nil {
+		return map[byte]uint64{}
+	}
+	return e.stats.snapshot()
+}
+
+// countVersion counts the token tk produced by op, its version being at index p
+func (e *engine) countVersion(op AuditOperation, tk string, p int) {
+	if e.stats == nil || (op != AuditTokenize && op != AuditRetokenize) || p < 0 || p >= len(tk) {
+		return
+	}
+	e.stats.add(tk[p])
+}
+
+// CurrentTokenizationVersion returns the version the engine currently tokenizes with, as reported by its
+// versioner, so that the token versions can be correlated with the key rotation schedule
+func (e *engine) CurrentTokenizationVersion() (byte, error) {
+	return e.versioner.GetTokenizationVersion()
+}
@@ -0,0 +1,87 @@
+package tkengine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_engine_VersionStats(t *testing.T) {
+	keys := &keyRepo{keys: map[byte][]byte{'a': make([]byte, 16), 'b': make([]byte, 16)}}
+	tests := map[string]struct {
+		ops  func(e TKEngine)
+		want map[byte]uint64
+	}{
+		"no_operation": {
+			ops:  func(e TKEngine) {},
+			want: map[byte]uint64{},
+		},
+		"tokenizations": {
+			ops: func(e TKEngine) {
+				e.EncryptCC("4444333322221111")
+				e.EncryptCC("4444333322221112")
+				e.(NumericTokenizer).EncryptNumeric("123456789", FormatOpts{MinLength: 9, MaxLength: 9, PreservedSuffix: 4})
+			},
+			want: map[byte]uint64{'b': 3},
+		},
+		"failures_and_detokenizations_are_not_counted": {
+			ops: func(e TKEngine) {
+				tk, _ := e.EncryptCC("4444333322221111")
+				e.DecryptTK(tk)
+				e.EncryptCC("4444")
+			},
+			want: map[byte]uint64{'b': 1},
+		},
+		"retokenization": {
+			ops:  func(e TKEngine) { e.(Retokenizer).Retokenize("444433aapchc1111") },
+			want: map[byte]uint64{'b': 1},
+		},
+		"lookup_tokens_are_not_counted": {
+			ops:  func(e TKEngine) { e.(TokenEnumerator).TokensForCard("4444333322221111") },
+			want: map[byte]uint64{},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngine(deterministicVersioner{tokVersion: 'b', detokVersions: []byte{'a', 'b'}}, keys, keys, DefaultAlphabetProvider{}, WithMinDomainSize(shortCardsDomain))
+			if err != nil {
+				t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+			}
+			tt.ops(e)
+			if got := e.(StatsReporter).VersionStats(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("VersionStats() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_engine_CurrentTokenizationVersion(t *testing.T) {
+	keys := &keyRepo{keys: map[byte][]byte{'a': make([]byte, 16), 'b': make([]byte, 16)}}
+	tests := map[string]struct {
+		versioner KeyVersioner
+		want      byte
+		wantErr   bool
+	}{
+		"version_a":       {deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a', 'b'}}, 'a', false},
+		"version_b":       {deterministicVersioner{tokVersion: 'b', detokVersions: []byte{'a', 'b'}}, 'b', false},
+		"versioner_error": {deterministicVersioner{tokError: true, tokVersion: 'a', detokVersions: []byte{'a'}}, 0, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e := &engine{versioner: tt.versioner, encryptionKeys: keys, hmacKeys: keys, alphaProvider: DefaultAlphabetProvider{}}
+			got, err := e.CurrentTokenizationVersion()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CurrentTokenizationVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("CurrentTokenizationVersion() = %c, want %c", got, tt.want)
+			}
+			if err != nil {
+				return
+			}
+			// it is the version of the produced tokens
+			if tk, err := e.EncryptCC("4444333322221111"); err != nil || tk[6] != got {
+				t.Errorf("EncryptCC() = %v, %v, want a token of version %c", tk, err, got)
+			}
+		})
+	}
+}
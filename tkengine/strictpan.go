@@ -0,0 +1,64 @@
+package tkengine
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrImplausiblePAN is returned by EncryptCC, for engines built with WithStrictPANChecks, when the input
+// credit card has the format of a card but not the structure of a real PAN
+var ErrImplausiblePAN = errors.New("Value is not a plausible PAN")
+
+// networkLengths are the card lengths issued by the networks DetectNetwork knows
+var networkLengths = map[CardNetwork][]int{
+	NetworkVisa:       {13, 16, 19},
+	NetworkMastercard: {16},
+	NetworkAmex:       {15},
+	NetworkDiscover:   {16, 17, 18, 19},
+	NetworkDiners:     {14, 15, 16, 17, 18, 19},
+	NetworkJCB:        {16, 17, 18, 19},
+	NetworkUnionPay:   {16, 17, 18, 19},
+}
+
+// WithStrictPANChecks makes EncryptCC return ErrImplausiblePAN instead of tokenizing the cards that
+// pass the format check (and possibly Luhn) but can't be real PANs: a single repeated digit (e.g.
+// 0000000000000000, which is Luhn-valid), a first digit which is not a card issuing Major Industry
+// Identifier (1 to 6) or a length the network of the card (see DetectNetwork) doesn't issue, e.g.
+// a 16-digit American Express card. The checks are opt-in as test data often fails them.
+func WithStrictPANChecks() Option {
+	return func(e *engine) {
+		e.strictPAN = true
+	}
+}
+
+// checkPlausiblePAN returns an error wrapping ErrImplausiblePAN if the credit card cc fails the
+// WithStrictPANChecks heuristics. The error never contains the card.
+func checkPlausiblePAN(cc string) error {
+	if isRepeatedDigit(cc) {
+		return fmt.Errorf("%w: single repeated digit", ErrImplausiblePAN)
+	}
+	if cc[0] < '1' || cc[0] > '6' {
+		return fmt.Errorf("%w: Major Industry Identifier %c is not a card issuer one", ErrImplausiblePAN, cc[0])
+	}
+	n := DetectNetwork(cc)
+	lengths, ok := networkLengths[n]
+	if !ok {
+		return nil
+	}
+	for _, l := range lengths {
+		if len(cc) == l {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: length %d not issued by the %s network", ErrImplausiblePAN, len(cc), n)
+}
+
+// isRepeatedDigit reports whether all the digits of cc are equal
+func isRepeatedDigit(cc string) bool {
+	for i := 1; i < len(cc); i++ {
+		if cc[i] != cc[0] {
+			return false
+		}
+	}
+	return true
+}
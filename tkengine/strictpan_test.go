@@ -0,0 +1,45 @@
+package tkengine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWithStrictPANChecks(t *testing.T) {
+	keys := fixedKeyRepo{false, make([]byte, 16)}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	tests := map[string]struct {
+		opts    []Option
+		cc      string
+		wantErr error
+	}{
+		"regular_card":             {[]Option{WithStrictPANChecks()}, "4444333322221111", nil},
+		"visa_13_digits":           {[]Option{WithStrictPANChecks()}, "4444333322221", nil},
+		"amex_15_digits":           {[]Option{WithStrictPANChecks()}, "378282246310005", nil},
+		"airline_mii":              {[]Option{WithStrictPANChecks()}, "1234333322221111", nil},
+		"unknown_network":          {[]Option{WithStrictPANChecks()}, "5999333322221111", nil},
+		"luhn_valid_zeros":         {[]Option{WithStrictPANChecks()}, "0000000000000000", ErrImplausiblePAN},
+		"repeated_digit":           {[]Option{WithStrictPANChecks()}, "4444444444444", ErrImplausiblePAN},
+		"mii_0":                    {[]Option{WithStrictPANChecks()}, "0444333322221111", ErrImplausiblePAN},
+		"mii_7":                    {[]Option{WithStrictPANChecks()}, "7444333322221111", ErrImplausiblePAN},
+		"mii_9":                    {[]Option{WithStrictPANChecks()}, "9444333322221111", ErrImplausiblePAN},
+		"amex_16_digits":           {[]Option{WithStrictPANChecks()}, "3782822463100051", ErrImplausiblePAN},
+		"mastercard_13_digits":     {[]Option{WithStrictPANChecks()}, "5555333322221", ErrImplausiblePAN},
+		"visa_17_digits":           {[]Option{WithStrictPANChecks()}, "44443333222211112", ErrImplausiblePAN},
+		"not_checked_by_default":   {nil, "0000000000000000", nil},
+		"invalid_card_takes_first": {[]Option{WithStrictPANChecks()}, "000000000000", ErrInvalidCC},
+		"test_pan_takes_first":     {[]Option{WithStrictPANChecks(), WithRejectTestPANs()}, "0000000000000000", ErrTestPAN},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, append(tt.opts, WithMinDomainSize(shortCardsDomain))...)
+			if err != nil {
+				t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+			}
+			_, err = e.EncryptCC(tt.cc)
+			if !errors.Is(err, tt.wantErr) || (err != nil) != (tt.wantErr != nil) {
+				t.Errorf("EncryptCC() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
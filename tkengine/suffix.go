@@ -0,0 +1,33 @@
+package tkengine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrTruncatedSuffix is returned when a token is invalid but would be valid with zeros inserted before
+// its trailing digits: the preserved last digits of the card started with zeros (e.g. 0012) and a system
+// storing them as a number trimmed these zeros. It wraps ErrInvalidTK: errors.Is matches both.
+var ErrTruncatedSuffix = fmt.Errorf("%w: truncated suffix", ErrInvalidTK)
+
+// checkTruncatedSuffix returns an error wrapping ErrTruncatedSuffix if the invalid token tk laid out as
+// opts ends with fewer digits than its preserved suffix and becomes a valid token once these are left
+// padded with zeros, nil otherwise. The token is not repaired: the zeros may as well have been lost in
+// a genuinely malformed token.
+func (e *engine) checkTruncatedSuffix(tk string, opts FormatOpts, detokVers []byte) error {
+	s := opts.PreservedSuffix
+	r := 0
+	for r < len(tk) && r < s && isDigit(tk[len(tk)-1-r]) {
+		r++
+	}
+	if r == s {
+		return nil
+	}
+	padded := tk[:len(tk)-r] + strings.Repeat("0", s-r) + tk[len(tk)-r:]
+	valid := isValidNumericTK(padded, opts, e.alphaProvider, detokVers) ||
+		e.detokAlphaProvider != nil && isValidNumericTK(padded, opts, e.detokAlphaProvider, detokVers)
+	if !valid {
+		return nil
+	}
+	return fmt.Errorf("%w: the token ends with %d digits instead of %d, the leading zeros of its last digits were probably trimmed", ErrTruncatedSuffix, r, s)
+}
@@ -0,0 +1,73 @@
+package tkengine
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_engine_leadingZeroSuffix(t *testing.T) {
+	keys := &keyRepo{keys: map[byte][]byte{'a': make([]byte, 16)}}
+	e, err := NewEngine(deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, keys, keys, DefaultAlphabetProvider{}, WithMinDomainSize(shortCardsDomain))
+	if err != nil {
+		t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+	}
+	for _, cc := range []string{"4444333322220012", "4444333322220000", "4444333322220001", "4444333330012", "4444333322221111000"} {
+		t.Run(cc, func(t *testing.T) {
+			tk, err := e.EncryptCC(cc)
+			if err != nil {
+				t.Fatalf("EncryptCC() error = %v", err)
+			}
+			if tk[len(tk)-4:] != cc[len(cc)-4:] {
+				t.Errorf("EncryptCC() = %v, want the last 4 digits of %v", tk, cc)
+			}
+			if got, err := e.DecryptTK(tk); err != nil || got != cc {
+				t.Errorf("DecryptTK(%v) = %v, %v, want %v", tk, got, err, cc)
+			}
+
+			// the suffix stored as a number loses its leading zeros
+			suffix := strings.TrimLeft(tk[len(tk)-4:], "0")
+			if suffix == "" {
+				suffix = "0"
+			}
+			if suffix == tk[len(tk)-4:] {
+				return
+			}
+			truncated := tk[:len(tk)-4] + suffix
+			_, err = e.DecryptTK(truncated)
+			if !errors.Is(err, ErrTruncatedSuffix) || !errors.Is(err, ErrInvalidTK) {
+				t.Errorf("DecryptTK(%v) error = %v, want ErrTruncatedSuffix", truncated, err)
+			}
+			if err != nil && strings.Contains(err.Error(), truncated) {
+				t.Errorf("DecryptTK() error = %v, should not contain the token", err)
+			}
+		})
+	}
+}
+
+func Test_engine_checkTruncatedSuffix(t *testing.T) {
+	e := &engine{
+		versioner:     deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}},
+		alphaProvider: DefaultAlphabetProvider{},
+	}
+	tests := map[string]struct {
+		tk      string
+		wantErr bool
+	}{
+		"truncated_by_2": {"444433aapchc12", true},
+		"truncated_by_3": {"444433aapchc2", true},
+		"complete":       {"444433aapchc1111", false},
+		"letter_suffix":  {"444433aapchc1x11", false},
+		"no_digits":      {"444433aapchcabcd", false},
+		"bad_middle":     {"444433aa9chc12", false},
+		"bad_version":    {"444433fapchc12", false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := e.checkTruncatedSuffix(tt.tk, CreditCardFormat, []byte{'a'})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkTruncatedSuffix() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package tkengine
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrTestPAN is returned by EncryptCC, for engines built with WithRejectTestPANs, when the input
+// credit card is a well-known test card or an obviously fake sequential or repeated digits number
+var ErrTestPAN = errors.New("Value is a test PAN")
+
+// DefaultTestPANs returns the well-known test cards of the main card networks and payment gateways
+// rejected by WithRejectTestPANs when no custom list is given
+func DefaultTestPANs() []string {
+	return []string{
+		// Visa
+		"4111111111111111", "4242424242424242", "4012888888881881", "4222222222222", "4000056655665556",
+		// Mastercard
+		"5555555555554444", "5105105105105100", "5200828282828210", "2223003122003222",
+		// American Express
+		"378282246310005", "371449635398431", "378734493671000",
+		// Discover, Diners Club and JCB
+		"6011111111111117", "6011000990139424", "30569309025904", "38520000023237", "3530111333300000", "3566002020360505",
+	}
+}
+
+// WithRejectTestPANs makes EncryptCC return ErrTestPAN instead of tokenizing test cards, which usually
+// reveal a bug upstream: the given pans, or DefaultTestPANs if none is given, and the numbers made of a
+// single repeated digit or of ascending or descending sequential digits (e.g. 1234567890123).
+func WithRejectTestPANs(pans ...string) Option {
+	if len(pans) == 0 {
+		pans = DefaultTestPANs()
+	}
+	testPANs := make(map[string]struct{}, len(pans))
+	for _, pan := range pans {
+		testPANs[pan] = struct{}{}
+	}
+	return func(e *engine) {
+		e.testPANs = testPANs
+	}
+}
+
+// isTestPAN reports whether cc is one of the engine test PANs or a sequential or repeated digits number
+func (e *engine) isTestPAN(cc string) bool {
+	if _, ok := e.testPANs[cc]; ok {
+		return true
+	}
+	return isSequentialPAN(cc)
+}
+
+// isSequentialPAN reports whether all the digits of cc are equal, or each digit follows the previous one
+// in ascending or descending order, wrapping around 9 and 0
+func isSequentialPAN(cc string) bool {
+	if len(cc) < 2 {
+		return false
+	}
+	step := (int(cc[1]) - int(cc[0]) + 10) % 10
+	if step != 0 && step != 1 && step != 9 {
+		return false
+	}
+	for i := 2; i < len(cc); i++ {
+		if (int(cc[i])-int(cc[i-1])+10)%10 != step {
+			return false
+		}
+	}
+	return true
+}
+
+// GenerateTestPAN returns a random card of length digits, in [13, 19], whose last digit is the Luhn check
+// digit, for tests and benchmarks needing realistic cards. The digits are drawn uniformly from the bytes of
+// rng, crypto/rand.Reader if nil: a seeded math/rand source gives reproducible cards. The cards belong to no
+// network in particular and may, although very unlikely, be real cards or be rejected by WithRejectTestPANs
+// or WithStrictPANChecks.
+func GenerateTestPAN(length int, rng io.Reader) (string, error) {
+	if length < CreditCardFormat.MinLength || length > CreditCardFormat.MaxLength {
+		return "", fmt.Errorf("%w: length %d out of range [%d, %d]", ErrInvalidCC, length, CreditCardFormat.MinLength, CreditCardFormat.MaxLength)
+	}
+	if rng == nil {
+		rng = rand.Reader
+	}
+	pan := make([]byte, length)
+	b := make([]byte, 1)
+	for i := 0; i < length-1; {
+		if _, err := io.ReadFull(rng, b); err != nil {
+			return "", fmt.Errorf("Could not read randomness: %w", err)
+		}
+		// bytes beyond the largest multiple of 10 are rejected so that digits are uniform
+		if b[0] >= 250 {
+			continue
+		}
+		pan[i] = '0' + b[0]%10
+		i++
+	}
+	pan[length-1] = luhnCheckDigit(pan[:length-1])
+	return string(pan), nil
+}
+
+// luhnCheckDigit returns the digit completing the digits of payload into a Luhn-valid number
+func luhnCheckDigit(payload []byte) byte {
+	sum := 0
+	// the check digit is not doubled: the last digit of the payload is
+	double := true
+	for i := len(payload) - 1; i >= 0; i-- {
+		d := int(payload[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return byte('0' + (10-sum%10)%10)
+}
@@ -0,0 +1,124 @@
+package tkengine
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestWithRejectTestPANs(t *testing.T) {
+	keys := fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	tests := map[string]struct {
+		opts    []Option
+		cc      string
+		wantErr error
+	}{
+		"regular_card":             {[]Option{WithRejectTestPANs()}, "4444333322221111", nil},
+		"default_test_pan":         {[]Option{WithRejectTestPANs()}, "4111111111111111", ErrTestPAN},
+		"default_amex_test_pan":    {[]Option{WithRejectTestPANs()}, "378282246310005", ErrTestPAN},
+		"ascending_sequence":       {[]Option{WithRejectTestPANs()}, "1234567890123", ErrTestPAN},
+		"descending_sequence":      {[]Option{WithRejectTestPANs()}, "9876543210987654", ErrTestPAN},
+		"repeated_digit":           {[]Option{WithRejectTestPANs()}, "0000000000000000", ErrTestPAN},
+		"custom_test_pan":          {[]Option{WithRejectTestPANs("4444333322221111")}, "4444333322221111", ErrTestPAN},
+		"custom_list_overrides":    {[]Option{WithRejectTestPANs("4444333322221111")}, "4111111111111111", nil},
+		"custom_list_sequence":     {[]Option{WithRejectTestPANs("4444333322221111")}, "1234567890123", ErrTestPAN},
+		"not_rejected_by_default":  {nil, "4111111111111111", nil},
+		"invalid_card_takes_first": {[]Option{WithRejectTestPANs()}, "411111111111", ErrInvalidCC},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, tt.opts...)
+			if err != nil {
+				t.Fatalf("NewEngine() error = %v", err)
+			}
+			_, err = e.EncryptCC(tt.cc)
+			if !errors.Is(err, tt.wantErr) || (err != nil) != (tt.wantErr != nil) {
+				t.Errorf("EncryptCC() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_isSequentialPAN(t *testing.T) {
+	tests := map[string]struct {
+		cc   string
+		want bool
+	}{
+		"ascending_wrapping":  {"7890123456789", true},
+		"descending_wrapping": {"2109876543210", true},
+		"repeated":            {"5555555555555555", true},
+		"regular":             {"4444333322221111", false},
+		"almost_sequential":   {"1234567890124", false},
+		"single_digit":        {"1", false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := isSequentialPAN(tt.cc); got != tt.want {
+				t.Errorf("isSequentialPAN(%v) = %v, want %v", tt.cc, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateTestPAN(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	keys := fixedKeyRepo{false, make([]byte, 16)}
+	e, err := NewEngine(deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, keys, keys, DefaultAlphabetProvider{}, WithMinDomainSize(shortCardsDomain))
+	if err != nil {
+		t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+	}
+	for l := CreditCardFormat.MinLength; l <= CreditCardFormat.MaxLength; l++ {
+		for i := 0; i < 100; i++ {
+			cc, err := GenerateTestPAN(l, rng)
+			if err != nil {
+				t.Fatalf("GenerateTestPAN(%d) error = %v", l, err)
+			}
+			if len(cc) != l || !IsCC(cc) || !IsLuhnValid(cc) {
+				t.Fatalf("GenerateTestPAN(%d) = %v, want a Luhn-valid card of %d digits", l, cc, l)
+			}
+			// random cards round trip
+			tk, err := e.EncryptCC(cc)
+			if err != nil {
+				t.Fatalf("EncryptCC(%v) error = %v", cc, err)
+			}
+			if got, err := e.DecryptTK(tk); err != nil || got != cc {
+				t.Fatalf("DecryptTK(%v) = %v, %v, want %v", tk, got, err, cc)
+			}
+		}
+	}
+}
+
+func TestGenerateTestPAN_errors(t *testing.T) {
+	tests := map[string]struct {
+		length  int
+		rng     io.Reader
+		wantErr error
+	}{
+		"too_short":        {12, nil, ErrInvalidCC},
+		"too_long":         {20, nil, ErrInvalidCC},
+		"exhausted_source": {16, bytes.NewReader(make([]byte, 10)), io.EOF},
+		"empty_source":     {16, bytes.NewReader(nil), io.EOF},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := GenerateTestPAN(tt.length, tt.rng); !errors.Is(err, tt.wantErr) {
+				t.Errorf("GenerateTestPAN() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGenerateTestPAN_reproducible(t *testing.T) {
+	a, errA := GenerateTestPAN(16, rand.New(rand.NewSource(42)))
+	b, errB := GenerateTestPAN(16, rand.New(rand.NewSource(42)))
+	if errA != nil || errB != nil || a != b {
+		t.Errorf("GenerateTestPAN() = %v, %v and %v, %v, want the same card from the same seed", a, errA, b, errB)
+	}
+	// the default source is crypto/rand
+	if cc, err := GenerateTestPAN(19, nil); err != nil || !IsLuhnValid(cc) {
+		t.Errorf("GenerateTestPAN() = %v, %v, want a Luhn-valid card", cc, err)
+	}
+}
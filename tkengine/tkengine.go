@@ -1,23 +1,79 @@
 package tkengine
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/capitalone/fpe/ff1"
+	"hash"
 	"math"
+	"math/bits"
 	"math/rand"
+	"reflect"
 	"regexp"
+	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 )
 
+var (
+	// ErrInvalidCC is returned when the input credit card does not have a valid format
+	ErrInvalidCC = errors.New("Invalid CC format")
+	// ErrInvalidTK is returned when the input token does not have a valid format
+	// or its version is not allowed for detokenization (see ErrVersionExpired)
+	ErrInvalidTK = errors.New("Invalid TK format")
+	// ErrAlreadyTokenized is returned by EncryptCC, for engines built with WithDoubleTokenizationCheck,
+	// when the input credit card is also a valid token
+	ErrAlreadyTokenized = errors.New("Value is already a token")
+	// ErrInternalCipherFailure is returned when a tokenization or detokenization
+	// operation panics. The panic is recovered so that a single failure does not
+	// crash the caller, and its stack is reported through the engine logger.
+	ErrInternalCipherFailure = errors.New("Internal cipher failure")
+	// ErrKeyUnavailable is returned when the key of a version can't be retrieved from the key
+	// repositories, e.g. for a well-formed token whose version key is missing in this region.
+	// Unlike ErrInvalidTK, the operation may succeed against another key repository.
+	ErrKeyUnavailable = errors.New("Key unavailable")
+	// ErrRoundTripFailure is returned by EncryptCC, for engines built with WithVerifyOnEncrypt,
+	// when the produced token does not detokenize back to the input credit card
+	ErrRoundTripFailure = errors.New("Token round trip failure")
+	// ErrMisconfiguredEngine is returned when an engine misses one of its dependencies: a nil
+	// versioner, key repository or alphabet provider, including typed nil pointers
+	ErrMisconfiguredEngine = errors.New("Misconfigured engine")
+	// ErrVersionExpired is returned when a well-formed token carries a version which is not among
+	// the detokenization versions, e.g. a version retired by the versioner after a key rotation:
+	// the token must be migrated rather than being malformed. It wraps ErrInvalidTK: errors.Is matches both.
+	ErrVersionExpired = fmt.Errorf("%w: version expired", ErrInvalidTK)
+)
+
+// IsInvalidInput returns true if err reports an input the engine refuses to tokenize or detokenize:
+// retrying the same input fails again. Servers report these errors as client errors.
+func IsInvalidInput(err error) bool {
+	for _, target := range []error{ErrInvalidCC, ErrInvalidTK, ErrInvalidValue, ErrAlreadyTokenized, ErrTestPAN, ErrImplausiblePAN,
+		ErrInputTooLong, ErrTokenIntegrity, ErrDomainTooSmall, ErrContextRequired} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsUnavailable returns true if err reports a key that can't be retrieved (see ErrKeyUnavailable):
+// the operation may succeed later or against another key repository.
+func IsUnavailable(err error) bool {
+	return errors.Is(err, ErrKeyUnavailable)
+}
+
 // TKEngine is a tokenization engine which regulates
-// encryption of credit cards and decryption of tokens
+// encryption of credit cards and decryption of tokens.
+// Intermediate byte slices holding card data (6x4, tweaks,
+// assembled cards) are wiped after use. Go strings are immutable
+// and can't be wiped: the cards passed to and returned by the engine
+// stay in memory until garbage collected, callers should keep
+// their lifetime as short as possible.
+// The other operations of the engines built by this package are
+// exposed through optional interfaces, e.g. Retokenizer.
 type TKEngine interface {
 	// EncryptCC takes a valid CC in input which has
 	// (13,19] characters and output a Token or an error
@@ -31,37 +87,222 @@ type TKEngine interface {
 	// so each character need to be a byte
 	// Error types: InvalidTK format
 	DecryptTK(tk string) (string, error)
+	// CurrentTokenizationVersion returns the version the engine
+	// currently tokenizes with, as reported by its versioner
+	CurrentTokenizationVersion() (byte, error)
 }
 
 // NewEngine returns a tokenization engine with custom versioner, encryption keys repositories and alphabet providers
-func NewEngine(versioner KeyVersioner, encryptionKeys KeyRepo, hmacKeys KeyRepo, alphaProvider AlphabetProvider) (TKEngine, error) {
+func NewEngine(versioner KeyVersioner, encryptionKeys KeyRepo, hmacKeys KeyRepo, alphaProvider AlphabetProvider, opts ...Option) (TKEngine, error) {
+	// Validate dependencies
+	if err := validateDependencies(versioner, encryptionKeys, hmacKeys); err != nil {
+		return nil, err
+	}
 	// Validate alpha-provider
 	if err := validateAlphabetProvider(alphaProvider); err != nil {
 		return nil, err
 	}
-	return &engine{
+	// Validate versioner
+	if err := validateVersioner(versioner); err != nil {
+		return nil, err
+	}
+	e := &engine{
 		versioner:      versioner,
 		encryptionKeys: encryptionKeys,
 		hmacKeys:       hmacKeys,
 		alphaProvider:  alphaProvider,
-	}, nil
+		stats:          newVersionStats(),
+	}
+	return e.applyOptions(opts).validateOptions()
+}
+
+// ValidateAlphabetProvider verifies that alphaProvider returns an alphabet of distinct single-byte
+// symbols of the right size for every base used by the engine and that the middle digits encoding
+// round-trips with them. It performs the same check as NewEngine.
+func ValidateAlphabetProvider(alphaProvider AlphabetProvider) error {
+	return validateAlphabetProvider(alphaProvider)
+}
+
+// MinVersion and MaxVersion bound the version bytes: versions are embedded as a char in the
+// tokens and must be printable ASCII characters, space excluded ('!' to '~')
+const (
+	MinVersion byte = '!'
+	MaxVersion byte = '~'
+)
+
+// ValidateVersion verifies that v is a printable ASCII character in [MinVersion, MaxVersion].
+// Other bytes would not be a single printable char of the token (e.g. string(byte(0x80)) is 2 bytes long).
+func ValidateVersion(v byte) error {
+	if v < MinVersion || v > MaxVersion {
+		return errors.New(fmt.Sprintf("Invalid version byte %d: versions should be printable ASCII characters in [%s, %s]", v, string(MinVersion), string(MaxVersion)))
+	}
+	return nil
+}
+
+// validateVersioner verifies that the versions are printable and that the current tokenization
+// version is also a detokenization version: otherwise freshly minted tokens would be immediately undecryptable
+func validateVersioner(versioner KeyVersioner) error {
+	tokVer, err := versioner.GetTokenizationVersion()
+	if err != nil {
+		return err
+	}
+	detokVers, err := versioner.GetDetokenizationVersions()
+	if err != nil {
+		return err
+	}
+	for _, v := range append([]byte{tokVer}, detokVers...) {
+		if err := ValidateVersion(v); err != nil {
+			return err
+		}
+	}
+	if !contains(detokVers, tokVer) {
+		return errors.New(fmt.Sprintf("Tokenization version %s is not among the detokenization versions [%s]: tokens would not be decryptable", string(tokVer), string(detokVers)))
+	}
+	return nil
+}
+
+// ValidateEncryptionKey verifies that key is a legal AES key for the FF1 cipher: 128, 192 or 256 bits
+func ValidateEncryptionKey(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("Invalid encryption key size %d bytes: it should be 16, 24 or 32 bytes", len(key)))
+	}
 }
 
 func validateAlphabetProvider(alphaProvider AlphabetProvider) error {
-	for _, i := range []uint32{14, 15, 16, 18, 22, 32} {
+	if isNilDependency(alphaProvider) {
+		return fmt.Errorf("%w: missing alphabet provider", ErrMisconfiguredEngine)
+	}
+	if err := validateEncodingCapacity(alphaProvider); err != nil {
+		return err
+	}
+	if err := validateAlphabetBases(alphaProvider, RequiredAlphabetBases()); err != nil {
+		return err
+	}
+	return selfCheckEncoding(alphaProvider)
+}
+
+// RequiredAlphabetBases returns the encoding bases an AlphabetProvider must provide an alphabet for
+// to tokenize credit cards: the bases of the 3 to 9 middle digits of 13 to 19-digit cards.
+func RequiredAlphabetBases() []uint32 {
+	return []uint32{14, 15, 16, 18, 22, 32}
+}
+
+// validateEncodingCapacity verifies that for every supported middle digits length n the alphabet
+// alphaProvider returns for the encoding base has enough symbols to encode the 10^n values with
+// n-1 symbols, otherwise tokens would be corrupted
+func validateEncodingCapacity(alphaProvider AlphabetProvider) error {
+	for n := 3; n <= 9; n++ {
+		base, err := encodingBaseToSaveOneChar(n)
+		if err != nil {
+			return err
+		}
+		alpha, err := alphaProvider.GetAlphabetForBase(base)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error while retriving alphabet for base %d: %v", base, err))
+		}
+		if powUint64(uint64(len(alpha)), n-1) < powUint64(10, n) {
+			return errors.New(fmt.Sprintf("alphabet for base %d has %d symbols: it can't encode %d middle digits with %d symbols (%d^%d < 10^%d)", base, len(alpha), n, n-1, len(alpha), n-1, n))
+		}
+	}
+	return nil
+}
+
+// validateAlphabetBases verifies that alphaProvider returns an alphabet of distinct single-byte
+// symbols of the right size for each of the bases
+func validateAlphabetBases(alphaProvider AlphabetProvider, bases []uint32) error {
+	for _, i := range bases {
 		alpha, err := alphaProvider.GetAlphabetForBase(i)
 		if err != nil {
-			return errors.New(fmt.Sprintf("Error while retriving alphabet for base %d: %v", len(alpha), err))
+			return errors.New(fmt.Sprintf("Error while retriving alphabet for base %d: %v", i, err))
+		}
+		if err := ValidateAlphabet(i, alpha); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateAlphabet verifies that alpha is an alphabet for base: exactly base distinct single-byte
+// ASCII symbols
+func ValidateAlphabet(base uint32, alpha []byte) error {
+	if len(alpha) != int(base) {
+		return errors.New(fmt.Sprintf("Got alphabet size %d for base %d. Size should match base", len(alpha), base))
+	}
+	uniqueSymbols := make(map[byte]struct{}, base)
+	for _, symbol := range alpha {
+		// one symbol must be exactly one character in the token: multibyte UTF-8 symbols
+		// would break length preservation
+		if symbol > unicode.MaxASCII {
+			return errors.New(fmt.Sprintf("alphabet for base %d contains non-ASCII symbol (byte %d). Only single-byte ASCII symbols are allowed", base, symbol))
+		}
+		uniqueSymbols[symbol] = struct{}{}
+	}
+	if len(uniqueSymbols) != len(alpha) {
+		return errors.New(fmt.Sprintf("alphabet for base %d contains duplicated elements [%v]", base, alpha))
+	}
+	return nil
+}
+
+// NewEngineWithAlphabets returns a tokenization engine producing tokens encoded with tokAlpha while
+// still accepting tokens encoded with detokAlpha. It covers alphabet migrations: new tokens are encoded
+// with the new alphabet and old tokens stay decryptable (Retokenize re-encodes them in the new alphabet).
+// For each base the two alphabets must be either identical or disjoint so that any token
+// is decoded with the alphabet it was encoded with.
+func NewEngineWithAlphabets(versioner KeyVersioner, encryptionKeys KeyRepo, hmacKeys KeyRepo, tokAlpha AlphabetProvider, detokAlpha AlphabetProvider, opts ...Option) (TKEngine, error) {
+	// Validate dependencies
+	if err := validateDependencies(versioner, encryptionKeys, hmacKeys); err != nil {
+		return nil, err
+	}
+	// Validate alpha-providers
+	if err := validateAlphabetProvider(tokAlpha); err != nil {
+		return nil, err
+	}
+	if err := validateAlphabetProvider(detokAlpha); err != nil {
+		return nil, err
+	}
+	if err := validateAlphabetsDisjoint(tokAlpha, detokAlpha); err != nil {
+		return nil, err
+	}
+	// Validate versioner
+	if err := validateVersioner(versioner); err != nil {
+		return nil, err
+	}
+	e := &engine{
+		versioner:          versioner,
+		encryptionKeys:     encryptionKeys,
+		hmacKeys:           hmacKeys,
+		alphaProvider:      tokAlpha,
+		detokAlphaProvider: detokAlpha,
+		stats:              newVersionStats(),
+	}
+	return e.applyOptions(opts).validateOptions()
+}
+
+// validateAlphabetsDisjoint verifies that for each base the middle digits can be encoded in, layouts
+// preserving fewer digits than credit cards included, the alphabets of a and b are either identical
+// or do not share any symbol
+func validateAlphabetsDisjoint(a AlphabetProvider, b AlphabetProvider) error {
+	for _, i := range saveOneCharBases() {
+		// a base missing in either alphabet (e.g. 12 or 13 without custom layouts) can't decode a
+		// token with the other alphabet: the layouts needing it are rejected by validateLayouts
+		alphaA, err := a.GetAlphabetForBase(i)
+		if err != nil {
+			continue
 		}
-		if len(alpha) != int(i) {
-			return errors.New(fmt.Sprintf("Got alphabet size %d for base %d. Size should match base", len(alpha), i))
+		alphaB, err := b.GetAlphabetForBase(i)
+		if err != nil {
+			continue
 		}
-		uniqueSymbols := make(map[byte]struct{}, i)
-		for _, symbol := range alpha {
-			uniqueSymbols[symbol] = struct{}{}
+		if string(alphaA) == string(alphaB) {
+			continue
 		}
-		if len(uniqueSymbols) != len(alpha) {
-			return errors.New(fmt.Sprintf("alphabet for base %d contains duplicated elements [%v]", i, alpha))
+		for _, symbol := range alphaB {
+			if contains(alphaA, symbol) {
+				return errors.New(fmt.Sprintf("alphabets for base %d share symbol %s: they should be either identical or disjoint", i, string(symbol)))
+			}
 		}
 	}
 	return nil
@@ -69,18 +310,52 @@ func validateAlphabetProvider(alphaProvider AlphabetProvider) error {
 
 // NewEngineWithDefaultAlphabet returns a TKEngine which relies on the versioner,
 // the encryption keys repository and the hmac keys repository passed in input
-func NewEngineWithDefaultAlphabet(versioner KeyVersioner, encryptionKeys KeyRepo, hmacKeys KeyRepo) TKEngine {
-	return &engine{
-		versioner:      versioner,
-		encryptionKeys: encryptionKeys,
-		hmacKeys:       hmacKeys,
-		alphaProvider:  DefaultAlphabetProvider{},
+func NewEngineWithDefaultAlphabet(versioner KeyVersioner, encryptionKeys KeyRepo, hmacKeys KeyRepo, opts ...Option) (TKEngine, error) {
+	return NewEngine(versioner, encryptionKeys, hmacKeys, DefaultAlphabetProvider{}, opts...)
+}
+
+// validateDependencies returns an error if the versioner or one of the key repositories is missing
+func validateDependencies(versioner KeyVersioner, encryptionKeys KeyRepo, hmacKeys KeyRepo) error {
+	if isNilDependency(versioner) {
+		return fmt.Errorf("%w: missing key versioner", ErrMisconfiguredEngine)
 	}
+	if isNilDependency(encryptionKeys) {
+		return fmt.Errorf("%w: missing encryption keys repository", ErrMisconfiguredEngine)
+	}
+	if isNilDependency(hmacKeys) {
+		return fmt.Errorf("%w: missing hmac keys repository", ErrMisconfiguredEngine)
+	}
+	return nil
+}
+
+// isNilDependency returns true if d is nil or holds a nil pointer, map, slice or func, on which the
+// engine would panic
+func isNilDependency(d interface{}) bool {
+	if d == nil {
+		return true
+	}
+	v := reflect.ValueOf(d)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// checkConfigured returns ErrMisconfiguredEngine if e or one of its dependencies is missing, so that
+// an engine not built by its constructors fails instead of panicking
+func (e *engine) checkConfigured() error {
+	if e == nil || e.versioner == nil || e.encryptionKeys == nil || e.hmacKeys == nil || e.alphaProvider == nil {
+		return ErrMisconfiguredEngine
+	}
+	return nil
 }
 
 // NewDummyEngine returns a TKEngine for tokenization and detokenization
-// versioning and implementation are hidden from users
-func NewDummyEngine() (TKEngine, error) {
+// versioning and implementation are hidden from users.
+// Its hard-coded keys are well-known: the engine reports IsInsecure and logs a warning
+// through WithLogger unless AllowInsecureDummyKeys is set
+func NewDummyEngine(opts ...Option) (TKEngine, error) {
 	// hard-coded encryption keys will have to change
 	encryptionKeys := []string{
 		"2B7E151628AED2A6ABF7158809CF4F3C",
@@ -128,9 +403,12 @@ func NewDummyEngine() (TKEngine, error) {
 		},
 		versioner:     dummyVersioner{}, // use dummy versioner
 		alphaProvider: DefaultAlphabetProvider{},
+		insecure:      true,
+		stats:         newVersionStats(),
 	}
 
-	return &e, nil
+	e.applyOptions(opts).warnInsecure()
+	return e.validateOptions()
 }
 
 // KeyRepo is a key repository which provides a container
@@ -153,6 +431,35 @@ type KeyVersioner interface {
 	GetDetokenizationVersions() ([]byte, error)
 }
 
+// Format identifies the generation of the token layout. The format is not stored
+// in a dedicated character (that would break length preservation): it is bound to the
+// version character, so each key version is associated with exactly one format.
+// A header char of format flags next to the version would leave 1 char to encode the
+// 3 middle digits of a 13-digit card (a base 1000 alphabet) and 2 chars for the 4 of a
+// 14-digit card (base 100). To migrate to a new format, introduce a new key version
+// declaring it: tokens of the older versions keep decoding with their own format.
+type Format uint8
+
+const (
+	// FormatV0 is the original token layout: 6 first digits || version char ||
+	// middle digits encrypted with FF1 and encoded in base x || 4 last digits.
+	// Versions for which no format is declared are considered FormatV0.
+	FormatV0 Format = 0
+	// FormatV1 is the FormatV0 layout whose FF1 tweak is derived from the preserved digits alone:
+	// the FormatV0 tweak input inserts as many zero bytes as suffix digits between the prefix and
+	// the suffix digits (see tweakInputV0), a construction kept for the existing tokens.
+	FormatV1 Format = 2
+)
+
+// FormatVersioner is an optional interface that a KeyVersioner can implement
+// to declare which token format is associated with each version. This allows
+// the engine to route the decryption of a token to the decoding logic of the
+// format it was produced with.
+type FormatVersioner interface {
+	// GetFormat returns the token format bound to the input version
+	GetFormat(version byte) (Format, error)
+}
+
 // AlphabetProvider is a provider regulating which alphabet
 // to use for encoding in different bases
 type AlphabetProvider interface {
@@ -163,6 +470,11 @@ type AlphabetProvider interface {
 	// Hex is a base 16 with associated alphabet equal to []byte{'0', '1', '2', '3', '4', '5', '6', '7', '8', '9', 'a', 'b', 'c', 'd', 'e', 'f'}
 	// analogously one can define different alphabets for different bases:
 	// base 5 can be used with alphabet []byte{'a', 'e', 'i', 'o', 'u'}
+	// Symbols must be single-byte ASCII characters: multibyte UTF-8 symbols are rejected
+	// by the engine as they would break the one char = one symbol length preservation.
+	// Alphabets are indexed by base: the engine always selects the base from the token length
+	// first and only then validates and decodes the middle chars against the alphabet of that
+	// base, hence the alphabets of different bases may share symbols, in any order.
 	GetAlphabetForBase(base uint32) ([]byte, error)
 }
 
@@ -170,7 +482,56 @@ type engine struct {
 	versioner      KeyVersioner
 	encryptionKeys KeyRepo
 	hmacKeys       KeyRepo
-	alphaProvider  AlphabetProvider
+	// alphaProvider is the alphabet used for tokenization
+	alphaProvider AlphabetProvider
+	// detokAlphaProvider is an optional alphabet accepted for detokenization
+	// in addition to alphaProvider
+	detokAlphaProvider AlphabetProvider
+	// logger is the optional logger reporting unexpected failures
+	logger Logger
+	// minDomainSize is the minimum size of the FF1 domain to encrypt,
+	// DefaultMinDomainSize if nil
+	minDomainSize *uint64
+	// tweakHash is the hash function of the tweak HMAC, SHA-256 if nil
+	tweakHash func() hash.Hash
+	// auditSink is the optional sink of the audit trail
+	auditSink AuditSink
+	// rejectTokens makes EncryptCC refuse credit cards which are also valid tokens
+	rejectTokens bool
+	// versionFallback makes DecryptTK try all the detokenization versions on corrupted tokens
+	versionFallback bool
+	// testPANs are the test cards EncryptCC refuses, if not nil (see WithRejectTestPANs)
+	testPANs map[string]struct{}
+	// strictPAN makes EncryptCC refuse the implausible PANs (see WithStrictPANChecks)
+	strictPAN bool
+	// verifyOnEncrypt makes EncryptCC detokenize the tokens it produces to check they round trip
+	verifyOnEncrypt bool
+	// decryptCache memoizes the detokenized cards, if not nil (see WithDecryptCache)
+	decryptCache *decryptCache
+	// cmacVersions are the versions whose tweaks are derived with AES-CMAC (see WithCMACTweaks)
+	cmacVersions map[byte]bool
+	// tokenWidth is the fixed width of the credit card tokens, 0 if they keep the card length
+	tokenWidth int
+	// distinctKeys makes the construction fail if versions share keys (see WithDistinctKeyValidation)
+	distinctKeys bool
+	// insecure marks the engines built with the hard-coded keys of NewDummyEngine
+	insecure bool
+	// allowInsecure silences the warning logged for insecure engines (see AllowInsecureDummyKeys)
+	allowInsecure bool
+	// stats counts the tokens produced per version, nil if not counted (see VersionStats)
+	stats *versionStats
+	// binLength detects the 8-digit BINs of the cards of binVersions (see WithBINLengthDetection)
+	binLength BINLengthDetector
+	// binVersions are the versions preserving the 8-digit BINs, nil without BIN length detection
+	binVersions map[byte]bool
+	// ff31Versions are the versions whose middle digits are encrypted with FF3-1 (see WithFF31)
+	ff31Versions map[byte]bool
+	// maxInputLength is the maximum length of the inputs, DefaultMaxInputLength if 0
+	maxInputLength int
+	// fipsMode restricts the engine to FIPS-approved primitives
+	fipsMode bool
+	// hmacs caches the tweak HMACs by version during batches (see withHMACCache), nil otherwise
+	hmacs map[byte]hash.Hash
 }
 
 // EncryptCC encrypts a credit card input and return the corresponding token. The token format preserves the
@@ -185,45 +546,216 @@ type engine struct {
 // 5. will encode the following info into the token:
 //    a. The version byte (in the 7th char)
 //    b. The encrypted payload in base_x ( where x will be a function of the total size of the card)
-func (e *engine) EncryptCC(cc string) (string, error) {
+func (e *engine) EncryptCC(cc string) (tk string, err error) {
+	if err := e.checkConfigured(); err != nil {
+		return "", err
+	}
+	defer func() { e.audit(AuditTokenize, tk, e.ccVersionIndex(tk), len(cc), err) }()
+	defer e.recoverCipherPanic("EncryptCC", &err)
+
+	return e.encryptCC(cc, nil)
+}
+
+// encryptCC tokenizes cc binding the token to aad (see EncryptCCWithAAD)
+func (e *engine) encryptCC(cc string, aad []byte) (string, error) {
 	// input validation
-	if !isValidCC(cc) {
-		return "", errors.New(fmt.Sprintf("Invalid CC format"))
+	if err := e.checkInputLength(cc); err != nil {
+		return "", err
+	}
+	if err := checkNumeric(cc, CreditCardFormat, ErrInvalidCC); err != nil {
+		return "", err
+	}
+	if err := e.checkCard(cc); err != nil {
+		return "", err
 	}
 
-	ccBytes := []byte(cc)
+	// retrieve write-version, its keys and its layout
+	v, te, err := e.tokenizationVersion()
+	if err != nil {
+		return "", err
+	}
+	opts, err := e.cardFormat(v, cc)
+	if err != nil {
+		return "", err
+	}
+	if err := checkCardLength(len(cc), opts, ErrInvalidCC); err != nil {
+		return "", err
+	}
+	if err := e.checkDomain(len(cc), opts); err != nil {
+		return "", err
+	}
 
-	// 6x4
-	sixByFour := make([]byte, 10)
-	copy(sixByFour, ccBytes[:6])
-	sixByFour = append(sixByFour, ccBytes[len(ccBytes)-4:]...)
+	tk, err := te.encryptVersion(cc, v, opts, e.alphaProvider, aad)
+	if err != nil {
+		return "", err
+	}
+	if tk, err = te.sealToken(tk); err != nil || !e.verifyOnEncrypt {
+		return tk, err
+	}
+	if err := te.verifyRoundTrip(cc, tk, aad); err != nil {
+		return "", err
+	}
+	return tk, nil
+}
+
+// encrypt tokenizes a value already validated against opts under the current tokenization
+// version, encoding its middle digits with alpha and mixing the optional aad into the tweak
+func (e *engine) encrypt(value string, opts FormatOpts, alpha AlphabetProvider, aad []byte) (string, error) {
+	// FF1 tweak source
+	if err := opts.checkContext(aad); err != nil {
+		return "", err
+	}
+
+	// FF1 domain size
+	if err := e.checkDomain(len(value), opts); err != nil {
+		return "", err
+	}
+
+	// retrieve write-version and its keys
+	v, te, err := e.tokenizationVersion()
+	if err != nil {
+		return "", err
+	}
+
+	return te.encryptVersion(value, v, opts, alpha, aad)
+}
+
+// encryptVersion tokenizes a value already validated against opts, domain included, under version v
+func (e *engine) encryptVersion(value string, v byte, opts FormatOpts, alpha AlphabetProvider, aad []byte) (string, error) {
+	if err := ValidateVersion(v); err != nil {
+		return "", err
+	}
+
+	// retrieve the format bound to the version
+	f, err := e.formatFor(v)
+	if err != nil {
+		return "", err
+	}
+
+	switch f {
+	case FormatV0, FormatMAC, FormatV1:
+		// the MAC of FormatMAC tokens is appended by sealToken
+		return e.encryptV0(value, v, f, opts, alpha, aad)
+	default:
+		return "", errors.New(fmt.Sprintf("Unsupported token format %d for version %s", f, string(v)))
+	}
+}
+
+// recoverCipherPanic converts a panic of the op operation into ErrInternalCipherFailure.
+// It must be deferred by the exported methods of the engine.
+func (e *engine) recoverCipherPanic(op string, err *error) {
+	if r := recover(); r != nil {
+		e.logf("recovered from panic in %s: %v\n%s", op, r, debug.Stack())
+		*err = ErrInternalCipherFailure
+	}
+}
+
+// formatFor returns the token format bound to version v. If the versioner does not
+// implement FormatVersioner every version is considered FormatV0.
+func (e *engine) formatFor(v byte) (Format, error) {
+	fv, ok := e.versioner.(FormatVersioner)
+	if !ok {
+		return FormatV0, nil
+	}
+	return fv.GetFormat(v)
+}
+
+// encryptV0 tokenizes a valid value under version v using the FormatV0 layout and the tweak input of format f
+func (e *engine) encryptV0(value string, v byte, f Format, opts FormatOpts, alpha AlphabetProvider, aad []byte) (string, error) {
+	valueBytes := []byte(value)
+	defer zero(valueBytes)
+
+	p, s := opts.PreservedPrefix, opts.PreservedSuffix
+
+	// 6x4 (or more generally prefix x suffix) followed by the optional aad
+	tweakInput := tweakInputWithAAD(f, valueBytes, p, s, aad)
+	defer zero(tweakInput)
 
 	// middle-digits
-	md := cc[6 : len(cc)-4]
+	md := value[p : len(value)-s]
 
-	// retrieve write-version
-	v, err := e.versioner.GetTokenizationVersion()
+	tkmd, err := e.encryptMDV0(tweakInput, md, v, opts.radix(), alpha)
 	if err != nil {
 		return "", err
 	}
 
-	// get encryption and hmac keys
-	ekey, err := e.encryptionKeys.GetKey(v)
+	// concatenate: prefix digits || version char || encoded middle digits TK || suffix digits
+	return fmt.Sprintf("%s%s%s%s", value[0:p], string(v), tkmd, value[len(value)-s:]), nil
+}
+
+// sixByFourV0 builds the FormatV0 tweak input out of the first 6 and the last 4 digits
+// of a card or token
+func sixByFourV0(b []byte) []byte {
+	return tweakInputV0(b, 6, 4)
+}
+
+// tweakInputV0 builds the FormatV0 tweak input out of the first p and the last s digits
+// of a value or token: the p digits, s zero bytes and the s digits. The zero bytes come from
+// appending to a slice allocated with a length instead of a capacity; FormatV1 drops them.
+func tweakInputV0(b []byte, p int, s int) []byte {
+	tweakInput := make([]byte, p+s)
+	copy(tweakInput, b[:p])
+	return append(tweakInput, b[len(b)-s:]...)
+}
+
+// tweakInputV1 builds the FormatV1 tweak input: the first p digits followed by the last s digits
+func tweakInputV1(b []byte, p int, s int) []byte {
+	tweakInput := make([]byte, 0, p+s)
+	tweakInput = append(tweakInput, b[:p]...)
+	return append(tweakInput, b[len(b)-s:]...)
+}
+
+// tweakInputFor builds the tweak input of format f out of the first p and the last s digits
+func tweakInputFor(f Format, b []byte, p int, s int) []byte {
+	if f == FormatV1 {
+		return tweakInputV1(b, p, s)
+	}
+	return tweakInputV0(b, p, s)
+}
+
+// hasV0Layout returns true if the tokens of format f are laid out as FormatV0 ones, MAC excluded
+func hasV0Layout(f Format) bool {
+	return f == FormatV0 || f == FormatMAC || f == FormatV1
+}
+
+// tweakInputWithAAD is the tweak input of format f followed by aad. Without aad it is the
+// tweak input of f so that tokens produced without aad are unchanged.
+func tweakInputWithAAD(f Format, b []byte, p int, s int, aad []byte) []byte {
+	tweakInput := tweakInputFor(f, b, p, s)
+	if len(aad) == 0 {
+		return tweakInput
+	}
+	defer zero(tweakInput)
+	return append(append(make([]byte, 0, len(tweakInput)+len(aad)), tweakInput...), aad...)
+}
+
+// encryptMDV0 encrypts the middle digits md, written in radix, under version v with the tweak derived
+// from sixByFour and returns them encoded with one char less than md
+func (e *engine) encryptMDV0(sixByFour []byte, md string, v byte, radix int, alpha AlphabetProvider) (string, error) {
+	// get encryption and hmac keys, wiped once the operation is done
+	ekey, err := getSecretKey(e.encryptionKeys, v)
 	if err != nil {
 		return "", err
 	}
-	hkey, err := e.hmacKeys.GetKey(v)
+	defer ekey.Close()
+	hkey, err := getSecretKey(e.hmacKeys, v)
 	if err != nil {
 		return "", err
 	}
+	defer hkey.Close()
+	if err := e.checkFIPSKeys(v, ekey.Bytes(), hkey.Bytes()); err != nil {
+		return "", err
+	}
 
-	// generating the hmac from 6x4 and retrieving the tweak
-	h := hmac.New(sha256.New, hkey)
-	h.Write(sixByFour)
-	tweak := h.Sum(nil)
+	// generating the tweak (hmac, or cmac) from 6x4
+	tweak, err := e.versionTweak(v, hkey.Bytes(), sixByFour)
+	if err != nil {
+		return "", err
+	}
+	defer zero(tweak)
 
 	// format preserving encryption cipher
-	cipher, err := ff1.NewCipher(10, len(tweak), ekey, tweak)
+	cipher, err := e.newFPECipher(v, radix, ekey.Bytes(), tweak)
 	if err != nil {
 		return "", err
 	}
@@ -241,13 +773,7 @@ func (e *engine) EncryptCC(cc string) (string, error) {
 
 	// encoding TkMD will generate an alpha-num token with one char less than the ciphertext
 	// this allows to accommodate also the version char in the token
-	tkmd, err := encodeTkMD(ciphertext, e.alphaProvider)
-	if err != nil {
-		return "", err
-	}
-
-	// concatenate: 6 first cc digits || version char || encoded middle digits TK || 4 last cc digits
-	return fmt.Sprintf("%s%s%s%s", cc[0:6], string(v), tkmd, cc[len(cc)-4:]), nil
+	return encodeTkMDRadix(ciphertext, radix, alpha)
 }
 
 func contains(s []byte, v byte) bool {
@@ -266,54 +792,208 @@ func contains(s []byte, v byte) bool {
 // 3. with the 6x4 of the token we will generate a tweak by "hmac-ing" it
 // 4. decode the middle-digits into its decimal string representation
 // 5. with the tweak and the encryption key linked to the version we will decrypt the decimal string cipher
-func (e *engine) DecryptTK(tk string) (string, error) {
+func (e *engine) DecryptTK(tk string) (_ string, err error) {
+	if err := e.checkConfigured(); err != nil {
+		return "", err
+	}
+	defer func() { e.audit(AuditDetokenize, tk, e.ccVersionIndex(tk), len(tk), err) }()
+	defer e.recoverCipherPanic("DecryptTK", &err)
+
+	return e.decryptTK(tk, nil)
+}
+
+// MaskedDecrypter is an optional interface of a TKEngine detokenizing for display only.
+// The engines built by this package implement it.
+type MaskedDecrypter interface {
+	// DecryptTKMasked is DecryptTK returning the decrypted CC
+	// masked, e.g. 444433******1111, for display only
+	DecryptTKMasked(tk string) (string, error)
+}
+
+// DecryptTKMasked decrypts a token like DecryptTK but only returns the masked card (see MaskPAN), for
+// consumers authorized to detokenize for display only. The full card only exists transiently within
+// the engine and is never returned.
+func (e *engine) DecryptTKMasked(tk string) (_ string, err error) {
+	if err := e.checkConfigured(); err != nil {
+		return "", err
+	}
+	defer func() { e.audit(AuditDetokenize, tk, e.ccVersionIndex(tk), len(tk), err) }()
+	defer e.recoverCipherPanic("DecryptTKMasked", &err)
 
+	cc, err := e.decryptTK(tk, nil)
+	if err != nil {
+		return "", err
+	}
+	return MaskPAN(cc), nil
+}
+
+// MaskPAN hides all but the first 6 and the last 4 digits of cc with '*' (444433******1111).
+// Inputs too short to keep them are fully hidden.
+func MaskPAN(cc string) string {
+	p, s := CreditCardFormat.PreservedPrefix, CreditCardFormat.PreservedSuffix
+	if len(cc) <= p+s {
+		return strings.Repeat("*", len(cc))
+	}
+	return cc[:p] + strings.Repeat("*", len(cc)-p-s) + cc[len(cc)-s:]
+}
+
+// decryptTK detokenizes tk bound to aad (see DecryptTKWithAAD)
+func (e *engine) decryptTK(tk string, aad []byte) (string, error) {
+	if err := e.checkInputLength(tk); err != nil {
+		return "", err
+	}
 	detokVers, err := e.versioner.GetDetokenizationVersions()
 	if err != nil {
 		return "", err
 	}
 
+	if tk, err = e.openToken(tk); err != nil {
+		return "", err
+	}
+
+	if e.versionFallback {
+		return e.decryptWithVersionFallback(tk, detokVers, aad)
+	}
+
 	// input validation
-	if !isValidTK(tk, e.alphaProvider, detokVers) {
-		return "", errors.New(fmt.Sprintf("Invalid TK format"))
+	alpha, opts, err := e.detokAlphabet(tk, detokVers)
+	if err != nil {
+		return "", err
 	}
 
-	tkBytes := []byte(tk)
+	if e.decryptCache == nil || len(aad) > 0 {
+		return e.decrypt(tk, opts, alpha, aad)
+	}
+	gen := e.keyGeneration()
+	if cc, ok := e.decryptCache.get(tk, gen); ok {
+		return cc, nil
+	}
+	cc, err := e.decrypt(tk, opts, alpha, aad)
+	if err != nil {
+		return "", err
+	}
+	e.decryptCache.put(tk, cc, gen)
+	return cc, nil
+}
 
-	// 6x4
-	sixByFour := make([]byte, 10)
-	copy(sixByFour, tkBytes[:6])
-	sixByFour = append(sixByFour, tkBytes[len(tkBytes)-4:]...)
+// detokAlphabet returns the alphabet tk is encoded with: the tokenization alphabet or, if any, the
+// additional detokenization alphabet, and the layout of tk (see LayoutVersioner). If tk is not a valid
+// token in any of them, the error describing why it is not a valid token in the tokenization alphabet
+// is returned, ErrVersionExpired if it is well-formed in one of them but its version is not a
+// detokenization version, or ErrTruncatedSuffix if its suffix looks truncated.
+func (e *engine) detokAlphabet(tk string, detokVers []byte) (AlphabetProvider, FormatOpts, error) {
+	opts, err := e.ccLayout(tk, detokVers)
+	if err != nil {
+		return nil, FormatOpts{}, err
+	}
+	// the structure is checked first, so that the tokens of retired versions are told apart
+	alpha := e.alphaProvider
+	err = checkNumericTKStructure(tk, opts, alpha)
+	if err != nil && e.detokAlphaProvider != nil && checkNumericTKStructure(tk, opts, e.detokAlphaProvider) == nil {
+		alpha, err = e.detokAlphaProvider, nil
+	}
+	if err == nil {
+		err = checkNumericTKVersion(tk, opts, detokVers)
+	}
+	if err == nil {
+		return alpha, opts, nil
+	}
+	if terr := e.checkTruncatedSuffix(tk, opts, detokVers); terr != nil {
+		return nil, FormatOpts{}, terr
+	}
+	return nil, FormatOpts{}, err
+}
+
+// decrypt detokenizes a token already validated against opts, decoding its middle digits with alpha
+// and mixing the optional aad into the tweak
+func (e *engine) decrypt(tk string, opts FormatOpts, alpha AlphabetProvider, aad []byte) (string, error) {
+	// FF1 tweak source
+	if err := opts.checkContext(aad); err != nil {
+		return "", err
+	}
 
 	// get token version
-	v := tk[6]
+	v := tk[opts.PreservedPrefix]
 
-	// get encryption and hmac keys
-	ekey, err := e.encryptionKeys.GetKey(v)
+	// route the token to the decoding logic of the format bound to its version
+	f, err := e.formatFor(v)
 	if err != nil {
 		return "", err
 	}
-	hkey, err := e.hmacKeys.GetKey(v)
+
+	switch f {
+	case FormatV0, FormatMAC, FormatV1:
+		// the MAC of FormatMAC tokens is verified by openToken
+		return e.decryptV0(tk, v, f, opts, alpha, aad)
+	default:
+		return "", errors.New(fmt.Sprintf("Unsupported token format %d for version %s", f, string(v)))
+	}
+}
+
+// decryptV0 detokenizes a valid tk produced under version v with the FormatV0 layout and the tweak input of format f
+func (e *engine) decryptV0(tk string, v byte, f Format, opts FormatOpts, alpha AlphabetProvider, aad []byte) (string, error) {
+	p, s := opts.PreservedPrefix, opts.PreservedSuffix
+
+	// 6x4 (or more generally prefix x suffix) followed by the optional aad
+	tweakInput := tweakInputWithAAD(f, []byte(tk), p, s, aad)
+	defer zero(tweakInput)
+
+	// Parsing middle-digits
+	md := tk[p : len(tk)-s]
+
+	plaintext, err := e.decryptMDV0(tweakInput, md, v, opts.radix(), alpha)
 	if err != nil {
 		return "", err
 	}
 
-	// Parsing middle-digits
-	md := tk[6 : len(tk)-4]
+	// concatenate: prefix digits || decrypted middle digits || suffix digits
+	return concatWiped(make([]byte, len(tk)), tk[0:p], plaintext, tk[len(tk)-s:]), nil
+}
 
-	// generating the hmac from 6x4 and retrieving the tweak
-	h := hmac.New(sha256.New, hkey)
-	h.Write(sixByFour)
-	tweak := h.Sum(nil)
+// concatWiped returns the concatenation of parts, assembled in buf which is wiped once the returned
+// string is built. buf must be at least as long as the parts together.
+func concatWiped(buf []byte, parts ...string) string {
+	defer zero(buf)
+	n := 0
+	for _, part := range parts {
+		n += copy(buf[n:], part)
+	}
+	return string(buf[:n])
+}
 
-	// decode middle-digits into decimal string representation
-	decmd, err := decodeTkMD(md[1:], e.alphaProvider)
+// decryptMDV0 decrypts the token middle digits md (version char included) produced under version v
+// with the tweak derived from sixByFour and returns the card middle digits, written in radix
+func (e *engine) decryptMDV0(sixByFour []byte, md string, v byte, radix int, alpha AlphabetProvider) (string, error) {
+	// get encryption and hmac keys, wiped once the operation is done
+	ekey, err := getSecretKey(e.encryptionKeys, v)
+	if err != nil {
+		return "", err
+	}
+	defer ekey.Close()
+	hkey, err := getSecretKey(e.hmacKeys, v)
+	if err != nil {
+		return "", err
+	}
+	defer hkey.Close()
+	if err := e.checkFIPSKeys(v, ekey.Bytes(), hkey.Bytes()); err != nil {
+		return "", err
+	}
+
+	// generating the tweak (hmac, or cmac) from 6x4
+	tweak, err := e.versionTweak(v, hkey.Bytes(), sixByFour)
+	if err != nil {
+		return "", err
+	}
+	defer zero(tweak)
+
+	// decode middle-digits into their radix string representation
+	decmd, err := decodeTkMDRadix(md[1:], radix, alpha)
 	if err != nil {
 		return "", err
 	}
 
 	// format preserving encryption cipher
-	cipher, err := ff1.NewCipher(10, len(tweak), ekey, tweak)
+	cipher, err := e.newFPECipher(v, radix, ekey.Bytes(), tweak)
 	if err != nil {
 		return "", err
 	}
@@ -329,8 +1009,126 @@ func (e *engine) DecryptTK(tk string) (string, error) {
 		return "", errors.New(fmt.Sprintf("middle digits [%s] and plaintext [%s] length differs", md, plaintext))
 	}
 
-	// concatenate: 6 first cc digits || version char || encoded middle digits TK || 4 last cc digits
-	return fmt.Sprintf("%s%s%s", tk[0:6], plaintext, tk[len(tk)-4:]), nil
+	return plaintext, nil
+}
+
+// Retokenizer is an optional interface of a TKEngine migrating tokens to the current tokenization
+// version. The engines built by this package implement it.
+type Retokenizer interface {
+	// Retokenize takes a valid TK in input and outputs the equivalent
+	// token under the current tokenization version. The input token is
+	// returned as is if it is already on the current tokenization version.
+	// Error types: InvalidTK format
+	Retokenize(tk string) (string, error)
+	// RetokenizeBatch retokenizes a list of TKs reporting the progress
+	// to the optional progress callback. Tokens and errors are returned
+	// in the same order as the input TKs.
+	RetokenizeBatch(tks []string, progress func(done, total int)) ([]string, []error)
+}
+
+// Retokenize re-encrypts a token under the current tokenization version and alphabet. It is meant to
+// migrate tokens during key (or alphabet) rotation: the middle digits are decrypted with the token version keys and
+// immediately re-encrypted with the tokenization version keys. As the first 6 and the last 4 digits
+// are shared by the card and the token, the full card number is never materialized, unless the token and
+// the tokenization version layouts differ (see LayoutVersioner).
+// If the token is already on the current tokenization version and alphabet it is returned as is.
+func (e *engine) Retokenize(tk string) (rtk string, err error) {
+	if err := e.checkConfigured(); err != nil {
+		return "", err
+	}
+	defer func() { e.audit(AuditRetokenize, rtk, e.ccVersionIndex(rtk), len(tk), err) }()
+	defer e.recoverCipherPanic("Retokenize", &err)
+
+	if err := e.checkInputLength(tk); err != nil {
+		return "", err
+	}
+	detokVers, err := e.versioner.GetDetokenizationVersions()
+	if err != nil {
+		return "", err
+	}
+
+	padded := tk
+	if tk, err = e.openToken(tk); err != nil {
+		return "", err
+	}
+
+	// input validation
+	alpha, oldOpts, err := e.detokAlphabet(tk, detokVers)
+	if err != nil {
+		return "", err
+	}
+
+	// retrieve write-version and its keys
+	v, te, err := e.tokenizationVersion()
+	if err != nil {
+		return "", err
+	}
+	if err := ValidateVersion(v); err != nil {
+		return "", err
+	}
+
+	// token already on the current version and alphabet: no-op
+	oldV := tk[oldOpts.PreservedPrefix]
+	if oldV == v && isValidNumericTK(tk, oldOpts, e.alphaProvider, detokVers) {
+		return padded, nil
+	}
+
+	// both the token and the write-version formats must be supported
+	var formats [2]Format
+	for i, ver := range []byte{oldV, v} {
+		f, err := e.formatFor(ver)
+		if err != nil {
+			return "", err
+		}
+		if !hasV0Layout(f) {
+			return "", errors.New(fmt.Sprintf("Unsupported token format %d for version %s", f, string(ver)))
+		}
+		formats[i] = f
+	}
+
+	opts, err := e.cardFormat(v, tk)
+	if err != nil {
+		return "", err
+	}
+	if err := checkCardLength(len(tk), opts, ErrInvalidTK); err != nil {
+		return "", err
+	}
+	if opts.PreservedPrefix != oldOpts.PreservedPrefix || opts.PreservedSuffix != oldOpts.PreservedSuffix {
+		// the layouts differ (see LayoutVersioner): the tweak inputs differ too, the card has to be
+		// decrypted to be tokenized with the new layout
+		rtk, err := te.retokenizeLayout(tk, oldOpts, opts, v, alpha)
+		if err != nil {
+			return "", err
+		}
+		return te.sealToken(rtk)
+	}
+	p, s := opts.PreservedPrefix, opts.PreservedSuffix
+
+	// 6x4 (or more generally prefix x suffix), built as the format of each version builds it
+	oldSixByFour := tweakInputFor(formats[0], []byte(tk), p, s)
+	defer zero(oldSixByFour)
+	sixByFour := tweakInputFor(formats[1], []byte(tk), p, s)
+	defer zero(sixByFour)
+
+	md, err := e.decryptMDV0(oldSixByFour, tk[p:len(tk)-s], oldV, opts.radix(), alpha)
+	if err != nil {
+		return "", err
+	}
+
+	tkmd, err := te.encryptMDV0(sixByFour, md, v, opts.radix(), e.alphaProvider)
+	if err != nil {
+		return "", err
+	}
+
+	// concatenate: prefix tk digits || version char || encoded middle digits TK || suffix tk digits
+	return te.sealToken(fmt.Sprintf("%s%s%s%s", tk[0:p], string(v), tkmd, tk[len(tk)-s:]))
+}
+
+// zero overwrites the content of b so that sensitive data does not linger in memory
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
 }
 
 // keyRepo simulates a key repository. In the real implementation
@@ -354,16 +1152,26 @@ func (r *keyRepo) GetKey(v byte) ([]byte, error) {
 	return key, nil
 }
 
-type dummyVersioner struct{}
+// dummyVersioner randomly picks the tokenization version. If set, rnd (guarded by mu)
+// replaces the global rand source (see WithRandSource).
+type dummyVersioner struct {
+	mu  *sync.Mutex
+	rnd *rand.Rand
+}
 
 // GetTokenizationVersion randomly selects a version from a to d
 func (verser dummyVersioner) GetTokenizationVersion() (byte, error) {
-	rand.Seed(time.Now().UnixNano())
 	// hardcoded versions
 	vers := []byte{'a', 'b', 'c', 'd'}
 	if len(vers) == 0 {
 		return 0, errors.New(fmt.Sprintf("Key repo contains no key"))
 	}
+	if verser.rnd != nil {
+		verser.mu.Lock()
+		defer verser.mu.Unlock()
+		return vers[verser.rnd.Intn(len(vers))], nil
+	}
+	rand.Seed(time.Now().UnixNano())
 	v := vers[rand.Intn(len(vers))]
 	return v, nil
 }
@@ -389,11 +1197,11 @@ func (r *keyRepo) GetWriteVersion() (byte, error) {
 	return v, nil
 }
 
-// encodingBaseToSaveOneChar get's in input the size of the CC or TK
+// encodingBaseToSaveOneChar get's in input the number of middle digits of the CC or TK
 // and return the base in which the encoding must be done
-// s should be in {13, 19} range otherwise an error is returned
+// s should be in [3, 19] range otherwise an error is returned
 func encodingBaseToSaveOneChar(s int) (uint32, error) {
-	if s < 3 || s > 9 {
+	if s < 3 || s > 19 {
 		return 0, errors.New(fmt.Sprintf("Invalid CC or TK size: %d", s))
 	}
 
@@ -405,25 +1213,55 @@ func encodingBaseToSaveOneChar(s int) (uint32, error) {
 		uint32(7): uint32(15), // 15 is the first x so that x^6 > 9999999
 		uint32(8): uint32(14), // 14 is the first x so that x^7 > 99999999
 		uint32(9): uint32(14), // 14 is the first x so that x^8 > 999999999
+		// longer sections are only used by layouts preserving fewer digits than credit cards
+		uint32(10): uint32(13), // 13 is the first x so that x^9 > 9999999999
+		uint32(11): uint32(13), // 13 is the first x so that x^10 > 99999999999
+		uint32(12): uint32(13), // 13 is the first x so that x^11 > 999999999999
+		uint32(13): uint32(13), // 13 is the first x so that x^12 > 9999999999999
+		uint32(14): uint32(12), // 12 is the first x so that x^13 > 99999999999999
+		uint32(15): uint32(12), // 12 is the first x so that x^14 > 999999999999999
+		// whole card sections are only used by layouts preserving no digit
+		uint32(16): uint32(12), // 12 is the first x so that x^15 > 9999999999999999
+		uint32(17): uint32(12), // 12 is the first x so that x^16 > 99999999999999999
+		uint32(18): uint32(12), // 12 is the first x so that x^17 > 999999999999999999
+		uint32(19): uint32(12), // 12 is the first x so that x^18 > 9999999999999999999
 	}
 
 	return m[uint32(s)], nil
 }
 
+// saveOneCharBases returns the distinct bases encodingBaseToSaveOneChar returns for the sections of 3 to 19 digits
+func saveOneCharBases() []uint32 {
+	var bases []uint32
+	for n := 3; n <= 19; n++ {
+		base, _ := encodingBaseToSaveOneChar(n)
+		if len(bases) == 0 || bases[len(bases)-1] != base {
+			bases = append(bases, base)
+		}
+	}
+	return bases
+}
+
 // bitsRequired return the least amount of bits
-// for representing a given number
+// for representing a given number, 0 for n = 0.
+// It is exact for every n, powers of two included.
 func bitsRequired(n uint32) uint32 {
-	return uint32(math.Ceil(math.Log2(float64(n))))
+	if n == 0 {
+		return 0
+	}
+	return uint32(bits.Len32(n))
 }
 
 // DefaultAlphabetProvider provides a default value for alphabet provider
 type DefaultAlphabetProvider struct{}
 
 // GetAlphabetForBase return the alphabet for the bases
-// 14, 15, 16, 18, 22, 32
+// 12, 13, 14, 15, 16, 18, 22, 32
 // anything different than those numbers will be considered an error
 func (d DefaultAlphabetProvider) GetAlphabetForBase(base uint32) ([]byte, error) {
 	b := map[uint32][]byte{
+		uint32(12): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l'},
+		uint32(13): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm'},
 		uint32(14): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'n'},
 		uint32(15): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'n', 'o'},
 		uint32(16): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'n', 'o', 'p'},
@@ -440,13 +1278,77 @@ func (d DefaultAlphabetProvider) GetAlphabetForBase(base uint32) ([]byte, error)
 	return alphabet, nil
 }
 
+// EncodeMiddleDigits encodes a string of n decimal digits (0-9), with n in [3, 19], into a string
+// of n-1 symbols. The encoding base is the smallest base able to represent any n-digit number with
+// n-1 symbols (32 for 3 digits, 22 for 4, 18 for 5, 16 for 6, 15 for 7, 14 for 8 and 9 digits,
+// 13 for 10 to 13 digits and 12 for 14 to 19 digits) and its symbols are retrieved from alphaProvider. The output is left-padded with the first symbol
+// of the alphabet. It is the building block the engine uses to make room for the version char.
+func EncodeMiddleDigits(digits string, alphaProvider AlphabetProvider) (string, error) {
+	return encodeTkMD(digits, alphaProvider)
+}
+
+// DecodeMiddleDigits is the inverse of EncodeMiddleDigits: it decodes a string of n-1 symbols, with
+// n in [3, 19], into the string of n decimal digits it encodes (left-padded with zeros). The symbols
+// must belong to the alphabet alphaProvider returns for the base EncodeMiddleDigits uses for n digits.
+func DecodeMiddleDigits(encoded string, alphaProvider AlphabetProvider) (string, error) {
+	return decodeTkMD(encoded, alphaProvider)
+}
+
+// EncodingCapacity returns, for middle sections of middleLen decimal digits, the size of the domain of the
+// "save one char" encoding (10^middleLen values) and the size of its codomain (base^(middleLen-1)
+// encodings, base being the one EncodeMiddleDigits uses for middleLen digits). bijective is true if
+// every value of the domain has its own encoding, i.e. the encoding is injective and hence a bijection
+// onto its image: no information is lost and decoding is always unambiguous. middleLen must be in [3, 19].
+// The codomain saturates at math.MaxUint64 (12^18 encodings of 19 digits).
+func EncodingCapacity(middleLen int) (domain uint64, codomain uint64, bijective bool, err error) {
+	base, err := encodingBaseToSaveOneChar(middleLen)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	domain = powUint64(10, middleLen)
+	codomain = 1
+	for i := 0; i < middleLen-1; i++ {
+		if codomain > math.MaxUint64/uint64(base) {
+			return domain, math.MaxUint64, true, nil
+		}
+		codomain *= uint64(base)
+	}
+	return domain, codomain, codomain >= domain, nil
+}
+
+// CapacityPlanner is an optional interface of a TKEngine sizing the token space of its versions.
+// The engines built by this package implement it.
+type CapacityPlanner interface {
+	// MaxDistinctTokens returns the number of distinct tokens of ccLen
+	// characters a tokenization version can take, for capacity planning
+	MaxDistinctTokens(ccLen int) uint64
+}
+
+// MaxDistinctTokens returns the number of distinct tokens of ccLen characters a tokenization version can
+// take: base^(middleLen-1) encodings of the middle-digits times the 10^(prefix+suffix) preserved digits.
+// It is 0 for card lengths the engine does not tokenize and saturates at math.MaxUint64.
+func (e *engine) MaxDistinctTokens(ccLen int) uint64 {
+	if ccLen < CreditCardFormat.MinLength || ccLen > CreditCardFormat.MaxLength || e.checkDomain(ccLen, CreditCardFormat) != nil {
+		return 0
+	}
+	preserved := CreditCardFormat.PreservedPrefix + CreditCardFormat.PreservedSuffix
+	_, codomain, _, err := EncodingCapacity(ccLen - preserved)
+	if err != nil {
+		return 0
+	}
+	if codomain > math.MaxUint64/powUint64(10, preserved) {
+		return math.MaxUint64
+	}
+	return codomain * powUint64(10, preserved)
+}
+
 // decodeTkMD takes in input a string that contains only the valid alphabet chars
 // and returns the equivalent digit string (0-9) whith exactly one more character
 // than the input tkMD. tkMD input must respect the size of the given token which is
 // [2, 18]
 func decodeTkMD(tkMD string, aphaProvider AlphabetProvider) (string, error) {
-	if len(tkMD) < 2 || len(tkMD) > 8 {
-		return "", errors.New(fmt.Sprintf("tk middle digits len is not in interval [2, 8]. Instead it is %d", len(tkMD)))
+	if len(tkMD) < 2 || len(tkMD) > 18 {
+		return "", errors.New(fmt.Sprintf("tk middle digits len is not in interval [2, 18]. Instead it is %d", len(tkMD)))
 	}
 
 	decodeds := len(tkMD) + 1
@@ -462,22 +1364,21 @@ func decodeTkMD(tkMD string, aphaProvider AlphabetProvider) (string, error) {
 	if err != nil {
 		return "", err
 	}
-
-	// build the alpha map for fast translation between byte and index
-	alphaMap := make(map[byte]int, len(alpha))
-	for i, el := range alpha {
-		alphaMap[el] = i
+	if len(alpha) != int(base) {
+		return "", errors.New(fmt.Sprintf("Got alphabet size %d for base %d. Size should match base", len(alpha), base))
 	}
 
-	var n uint32 = 0
-	for i, b := range []byte(tkMD) {
-		m, ok := alphaMap[b]
-		if !ok {
-			return "", errors.New(fmt.Sprintf("Found char in token that does not belong to the alphabet: char %s ( byte %d)", string(b), b))
-		}
-		n = n + (uint32(m) * uint32(math.Pow(float64(base), float64(len(tkMD)-1-i))))
+	// 18 symbols in base 12 can exceed the uint64 range, which is also beyond 19 decimal digits
+	n, err := DecodeBaseN(tkMD, alpha)
+	if err != nil {
+		return "", err
+	}
+	// the encoding base can represent more values than the decimal digits: a token whose
+	// middle-digits decode beyond the largest decodeds-digits number is malformed
+	if n >= powUint64(10, decodeds) {
+		return "", errors.New(fmt.Sprintf("tk middle digits decode to a value exceeding %d decimal digits", decodeds))
 	}
-	str := strconv.Itoa(int(n))
+	str := strconv.FormatUint(n, 10)
 	var strb strings.Builder
 	strb.Grow(decodeds)
 	for i := 0; i < decodeds-len(str); i++ {
@@ -494,12 +1395,12 @@ func decodeTkMD(tkMD string, aphaProvider AlphabetProvider) (string, error) {
 // and returns an alpha-num encoding in a base that allows to represent
 // it using one less character than in input
 func encodeTkMD(ciphertext string, alphaProvider AlphabetProvider) (string, error) {
-	if len(ciphertext) < 3 || len(ciphertext) > 9 {
-		return "", errors.New(fmt.Sprintf("ciphertext len is not in interval [3, 9]. Instead it is %d", len(ciphertext)))
+	if len(ciphertext) < 3 || len(ciphertext) > 19 {
+		return "", errors.New(fmt.Sprintf("ciphertext len is not in interval [3, 19]. Instead it is %d", len(ciphertext)))
 	}
 
 	// parsing ciphertext into a number
-	n, err := strconv.ParseUint(ciphertext, 10, 32)
+	n, err := strconv.ParseUint(ciphertext, 10, 64)
 	if err != nil {
 		return "", err
 	}
@@ -515,82 +1416,81 @@ func encodeTkMD(ciphertext string, alphaProvider AlphabetProvider) (string, erro
 	if err != nil {
 		return "", err
 	}
-
-	fsize := len(ciphertext) - 1
-	var strb strings.Builder
-	strb.Grow(fsize)
-	for i := 1; i < fsize+1; i++ {
-		m := uint32(int32(n) / int32(math.Pow(float64(base), float64(fsize-i))))
-		n = uint64(int32(n) % int32(math.Pow(float64(base), float64(fsize-i))))
-		_, err := fmt.Fprintf(&strb, "%s", string(alpha[m]))
-		if err != nil {
-			return "", err
-		}
+	if len(alpha) != int(base) {
+		return "", errors.New(fmt.Sprintf("Got alphabet size %d for base %d. Size should match base", len(alpha), base))
 	}
 
-	return strb.String(), nil
+	return EncodeBaseN(n, alpha, len(ciphertext)-1)
 }
 
-// isValidCC returns true if string matches regex [0-9]{13,19}
-func isValidCC(cc string) bool {
-	// in real program might be worth considering having global/static regex
-	// and not build it each time
-	ccRe := regexp.MustCompile(`^[0-9]{13,19}$`)
-	return ccRe.Match([]byte(cc))
+// IsCC returns true if s has the format of a credit card: 13 to 19 digits
+func IsCC(s string) bool {
+	return isValidCC(s)
 }
 
-// isValidCC returns true if string matches token structure
-func isValidTK(tk string, alphaProvider AlphabetProvider, vers []byte) bool {
-	if len(tk) < 13 || len(tk) > 19 {
-		return false
-	}
-	// six first digits
-	six := tk[:6]
-	for _, el := range six {
-		if !unicode.IsDigit(el) {
+// IsLuhnValid returns true if cc is made of digits satisfying the Luhn checksum
+func IsLuhnValid(cc string) bool {
+	sum := 0
+	double := false
+	for i := len(cc) - 1; i >= 0; i-- {
+		d := int(cc[i] - '0')
+		if d < 0 || d > 9 {
 			return false
 		}
-	}
-
-	// for last digits
-	four := tk[len(tk)-4:]
-	for _, el := range four {
-		if !unicode.IsDigit(el) {
-			return false
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
 		}
+		sum += d
+		double = !double
 	}
+	return len(cc) > 0 && sum%10 == 0
+}
 
-	// retrieve the encoding base for the specific ciphertext
-	base, err := encodingBaseToSaveOneChar(len(tk) - 10)
-	if err != nil {
+// IsToken returns true if s is a valid token for the engine alphabets and detokenization versions.
+// It neither decrypts s nor retrieves any key: dispatchers can use it to route tokens among engines
+// configured with different alphabets or version sets. Note that with alphabets containing digits some tokens are made of digits only and hence are
+// also valid credit cards (see WithDoubleTokenizationCheck).
+func (e *engine) IsToken(s string) bool {
+	if e.checkInputLength(s) != nil {
 		return false
 	}
-
-	// retrieve the alphabet for the encoding base
-	alpha, err := alphaProvider.GetAlphabetForBase(base)
+	detokVers, err := e.versioner.GetDetokenizationVersions()
 	if err != nil {
 		return false
 	}
-
-	// build the alpha map
-	alphaMap := make(map[byte]int, len(alpha))
-	for i, el := range alpha {
-		alphaMap[el] = i
+	// the MAC of FormatMAC tokens is not verified: it would retrieve the hmac key
+	if s, err = e.stripToken(s); err != nil {
+		return false
 	}
+	_, _, err = e.detokAlphabet(s, detokVers)
+	return err == nil
+}
 
-	// middle digits belong to alphabet in this base
-	middle := tk[7 : len(tk)-4]
-	for _, el := range middle {
-		_, ok := alphaMap[byte(el)]
-		if !ok {
-			return false
-		}
-	}
+// isValidCC returns true if string matches regex [0-9]{13,19}
+func isValidCC(cc string) bool {
+	// in real program might be worth considering having global/static regex
+	// and not build it each time
+	ccRe := regexp.MustCompile(`^[0-9]{13,19}$`)
+	return ccRe.Match([]byte(cc))
+}
 
-	// check in versioner if the key belong to the current 'Detokenization' keys
-	if !contains(vers, tk[6]) {
-		return false
-	}
+// isValidTK returns true if string matches token structure and its version is among vers
+func isValidTK(tk string, alphaProvider AlphabetProvider, vers []byte) bool {
+	return isStructurallyValidTK(tk, alphaProvider) && isAcceptedVersion(tk, vers)
+}
 
-	return true
-}
\ No newline at end of file
+// isStructurallyValidTK returns true if tk has the structure of a credit card token encoded with
+// alphaProvider: 6 leading and 4 trailing digits around a version char and middle chars of the alphabet
+// of the base of its length. The version itself is not checked (see isAcceptedVersion).
+func isStructurallyValidTK(tk string, alphaProvider AlphabetProvider) bool {
+	return checkNumericTKStructure(tk, CreditCardFormat, alphaProvider) == nil
+}
+
+// isAcceptedVersion returns true if the version of tk, a structurally valid credit card token (see
+// isStructurallyValidTK), is among the detokenization versions vers
+func isAcceptedVersion(tk string, vers []byte) bool {
+	return checkNumericTKVersion(tk, CreditCardFormat, vers) == nil
+}
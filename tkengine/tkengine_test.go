@@ -1,8 +1,15 @@
 package tkengine
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
 	"errors"
 	"fmt"
+	"hash"
+	"math"
+	"math/rand"
+	"strings"
 	"testing"
 )
 
@@ -39,76 +46,53 @@ func (f fixedKeyRepo) GetKey(_ byte) ([]byte, error) {
 	return f.key, nil
 }
 
-type missingBase14AlphaProvider struct{}
+// shortCardsDomain is the FF1 domain of the 3 middle digits of 13-digit cards, below DefaultMinDomainSize:
+// engines built WithMinDomainSize(shortCardsDomain) tokenize the cards of every length
+var shortCardsDomain uint64 = 1000
 
-func (d missingBase14AlphaProvider) GetAlphabetForBase(base uint32) ([]byte, error) {
-	b := map[uint32][]byte{
-		uint32(15): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'n', 'o'},
-		uint32(16): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'n', 'o', 'p'},
-		uint32(18): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'n', 'o', 'p', 'q', 'r'},
-		uint32(22): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'n', 'o', 'p', 'q', 'r', 's', 't', 'u', 'v'},
-		uint32(32): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'n', 'o', 'p', 'q', 'r', 's', 't', 'u', 'v', 'w', 'x', 'y', 'z', '0', '1', '2', '3', '4', '5'},
+// defaultAlphabetsWith returns the alphabets of DefaultAlphabetProvider with the alphabets of overrides,
+// an empty alphabet removing its base
+func defaultAlphabetsWith(overrides map[uint32]string) MapAlphabetProvider {
+	m := make(MapAlphabetProvider)
+	for _, base := range append([]uint32{12, 13}, RequiredAlphabetBases()...) {
+		m[base], _ = DefaultAlphabetProvider{}.GetAlphabetForBase(base)
 	}
-
-	alphabet, ok := b[base]
-	if !ok {
-		return []byte{}, errors.New(fmt.Sprintf("No availlable alphabet for base %d", base))
-	}
-
-	return alphabet, nil
-}
-
-type wrongSizeBase14AlphaProvider struct{}
-
-func (d wrongSizeBase14AlphaProvider) GetAlphabetForBase(base uint32) ([]byte, error) {
-	b := map[uint32][]byte{
-		uint32(14): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm'},
-		uint32(16): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'n', 'o', 'p'},
-		uint32(18): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'n', 'o', 'p', 'q', 'r'},
-		uint32(22): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'n', 'o', 'p', 'q', 'r', 's', 't', 'u', 'v'},
-		uint32(32): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'n', 'o', 'p', 'q', 'r', 's', 't', 'u', 'v', 'w', 'x', 'y', 'z', '0', '1', '2', '3', '4', '5'},
-	}
-
-	alphabet, ok := b[base]
-	if !ok {
-		return []byte{}, errors.New(fmt.Sprintf("No availlable alphabet for base %d", base))
+	for base, alpha := range overrides {
+		if alpha == "" {
+			delete(m, base)
+			continue
+		}
+		m[base] = []byte(alpha)
 	}
-
-	return alphabet, nil
+	return m
 }
 
-type duplicatedSymbolsBase14AlphaProvider struct{}
-
-func (d duplicatedSymbolsBase14AlphaProvider) GetAlphabetForBase(base uint32) ([]byte, error) {
-	b := map[uint32][]byte{
-		uint32(14): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'm'},
-		uint32(15): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'n', 'o'},
-		uint32(16): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'n', 'o', 'p'},
-		uint32(18): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'n', 'o', 'p', 'q', 'r'},
-		uint32(22): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'n', 'o', 'p', 'q', 'r', 's', 't', 'u', 'v'},
-		uint32(32): {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'n', 'o', 'p', 'q', 'r', 's', 't', 'u', 'v', 'w', 'x', 'y', 'z', '0', '1', '2', '3', '4', '5'},
-	}
-
-	alphabet, ok := b[base]
-	if !ok {
-		return []byte{}, errors.New(fmt.Sprintf("No availlable alphabet for base %d", base))
-	}
-
-	return alphabet, nil
-}
+var (
+	missingBase14AlphaProvider           = defaultAlphabetsWith(map[uint32]string{14: ""})
+	wrongSizeBase14AlphaProvider         = defaultAlphabetsWith(map[uint32]string{14: "abcdefghijklm"})
+	duplicatedSymbolsBase14AlphaProvider = defaultAlphabetsWith(map[uint32]string{14: "abcdefghijklmm"})
+	// 'é' is encoded in two bytes in UTF-8: 12 ASCII symbols + 2 bytes = 14 bytes
+	multibyteBase14AlphaProvider = defaultAlphabetsWith(map[uint32]string{14: "abcdefghijklé"})
+)
 
 func Test_bitsRequired(t *testing.T) {
 	tests := map[string]struct {
 		n    uint32
 		want uint32
 	}{
-		"99_7":        {99, 7},
-		"999_10":      {999, 10},
-		"9999_14":     {9999, 14},
-		"99999_17":    {99999, 17},
-		"999999_20":   {999999, 20},
-		"9999999_24":  {9999999, 24},
-		"99999999_24": {99999999, 27},
+		"0_0":          {0, 0},
+		"1_1":          {1, 1},
+		"8_4":          {8, 4},
+		"99_7":         {99, 7},
+		"999_10":       {999, 10},
+		"1024_11":      {1024, 11},
+		"9999_14":      {9999, 14},
+		"99999_17":     {99999, 17},
+		"999999_20":    {999999, 20},
+		"9999999_24":   {9999999, 24},
+		"99999999_27":  {99999999, 27},
+		"2pow31_32":    {1 << 31, 32},
+		"maxuint32_32": {math.MaxUint32, 32},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -125,14 +109,18 @@ func Test_encodeTkMD(t *testing.T) {
 		want       string
 		wantErr    bool
 	}{
-		"000_aa":          {"000", "aa", false},
-		"001_ab":          {"001", "ab", false},
-		"021_av":          {"021", "av", false},
-		"352_la":          {"352", "la", false},
-		"353_av":          {"353", "lb", false},
-		"00001_aaab":      {"00001", "aaab", false},
-		"too_short_error": {"53", "", true},
-		"too_long_error":  {"0123456789", "", true},
+		"000_aa":              {"000", "aa", false},
+		"001_ab":              {"001", "ab", false},
+		"021_av":              {"021", "av", false},
+		"352_la":              {"352", "la", false},
+		"353_lb":              {"353", "lb", false},
+		"00001_aaab":          {"00001", "aaab", false},
+		"999999999_max":       {"999999999", "jglelglf", false},
+		"too_short_error":     {"53", "", true},
+		"999999999999999_max": {"999999999999999", "jebkgieiiblifd", false},
+		"1234567890_base_13":  {"1234567890", "bgikaigfk", false},
+		"too_long_error":      {"01234567890123456789", "", true},
+		"19_digits_max":       {"9999999999999999999", "egbahkggkibhkbbafd", false},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -154,14 +142,22 @@ func Test_decodeTkMD(t *testing.T) {
 		want    string
 		wantErr bool
 	}{
-		"aa_000":          {"aa", "000", false},
-		"ab_001":          {"ab", "001", false},
-		"av_021":          {"av", "021", false},
-		"la_352":          {"la", "352", false},
-		"lb_353":          {"lb", "353", false},
-		"aaab_00001":      {"aaab", "00001", false},
-		"too_short_error": {"3", "", true},
-		"too_long_error":  {"012345678", "", true},
+		"aa_000":             {"aa", "000", false},
+		"ab_001":             {"ab", "001", false},
+		"av_021":             {"av", "021", false},
+		"la_352":             {"la", "352", false},
+		"lb_353":             {"lb", "353", false},
+		"aaab_00001":         {"aaab", "00001", false},
+		"too_short_error":    {"3", "", true},
+		"jebkgieiiblifd_max": {"jebkgieiiblifd", "999999999999999", false},
+		"bgikaigfk_base_13":  {"bgikaigfk", "1234567890", false},
+		"too_long_error":     {"aaaaaaaaaaaaaaaaaaa", "", true},
+		"19_digits_max":      {"egbahkggkibhkbbafd", "9999999999999999999", false},
+		"out_of_range_19":    {"egbahkggkibhkbbafe", "", true},
+		"uint64_overflow_19": {"llllllllllllllllll", "", true},
+		"999_boundary":       {"5h", "999", false},
+		"out_of_range_3":     {"5i", "", true},
+		"out_of_range_9":     {"nnnnnnnn", "", true},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -177,6 +173,33 @@ func Test_decodeTkMD(t *testing.T) {
 	}
 }
 
+func Test_encodeDecodeTkMD_allThreeDigits(t *testing.T) {
+	alpha, err := DefaultAlphabetProvider{}.GetAlphabetForBase(32)
+	if err != nil {
+		t.Fatalf("GetAlphabetForBase() error = %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		md := fmt.Sprintf("%03d", i)
+		want := string([]byte{alpha[i/32], alpha[i%32]})
+		got, err := encodeTkMD(md, DefaultAlphabetProvider{})
+		if err != nil {
+			t.Errorf("encodeTkMD(%s) error = %v", md, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("encodeTkMD(%s) got = %v, want %v", md, got, want)
+		}
+		dec, err := decodeTkMD(got, DefaultAlphabetProvider{})
+		if err != nil {
+			t.Errorf("decodeTkMD(%s) error = %v", got, err)
+			continue
+		}
+		if dec != md {
+			t.Errorf("decodeTkMD(%s) got = %v, want %v", got, dec, md)
+		}
+	}
+}
+
 func Test_engine_EncryptCC(t *testing.T) {
 
 	type fields struct {
@@ -420,7 +443,7 @@ func TestNewEngine(t *testing.T) {
 				},
 				encryptionKeys: fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
 				hmacKeys:       fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
-				alphaProvider:  missingBase14AlphaProvider{},
+				alphaProvider:  missingBase14AlphaProvider,
 			},
 			wantErr: true,
 		},
@@ -434,7 +457,7 @@ func TestNewEngine(t *testing.T) {
 				},
 				encryptionKeys: fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
 				hmacKeys:       fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
-				alphaProvider:  wrongSizeBase14AlphaProvider{},
+				alphaProvider:  wrongSizeBase14AlphaProvider,
 			},
 			wantErr: true,
 		},
@@ -448,7 +471,46 @@ func TestNewEngine(t *testing.T) {
 				},
 				encryptionKeys: fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
 				hmacKeys:       fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
-				alphaProvider:  duplicatedSymbolsBase14AlphaProvider{},
+				alphaProvider:  duplicatedSymbolsBase14AlphaProvider,
+			},
+			wantErr: true,
+		},
+		"error_due_to_tokenization_version_not_among_detokenization_versions": {
+			args: args{
+				versioner: deterministicVersioner{
+					tokVersion:    byte('e'),
+					detokVersions: []byte{'a', 'b', 'c', 'd'},
+				},
+				encryptionKeys: fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+				hmacKeys:       fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+				alphaProvider:  DefaultAlphabetProvider{},
+			},
+			wantErr: true,
+		},
+		"error_due_to_versioner_failure": {
+			args: args{
+				versioner: deterministicVersioner{
+					tokError:      true,
+					tokVersion:    byte('a'),
+					detokVersions: []byte{'a', 'b', 'c', 'd'},
+				},
+				encryptionKeys: fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+				hmacKeys:       fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+				alphaProvider:  DefaultAlphabetProvider{},
+			},
+			wantErr: true,
+		},
+		"error_due_to_multibyte_symbols_in_base_14_alphabet": {
+			args: args{
+				versioner: deterministicVersioner{
+					tokError:      false,
+					detokError:    false,
+					tokVersion:    byte('a'),
+					detokVersions: []byte{'a', 'b', 'c', 'd'},
+				},
+				encryptionKeys: fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+				hmacKeys:       fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+				alphaProvider:  multibyteBase14AlphaProvider,
 			},
 			wantErr: true,
 		},
@@ -464,3 +526,1358 @@ func TestNewEngine(t *testing.T) {
 	}
 }
 
+type formattedVersioner struct {
+	deterministicVersioner
+	formatErr bool
+	formats   map[byte]Format
+}
+
+func (f formattedVersioner) GetFormat(version byte) (Format, error) {
+	if f.formatErr {
+		return 0, errors.New("no available format")
+	}
+	return f.formats[version], nil
+}
+
+func Test_engine_formatRouting(t *testing.T) {
+	versioner := deterministicVersioner{
+		tokError:      false,
+		detokError:    false,
+		tokVersion:    byte('a'),
+		detokVersions: []byte{'a', 'b', 'c', 'd'},
+	}
+	tests := map[string]struct {
+		versioner  KeyVersioner
+		cc         string
+		tk         string
+		wantEncErr bool
+		wantDecErr bool
+	}{
+		"versioner_without_formats_is_format_v0": {
+			versioner: versioner,
+			cc:        "4444333322221111",
+			tk:        "444433aapchc1111",
+		},
+		"explicit_format_v0": {
+			versioner: formattedVersioner{deterministicVersioner: versioner, formats: map[byte]Format{'a': FormatV0}},
+			cc:        "4444333322221111",
+			tk:        "444433aapchc1111",
+		},
+		"format_v1": {
+			versioner: formattedVersioner{deterministicVersioner: versioner, formats: map[byte]Format{'a': FormatV1}},
+			cc:        "4444333322221111",
+			tk:        "444433anchfl1111",
+		},
+		"format_v1_13_digits": {
+			versioner: formattedVersioner{deterministicVersioner: versioner, formats: map[byte]Format{'a': FormatV1}},
+			cc:        "4444333322221",
+			tk:        "444433a1j2221",
+		},
+		"unsupported_format": {
+			versioner:  formattedVersioner{deterministicVersioner: versioner, formats: map[byte]Format{'a': Format(15)}},
+			cc:         "4444333322221111",
+			tk:         "444433aapchc1111",
+			wantEncErr: true,
+			wantDecErr: true,
+		},
+		"format_error": {
+			versioner:  formattedVersioner{deterministicVersioner: versioner, formatErr: true},
+			cc:         "4444333322221111",
+			tk:         "444433aapchc1111",
+			wantEncErr: true,
+			wantDecErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e := &engine{
+				minDomainSize:  &shortCardsDomain,
+				versioner:      tt.versioner,
+				encryptionKeys: fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+				hmacKeys:       fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+				alphaProvider:  DefaultAlphabetProvider{},
+			}
+			tk, err := e.EncryptCC(tt.cc)
+			if (err != nil) != tt.wantEncErr {
+				t.Errorf("EncryptCC() error = %v, wantErr %v", err, tt.wantEncErr)
+			}
+			if err == nil && tk != tt.tk {
+				t.Errorf("EncryptCC() got = %v, want %v", tk, tt.tk)
+			}
+			cc, err := e.DecryptTK(tt.tk)
+			if (err != nil) != tt.wantDecErr {
+				t.Errorf("DecryptTK() error = %v, wantErr %v", err, tt.wantDecErr)
+			}
+			if err == nil && cc != tt.cc {
+				t.Errorf("DecryptTK() got = %v, want %v", cc, tt.cc)
+			}
+		})
+	}
+}
+
+func Test_tweakInput(t *testing.T) {
+	b := []byte("4444333322221111")
+	tests := map[string]struct {
+		f    Format
+		p, s int
+		want string
+	}{
+		// the legacy construction, kept for the existing tokens
+		"format_v0":      {FormatV0, 6, 4, "444433\x00\x00\x00\x001111"},
+		"format_mac":     {FormatMAC, 6, 4, "444433\x00\x00\x00\x001111"},
+		"format_v1":      {FormatV1, 6, 4, "4444331111"},
+		"format_v1_8x4":  {FormatV1, 8, 4, "444433331111"},
+		"format_v1_no_s": {FormatV1, 6, 0, "444433"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tweakInputFor(tt.f, b, tt.p, tt.s); string(got) != tt.want {
+				t.Errorf("tweakInputFor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_concatWiped(t *testing.T) {
+	buf := make([]byte, 16)
+	if got := concatWiped(buf, "444433", "332222", "1111"); got != "4444333322221111" {
+		t.Errorf("concatWiped() = %v, want 4444333322221111", got)
+	}
+	// the assembled card doesn't stay in the buffer
+	if !bytes.Equal(buf, make([]byte, 16)) {
+		t.Errorf("concatWiped() left %v in the buffer, want it wiped", buf)
+	}
+}
+
+func Test_engine_RetokenizeToFormatV1(t *testing.T) {
+	versioner := formattedVersioner{
+		deterministicVersioner: deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a', 'b'}},
+		formats:                map[byte]Format{'a': FormatV1},
+	}
+	keys := fixedKeyRepo{false, make([]byte, 16)}
+	e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	// the FormatV0 token of version b is decrypted with the legacy tweak and encrypted with the FormatV1 one
+	if got, err := e.DecryptTK("444433bapchc1111"); err != nil || got != "4444333322221111" {
+		t.Errorf("DecryptTK() = %v, %v, want 4444333322221111", got, err)
+	}
+	if got, err := e.(Retokenizer).Retokenize("444433bapchc1111"); err != nil || got != "444433anchfl1111" {
+		t.Errorf("Retokenize() = %v, %v, want 444433anchfl1111", got, err)
+	}
+}
+
+func TestValidateEncryptionKey(t *testing.T) {
+	tests := map[string]struct {
+		key     []byte
+		wantErr bool
+	}{
+		"aes_128": {make([]byte, 16), false},
+		"aes_192": {make([]byte, 24), false},
+		"aes_256": {make([]byte, 32), false},
+		"empty":   {[]byte{}, true},
+		"15_byte": {make([]byte, 15), true},
+		"64_byte": {make([]byte, 64), true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := ValidateEncryptionKey(tt.key); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateEncryptionKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_engine_Retokenize(t *testing.T) {
+	keys := &keyRepo{keys: map[byte][]byte{
+		'a': {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		'b': {1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+	}}
+	tests := map[string]struct {
+		tokVersion byte
+		tk         string
+		wantCC     string
+		wantSame   bool
+		wantErr    bool
+	}{
+		"token_on_old_version_is_migrated": {
+			tokVersion: 'b',
+			tk:         "444433aapchc1111",
+			wantCC:     "4444333322221111",
+		},
+		"token_on_current_version_is_untouched": {
+			tokVersion: 'a',
+			tk:         "444433aapchc1111",
+			wantCC:     "4444333322221111",
+			wantSame:   true,
+		},
+		"invalid_input_TK": {
+			tokVersion: 'b',
+			tk:         "444333322221111",
+			wantErr:    true,
+		},
+		"token_on_version_not_available_for_detok": {
+			tokVersion: 'b',
+			tk:         "444433fapchc1111",
+			wantErr:    true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e := &engine{
+				versioner: deterministicVersioner{
+					tokVersion:    tt.tokVersion,
+					detokVersions: []byte{'a', 'b'},
+				},
+				encryptionKeys: keys,
+				hmacKeys:       keys,
+				alphaProvider:  DefaultAlphabetProvider{},
+			}
+			got, err := e.Retokenize(tt.tk)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Retokenize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if (got == tt.tk) != tt.wantSame {
+				t.Errorf("Retokenize() got = %v, input %v, want same %v", got, tt.tk, tt.wantSame)
+			}
+			if got[6] != tt.tokVersion {
+				t.Errorf("Retokenize() got version = %s, want %s", string(got[6]), string(tt.tokVersion))
+			}
+			cc, err := e.DecryptTK(got)
+			if err != nil {
+				t.Errorf("DecryptTK() error = %v", err)
+				return
+			}
+			if cc != tt.wantCC {
+				t.Errorf("DecryptTK() got = %v, want %v", cc, tt.wantCC)
+			}
+		})
+	}
+}
+
+type upperAlphaProvider struct{}
+
+func (u upperAlphaProvider) GetAlphabetForBase(base uint32) ([]byte, error) {
+	alpha, err := DefaultAlphabetProvider{}.GetAlphabetForBase(base)
+	if err != nil {
+		return nil, err
+	}
+	upper := []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZ6789!?")
+	return upper[:len(alpha)], nil
+}
+
+func TestNewEngineWithAlphabets(t *testing.T) {
+	versioner := deterministicVersioner{
+		tokVersion:    byte('a'),
+		detokVersions: []byte{'a', 'b', 'c', 'd'},
+	}
+	keys := fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+	tests := map[string]struct {
+		tokAlpha   AlphabetProvider
+		detokAlpha AlphabetProvider
+		wantErr    bool
+	}{
+		"disjoint_alphabets":  {upperAlphaProvider{}, DefaultAlphabetProvider{}, false},
+		"identical_alphabets": {DefaultAlphabetProvider{}, DefaultAlphabetProvider{}, false},
+		"overlapping_alphabets": {
+			tokAlpha:   DefaultAlphabetProvider{},
+			detokAlpha: duplicatedSymbolsBase14AlphaProvider,
+			wantErr:    true,
+		},
+		"invalid_tok_alphabet":   {missingBase14AlphaProvider, DefaultAlphabetProvider{}, true},
+		"invalid_detok_alphabet": {DefaultAlphabetProvider{}, wrongSizeBase14AlphaProvider, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewEngineWithAlphabets(versioner, keys, keys, tt.tokAlpha, tt.detokAlpha)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewEngineWithAlphabets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewEngineWithDefaultAlphabet(t *testing.T) {
+	versioner := deterministicVersioner{
+		tokVersion:    byte('a'),
+		detokVersions: []byte{'a', 'b', 'c', 'd'},
+	}
+	keys := fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+	tests := map[string]struct {
+		versioner      KeyVersioner
+		encryptionKeys KeyRepo
+		hmacKeys       KeyRepo
+		wantErr        bool
+	}{
+		"valid_dependencies":  {versioner, keys, keys, false},
+		"nil_versioner":       {nil, keys, keys, true},
+		"nil_encryption_keys": {versioner, nil, keys, true},
+		"nil_hmac_keys":       {versioner, keys, nil, true},
+		"typed_nil_keys":      {versioner, (*keyRepo)(nil), keys, true},
+		"typed_nil_versioner": {(*RefreshableKeyring)(nil), keys, keys, true},
+		"invalid_versioner":   {deterministicVersioner{tokVersion: 'z', detokVersions: []byte{'a'}}, keys, keys, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngineWithDefaultAlphabet(tt.versioner, tt.encryptionKeys, tt.hmacKeys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewEngineWithDefaultAlphabet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.HasPrefix(name, "nil_") || strings.HasPrefix(name, "typed_nil_") {
+				if !errors.Is(err, ErrMisconfiguredEngine) {
+					t.Errorf("NewEngineWithDefaultAlphabet() error = %v, want ErrMisconfiguredEngine", err)
+				}
+			}
+			if err != nil {
+				return
+			}
+			if got, err := e.EncryptCC("4444333322221111"); err != nil || got != "444433aapchc1111" {
+				t.Errorf("EncryptCC() got = %v, %v, want 444433aapchc1111", got, err)
+			}
+		})
+	}
+}
+
+func TestNewEngine_nilAlphabetProvider(t *testing.T) {
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	keys := fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+	tests := map[string]struct {
+		tokAlpha   AlphabetProvider
+		detokAlpha AlphabetProvider
+	}{
+		"nil_tokenization_alphabet":   {nil, DefaultAlphabetProvider{}},
+		"nil_detokenization_alphabet": {DefaultAlphabetProvider{}, nil},
+		"nil_map_alphabet":            {MapAlphabetProvider(nil), DefaultAlphabetProvider{}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if tt.detokAlpha != nil {
+				if _, err := NewEngine(versioner, keys, keys, tt.tokAlpha); !errors.Is(err, ErrMisconfiguredEngine) {
+					t.Errorf("NewEngine() error = %v, want ErrMisconfiguredEngine", err)
+				}
+			}
+			if _, err := NewEngineWithAlphabets(versioner, keys, keys, tt.tokAlpha, tt.detokAlpha); !errors.Is(err, ErrMisconfiguredEngine) {
+				t.Errorf("NewEngineWithAlphabets() error = %v, want ErrMisconfiguredEngine", err)
+			}
+		})
+	}
+}
+
+func TestNewDummyEngine_optionalInterfaces(t *testing.T) {
+	e, err := NewDummyEngine(AllowInsecureDummyKeys())
+	if err != nil {
+		t.Fatalf("NewDummyEngine() error = %v", err)
+	}
+	tests := map[string]func(e TKEngine) bool{
+		"Retokenizer":         func(e TKEngine) bool { _, ok := e.(Retokenizer); return ok },
+		"MiddleTokenizer":     func(e TKEngine) bool { _, ok := e.(MiddleTokenizer); return ok },
+		"InjectivityVerifier": func(e TKEngine) bool { _, ok := e.(InjectivityVerifier); return ok },
+		"ShadowTokenizer":     func(e TKEngine) bool { _, ok := e.(ShadowTokenizer); return ok },
+		"ConfigExporter":      func(e TKEngine) bool { _, ok := e.(ConfigExporter); return ok },
+		"Warmer":              func(e TKEngine) bool { _, ok := e.(Warmer); return ok },
+		"TweakRewrapper":      func(e TKEngine) bool { _, ok := e.(TweakRewrapper); return ok },
+		"StatsReporter":       func(e TKEngine) bool { _, ok := e.(StatsReporter); return ok },
+		"InsecureReporter":    func(e TKEngine) bool { _, ok := e.(InsecureReporter); return ok },
+		"CacheClearer":        func(e TKEngine) bool { _, ok := e.(CacheClearer); return ok },
+		"TokenEnumerator":     func(e TKEngine) bool { _, ok := e.(TokenEnumerator); return ok },
+		"FIPSReporter":        func(e TKEngine) bool { _, ok := e.(FIPSReporter); return ok },
+		"MaskedDecrypter":     func(e TKEngine) bool { _, ok := e.(MaskedDecrypter); return ok },
+		"AADTokenizer":        func(e TKEngine) bool { _, ok := e.(AADTokenizer); return ok },
+		"CapacityPlanner":     func(e TKEngine) bool { _, ok := e.(CapacityPlanner); return ok },
+		"TokenInspector":      func(e TKEngine) bool { _, ok := e.(TokenInspector); return ok },
+		"NumericTokenizer":    func(e TKEngine) bool { _, ok := e.(NumericTokenizer); return ok },
+	}
+	for name, implements := range tests {
+		if !implements(e) {
+			t.Errorf("engine does not implement %s", name)
+		}
+	}
+}
+
+func Test_engine_checkConfigured(t *testing.T) {
+	keys := fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	tests := map[string]*engine{
+		"nil_engine":          nil,
+		"zero_engine":         {},
+		"nil_encryption_keys": {versioner: versioner, hmacKeys: keys, alphaProvider: DefaultAlphabetProvider{}},
+		"nil_hmac_keys":       {versioner: versioner, encryptionKeys: keys, alphaProvider: DefaultAlphabetProvider{}},
+		"nil_alphabet":        {versioner: versioner, encryptionKeys: keys, hmacKeys: keys},
+	}
+	for name, e := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := e.EncryptCC("4444333322221111"); !errors.Is(err, ErrMisconfiguredEngine) {
+				t.Errorf("EncryptCC() error = %v, want ErrMisconfiguredEngine", err)
+			}
+			if _, err := e.DecryptTK("444433aapchc1111"); !errors.Is(err, ErrMisconfiguredEngine) {
+				t.Errorf("DecryptTK() error = %v, want ErrMisconfiguredEngine", err)
+			}
+			if _, err := e.DecryptTKMasked("444433aapchc1111"); !errors.Is(err, ErrMisconfiguredEngine) {
+				t.Errorf("DecryptTKMasked() error = %v, want ErrMisconfiguredEngine", err)
+			}
+			if _, err := e.EncryptNumeric("4444333322221111", CreditCardFormat); !errors.Is(err, ErrMisconfiguredEngine) {
+				t.Errorf("EncryptNumeric() error = %v, want ErrMisconfiguredEngine", err)
+			}
+			if _, err := e.DecryptNumeric("444433aapchc1111", CreditCardFormat); !errors.Is(err, ErrMisconfiguredEngine) {
+				t.Errorf("DecryptNumeric() error = %v, want ErrMisconfiguredEngine", err)
+			}
+			if _, err := e.Retokenize("444433aapchc1111"); !errors.Is(err, ErrMisconfiguredEngine) {
+				t.Errorf("Retokenize() error = %v, want ErrMisconfiguredEngine", err)
+			}
+			if _, errs := e.RetokenizeBatch([]string{"444433aapchc1111"}, nil); !errors.Is(errs[0], ErrMisconfiguredEngine) {
+				t.Errorf("RetokenizeBatch() error = %v, want ErrMisconfiguredEngine", errs[0])
+			}
+		})
+	}
+}
+
+func Test_engine_alphabetMigration(t *testing.T) {
+	versioner := deterministicVersioner{
+		tokVersion:    byte('a'),
+		detokVersions: []byte{'a', 'b', 'c', 'd'},
+	}
+	keys := fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+	e, err := NewEngineWithAlphabets(versioner, keys, keys, upperAlphaProvider{}, DefaultAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngineWithAlphabets() error = %v", err)
+	}
+
+	// new tokens are produced in the tokenization alphabet
+	tk, err := e.EncryptCC("4444333322221111")
+	if err != nil {
+		t.Fatalf("EncryptCC() error = %v", err)
+	}
+	if want := "444433aAPCHC1111"; tk != want {
+		t.Errorf("EncryptCC() got = %v, want %v", tk, want)
+	}
+
+	// both old and new tokens are decryptable
+	for _, tk := range []string{"444433aapchc1111", "444433aAPCHC1111"} {
+		cc, err := e.DecryptTK(tk)
+		if err != nil {
+			t.Errorf("DecryptTK(%s) error = %v", tk, err)
+			continue
+		}
+		if want := "4444333322221111"; cc != want {
+			t.Errorf("DecryptTK(%s) got = %v, want %v", tk, cc, want)
+		}
+	}
+
+	// old tokens are migrated to the new alphabet, new tokens are untouched
+	for _, tk := range []string{"444433aapchc1111", "444433aAPCHC1111"} {
+		got, err := e.(Retokenizer).Retokenize(tk)
+		if err != nil {
+			t.Errorf("Retokenize(%s) error = %v", tk, err)
+			continue
+		}
+		if want := "444433aAPCHC1111"; got != want {
+			t.Errorf("Retokenize(%s) got = %v, want %v", tk, got, want)
+		}
+	}
+}
+
+type panickingKeyRepo struct{}
+
+func (p panickingKeyRepo) GetKey(_ byte) ([]byte, error) {
+	panic("corrupted key repository")
+}
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (r *recordingLogger) Printf(format string, v ...interface{}) {
+	r.lines = append(r.lines, fmt.Sprintf(format, v...))
+}
+
+func Test_engine_recoversCipherPanics(t *testing.T) {
+	logger := &recordingLogger{}
+	e, err := NewEngine(deterministicVersioner{
+		tokVersion:    byte('a'),
+		detokVersions: []byte{'a', 'b', 'c', 'd'},
+	}, panickingKeyRepo{}, panickingKeyRepo{}, DefaultAlphabetProvider{}, WithLogger(logger))
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	tests := map[string]func() (string, error){
+		"EncryptCC":  func() (string, error) { return e.EncryptCC("4444333322221111") },
+		"DecryptTK":  func() (string, error) { return e.DecryptTK("444433aapchc1111") },
+		"Retokenize": func() (string, error) { return e.(Retokenizer).Retokenize("444433bapchc1111") },
+		"EncryptNumeric": func() (string, error) {
+			return e.(NumericTokenizer).EncryptNumeric("4444333322221111", CreditCardFormat)
+		},
+		"DecryptNumeric": func() (string, error) {
+			return e.(NumericTokenizer).DecryptNumeric("444433aapchc1111", CreditCardFormat)
+		},
+	}
+	for name, op := range tests {
+		t.Run(name, func(t *testing.T) {
+			logger.lines = nil
+			got, err := op()
+			if !errors.Is(err, ErrInternalCipherFailure) {
+				t.Errorf("%s() error = %v, want %v", name, err, ErrInternalCipherFailure)
+			}
+			if got != "" {
+				t.Errorf("%s() got = %v, want empty", name, got)
+			}
+			if len(logger.lines) != 1 || !strings.Contains(logger.lines[0], "corrupted key repository") {
+				t.Errorf("%s() logged %v, want the recovered panic", name, logger.lines)
+			}
+		})
+	}
+}
+
+func TestEncodeDecodeMiddleDigits(t *testing.T) {
+	tests := map[string]struct {
+		digits  string
+		encoded string
+		wantErr bool
+	}{
+		"3_digits":        {"353", "lb", false},
+		"5_digits":        {"00001", "aaab", false},
+		"9_digits":        {"000000000", "aaaaaaaa", false},
+		"too_short_error": {"53", "", true},
+		"too_long_error":  {"01234567890123456789", "", true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := EncodeMiddleDigits(tt.digits, DefaultAlphabetProvider{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("EncodeMiddleDigits() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if encoded != tt.encoded {
+				t.Errorf("EncodeMiddleDigits() got = %v, want %v", encoded, tt.encoded)
+			}
+			digits, err := DecodeMiddleDigits(encoded, DefaultAlphabetProvider{})
+			if err != nil {
+				t.Errorf("DecodeMiddleDigits() error = %v", err)
+				return
+			}
+			if digits != tt.digits {
+				t.Errorf("DecodeMiddleDigits() got = %v, want %v", digits, tt.digits)
+			}
+		})
+	}
+}
+
+func Test_engine_minDomainSize(t *testing.T) {
+	tests := map[string]struct {
+		opts    []Option
+		cc      string
+		wantErr bool
+	}{
+		"default_rejects_15_digits":    {nil, "444433332222111", true},
+		"default_accepts_16_digits":    {nil, "4444333322221111", false},
+		"1000_accepts_13_digits":       {[]Option{WithMinDomainSize(1000)}, "4444333322221", false},
+		"1001_rejects_13_digits":       {[]Option{WithMinDomainSize(1001)}, "4444333322221", true},
+		"1e9_accepts_19_digits":        {[]Option{WithMinDomainSize(1e9)}, "4444333322221111222", false},
+		"1e10_rejects_all_card_length": {[]Option{WithMinDomainSize(1e10)}, "4444333322221111222", true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngineWithDefaultAlphabet(deterministicVersioner{
+				tokVersion:    byte('a'),
+				detokVersions: []byte{'a', 'b', 'c', 'd'},
+			},
+				fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+				fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+				tt.opts...)
+			if err != nil {
+				t.Fatalf("NewEngineWithDefaultAlphabet() error = %v", err)
+			}
+			_, err = e.EncryptCC(tt.cc)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("EncryptCC() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !errors.Is(err, ErrDomainTooSmall) {
+				t.Errorf("EncryptCC() error = %v, want %v", err, ErrDomainTooSmall)
+			}
+		})
+	}
+}
+
+func TestWithMinDomainSize_zero(t *testing.T) {
+	keys := fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	if _, err := NewEngineWithDefaultAlphabet(versioner, keys, keys, WithMinDomainSize(0)); err == nil {
+		t.Errorf("NewEngineWithDefaultAlphabet() with WithMinDomainSize(0) expected an error")
+	}
+}
+
+func Test_engine_checkDomain_radix(t *testing.T) {
+	e := &engine{minDomainSize: new(uint64)}
+	*e.minDomainSize = 100
+	tests := map[string]struct {
+		opts    FormatOpts
+		l       int
+		wantErr bool
+	}{
+		"radix_2_2_symbols":   {FormatOpts{MinLength: 2, MaxLength: 10, Radix: 2}, 2, true},
+		"radix_2_7_symbols":   {FormatOpts{MinLength: 2, MaxLength: 10, Radix: 2}, 7, false},
+		"radix_10_2_symbols":  {FormatOpts{MinLength: 2, MaxLength: 10}, 2, false},
+		"radix_36_19_symbols": {FormatOpts{MinLength: 2, MaxLength: 19, Radix: 36}, 19, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := e.checkDomain(tt.l, tt.opts); (err != nil) != tt.wantErr || err != nil && !errors.Is(err, ErrDomainTooSmall) {
+				t.Errorf("checkDomain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewDummyEngine_WithRandSource(t *testing.T) {
+	tokenize := func(seed int64) []string {
+		e, err := NewDummyEngine(WithRandSource(rand.NewSource(seed)))
+		if err != nil {
+			t.Fatalf("NewDummyEngine() error = %v", err)
+		}
+		tks := make([]string, 0, 10)
+		for i := 0; i < 10; i++ {
+			tk, err := e.EncryptCC("4444333322221111")
+			if err != nil {
+				t.Fatalf("EncryptCC() error = %v", err)
+			}
+			tks = append(tks, tk)
+		}
+		return tks
+	}
+
+	got, again := tokenize(42), tokenize(42)
+	versions := rand.New(rand.NewSource(42))
+	for i := range got {
+		if got[i] != again[i] {
+			t.Errorf("EncryptCC() #%d got = %v and %v with the same seed", i, got[i], again[i])
+		}
+		if want := []byte{'a', 'b', 'c', 'd'}[versions.Intn(4)]; got[i][6] != want {
+			t.Errorf("EncryptCC() #%d got = %v, want version %s", i, got[i], string(want))
+		}
+	}
+}
+
+// oversizedHash is a hash whose output exceeds the FF1 tweak limit
+type oversizedHash struct {
+	hash.Hash
+}
+
+func (oversizedHash) Size() int { return 2 * MaxTweakLength }
+
+func Test_engine_tweakHash(t *testing.T) {
+	versioner := deterministicVersioner{tokVersion: byte('a'), detokVersions: []byte{'a'}}
+	keys := fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+	tests := map[string]struct {
+		opts    []Option
+		want    string
+		wantErr bool
+	}{
+		"default_sha256":  {nil, "444433aapchc1111", false},
+		"explicit_sha256": {[]Option{WithTweakHash(sha256.New)}, "444433aapchc1111", false},
+		"sha512":          {[]Option{WithTweakHash(sha512.New)}, "", false},
+		"oversized_hash":  {[]Option{WithTweakHash(func() hash.Hash { return oversizedHash{sha512.New()} })}, "", true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewEngine() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			tk, err := e.EncryptCC("4444333322221111")
+			if err != nil {
+				t.Errorf("EncryptCC() error = %v", err)
+				return
+			}
+			if tt.want != "" && tk != tt.want {
+				t.Errorf("EncryptCC() got = %v, want %v", tk, tt.want)
+			}
+			if tt.want == "" && tk == "444433aapchc1111" {
+				t.Errorf("EncryptCC() got = %v, want a token depending on the hash", tk)
+			}
+			cc, err := e.DecryptTK(tk)
+			if err != nil || cc != "4444333322221111" {
+				t.Errorf("DecryptTK() got = %v, %v, want 4444333322221111", cc, err)
+			}
+		})
+	}
+
+	if _, err := NewDummyEngine(WithTweakHash(func() hash.Hash { return oversizedHash{sha512.New()} })); err == nil {
+		t.Errorf("NewDummyEngine() expected error for oversized tweak hash")
+	}
+}
+
+func Test_engine_withHMACCache(t *testing.T) {
+	e := &engine{}
+	cached := e.withHMACCache()
+	versions := []byte{'a', 'b', 'a', 'c', 'b'}
+	keys := [][]byte{{0, 0, 0, 0}, {1, 1, 1, 1}, {0, 0, 0, 0}, {2, 2}, {1, 1, 1, 1}}
+	inputs := [][]byte{[]byte("4444331111"), []byte("5555442222"), []byte("4444331111"), []byte("4444331111"), []byte("6666553333")}
+	for i, hkey := range keys {
+		want, err := e.tweak(versions[i], hkey, inputs[i])
+		if err != nil {
+			t.Fatalf("tweak() error = %v", err)
+		}
+		got, err := cached.tweak(versions[i], hkey, inputs[i])
+		if err != nil {
+			t.Fatalf("tweak() error = %v", err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("cached tweak(%v, %s) = %x, want %x", hkey, inputs[i], got, want)
+		}
+	}
+	if len(cached.hmacs) != 3 {
+		t.Errorf("got %d cached HMACs, want one per version", len(cached.hmacs))
+	}
+	if e.hmacs != nil {
+		t.Errorf("the engine HMACs should not be cached outside batches")
+	}
+}
+
+func TestEncodingCapacity(t *testing.T) {
+	tests := map[string]struct {
+		middleLen    int
+		wantDomain   uint64
+		wantCodomain uint64
+		wantErr      bool
+	}{
+		"3_digits_base_32":    {3, 1000, 1024, false},
+		"4_digits_base_22":    {4, 10000, 10648, false},
+		"5_digits_base_18":    {5, 100000, 104976, false},
+		"6_digits_base_16":    {6, 1000000, 1048576, false},
+		"7_digits_base_15":    {7, 10000000, 11390625, false},
+		"8_digits_base_14":    {8, 100000000, 105413504, false},
+		"9_digits_base_14":    {9, 1000000000, 1475789056, false},
+		"too_short_error":     {2, 0, 0, true},
+		"too_long_error":      {20, 0, 0, true},
+		"18_digits_base_12":   {18, 1000000000000000000, 2218611106740436992, false},
+		"19_digits_saturated": {19, 10000000000000000000, math.MaxUint64, false},
+		"10_digits_base_13":   {10, 10000000000, 10604499373, false},
+		"15_digits_base_12":   {15, 1000000000000000, 1283918464548864, false},
+		"negative_len_error":  {-1, 0, 0, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			domain, codomain, bijective, err := EncodingCapacity(tt.middleLen)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("EncodingCapacity() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if domain != tt.wantDomain || codomain != tt.wantCodomain {
+				t.Errorf("EncodingCapacity() got = (%d, %d), want (%d, %d)", domain, codomain, tt.wantDomain, tt.wantCodomain)
+			}
+			// the encoding never loses information for the supported lengths
+			if bijective != !tt.wantErr {
+				t.Errorf("EncodingCapacity() bijective = %v, want %v", bijective, !tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_engine_MaxDistinctTokens(t *testing.T) {
+	tests := map[string]struct {
+		ccLen         int
+		minDomainSize uint64
+		want          uint64
+	}{
+		"13_digits":        {ccLen: 13, want: 10240000000000},
+		"14_digits":        {ccLen: 14, want: 106480000000000},
+		"15_digits":        {ccLen: 15, want: 1049760000000000},
+		"16_digits":        {ccLen: 16, want: 10485760000000000},
+		"17_digits":        {ccLen: 17, want: 113906250000000000},
+		"18_digits":        {ccLen: 18, want: 1054135040000000000},
+		"19_digits":        {ccLen: 19, want: 14757890560000000000},
+		"too_short":        {ccLen: 12, want: 0},
+		"too_long":         {ccLen: 20, want: 0},
+		"below_min_domain": {ccLen: 15, minDomainSize: DefaultMinDomainSize, want: 0},
+		"at_min_domain":    {ccLen: 16, minDomainSize: DefaultMinDomainSize, want: 10485760000000000},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			opts := []Option{WithMinDomainSize(1000)}
+			if tt.minDomainSize != 0 {
+				opts = []Option{WithMinDomainSize(tt.minDomainSize)}
+			}
+			e, err := NewEngineWithDefaultAlphabet(deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, fixedKeyRepo{}, fixedKeyRepo{}, opts...)
+			if err != nil {
+				t.Fatalf("NewEngineWithDefaultAlphabet() error = %v", err)
+			}
+			if got := e.(CapacityPlanner).MaxDistinctTokens(tt.ccLen); got != tt.want {
+				t.Errorf("MaxDistinctTokens(%d) = %d, want %d", tt.ccLen, got, tt.want)
+			}
+		})
+	}
+}
+
+// shortBase32AlphaProvider misses one symbol in base 32: 31^2 < 10^3
+type shortBase32AlphaProvider struct{}
+
+func (shortBase32AlphaProvider) GetAlphabetForBase(base uint32) ([]byte, error) {
+	alpha, err := DefaultAlphabetProvider{}.GetAlphabetForBase(base)
+	if base == 32 {
+		return alpha[:31], err
+	}
+	return alpha, err
+}
+
+func Test_validateEncodingCapacity(t *testing.T) {
+	_, err := NewEngine(deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, &keyRepo{}, &keyRepo{}, shortBase32AlphaProvider{})
+	if err == nil || !strings.Contains(err.Error(), "can't encode 3 middle digits") {
+		t.Errorf("NewEngine() error = %v, want an encoding capacity error", err)
+	}
+	if err := validateEncodingCapacity(DefaultAlphabetProvider{}); err != nil {
+		t.Errorf("validateEncodingCapacity() error = %v", err)
+	}
+	// unvalidated alphabets fail instead of corrupting the encoding
+	if got, err := EncodeMiddleDigits("999", shortBase32AlphaProvider{}); err == nil {
+		t.Errorf("EncodeMiddleDigits() got = %v, want error", got)
+	}
+	if got, err := DecodeMiddleDigits("aa", shortBase32AlphaProvider{}); err == nil {
+		t.Errorf("DecodeMiddleDigits() got = %v, want error", got)
+	}
+}
+
+// digitsFirstAlphabetProvider provides alphabets starting with the 10 digits
+type digitsFirstAlphabetProvider struct{}
+
+func (digitsFirstAlphabetProvider) GetAlphabetForBase(base uint32) ([]byte, error) {
+	return []byte("0123456789abcdefghijklmnopqrstuv"[:base]), nil
+}
+
+func Test_engine_invalidInputDiagnostics(t *testing.T) {
+	keys := fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+	e, err := NewEngine(deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, keys, keys, DefaultAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	tests := map[string]struct {
+		op      func(s string) error
+		input   string
+		wantErr error
+		want    string
+	}{
+		"cc_too_short": {
+			op:      func(s string) error { _, err := e.EncryptCC(s); return err },
+			input:   "444433332222",
+			wantErr: ErrInvalidCC,
+			want:    "Invalid CC format: length 12 out of range [13, 19]",
+		},
+		"cc_non_numeric": {
+			op:      func(s string) error { _, err := e.EncryptCC(s); return err },
+			input:   "44443333222211x1",
+			wantErr: ErrInvalidCC,
+			want:    "Invalid CC format: non-numeric characters",
+		},
+		"tk_too_long": {
+			op:      func(s string) error { _, err := e.DecryptTK(s); return err },
+			input:   "444433aapchc11112222",
+			wantErr: ErrInvalidTK,
+			want:    "Invalid TK format: length 20 out of range [13, 19]",
+		},
+		"tk_non_numeric_prefix": {
+			op:      func(s string) error { _, err := e.DecryptTK(s); return err },
+			input:   "4x4433aapchc1111",
+			wantErr: ErrInvalidTK,
+			want:    "Invalid TK format: non-numeric prefix or suffix",
+		},
+		"tk_non_numeric_suffix": {
+			op:      func(s string) error { _, err := e.(Retokenizer).Retokenize(s); return err },
+			input:   "444433aapchc111x",
+			wantErr: ErrInvalidTK,
+			want:    "Invalid TK format: non-numeric prefix or suffix",
+		},
+		"tk_alphabet_mismatch": {
+			op:      func(s string) error { _, err := e.DecryptTK(s); return err },
+			input:   "444433aaPchc1111",
+			wantErr: ErrInvalidTK,
+			want:    "Invalid TK format: alphabet mismatch, middle characters outside the base 16 alphabet",
+		},
+		"tk_unknown_version": {
+			op:      func(s string) error { _, err := e.(TokenInspector).TokenVersion(s); return err },
+			input:   "444433bapchc1111",
+			wantErr: ErrVersionExpired,
+			want:    "Invalid TK format: version expired: version b not in the detokenization versions",
+		},
+		"tk_invalid_version": {
+			op:      func(s string) error { _, err := e.DecryptTK(s); return err },
+			input:   "444433\x01apchc1111",
+			wantErr: ErrInvalidTK,
+			want:    "Invalid TK format: invalid version byte 1",
+		},
+		"numeric_non_radix": {
+			op: func(s string) error {
+				_, err := e.(NumericTokenizer).EncryptNumeric(s, FormatOpts{MinLength: 10, MaxLength: 10, PreservedPrefix: 2, PreservedSuffix: 2, Radix: 16, Alphabet: printableAlphabetProvider{}})
+				return err
+			},
+			input:   "00ff00ff0g",
+			wantErr: ErrInvalidValue,
+			want:    "Invalid value format: non radix 16 characters",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tt.op(tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("error = %q, want %q", err.Error(), tt.want)
+			}
+			// the raw input never appears in the error
+			if strings.Contains(err.Error(), tt.input) {
+				t.Errorf("error %q contains the input", err.Error())
+			}
+		})
+	}
+}
+
+func Test_engine_DecryptTKMasked(t *testing.T) {
+	keys := fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+	e, err := NewEngine(deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, keys, keys, DefaultAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	tests := map[string]struct {
+		tk      string
+		want    string
+		wantErr bool
+	}{
+		"nominal":        {tk: "444433aapchc1111", want: "444433******1111"},
+		"invalid_token":  {tk: "444433zapchc1111", wantErr: true},
+		"invalid_length": {tk: "4444", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := e.(MaskedDecrypter).DecryptTKMasked(tt.tk)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecryptTKMasked() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DecryptTKMasked() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskPAN(t *testing.T) {
+	tests := map[string]struct {
+		cc   string
+		want string
+	}{
+		"13_digits": {"4444333322221", "444433***2221"},
+		"16_digits": {"4444333322221111", "444433******1111"},
+		"19_digits": {"4444333322221111222", "444433*********1222"},
+		"short":     {"4444333322", "**********"},
+		"empty":     {"", ""},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := MaskPAN(tt.cc); got != tt.want {
+				t.Errorf("MaskPAN(%v) = %v, want %v", tt.cc, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsCC_IsToken(t *testing.T) {
+	e := &engine{
+		versioner: deterministicVersioner{
+			tokVersion:    byte('a'),
+			detokVersions: []byte{'a', 'b'},
+		},
+		alphaProvider: DefaultAlphabetProvider{},
+	}
+	tests := map[string]struct {
+		s         string
+		wantCC    bool
+		wantToken bool
+	}{
+		"credit_card":         {"4444333322221111", true, false},
+		"token":               {"444433aapchc1111", false, true},
+		"token_other_version": {"444433bapchc1111", false, true},
+		"token_wrong_version": {"444433capchc1111", false, false},
+		"too_short":           {"444433", false, false},
+		"empty":               {"", false, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := IsCC(tt.s); got != tt.wantCC {
+				t.Errorf("IsCC() got = %v, want %v", got, tt.wantCC)
+			}
+			if got := e.IsToken(tt.s); got != tt.wantToken {
+				t.Errorf("IsToken() got = %v, want %v", got, tt.wantToken)
+			}
+		})
+	}
+}
+
+func Test_engine_versionCollidingWithAlphabet(t *testing.T) {
+	keys := fixedKeyRepo{false, make([]byte, 16)}
+	// a and b are the first symbols of every alphabet
+	e, err := NewEngine(deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, keys, keys, DefaultAlphabetProvider{}, WithMinDomainSize(shortCardsDomain))
+	if err != nil {
+		t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+	}
+	tests := map[string]struct {
+		tk   string
+		want bool
+	}{
+		"version_repeated_in_middle": {"444433aaaaaa1111", true},
+		"version_absent_from_middle": {"444433abbbbb1111", true},
+		"version_only_in_middle":     {"444433baaaaa1111", false},
+		"version_shifted_right":      {"4444330aaaaa1111", false},
+		"13_digits":                  {"444433aaa1111", true},
+		"18_digits":                  {"444433aaaaaaaa1111", true},
+		"19_digits":                  {"444433aaaaaaaaa1111", true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := e.(TokenInspector).IsToken(tt.tk); got != tt.want {
+				t.Fatalf("IsToken(%v) = %v, want %v", tt.tk, got, tt.want)
+			}
+			if !tt.want {
+				return
+			}
+			// the middle chars equal to the version char are decoded as middle chars
+			d, err := e.(TokenInspector).DescribeToken(tt.tk)
+			if err != nil || d.Version != 'a' || d.MiddleLength != len(tt.tk)-10 {
+				t.Errorf("DescribeToken(%v) = %+v, %v, want version a and %d middle digits", tt.tk, d, err, len(tt.tk)-10)
+			}
+			cc, err := e.DecryptTK(tt.tk)
+			if err != nil {
+				t.Fatalf("DecryptTK(%v) error = %v", tt.tk, err)
+			}
+			if got, err := e.EncryptCC(cc); err != nil || got != tt.tk {
+				t.Errorf("EncryptCC(%v) = %v, %v, want %v", cc, got, err, tt.tk)
+			}
+		})
+	}
+}
+
+func Test_engine_doubleTokenizationCheck(t *testing.T) {
+	keys := fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+	versioner := deterministicVersioner{tokVersion: byte('7'), detokVersions: []byte{'7'}}
+	e, err := NewEngine(versioner, keys, keys, digitsFirstAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	checked, err := NewEngine(versioner, keys, keys, digitsFirstAlphabetProvider{}, WithDoubleTokenizationCheck())
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	// with digit alphabets and versions some tokens are also valid credit cards
+	var tk string
+	for i := 0; i < 1000 && tk == ""; i++ {
+		got, err := e.EncryptCC(fmt.Sprintf("444433%06d1111", i))
+		if err != nil {
+			t.Fatalf("EncryptCC() error = %v", err)
+		}
+		if IsCC(got) {
+			tk = got
+		}
+	}
+	if tk == "" {
+		t.Fatal("no token made of digits only found")
+	}
+
+	if _, err := e.EncryptCC(tk); err != nil {
+		t.Errorf("EncryptCC(%s) error = %v, want the token tokenized again", tk, err)
+	}
+	if _, err := checked.EncryptCC(tk); !errors.Is(err, ErrAlreadyTokenized) {
+		t.Errorf("EncryptCC(%s) error = %v, want %v", tk, err, ErrAlreadyTokenized)
+	}
+	if _, err := checked.EncryptCC("4444333322221111"); err != nil {
+		t.Errorf("EncryptCC() error = %v", err)
+	}
+}
+
+func TestValidateAlphabet(t *testing.T) {
+	tests := map[string]struct {
+		base    uint32
+		alpha   string
+		wantErr bool
+	}{
+		"valid":              {14, "abcdefghijklmn", false},
+		"too_short":          {14, "abcdefghijklm", true},
+		"too_long":           {14, "abcdefghijklmno", true},
+		"duplicated_symbols": {14, "abcdefghijklmm", true},
+		"non_ascii_symbol":   {3, "ab\xe9", true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := ValidateAlphabet(tt.base, []byte(tt.alpha)); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateAlphabet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// the default alphabets cover the required bases
+	for _, base := range RequiredAlphabetBases() {
+		alpha, err := DefaultAlphabetProvider{}.GetAlphabetForBase(base)
+		if err != nil {
+			t.Fatalf("GetAlphabetForBase(%d) error = %v", base, err)
+		}
+		if err := ValidateAlphabet(base, alpha); err != nil {
+			t.Errorf("ValidateAlphabet(%d) error = %v", base, err)
+		}
+	}
+}
+
+func TestValidateVersion(t *testing.T) {
+	tests := map[string]struct {
+		v       byte
+		wantErr bool
+	}{
+		"nul":         {0x00, true},
+		"space":       {' ', true},
+		"exclamation": {'!', false},
+		"letter":      {'a', false},
+		"digit":       {'7', false},
+		"tilde":       {'~', false},
+		"del":         {0x7F, true},
+		"non_ascii":   {0x80, true},
+		"max_byte":    {0xFF, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := ValidateVersion(tt.v); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// engines refuse versioners with out-of-range versions
+			keys := fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+			versioner := deterministicVersioner{tokVersion: tt.v, detokVersions: []byte{tt.v}}
+			e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewEngine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// and the versions returned after construction are checked too
+			e = &engine{versioner: versioner, encryptionKeys: keys, hmacKeys: keys, alphaProvider: DefaultAlphabetProvider{}}
+			tk, err := e.EncryptCC("4444333322221111")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("EncryptCC() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (len(tk) != 16 || tk[6] != tt.v) {
+				t.Errorf("EncryptCC() got = %q, want version %q at index 6", tk, tt.v)
+			}
+		})
+	}
+}
+
+func Test_engine_keyUnavailable(t *testing.T) {
+	zero := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	tests := map[string]struct {
+		encKeys  KeyRepo
+		hmacKeys KeyRepo
+		ops      func(e TKEngine) error
+		wantErr  error
+	}{
+		"tokenize_missing_encryption_key": {fixedKeyRepo{err: true}, fixedKeyRepo{key: zero}, func(e TKEngine) error { _, err := e.EncryptCC("4444333322221111"); return err }, ErrKeyUnavailable},
+		"tokenize_missing_hmac_key":       {fixedKeyRepo{key: zero}, fixedKeyRepo{err: true}, func(e TKEngine) error { _, err := e.EncryptCC("4444333322221111"); return err }, ErrKeyUnavailable},
+		"detokenize_missing_key":          {fixedKeyRepo{err: true}, fixedKeyRepo{err: true}, func(e TKEngine) error { _, err := e.DecryptTK("444433aapchc1111"); return err }, ErrKeyUnavailable},
+		"detokenize_invalid_token":        {fixedKeyRepo{err: true}, fixedKeyRepo{err: true}, func(e TKEngine) error { _, err := e.DecryptTK("444433zapchc1111"); return err }, ErrInvalidTK},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngineWithDefaultAlphabet(versioner, tt.encKeys, tt.hmacKeys)
+			if err != nil {
+				t.Fatalf("NewEngineWithDefaultAlphabet() error = %v", err)
+			}
+			err = tt.ops(e)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == ErrKeyUnavailable && errors.Is(err, ErrInvalidTK) {
+				t.Errorf("error = %v, should not be %v", err, ErrInvalidTK)
+			}
+		})
+	}
+}
+
+func Test_engine_versionExpired(t *testing.T) {
+	keys := fixedKeyRepo{false, make([]byte, 16)}
+	// b was retired by the versioner: its tokens are well-formed but no longer detokenized
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	tests := map[string]struct {
+		tk      string
+		wantErr error
+	}{
+		"retired_version":         {"444433bapchc1111", ErrVersionExpired},
+		"current_version":         {"444433aapchc1111", nil},
+		"malformed_retired_token": {"444433baPchc1111", ErrInvalidTK},
+		"invalid_version_byte":    {"444433 apchc1111", ErrInvalidTK},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{})
+			if err != nil {
+				t.Fatalf("NewEngine() error = %v", err)
+			}
+			_, err = e.DecryptTK(tt.tk)
+			if !errors.Is(err, tt.wantErr) || (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("DecryptTK(%v) error = %v, want %v", tt.tk, err, tt.wantErr)
+			}
+			// only the well-formed tokens of retired versions are reported as expired
+			if tt.wantErr == ErrInvalidTK && errors.Is(err, ErrVersionExpired) {
+				t.Errorf("DecryptTK(%v) error = %v, should not be %v", tt.tk, err, ErrVersionExpired)
+			}
+		})
+	}
+}
+
+func Test_engine_versionExpiredDetokAlphabet(t *testing.T) {
+	keys := fixedKeyRepo{false, make([]byte, 16)}
+	e, err := NewEngineWithAlphabets(deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, keys, keys, upperAlphaProvider{}, DefaultAlphabetProvider{})
+	if err != nil {
+		t.Fatalf("NewEngineWithAlphabets() error = %v", err)
+	}
+	// the token is only well-formed in the detokenization alphabet
+	if _, err := e.DecryptTK("444433bapchc1111"); !errors.Is(err, ErrVersionExpired) {
+		t.Errorf("DecryptTK() error = %v, want %v", err, ErrVersionExpired)
+	}
+}
+
+func Test_isValidTK_failureModes(t *testing.T) {
+	vers := []byte{'a'}
+	tests := map[string]struct {
+		tk             string
+		wantStructure  bool
+		wantVersion    bool
+		wantErr        error
+		wantNotExpired bool
+	}{
+		"valid":              {"444433aapchc1111", true, true, nil, false},
+		"too_short":          {"444433aa1111", false, false, ErrInvalidTK, true},
+		"too_long":           {"444433aapchc11112222", false, false, ErrInvalidTK, true},
+		"non_numeric_prefix": {"4x4433aapchc1111", false, false, ErrInvalidTK, true},
+		"non_numeric_suffix": {"444433aapchc111x", false, false, ErrInvalidTK, true},
+		"alphabet_mismatch":  {"444433aaPchc1111", false, false, ErrInvalidTK, true},
+		"retired_version":    {"444433bapchc1111", true, false, ErrVersionExpired, false},
+		"invalid_version":    {"444433 apchc1111", true, false, ErrInvalidTK, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := isStructurallyValidTK(tt.tk, DefaultAlphabetProvider{}); got != tt.wantStructure {
+				t.Errorf("isStructurallyValidTK(%v) = %v, want %v", tt.tk, got, tt.wantStructure)
+			}
+			// the version is only checked on structurally valid tokens
+			if tt.wantStructure {
+				if got := isAcceptedVersion(tt.tk, vers); got != tt.wantVersion {
+					t.Errorf("isAcceptedVersion(%v) = %v, want %v", tt.tk, got, tt.wantVersion)
+				}
+			}
+			if got, want := isValidTK(tt.tk, DefaultAlphabetProvider{}, vers), tt.wantErr == nil; got != want {
+				t.Errorf("isValidTK(%v) = %v, want %v", tt.tk, got, want)
+			}
+			err := checkNumericTK(tt.tk, CreditCardFormat, DefaultAlphabetProvider{}, vers)
+			if !errors.Is(err, tt.wantErr) || (err != nil) != (tt.wantErr != nil) {
+				t.Errorf("checkNumericTK(%v) error = %v, want %v", tt.tk, err, tt.wantErr)
+			}
+			if tt.wantNotExpired && errors.Is(err, ErrVersionExpired) {
+				t.Errorf("checkNumericTK(%v) error = %v, should not be %v", tt.tk, err, ErrVersionExpired)
+			}
+		})
+	}
+}
+
+// rotatingAlphabetProvider returns the default alphabets rotated by one more symbol on every call,
+// once started (the encoding self-check of the engine construction would fail otherwise)
+type rotatingAlphabetProvider struct {
+	calls   *int
+	started *bool
+}
+
+func (r rotatingAlphabetProvider) GetAlphabetForBase(base uint32) ([]byte, error) {
+	alpha, err := DefaultAlphabetProvider{}.GetAlphabetForBase(base)
+	if err != nil || r.started != nil && !*r.started {
+		return alpha, err
+	}
+	*r.calls++
+	k := *r.calls % len(alpha)
+	return append(append([]byte(nil), alpha[k:]...), alpha[:k]...), nil
+}
+
+func TestWithVerifyOnEncrypt(t *testing.T) {
+	keys := fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+	tests := map[string]struct {
+		versioner KeyVersioner
+		alpha     AlphabetProvider
+		opts      []Option
+		// want is the expected token, any token if empty and no error is expected
+		want    string
+		wantErr error
+	}{
+		"verified":              {deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, DefaultAlphabetProvider{}, []Option{WithVerifyOnEncrypt()}, "444433aapchc1111", nil},
+		"unstable_alphabet":     {deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, rotatingAlphabetProvider{new(int), new(bool)}, []Option{WithVerifyOnEncrypt()}, "", ErrRoundTripFailure},
+		"unverified_by_default": {deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}, rotatingAlphabetProvider{new(int), new(bool)}, nil, "", nil},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngine(tt.versioner, keys, keys, tt.alpha, tt.opts...)
+			if err != nil {
+				t.Fatalf("NewEngine() error = %v", err)
+			}
+			if r, ok := tt.alpha.(rotatingAlphabetProvider); ok {
+				*r.started = true
+			}
+			got, err := e.EncryptCC("4444333322221111")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("EncryptCC() error = %v, want %v", err, tt.wantErr)
+			}
+			if (tt.wantErr != nil || tt.want != "") && got != tt.want || tt.wantErr == nil && got == "" {
+				t.Errorf("EncryptCC() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsInvalidInputIsUnavailable(t *testing.T) {
+	tests := map[string]struct {
+		err             error
+		wantInvalid     bool
+		wantUnavailable bool
+	}{
+		"nil":              {nil, false, false},
+		"invalid_cc":       {fmt.Errorf("%w: length 2 out of range [13, 19]", ErrInvalidCC), true, false},
+		"invalid_tk":       {ErrInvalidTK, true, false},
+		"version_expired":  {fmt.Errorf("%w: version f", ErrVersionExpired), true, false},
+		"truncated_suffix": {ErrTruncatedSuffix, true, false},
+		"invalid_value":    {ErrInvalidValue, true, false},
+		"already_tk":       {ErrAlreadyTokenized, true, false},
+		"test_pan":         {ErrTestPAN, true, false},
+		"implausible_pan":  {ErrImplausiblePAN, true, false},
+		"input_too_long":   {ErrInputTooLong, true, false},
+		"token_integrity":  {ErrTokenIntegrity, true, false},
+		"domain_too_small": {ErrDomainTooSmall, true, false},
+		"context_required": {ErrContextRequired, true, false},
+		"key_unavailable":  {fmt.Errorf("%w: version a", ErrKeyUnavailable), false, true},
+		"cipher_failure":   {ErrInternalCipherFailure, false, false},
+		"misconfigured":    {ErrMisconfiguredEngine, false, false},
+		"round_trip":       {ErrRoundTripFailure, false, false},
+		"other":            {errors.New("boom"), false, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := IsInvalidInput(tt.err); got != tt.wantInvalid {
+				t.Errorf("IsInvalidInput() = %v, want %v", got, tt.wantInvalid)
+			}
+			if got := IsUnavailable(tt.err); got != tt.wantUnavailable {
+				t.Errorf("IsUnavailable() = %v, want %v", got, tt.wantUnavailable)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// Package tkenginetest provides a reproducible tokenization engine for the test suites
+// of the packages relying on tkengine.
+package tkenginetest
+
+import (
+	"crypto-token/tkengine"
+	"errors"
+	"fmt"
+)
+
+// NewTestEngine returns an engine with the default alphabet tokenizing and detokenizing under the
+// single version, with encKey as FF1 key and hmacKey as tweak HMAC key. The same inputs always give
+// the same tokens. It panics if the engine can't be built (e.g. invalid version or key size) as
+// it is meant for test setups only.
+func NewTestEngine(version byte, encKey, hmacKey []byte) tkengine.TKEngine {
+	if err := tkengine.ValidateEncryptionKey(encKey); err != nil {
+		panic(fmt.Sprintf("tkenginetest: invalid encryption key: %v", err))
+	}
+	e, err := tkengine.NewEngineWithDefaultAlphabet(versioner{version}, keyRepo{version, encKey}, keyRepo{version, hmacKey})
+	if err != nil {
+		panic(fmt.Sprintf("tkenginetest: could not build engine: %v", err))
+	}
+	return e
+}
+
+// versioner tokenizes and detokenizes under a single version
+type versioner struct {
+	version byte
+}
+
+// GetTokenizationVersion returns the single version
+func (v versioner) GetTokenizationVersion() (byte, error) {
+	return v.version, nil
+}
+
+// GetDetokenizationVersions returns the single version
+func (v versioner) GetDetokenizationVersions() ([]byte, error) {
+	return []byte{v.version}, nil
+}
+
+// keyRepo holds the key of a single version
+type keyRepo struct {
+	version byte
+	key     []byte
+}
+
+// GetKey returns the key if v is the repository version
+func (r keyRepo) GetKey(v byte) ([]byte, error) {
+	if v != r.version {
+		return nil, errors.New(fmt.Sprintf("No key exists for version %v", v))
+	}
+	return r.key, nil
+}
+
+// AlphabetProvider is a deterministic alphabet provider serving arbitrary alphabets indexed by base,
+// including bases tkengine.DefaultAlphabetProvider doesn't supply. Unlike tkengine.NewMapAlphabetProvider
+// it does not validate its alphabets, so that tests can also exercise invalid configurations.
+type AlphabetProvider map[uint32]string
+
+// GetAlphabetForBase returns the alphabet of base, an error if there is none
+func (a AlphabetProvider) GetAlphabetForBase(base uint32) ([]byte, error) {
+	alpha, ok := a[base]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("No alphabet for base %d", base))
+	}
+	return []byte(alpha), nil
+}
+
+// DefaultAlphabets returns an AlphabetProvider holding the alphabets of tkengine.DefaultAlphabetProvider,
+// for tests to add, replace or remove bases
+func DefaultAlphabets() AlphabetProvider {
+	a := make(AlphabetProvider)
+	// bases 12 and 13 encode the middle digits of the longer numeric values
+	for _, base := range append([]uint32{12, 13}, tkengine.RequiredAlphabetBases()...) {
+		alpha, err := tkengine.DefaultAlphabetProvider{}.GetAlphabetForBase(base)
+		if err != nil {
+			panic(fmt.Sprintf("tkenginetest: no default alphabet for base %d: %v", base, err))
+		}
+		a[base] = string(alpha)
+	}
+	return a
+}
@@ -0,0 +1,79 @@
+package tkenginetest
+
+import (
+	"crypto-token/tkengine"
+	"testing"
+)
+
+func TestNewTestEngine(t *testing.T) {
+	zero := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	tests := map[string]struct {
+		version   byte
+		encKey    []byte
+		hmacKey   []byte
+		wantTK    string
+		wantPanic bool
+	}{
+		"known_vector":     {version: 'a', encKey: zero, hmacKey: zero, wantTK: "444433aapchc1111"},
+		"invalid_version":  {version: ' ', encKey: zero, hmacKey: zero, wantPanic: true},
+		"invalid_key_size": {version: 'a', encKey: []byte{0}, hmacKey: zero, wantPanic: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); (r != nil) != tt.wantPanic {
+					t.Errorf("NewTestEngine() panic = %v, wantPanic %v", r, tt.wantPanic)
+				}
+			}()
+			e := NewTestEngine(tt.version, tt.encKey, tt.hmacKey)
+
+			tk, err := e.EncryptCC("4444333322221111")
+			if err != nil || tk != tt.wantTK {
+				t.Errorf("EncryptCC() got = %v, %v, want %v", tk, err, tt.wantTK)
+			}
+			cc, err := e.DecryptTK(tk)
+			if err != nil || cc != "4444333322221111" {
+				t.Errorf("DecryptTK() got = %v, %v, want 4444333322221111", cc, err)
+			}
+		})
+	}
+}
+
+func TestAlphabetProvider(t *testing.T) {
+	withBase7 := DefaultAlphabets()
+	withBase7[7] = "ABCDEFG"
+	withoutBase14 := DefaultAlphabets()
+	delete(withoutBase14, 14)
+	tests := map[string]struct {
+		provider  AlphabetProvider
+		wantValid bool
+	}{
+		"default_alphabets":   {DefaultAlphabets(), true},
+		"non_default_base":    {withBase7, true},
+		"missing_base":        {withoutBase14, false},
+		"empty":               {AlphabetProvider{}, false},
+		"wrong_size_alphabet": {AlphabetProvider{14: "abc"}, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tkengine.ValidateAlphabetProvider(tt.provider); (err == nil) != tt.wantValid {
+				t.Errorf("ValidateAlphabetProvider() error = %v, wantValid %v", err, tt.wantValid)
+			}
+		})
+	}
+
+	if alpha, err := withBase7.GetAlphabetForBase(7); err != nil || string(alpha) != "ABCDEFG" {
+		t.Errorf("GetAlphabetForBase(7) = %s, %v, want ABCDEFG", alpha, err)
+	}
+	if _, err := withoutBase14.GetAlphabetForBase(14); err == nil {
+		t.Errorf("GetAlphabetForBase(14) of a provider without base 14 succeeded")
+	}
+	// the default alphabets produce the default tokens
+	e, err := tkengine.NewEngine(versioner{'a'}, keyRepo{'a', make([]byte, 16)}, keyRepo{'a', make([]byte, 16)}, DefaultAlphabets())
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	if tk, err := e.EncryptCC("4444333322221111"); err != nil || tk != "444433aapchc1111" {
+		t.Errorf("EncryptCC() = %s, %v, want 444433aapchc1111", tk, err)
+	}
+}
@@ -0,0 +1,29 @@
+package tkengine
+
+// ComputeToken is the reference tokenization function of the token format: it returns the token of cc
+// under version, with encKey as FF1 key, hmacKey as tweak HMAC key (HMAC-SHA-256) and the alphabets of
+// alpha. It involves no versioner nor any other state, the same inputs always giving the same token, so
+// that known-answer test vectors can be published for other implementations of the format. The minimum
+// domain size of the engines (see WithMinDomainSize) doesn't apply: the vectors cover every card length.
+func ComputeToken(cc string, version byte, encKey, hmacKey []byte, alpha AlphabetProvider) (string, error) {
+	e, err := NewEngine(singleVersioner{version}, &keyRepo{keys: map[byte][]byte{version: encKey}}, &keyRepo{keys: map[byte][]byte{version: hmacKey}}, alpha, WithMinDomainSize(1))
+	if err != nil {
+		return "", err
+	}
+	return e.EncryptCC(cc)
+}
+
+// singleVersioner tokenizes and detokenizes under a single version
+type singleVersioner struct {
+	version byte
+}
+
+// GetTokenizationVersion returns the single version
+func (s singleVersioner) GetTokenizationVersion() (byte, error) {
+	return s.version, nil
+}
+
+// GetDetokenizationVersions returns the single version
+func (s singleVersioner) GetDetokenizationVersions() ([]byte, error) {
+	return []byte{s.version}, nil
+}
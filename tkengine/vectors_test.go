@@ -0,0 +1,50 @@
+package tkengine
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestComputeToken(t *testing.T) {
+	zero := make([]byte, 16)
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	tests := map[string]struct {
+		cc      string
+		version byte
+		encKey  []byte
+		hmacKey []byte
+		alpha   AlphabetProvider
+		want    string
+		wantErr bool
+	}{
+		"zero_keys_16_digits": {"4444333322221111", 'a', zero, zero, DefaultAlphabetProvider{}, "444433aapchc1111", false},
+		"zero_keys_13_digits": {"4444333322221", 'a', zero, zero, DefaultAlphabetProvider{}, "444433ad32221", false},
+		"invalid_cc":          {"44443333", 'a', zero, zero, DefaultAlphabetProvider{}, "", true},
+		"invalid_version":     {"4444333322221111", 0x80, zero, zero, DefaultAlphabetProvider{}, "", true},
+		"invalid_key":         {"4444333322221111", 'a', zero[:5], zero, DefaultAlphabetProvider{}, "", true},
+		"invalid_alphabet":    {"4444333322221111", 'a', zero, zero, missingBase14AlphaProvider, "", true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ComputeToken(tt.cc, tt.version, tt.encKey, tt.hmacKey, tt.alpha)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ComputeToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ComputeToken() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// the reference function agrees with the engine for any key
+	e, err := NewEngine(singleVersioner{'k'}, &keyRepo{keys: map[byte][]byte{'k': key}}, &keyRepo{keys: map[byte][]byte{'k': key}}, DefaultAlphabetProvider{}, WithMinDomainSize(shortCardsDomain))
+	if err != nil {
+		t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+	}
+	for _, cc := range []string{"4444333322221", "44443333222211119", "4444333322221111999"} {
+		want, _ := e.EncryptCC(cc)
+		if got, err := ComputeToken(cc, 'k', key, key, DefaultAlphabetProvider{}); err != nil || got != want {
+			t.Errorf("ComputeToken(%v) = %v, %v, want %v", cc, got, err, want)
+		}
+	}
+}
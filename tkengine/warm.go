@@ -0,0 +1,81 @@
+package tkengine
+
+import (
+	"fmt"
+)
+
+// warmTweakInput is the tweak input Warm derives the tweaks from, the preserved digits of a 16-digit card
+var warmTweakInput = []byte("0000000000")
+
+// Warmer is an optional interface of a TKEngine checking its keys ahead of the first operation.
+// The engines built by this package implement it.
+type Warmer interface {
+	// Warm checks that the keys of all the versions are available
+	// and valid, naming the first failing version
+	Warm() error
+}
+
+// Warm checks, typically at startup, that the engine can tokenize and detokenize under all its versions:
+// for the tokenization version and each detokenization version it retrieves the keys, derives a tweak
+// and builds the FF1 (or FF3-1, see WithFF31) cipher with them, so that a missing or invalid key fails fast
+// instead of failing the first request using it. The error names the first failing version and never contains key material.
+// The ciphers are bound to the tweak of each card, hence not reused across operations: Warm is a
+// preflight of the keys, it does not speed up the operations that follow.
+func (e *engine) Warm() error {
+	v, te, err := e.tokenizationVersion()
+	if err != nil {
+		return fmt.Errorf("Warm-up of the tokenization version failed: %w", err)
+	}
+	detokVers, err := e.versioner.GetDetokenizationVersions()
+	if err != nil {
+		return fmt.Errorf("Warm-up of the detokenization versions failed: %w", err)
+	}
+	vers := []byte{v}
+	for _, dv := range detokVers {
+		if !contains(vers, dv) {
+			vers = append(vers, dv)
+		}
+	}
+	for _, ver := range vers {
+		// the tokenization version is warmed up with the keys it tokenizes with (see KeyProvider)
+		if err := te.warmVersion(ver); err != nil {
+			return fmt.Errorf("Warm-up of version %q failed: %w", ver, err)
+		}
+	}
+	return nil
+}
+
+// warmVersion builds the FF1 cipher of version v with its keys and its format
+func (e *engine) warmVersion(v byte) error {
+	if err := ValidateVersion(v); err != nil {
+		return err
+	}
+	if _, err := e.formatFor(v); err != nil {
+		return err
+	}
+	if _, err := e.ccFormat(v); err != nil {
+		return err
+	}
+
+	ekey, err := getSecretKey(e.encryptionKeys, v)
+	if err != nil {
+		return err
+	}
+	defer ekey.Close()
+	hkey, err := getSecretKey(e.hmacKeys, v)
+	if err != nil {
+		return err
+	}
+	defer hkey.Close()
+	if err := e.checkFIPSKeys(v, ekey.Bytes(), hkey.Bytes()); err != nil {
+		return err
+	}
+
+	tweak, err := e.versionTweak(v, hkey.Bytes(), warmTweakInput)
+	if err != nil {
+		return err
+	}
+	defer zero(tweak)
+	_, err = e.newFPECipher(v, 10, ekey.Bytes(), tweak)
+	return err
+}
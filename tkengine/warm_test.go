@@ -0,0 +1,47 @@
+package tkengine
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_engine_Warm(t *testing.T) {
+	key := make([]byte, 16)
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a', 'b'}}
+	tests := map[string]struct {
+		encryptionKeys map[byte][]byte
+		hmacKeys       map[byte][]byte
+		opts           []Option
+		wantVersion    string
+		wantErr        error
+	}{
+		"all_keys":               {map[byte][]byte{'a': key, 'b': key}, map[byte][]byte{'a': key, 'b': key}, nil, "", nil},
+		"missing_encryption_key": {map[byte][]byte{'a': key}, map[byte][]byte{'a': key, 'b': key}, nil, "'b'", ErrKeyUnavailable},
+		"missing_hmac_key":       {map[byte][]byte{'a': key, 'b': key}, map[byte][]byte{'b': key}, nil, "'a'", ErrKeyUnavailable},
+		"invalid_encryption_key": {map[byte][]byte{'a': key, 'b': key[:5]}, map[byte][]byte{'a': key, 'b': key}, nil, "'b'", nil},
+		"invalid_cmac_tweak_key": {map[byte][]byte{'a': key, 'b': key}, map[byte][]byte{'a': key, 'b': key[:5]}, []Option{WithCMACTweaks('b')}, "'b'", nil},
+		"fips_short_hmac_key":    {map[byte][]byte{'a': key, 'b': key}, map[byte][]byte{'a': key[:8], 'b': key}, []Option{WithFIPSMode()}, "'a'", nil},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEngine(versioner, &keyRepo{keys: tt.encryptionKeys}, &keyRepo{keys: tt.hmacKeys}, DefaultAlphabetProvider{}, tt.opts...)
+			if err != nil {
+				t.Fatalf("NewEngine() error = %v", err)
+			}
+			err = e.(Warmer).Warm()
+			if (err != nil) != (tt.wantVersion != "") {
+				t.Fatalf("Warm() error = %v, want an error for version %v", err, tt.wantVersion)
+			}
+			if err == nil {
+				return
+			}
+			if !strings.Contains(err.Error(), "version "+tt.wantVersion) {
+				t.Errorf("Warm() error = %v, want it to name version %v", err, tt.wantVersion)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Warm() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package tkengine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// FixedWidthPadding is the char padding the tokens of engines built with WithFixedTokenWidth. It
+// must not belong to the alphabets encoding the middle digits, which is the case of the default ones.
+const FixedWidthPadding = '9'
+
+// WithFixedTokenWidth makes the engine emit credit card tokens of exactly width chars, in
+// [CreditCardFormat.MinLength, CreditCardFormat.MaxLength], for fixed-width storage. Tokens of shorter
+// cards are padded with FixedWidthPadding between the encoded middle digits and the last 4 digits:
+// since the padding char is not part of the middle digits alphabets, the number of padding chars,
+// hence the card length, is recovered unambiguously at detokenization. Cards longer than width are
+// rejected. DecryptTK, IsToken, TokenVersion and Retokenize expect padded tokens, numeric values
+// (EncryptNumeric) are not padded. The engine construction fails if width is out of range or if an
+// alphabet of the engine contains FixedWidthPadding.
+func WithFixedTokenWidth(width int) Option {
+	return func(e *engine) {
+		e.tokenWidth = width
+	}
+}
+
+// validateTokenWidth returns an error if the fixed token width of the engine can't be honored
+func (e *engine) validateTokenWidth() error {
+	if e.tokenWidth == 0 {
+		return nil
+	}
+	if e.tokenWidth < CreditCardFormat.MinLength || e.tokenWidth > CreditCardFormat.MaxLength {
+		return errors.New(fmt.Sprintf("Invalid fixed token width %d: it should be in [%d, %d]", e.tokenWidth, CreditCardFormat.MinLength, CreditCardFormat.MaxLength))
+	}
+	preserved := CreditCardFormat.PreservedPrefix + CreditCardFormat.PreservedSuffix
+	for _, alpha := range []AlphabetProvider{e.alphaProvider, e.detokAlphaProvider} {
+		if alpha == nil {
+			continue
+		}
+		for l := CreditCardFormat.MinLength; l <= e.tokenWidth; l++ {
+			base, err := encodingBaseToSaveOneChar(l - preserved)
+			if err != nil {
+				return err
+			}
+			symbols, err := alpha.GetAlphabetForBase(base)
+			if err != nil {
+				return err
+			}
+			if strings.IndexByte(string(symbols), FixedWidthPadding) >= 0 {
+				return errors.New(fmt.Sprintf("Invalid fixed token width: the alphabet for base %d contains the padding char %q", base, FixedWidthPadding))
+			}
+		}
+	}
+	return nil
+}
+
+// padToken pads the credit card token tk to the fixed token width of the engine, if any
+func (e *engine) padToken(tk string) (string, error) {
+	if e.tokenWidth == 0 {
+		return tk, nil
+	}
+	if len(tk) > e.tokenWidth {
+		return "", fmt.Errorf("%w: length %d exceeds the fixed token width %d", ErrInvalidCC, len(tk), e.tokenWidth)
+	}
+	s := len(tk) - CreditCardFormat.PreservedSuffix
+	return tk[:s] + strings.Repeat(string(FixedWidthPadding), e.tokenWidth-len(tk)) + tk[s:], nil
+}
+
+// unpadToken strips the padding of the fixed width token tk, if the engine has a fixed token width
+func (e *engine) unpadToken(tk string) (string, error) {
+	if e.tokenWidth == 0 {
+		return tk, nil
+	}
+	if len(tk) != e.tokenWidth {
+		return "", fmt.Errorf("%w: length %d differs from the fixed token width %d", ErrInvalidTK, len(tk), e.tokenWidth)
+	}
+	s := len(tk) - CreditCardFormat.PreservedSuffix
+	// the encoded middle digits of the shortest cards are preceded by the prefix and the version char
+	minEnd := CreditCardFormat.MinLength - CreditCardFormat.PreservedSuffix
+	end := s
+	for end > minEnd && tk[end-1] == FixedWidthPadding {
+		end--
+	}
+	return tk[:end] + tk[s:], nil
+}
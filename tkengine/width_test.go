@@ -0,0 +1,109 @@
+package tkengine
+
+import (
+	"errors"
+	"testing"
+)
+
+// paddingAlphabetProvider is the default alphabet provider with the padding char in the base 32 alphabet
+type paddingAlphabetProvider struct{}
+
+func (paddingAlphabetProvider) GetAlphabetForBase(base uint32) ([]byte, error) {
+	alpha, err := DefaultAlphabetProvider{}.GetAlphabetForBase(base)
+	if err != nil || base != 32 {
+		return alpha, err
+	}
+	return append([]byte{FixedWidthPadding}, alpha[1:]...), nil
+}
+
+func TestWithFixedTokenWidth(t *testing.T) {
+	keys := fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	tests := map[string]struct {
+		width   int
+		alpha   AlphabetProvider
+		wantErr bool
+	}{
+		"width_19":            {19, DefaultAlphabetProvider{}, false},
+		"width_13":            {13, DefaultAlphabetProvider{}, false},
+		"width_too_small":     {12, DefaultAlphabetProvider{}, true},
+		"width_too_large":     {20, DefaultAlphabetProvider{}, true},
+		"padding_in_alphabet": {19, paddingAlphabetProvider{}, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewEngine(versioner, keys, keys, tt.alpha, WithFixedTokenWidth(tt.width))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewEngine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_engine_fixedTokenWidth(t *testing.T) {
+	keys := fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, WithFixedTokenWidth(19), WithMinDomainSize(shortCardsDomain))
+	if err != nil {
+		t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+	}
+	plain, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, WithMinDomainSize(shortCardsDomain))
+	if err != nil {
+		t.Fatalf("NewEngine(, WithMinDomainSize(shortCardsDomain)) error = %v", err)
+	}
+	ccs := []string{
+		"4444333322221", "4444333322229", "44443333222211", "444433332222111",
+		"4444333322221111", "4444333322229999", "44443333222211119", "444433332222111199", "4444333322221111999",
+	}
+	for _, cc := range ccs {
+		t.Run(cc, func(t *testing.T) {
+			tk, err := e.EncryptCC(cc)
+			if err != nil {
+				t.Fatalf("EncryptCC() error = %v", err)
+			}
+			if len(tk) != 19 || tk[:6] != cc[:6] || tk[15:] != cc[len(cc)-4:] {
+				t.Errorf("EncryptCC() got = %v, want a 19 chars token preserving the 6x4 of %v", tk, cc)
+			}
+			// the padding sits between the encoded middle digits and the last 4 digits of the unpadded token
+			want, _ := plain.EncryptCC(cc)
+			if unpadded, err := e.(*engine).unpadToken(tk); err != nil || unpadded != want {
+				t.Errorf("unpadToken(%v) = %v, %v, want %v", tk, unpadded, err, want)
+			}
+			if got, err := e.DecryptTK(tk); err != nil || got != cc {
+				t.Errorf("DecryptTK(%v) = %v, %v, want %v", tk, got, err, cc)
+			}
+			if !e.(TokenInspector).IsToken(tk) {
+				t.Errorf("IsToken(%v) = false, want true", tk)
+			}
+			if v, err := e.(TokenInspector).TokenVersion(tk); err != nil || v != 'a' {
+				t.Errorf("TokenVersion(%v) = %c, %v, want a", tk, v, err)
+			}
+			if rtk, err := e.(Retokenizer).Retokenize(tk); err != nil || rtk != tk {
+				t.Errorf("Retokenize(%v) = %v, %v, want %v", tk, rtk, err, tk)
+			}
+		})
+	}
+
+	// unpadded tokens are not tokens of the fixed width engine
+	if _, err := e.DecryptTK("444433aapchc1111"); !errors.Is(err, ErrInvalidTK) {
+		t.Errorf("DecryptTK() error = %v, want %v", err, ErrInvalidTK)
+	}
+	if e.(TokenInspector).IsToken("444433aapchc1111") {
+		t.Errorf("IsToken() = true for an unpadded token")
+	}
+}
+
+func Test_engine_fixedTokenWidthTooLong(t *testing.T) {
+	keys := fixedKeyRepo{false, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+	versioner := deterministicVersioner{tokVersion: 'a', detokVersions: []byte{'a'}}
+	e, err := NewEngine(versioner, keys, keys, DefaultAlphabetProvider{}, WithFixedTokenWidth(16))
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	if _, err := e.EncryptCC("44443333222211119"); !errors.Is(err, ErrInvalidCC) {
+		t.Errorf("EncryptCC() error = %v, want %v", err, ErrInvalidCC)
+	}
+	if tk, err := e.EncryptCC("4444333322221111"); err != nil || tk != "444433aapchc1111" {
+		t.Errorf("EncryptCC() = %v, %v, want 444433aapchc1111", tk, err)
+	}
+}